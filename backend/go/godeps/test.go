@@ -110,8 +110,13 @@ if {{.IfTest}}:
 			'bin': test_build,
 			'data': '$(collect "{}/." include="go_test_data")'.format(heph.pkg.addr()),
 		},
-		'run': ['./$SRC_BIN -test.v "$@" 2>&1 | tee $OUT'],
-		'out': ['test_out'],
+		'run': [
+			'status=0; ./$SRC_BIN -test.v "$@" 2>&1 | tee $OUT_TEST_OUT || status=$?',
+			'go tool test2json -p {{.ImportPath}} < $OUT_TEST_OUT > $OUT_TEST_RESULTS',
+			'exit $status',
+		],
+		'out': {'test_out': 'test_out', 'test_results': 'test_results.json'},
+		'tools': [go],
 		'labels': ['test', 'go-test'],
 		'pass_args': True,
 	}
@@ -125,6 +130,8 @@ if {{.IfTest}}:
 			args['run'] = pre_run+args['run']
 		elif k == 'deps': 
 			args[k] |= v
+		elif k == 'tools':
+			args[k] += v if type(v) == "list" else [v]
 		else:
 			args[k] = v
 
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"heph/engine"
+	"sort"
+)
+
+var cacheVerifyFix bool
+
+func init() {
+	cacheCmd.AddCommand(cacheVerifyCmd)
+	rootCmd.AddCommand(cacheCmd)
+
+	cacheVerifyCmd.Flags().BoolVar(&cacheVerifyFix, "fix", false, "Evicts the corrupted entries")
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the caches",
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify [selector...]",
+	Short: "Verifies the cache entries against their checksums, defaults to //...",
+	Long: `Verifies the artifacts of the local cache entries and of the entries of the remote caches against the checksums
+recorded in their manifest. Remote caches have to be listable (file, gs, s3), entries stored by an older heph are not verified`,
+	ValidArgsFunction: ValidArgsFunctionTargets,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		if len(args) == 0 {
+			args = []string{"//..."}
+		}
+
+		err := preRunWithGenWithOpts(ctx, PreRunOpts{
+			Engine:  Engine,
+			LinkAll: true,
+		})
+		if err != nil {
+			return err
+		}
+
+		matchers := make(engine.TargetMatchers, 0, len(args))
+		for _, s := range args {
+			matchers = append(matchers, engine.ParseTargetSelector("", s))
+		}
+		matcher := engine.OrMatcher(matchers...)
+
+		targets := make([]*engine.Target, 0)
+		for _, target := range Engine.Targets.Slice() {
+			if target.Cache.Enabled && matcher(target) {
+				targets = append(targets, target)
+			}
+		}
+
+		sort.Slice(targets, func(i, j int) bool {
+			return targets[i].FQN < targets[j].FQN
+		})
+
+		results := make([]engine.CacheVerifyResult, 0)
+		for _, target := range targets {
+			res, err := Engine.VerifyLocalCache(target, cacheVerifyFix)
+			if err != nil {
+				return err
+			}
+			results = append(results, res...)
+		}
+
+		for _, cache := range Engine.Config.Caches {
+			if !cache.Read {
+				continue
+			}
+
+			res, err := Engine.VerifyRemoteCache(ctx, cache.Name, targets, cacheVerifyFix)
+			if err != nil {
+				return fmt.Errorf("%v: %w", cache.Name, err)
+			}
+			results = append(results, res...)
+		}
+
+		corrupted, unverified := 0, 0
+		for _, res := range results {
+			if res.Unverified {
+				unverified++
+			}
+
+			if res.Err == nil {
+				continue
+			}
+			corrupted++
+
+			fmt.Printf("%v %v %v: %v\n", res.Cache, res.Target, res.InputHash, res.Err)
+			if res.Evicted {
+				fmt.Printf("%v %v %v: evicted\n", res.Cache, res.Target, res.InputHash)
+			}
+		}
+
+		fmt.Printf("%v entries, %v corrupted, %v without checksums\n", len(results), corrupted, unverified)
+
+		if corrupted > 0 && !cacheVerifyFix {
+			return fmt.Errorf("%v corrupted entries, --fix evicts them", corrupted)
+		}
+
+		return nil
+	},
+}
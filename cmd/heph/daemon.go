@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bep/debounce"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"heph/daemon"
+	"heph/engine"
+	log "heph/hlog"
+	"heph/utils/fs"
+	"heph/worker"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var noDaemon *bool
+
+// daemonServing is set while the daemon runs a request
+var daemonServing *daemonCtx
+
+// daemonEngineFlags are used to build the engine, the daemon one is kept warm with the values it was started with
+var daemonEngineFlags = []string{"param", "profile", "no-gen", "offline"}
+
+func init() {
+	daemonCmd.AddCommand(daemonStopCmd)
+	rootCmd.AddCommand(daemonCmd)
+
+	noDaemon = rootCmd.PersistentFlags().Bool("no-daemon", false, "Do not forward run and query to the daemon")
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keeps the parsed graph warm, serving run and query to the other invocations",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		root, err := findRoot()
+		if err != nil {
+			return err
+		}
+
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		defer watcher.Close()
+
+		d := &daemonCtx{
+			ctx:     ctx,
+			watcher: watcher,
+			flags:   snapshotFlags(rootCmd),
+			env:     os.Environ(),
+		}
+
+		err = d.load()
+		if err != nil {
+			return err
+		}
+
+		srv, err := daemon.Listen(daemon.SocketPath(root), d.handle)
+		if err != nil {
+			return err
+		}
+		defer srv.Close()
+
+		go func() {
+			err := d.watchFiles()
+			if err != nil {
+				log.Errorf("watch: %v", err)
+			}
+		}()
+
+		log.Infof("Listening on %v", daemon.SocketPath(root))
+
+		return srv.Serve(ctx)
+	},
+}
+
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stops the daemon",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root, err := findRoot()
+		if err != nil {
+			return err
+		}
+
+		_, err = daemon.Do(cmd.Context(), daemon.SocketPath(root), daemon.Request{Stop: true}, os.Stdout, os.Stderr)
+		if err != nil {
+			if errors.Is(err, daemon.ErrNotRunning) {
+				log.Info("Daemon is not running")
+				return nil
+			}
+			return err
+		}
+
+		log.Info("Daemon stopped")
+
+		return nil
+	},
+}
+
+type daemonCtx struct {
+	ctx     context.Context
+	watcher *fsnotify.Watcher
+	flags   []flagSnapshot
+	// env is the environment the daemon was started with, restored between requests
+	env []string
+
+	m sync.Mutex
+	e *engine.Engine
+	// err is the error of the last load, reported to the clients until the next one
+	err error
+
+	filesm sync.Mutex
+	files  map[string]struct{}
+}
+
+// load parses and links the graph, and watches the files it depends on
+func (d *daemonCtx) load() error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	return d.loadLocked()
+}
+
+func (d *daemonCtx) loadLocked() error {
+	start := time.Now()
+
+	e, err := engineFactory()
+	if err != nil {
+		return err
+	}
+	// Stops the pool used for loading, each request gets its own
+	defer e.RunExitHandlers()
+
+	err = preRunWithGenWithOpts(d.ctx, PreRunOpts{
+		Engine:       e,
+		PoolWaitName: "Daemon load",
+		LinkAll:      true,
+	})
+	if err != nil {
+		d.e, d.err = nil, err
+		return err
+	}
+
+	d.e, d.err = e, nil
+
+	files := d.watchedFiles(e)
+	for _, p := range d.watcher.WatchList() {
+		_ = d.watcher.Remove(p)
+	}
+	for _, p := range e.GetWatcherList(files) {
+		err := d.watcher.Add(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	d.filesm.Lock()
+	d.files = map[string]struct{}{}
+	for _, file := range files {
+		d.files[file.Abs()] = struct{}{}
+	}
+	d.filesm.Unlock()
+
+	log.Infof("Loaded %v targets in %v", len(e.Targets.Slice()), time.Since(start).Round(time.Millisecond))
+
+	return nil
+}
+
+// watchedFiles returns the files which invalidate the graph: BUILD files, config, roots lock and the deps of gen targets.
+// They trigger a reload early, what the BUILD files load or glob is revalidated before each request
+func (d *daemonCtx) watchedFiles(e *engine.Engine) []fs.Path {
+	files := make([]fs.Path, 0)
+	for _, file := range e.SourceFiles {
+		files = append(files, fs.NewPath(file.Path, ""))
+	}
+
+	for _, name := range append([]string{"", "local"}, e.Config.Profiles...) {
+		file := ".hephconfig"
+		if name != "" {
+			file += "." + name
+		}
+		files = append(files, e.Root.Join(file))
+	}
+	files = append(files, e.Root.Join(engine.RootsLockFile))
+
+	genTargets := make([]*engine.Target, 0)
+	for _, target := range e.Targets.Slice() {
+		if target.Gen {
+			genTargets = append(genTargets, target)
+		}
+	}
+	files = append(files, e.GetFileHashDeps(genTargets...)...)
+
+	return files
+}
+
+func (d *daemonCtx) isGraphFile(path string) bool {
+	base := filepath.Base(path)
+	if base == "BUILD" || strings.HasPrefix(base, "BUILD.") || strings.HasPrefix(base, ".hephconfig") || base == engine.RootsLockFile {
+		return true
+	}
+
+	d.filesm.Lock()
+	defer d.filesm.Unlock()
+
+	_, ok := d.files[path]
+	return ok
+}
+
+func (d *daemonCtx) watchFiles() error {
+	debounced := debounce.New(500 * time.Millisecond)
+
+	for {
+		select {
+		case event, ok := <-d.watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			// Ignore if its only a chmod
+			if event.Op == fsnotify.Chmod || !d.isGraphFile(event.Name) {
+				continue
+			}
+
+			debounced(func() {
+				log.Infof("%v changed, reloading graph...", event.Name)
+
+				err := d.load()
+				if err != nil {
+					log.Errorf("reload: %v", err)
+				}
+			})
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			return err
+		case <-d.ctx.Done():
+			return d.ctx.Err()
+		}
+	}
+}
+
+// handle runs the command of the request against the warm engine, one at a time
+func (d *daemonCtx) handle(ctx context.Context, req daemon.Request, stdout, stderr io.Writer) int {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	restore := redirectIO(stdout, stderr, req.Stdin)
+	defer restore()
+
+	// Targets pass the env of the client, as if it ran the command itself
+	if req.Env != nil {
+		err := restoreEnv(req.Env)
+		if err != nil {
+			return printErrorExitCode(fmt.Errorf("daemon: %w", err))
+		}
+		defer func() {
+			_ = restoreEnv(d.env)
+		}()
+	}
+
+	if d.e != nil {
+		// The watcher does not see what the BUILD files load or glob
+		what, changed, err := d.e.BuildFilesChanged()
+		if err != nil {
+			return printErrorExitCode(fmt.Errorf("daemon: %w", err))
+		}
+
+		if !changed {
+			// The env is read when linking the targets
+			if name, ok := d.e.LinkEnvChanged(); ok {
+				what, changed = "$"+name, true
+			}
+		}
+
+		if changed {
+			log.Infof("%v changed, reloading graph...", what)
+
+			_ = d.loadLocked()
+		}
+	}
+
+	if d.err != nil {
+		return printErrorExitCode(fmt.Errorf("daemon: %w", d.err))
+	}
+
+	err := restoreFlags(d.flags)
+	if err != nil {
+		return printErrorExitCode(err)
+	}
+	defer func() {
+		_ = restoreFlags(d.flags)
+
+		if lvl, err := log.ParseLevel(*logLevel); err == nil {
+			log.SetLevel(lvl)
+		}
+	}()
+
+	targetsFromStdin = nil
+	if req.Stdin != nil {
+		targetsFromStdin, err = parseTargetPaths(bytes.NewReader(req.Stdin))
+		if err != nil {
+			return printErrorExitCode(err)
+		}
+	}
+
+	d.e.ResetRun()
+	d.e.Cwd = req.Cwd
+	Engine = d.e
+
+	daemonServing = d
+	defer func() {
+		daemonServing = nil
+	}()
+
+	// Subcommands would otherwise keep the context of the request they first ran
+	walkCommands(rootCmd, func(cmd *cobra.Command) {
+		cmd.SetContext(ctx)
+	})
+
+	rootCmd.SetArgs(append([]string{}, req.Args...))
+	err = rootCmd.ExecuteContext(ctx)
+
+	return printErrorExitCode(err)
+}
+
+// preRun prepares the engine for the command about to run, called once its flags are parsed
+func (d *daemonCtx) preRun(cmd *cobra.Command) error {
+	if !isDaemonCommand(cmd) {
+		return fmt.Errorf("%v is not supported by the daemon", cmd.CommandPath())
+	}
+
+	for _, name := range daemonEngineFlags {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("--%v must be set when starting the daemon", name)
+		}
+	}
+
+	e := d.e
+	e.Pool = worker.NewPool(workers)
+	e.RegisterExitHandler(func() {
+		e.Pool.Stop(nil)
+	})
+
+	return nil
+}
+
+func isDaemonCommand(cmd *cobra.Command) bool {
+	if cmd == runCmd {
+		return true
+	}
+
+	if cmd == fzfCmd {
+		// Interactive
+		return false
+	}
+
+	for c := cmd; c != nil; c = c.Parent() {
+		if c == queryCmd {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runInDaemon forwards the invocation to the daemon of the repo when one is running,
+// returns false if the command has to run locally
+func runInDaemon(cmd *cobra.Command, args []string) (bool, error) {
+	if *noDaemon || !isDaemonCommand(cmd) {
+		return false, nil
+	}
+
+	for _, name := range append([]string{"shell", "cpuprofile", "memprofile"}, daemonEngineFlags...) {
+		if f := cmd.Flags().Lookup(name); f != nil && f.Changed {
+			return false, nil
+		}
+	}
+
+	root, err := findRoot()
+	if err != nil {
+		return false, nil
+	}
+
+	path := daemon.SocketPath(root)
+	if _, err := os.Stat(path); err != nil {
+		return false, nil
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return false, err
+	}
+
+	req := daemon.Request{
+		Args: os.Args[1:],
+		Cwd:  cwd,
+		Env:  os.Environ(),
+	}
+
+	if hasStdin(args) {
+		req.Stdin, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	code, err := daemon.Do(cmd.Context(), path, req, os.Stdout, os.Stderr)
+	if err != nil {
+		if errors.Is(err, daemon.ErrNotRunning) {
+			if req.Stdin != nil {
+				return false, fmt.Errorf("daemon: %w", err)
+			}
+
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, ErrorWithExitCode{
+		ExitCode: code,
+	}
+}
+
+// redirectIO points the streams of the commands, and the logger, to the ones of the request until restore is called.
+// The streams of the process are left alone
+func redirectIO(stdout, stderr io.Writer, stdin []byte) func() {
+	prevIsTerm := isTerm
+
+	setup := func(stdout, stderr io.Writer, stdin io.Reader, term bool) {
+		rootCmd.SetOut(stdout)
+		rootCmd.SetErr(stderr)
+		rootCmd.SetIn(stdin)
+		isTerm = term
+
+		if stderr == nil {
+			stderr = os.Stderr
+		}
+		log.SetOutput(stderr)
+		setupPoolStyles(stderr)
+	}
+
+	setup(stdout, stderr, bytes.NewReader(stdin), false)
+
+	return func() {
+		setup(nil, nil, nil, prevIsTerm)
+	}
+}
+
+type flagSnapshot struct {
+	flag  *pflag.Flag
+	value string
+	slice []string
+}
+
+// snapshotFlags records the values of the flags of cmd and its subcommands, to be restored between requests
+func snapshotFlags(cmd *cobra.Command) []flagSnapshot {
+	seen := map[*pflag.Flag]struct{}{}
+	snapshots := make([]flagSnapshot, 0)
+
+	walkCommands(cmd, func(cmd *cobra.Command) {
+		// Those are added lazily, make sure they get reset too
+		cmd.InitDefaultHelpFlag()
+		cmd.InitDefaultVersionFlag()
+
+		for _, set := range []*pflag.FlagSet{cmd.Flags(), cmd.PersistentFlags()} {
+			set.VisitAll(func(f *pflag.Flag) {
+				if _, ok := seen[f]; ok {
+					return
+				}
+				seen[f] = struct{}{}
+
+				s := flagSnapshot{flag: f, value: f.Value.String()}
+				if sv, ok := f.Value.(pflag.SliceValue); ok {
+					s.slice = sv.GetSlice()
+				}
+
+				snapshots = append(snapshots, s)
+			})
+		}
+	})
+
+	return snapshots
+}
+
+func walkCommands(cmd *cobra.Command, f func(cmd *cobra.Command)) {
+	f(cmd)
+
+	for _, c := range cmd.Commands() {
+		walkCommands(c, f)
+	}
+}
+
+func restoreFlags(snapshots []flagSnapshot) error {
+	for _, s := range snapshots {
+		var err error
+		if sv, ok := s.flag.Value.(pflag.SliceValue); ok {
+			err = sv.Replace(s.slice)
+		} else {
+			err = s.flag.Value.Set(s.value)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %w", s.flag.Name, err)
+		}
+
+		s.flag.Changed = false
+	}
+
+	return nil
+}
+
+// restoreEnv replaces the environment of the process with env
+func restoreEnv(env []string) error {
+	os.Clearenv()
+
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		err := os.Setenv(k, v)
+		if err != nil {
+			return fmt.Errorf("%v: %w", k, err)
+		}
+	}
+
+	return nil
+}
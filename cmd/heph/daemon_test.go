@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"heph/daemon"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDaemonEnv(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, ".hephconfig"), []byte("cache_order: none\n"), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+target(name="a", run="echo $HEPH_TEST_DAEMON_ENV", pass_env=["HEPH_TEST_DAEMON_ENV"], cache=False)
+`), os.ModePerm)
+	require.NoError(t, err)
+
+	t.Setenv("HEPH_CWD", dir)
+	t.Setenv("HEPH_TEST_DAEMON_ENV", "daemon")
+
+	watcher, err := fsnotify.NewWatcher()
+	require.NoError(t, err)
+	defer watcher.Close()
+
+	d := &daemonCtx{
+		ctx:     ctx,
+		watcher: watcher,
+		flags:   snapshotFlags(rootCmd),
+		env:     os.Environ(),
+	}
+	require.NoError(t, d.load())
+
+	run := func(value string) string {
+		env := append(os.Environ(), "HEPH_TEST_DAEMON_ENV="+value)
+
+		var stdout, stderr bytes.Buffer
+		code := d.handle(ctx, daemon.Request{Args: []string{"run", "//:a", "--plain"}, Cwd: dir, Env: env}, &stdout, &stderr)
+		require.Equal(t, 0, code, stderr.String())
+
+		return stdout.String()
+	}
+
+	// The target gets the env of the client, not the one the daemon was started with
+	assert.Equal(t, "client1\n", run("client1"))
+	assert.Equal(t, "client2\n", run("client2"))
+
+	assert.Equal(t, "daemon", os.Getenv("HEPH_TEST_DAEMON_ENV"))
+}
@@ -44,15 +44,24 @@ func Execute() {
 	utils.Seed()
 
 	if err := execute(); err != nil {
-		exitCode := 1
-		var eerr ErrorWithExitCode
-		if errors.As(err, &eerr) {
-			exitCode = eerr.ExitCode
-			// This is required in case ErrorWithExitCode does not have an Err set, just an ExitCode
-			err = eerr.Err
-		}
-		printHumanError(err)
-
-		os.Exit(exitCode)
+		os.Exit(printErrorExitCode(err))
 	}
 }
+
+// printErrorExitCode prints err and returns the exit code to use, 0 if err is nil
+func printErrorExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	exitCode := 1
+	var eerr ErrorWithExitCode
+	if errors.As(err, &eerr) {
+		exitCode = eerr.ExitCode
+		// This is required in case ErrorWithExitCode does not have an Err set, just an ExitCode
+		err = eerr.Err
+	}
+	printHumanError(err)
+
+	return exitCode
+}
@@ -21,6 +21,11 @@ type boolStr struct {
 }
 
 func (bs *boolStr) Set(s string) error {
+	if s == "false" {
+		*bs = boolStr{}
+		return nil
+	}
+
 	if s == "true" {
 		s = ""
 	}
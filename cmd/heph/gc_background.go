@@ -0,0 +1,51 @@
+package main
+
+import (
+	"heph/engine"
+	log "heph/hlog"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// startBackgroundGC starts `heph gc` detached from the current process when the local cache is over its budget
+func startBackgroundGC(e *engine.Engine) {
+	exceeded, err := e.GCBudgetExceeded()
+	if err != nil {
+		log.Debugf("gc budget: %v", err)
+		return
+	}
+
+	if !exceeded {
+		return
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Debugf("gc budget: %v", err)
+		return
+	}
+
+	args := []string{"gc"}
+	for _, profile := range e.Config.Profiles {
+		args = append(args, "--profile", profile)
+	}
+	for _, param := range *params {
+		args = append(args, "--param", param)
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = e.Root.Abs()
+	// Survives the end of this process
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err = cmd.Start()
+	if err != nil {
+		log.Debugf("gc budget: %v", err)
+		return
+	}
+
+	log.Infof("Local cache over budget, running GC in the background")
+
+	_ = cmd.Process.Release()
+}
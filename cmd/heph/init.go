@@ -78,6 +78,7 @@ func engineInitWithEngine(ctx context.Context, e *engine.Engine) error {
 	}
 
 	e.Config.Profiles = *profiles
+	e.Offline = *offline
 
 	err := e.Init(ctx)
 	if err != nil {
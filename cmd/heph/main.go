@@ -1,5 +1,9 @@
 package main
 
+import "heph/sandbox"
+
 func main() {
+	sandbox.Init()
+
 	Execute()
 }
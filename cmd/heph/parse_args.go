@@ -8,6 +8,7 @@ import (
 	log "heph/hlog"
 	"heph/targetspec"
 	"heph/tgt"
+	"io"
 	"os"
 	"strings"
 )
@@ -31,7 +32,19 @@ func parseTargetPathsFromStdin() ([]targetspec.TargetPath, error) {
 		return targetsFromStdin, nil
 	}
 
-	s := bufio.NewScanner(os.Stdin)
+	tps, err := parseTargetPaths(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	targetsFromStdin = tps
+
+	return targetsFromStdin, nil
+}
+
+func parseTargetPaths(r io.Reader) ([]targetspec.TargetPath, error) {
+	tps := make([]targetspec.TargetPath, 0)
+
+	s := bufio.NewScanner(r)
 	for s.Scan() {
 		t := s.Text()
 		t = strings.TrimSpace(t)
@@ -45,10 +58,10 @@ func parseTargetPathsFromStdin() ([]targetspec.TargetPath, error) {
 			return nil, err
 		}
 
-		targetsFromStdin = append(targetsFromStdin, tp)
+		tps = append(tps, tp)
 	}
 
-	return targetsFromStdin, nil
+	return tps, s.Err()
 }
 
 func hasStdin(args []string) bool {
@@ -4,16 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/maps"
 	"gopkg.in/yaml.v3"
 	"heph/cmd/heph/search"
 	"heph/engine"
 	log "heph/hlog"
 	"heph/packages"
+	"heph/query"
 	"heph/targetspec"
 	"heph/tgt"
 	"heph/utils"
 	"heph/utils/sets"
-	"os"
+	"io"
 	"os/exec"
 	"path/filepath"
 	"sort"
@@ -28,6 +30,9 @@ var spec bool
 var transitive bool
 var output string
 var all bool
+var verify bool
+var queryOutput string
+var explainRemote string
 
 func init() {
 	queryCmd.AddCommand(configCmd)
@@ -36,6 +41,7 @@ func init() {
 	queryCmd.AddCommand(graphCmd)
 	queryCmd.AddCommand(graphDotCmd)
 	queryCmd.AddCommand(changesCmd)
+	queryCmd.AddCommand(affectedCmd)
 	queryCmd.AddCommand(targetCmd)
 	queryCmd.AddCommand(pkgsCmd)
 	queryCmd.AddCommand(revdepsCmd)
@@ -43,9 +49,11 @@ func init() {
 	queryCmd.AddCommand(outCmd)
 	queryCmd.AddCommand(hashoutCmd)
 	queryCmd.AddCommand(hashinCmd)
+	queryCmd.AddCommand(explainHashCmd)
 	queryCmd.AddCommand(outRootCmd)
 	queryCmd.AddCommand(orderedCachesCmd)
 	queryCmd.AddCommand(labelsCmd)
+	queryCmd.AddCommand(rulesCmd)
 
 	// Private, for internal testing
 	queryCmd.AddCommand(cacheRootCmd)
@@ -56,23 +64,48 @@ func init() {
 
 	outCmd.Flags().StringVar(&output, "output", "", "Output name")
 
+	hashoutCmd.Flags().BoolVar(&verify, "verify", false, "Rebuild the target and check the outputs are reproducible")
+
+	explainHashCmd.Flags().StringVar(&explainRemote, "remote", "", "Name of the cache to read the previous hash from, instead of the local cache")
+
 	targetCmd.Flags().BoolVar(&spec, "spec", false, "Print spec")
 
 	queryCmd.Flags().StringArrayVarP(&include, "include", "i", nil, "Label/Target to include")
 	queryCmd.Flags().StringArrayVarP(&exclude, "exclude", "e", nil, "Label/target to exclude, takes precedence over --include")
 	queryCmd.Flags().BoolVarP(&all, "all", "a", false, "Outputs private targets")
+	queryCmd.Flags().StringVar(&queryOutput, "output", "list", "Output format of the query expression: list, json or dot")
 	fzfCmd.Flags().BoolVarP(&all, "all", "a", false, "Outputs private targets")
+	affectedCmd.Flags().StringArrayVarP(&include, "include", "i", nil, "Label/Target to include")
+	affectedCmd.Flags().StringArrayVarP(&exclude, "exclude", "e", nil, "Label/target to exclude, takes precedence over --include")
+	affectedCmd.Flags().BoolVarP(&all, "all", "a", false, "Outputs private targets")
 
 	queryCmd.RegisterFlagCompletionFunc("include", ValidArgsFunctionLabelsOrTargets)
 	queryCmd.RegisterFlagCompletionFunc("exclude", ValidArgsFunctionLabelsOrTargets)
+	affectedCmd.RegisterFlagCompletionFunc("include", ValidArgsFunctionLabelsOrTargets)
+	affectedCmd.RegisterFlagCompletionFunc("exclude", ValidArgsFunctionLabelsOrTargets)
 }
 
 var queryCmd = &cobra.Command{
-	Use:     "query",
+	Use:     "query [expr]",
 	Aliases: []string{"q"},
 	Short:   "Query the graph",
-	Args:    cobra.RangeArgs(0, 1),
+	Long: `Query the graph, with --include/--exclude or an expression such as:
+  deps(//app:bin) intersect label(go) except //thirdparty/...
+
+Functions:
+  deps(expr[, depth])              expr and its dependencies
+  rdeps(universe, expr[, depth])   expr and the targets of universe depending on it
+  somepath(from, to)               a path from a target of from to one of to
+  allpaths(from, to)               all the targets on a path from a target of from to one of to
+  label(label[, expr])             targets having the label
+  attr(name, regex, expr)          targets with the spec attribute fully matching regex
+  filter(regex, expr)              targets with the FQN matching regex
+
+Operators, left associative: intersect (^), except (-), union (+)`,
+	Args: cobra.RangeArgs(0, 1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		ctx := cmd.Context()
 
 		err := blockReadStdin(args)
@@ -80,6 +113,20 @@ var queryCmd = &cobra.Command{
 			return err
 		}
 
+		var expr query.Expr
+		if len(args) == 1 && !hasStdin(args) {
+			expr, err = query.Parse(args[0])
+			if err != nil {
+				return err
+			}
+
+			switch queryOutput {
+			case "list", "json", "dot":
+			default:
+				return fmt.Errorf("output must be one of list, json, dot, got %v", queryOutput)
+			}
+		}
+
 		err = engineInit(ctx)
 		if err != nil {
 			return err
@@ -88,11 +135,16 @@ var queryCmd = &cobra.Command{
 		err = preRunWithGenWithOpts(ctx, PreRunOpts{
 			Engine:       Engine,
 			PoolWaitName: "Query gen",
+			LinkAll:      expr != nil,
 		})
 		if err != nil {
 			return err
 		}
 
+		if expr != nil {
+			return runQueryExpr(cmd.OutOrStdout(), expr)
+		}
+
 		targets := Engine.Targets.Slice()
 		if hasStdin(args) {
 			stargets, err := parseTargetsFromStdin(Engine)
@@ -114,37 +166,131 @@ var queryCmd = &cobra.Command{
 			}
 		}
 
-		includeMatchers := make(engine.TargetMatchers, 0)
-		for _, s := range include {
-			includeMatchers = append(includeMatchers, engine.ParseTargetSelector("", s))
+		selected := filterIncludeExclude(targets, include, exclude)
+
+		if len(selected) == 0 {
+			return nil
 		}
-		excludeMatchers := make(engine.TargetMatchers, 0)
-		for _, s := range exclude {
-			excludeMatchers = append(excludeMatchers, engine.ParseTargetSelector("", s))
+
+		fmt.Fprintln(out, strings.Join(sortedTargetNames(selected, false), "\n"))
+		return nil
+	},
+}
+
+func runQueryExpr(out io.Writer, expr query.Expr) error {
+	targets, err := Engine.Query(expr)
+	if err != nil {
+		return err
+	}
+
+	if !all {
+		targets = engine.FilterPublicTargets(targets)
+	}
+
+	if len(include) > 0 || len(exclude) > 0 {
+		selected := filterIncludeExclude(targets, include, exclude)
+
+		targets = make([]*engine.Target, 0, len(selected))
+		for _, t := range selected {
+			targets = append(targets, Engine.Targets.Find(t.FQN))
 		}
+	}
+
+	result := engine.NewTargets(len(targets))
+	result.AddAll(targets)
 
-		matcher := engine.YesMatcher()
-		if len(includeMatchers) > 0 {
-			matcher = engine.OrMatcher(includeMatchers...)
+	// Edges between the targets of the result
+	deps := func(target *engine.Target) ([]*engine.Target, error) {
+		parents, err := Engine.DAG().GetParents(target)
+		if err != nil {
+			return nil, err
 		}
-		if len(excludeMatchers) > 0 {
-			matcher = engine.AndMatcher(matcher, engine.NotMatcher(engine.OrMatcher(excludeMatchers...)))
+
+		deps := make([]*engine.Target, 0)
+		for _, parent := range parents {
+			if result.Find(parent.FQN) != nil {
+				deps = append(deps, parent)
+			}
 		}
 
-		selected := make([]*tgt.Target, 0)
+		return deps, nil
+	}
+
+	switch queryOutput {
+	case "json":
+		type queryTarget struct {
+			FQN  string
+			Deps []string
+		}
+
+		qts := make([]queryTarget, 0, len(targets))
 		for _, target := range targets {
-			if matcher(target) {
-				selected = append(selected, target.Target)
+			tdeps, err := deps(target)
+			if err != nil {
+				return err
+			}
+
+			qt := queryTarget{FQN: target.FQN, Deps: make([]string, 0, len(tdeps))}
+			for _, dep := range tdeps {
+				qt.Deps = append(qt.Deps, dep.FQN)
 			}
+
+			qts = append(qts, qt)
 		}
 
-		if len(selected) == 0 {
-			return nil
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "    ")
+		return enc.Encode(qts)
+	case "dot":
+		fmt.Fprint(out, dotHeader)
+		for _, target := range targets {
+			fmt.Fprintf(out, "    %v [label=\"%v\"];\n", strconv.Quote(target.FQN), target.FQN)
+
+			tdeps, err := deps(target)
+			if err != nil {
+				return err
+			}
+
+			for _, dep := range tdeps {
+				fmt.Fprintf(out, "    %v -> %v;\n", strconv.Quote(dep.FQN), strconv.Quote(target.FQN))
+			}
 		}
+		fmt.Fprintln(out, "}")
+	default:
+		for _, target := range targets {
+			fmt.Fprintln(out, target.FQN)
+		}
+	}
 
-		fmt.Println(strings.Join(sortedTargetNames(selected, false), "\n"))
-		return nil
-	},
+	return nil
+}
+
+func filterIncludeExclude(targets []*engine.Target, include, exclude []string) []*tgt.Target {
+	includeMatchers := make(engine.TargetMatchers, 0)
+	for _, s := range include {
+		includeMatchers = append(includeMatchers, engine.ParseTargetSelector("", s))
+	}
+	excludeMatchers := make(engine.TargetMatchers, 0)
+	for _, s := range exclude {
+		excludeMatchers = append(excludeMatchers, engine.ParseTargetSelector("", s))
+	}
+
+	matcher := engine.YesMatcher()
+	if len(includeMatchers) > 0 {
+		matcher = engine.OrMatcher(includeMatchers...)
+	}
+	if len(excludeMatchers) > 0 {
+		matcher = engine.AndMatcher(matcher, engine.NotMatcher(engine.OrMatcher(excludeMatchers...)))
+	}
+
+	selected := make([]*tgt.Target, 0)
+	for _, target := range targets {
+		if matcher(target) {
+			selected = append(selected, target.Target)
+		}
+	}
+
+	return selected
 }
 
 var fzfCmd = &cobra.Command{
@@ -191,6 +337,8 @@ var configCmd = &cobra.Command{
 	Short: "Prints config",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		ctx := cmd.Context()
 
 		err := engineInit(ctx)
@@ -203,7 +351,7 @@ var configCmd = &cobra.Command{
 			return err
 		}
 
-		fmt.Println(string(b))
+		fmt.Fprintln(out, string(b))
 
 		return nil
 	},
@@ -214,6 +362,8 @@ var codegenCmd = &cobra.Command{
 	Short: "Prints codegen paths",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		err := preRunWithGen(cmd.Context())
 		if err != nil {
 			return err
@@ -228,7 +378,7 @@ var codegenCmd = &cobra.Command{
 		sort.Strings(paths)
 
 		for _, s := range paths {
-			fmt.Println(s)
+			fmt.Fprintln(out, s)
 		}
 
 		return nil
@@ -241,6 +391,8 @@ var graphCmd = &cobra.Command{
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: ValidArgsFunctionTargets,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		target, err := parseTargetFromArgs(cmd.Context(), args)
 		if err != nil {
 			return err
@@ -251,18 +403,30 @@ var graphCmd = &cobra.Command{
 			return err
 		}
 
-		fmt.Print(ances.String())
+		fmt.Fprint(out, ances.String())
 
 		return nil
 	},
 }
 
+const dotHeader = `
+digraph G  {
+	fontname="Helvetica,Arial,sans-serif"
+	node [fontname="Helvetica,Arial,sans-serif"]
+	edge [fontname="Helvetica,Arial,sans-serif"]
+	rankdir="LR"
+	node [fontsize=10, shape=box, height=0.25]
+	edge [fontsize=10]
+`
+
 var graphDotCmd = &cobra.Command{
 	Use:               "graphdot [ancestors|descendants <target>]",
 	Short:             "Outputs graph do",
 	Args:              cobra.ArbitraryArgs,
 	ValidArgsFunction: ValidArgsFunctionTargets,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		err := engineInit(cmd.Context())
 		if err != nil {
 			return err
@@ -300,15 +464,7 @@ var graphDotCmd = &cobra.Command{
 			}
 		}
 
-		fmt.Printf(`
-digraph G  {
-	fontname="Helvetica,Arial,sans-serif"
-	node [fontname="Helvetica,Arial,sans-serif"]
-	edge [fontname="Helvetica,Arial,sans-serif"]
-	rankdir="LR"
-	node [fontsize=10, shape=box, height=0.25]
-	edge [fontsize=10]
-`)
+		fmt.Fprint(out, dotHeader)
 		id := func(target *engine.Target) string {
 			return strconv.Quote(target.FQN)
 		}
@@ -329,7 +485,7 @@ digraph G  {
 				panic(err)
 			}
 
-			fmt.Printf("    %v [label=\"%v\"%v];\n", id(target), target.FQN, extra)
+			fmt.Fprintf(out, "    %v [label=\"%v\"%v];\n", id(target), target.FQN, extra)
 
 			skip := sets.NewStringSet(0)
 			//for _, tool := range target.Tools.Targets {
@@ -341,12 +497,12 @@ digraph G  {
 					continue
 				}
 
-				fmt.Printf("    %v -> %v;\n", id(ancestor), id(target))
+				fmt.Fprintf(out, "    %v -> %v;\n", id(ancestor), id(target))
 			}
-			fmt.Println()
+			fmt.Fprintln(out)
 		}
 
-		fmt.Println("}")
+		fmt.Fprintln(out, "}")
 
 		return nil
 	},
@@ -367,13 +523,13 @@ var changesCmd = &cobra.Command{
 
 		cmd := exec.Command("git", "--no-pager", "diff", "--name-only", since+"...HEAD")
 		cmd.Dir = Engine.Root.Abs()
-		out, err := cmd.Output()
+		diff, err := cmd.Output()
 		if err != nil {
 			return err
 		}
 
 		affectedTargets := make([]*tgt.Target, 0)
-		affectedFiles := strings.Split(string(out), "\n")
+		affectedFiles := strings.Split(string(diff), "\n")
 
 		allTargets := Engine.Targets.Slice()
 
@@ -391,10 +547,54 @@ var changesCmd = &cobra.Command{
 			}
 		}
 
+		out := c.OutOrStdout()
 		for _, t := range affectedTargets {
-			fmt.Println(t.FQN)
+			fmt.Fprintln(out, t.FQN)
+		}
+
+		return nil
+	},
+}
+
+var affectedCmd = &cobra.Command{
+	Use:   "affected <base>",
+	Short: "Prints the targets affected by the changes since base, including the BUILD files changes",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		err := engineInit(ctx)
+		if err != nil {
+			return err
+		}
+
+		// Generated targets cannot be compared with base, they are affected through their inputs
+		parsed := append([]*engine.Target{}, Engine.Targets.Slice()...)
+
+		err = preRunWithGenWithOpts(ctx, PreRunOpts{
+			Engine:       Engine,
+			PoolWaitName: "Query gen",
+			LinkAll:      true,
+		})
+		if err != nil {
+			return err
+		}
+
+		targets, err := Engine.AffectedSince(ctx, args[0], parsed)
+		if err != nil {
+			return err
+		}
+
+		if !all {
+			targets = engine.FilterPublicTargets(targets)
 		}
 
+		selected := filterIncludeExclude(targets, include, exclude)
+		if len(selected) == 0 {
+			return nil
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), strings.Join(sortedTargetNames(selected, false), "\n"))
 		return nil
 	},
 }
@@ -405,6 +605,8 @@ var targetCmd = &cobra.Command{
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: ValidArgsFunctionTargets,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		ctx := cmd.Context()
 
 		tp, err := targetspec.TargetParse("", args[0])
@@ -423,7 +625,7 @@ var targetCmd = &cobra.Command{
 		}
 
 		if spec {
-			enc := json.NewEncoder(os.Stdout)
+			enc := json.NewEncoder(out)
 			enc.SetEscapeHTML(false)
 			enc.SetIndent("", "    ")
 			if err := enc.Encode(target.TargetSpec); err != nil {
@@ -438,53 +640,65 @@ var targetCmd = &cobra.Command{
 			return err
 		}
 
-		fmt.Println(target.FQN)
+		fmt.Fprintln(out, target.FQN)
 
-		fmt.Println("Transitive:")
-		printTools("    ", target.OwnTransitive.Tools)
-		printDeps("    ", target.OwnTransitive.Deps)
-		fmt.Println("    pass_env:", target.OwnTransitive.PassEnv)
-		fmt.Println("    runtime_pass_env:", target.OwnTransitive.RuntimePassEnv)
+		if len(target.Providers) > 0 {
+			fmt.Fprintln(out, "Providers:")
+			for _, p := range target.Providers {
+				fmt.Fprintf(out, "    %v:\n", p.Name)
+				keys := maps.Keys(p.Fields)
+				sort.Strings(keys)
+				for _, k := range keys {
+					fmt.Fprintf(out, "        %v: %s\n", k, p.Fields[k])
+				}
+			}
+		}
 
-		fmt.Println("Deep Transitive:")
-		printTools("    ", target.DeepOwnTransitive.Tools)
-		printDeps("    ", target.DeepOwnTransitive.Deps)
-		fmt.Println("    pass_env:", target.DeepOwnTransitive.PassEnv)
-		fmt.Println("    runtime_pass_env:", target.DeepOwnTransitive.RuntimePassEnv)
+		fmt.Fprintln(out, "Transitive:")
+		printTools(out, "    ", target.OwnTransitive.Tools)
+		printDeps(out, "    ", target.OwnTransitive.Deps)
+		fmt.Fprintln(out, "    pass_env:", target.OwnTransitive.PassEnv)
+		fmt.Fprintln(out, "    runtime_pass_env:", target.OwnTransitive.RuntimePassEnv)
 
-		fmt.Println("Deps:")
-		printTools("    ", target.Tools)
-		printDeps("    ", target.Deps)
+		fmt.Fprintln(out, "Deep Transitive:")
+		printTools(out, "    ", target.DeepOwnTransitive.Tools)
+		printDeps(out, "    ", target.DeepOwnTransitive.Deps)
+		fmt.Fprintln(out, "    pass_env:", target.DeepOwnTransitive.PassEnv)
+		fmt.Fprintln(out, "    runtime_pass_env:", target.DeepOwnTransitive.RuntimePassEnv)
 
-		fmt.Println("Deps from transitive:")
-		printTools("    ", target.TransitiveDeps.Tools)
-		printDeps("    ", target.TransitiveDeps.Deps)
-		fmt.Println("    pass_env:", target.TransitiveDeps.PassEnv)
-		fmt.Println("    runtime_pass_env:", target.TransitiveDeps.RuntimePassEnv)
+		fmt.Fprintln(out, "Deps:")
+		printTools(out, "    ", target.Tools)
+		printDeps(out, "    ", target.Deps)
+
+		fmt.Fprintln(out, "Deps from transitive:")
+		printTools(out, "    ", target.TransitiveDeps.Tools)
+		printDeps(out, "    ", target.TransitiveDeps.Deps)
+		fmt.Fprintln(out, "    pass_env:", target.TransitiveDeps.PassEnv)
+		fmt.Fprintln(out, "    runtime_pass_env:", target.TransitiveDeps.RuntimePassEnv)
 
 		return nil
 	},
 }
 
-func printDeps(indent string, deps tgt.TargetNamedDeps) {
-	fmt.Println(indent + "Targets:")
+func printDeps(out io.Writer, indent string, deps tgt.TargetNamedDeps) {
+	fmt.Fprintln(out, indent+"Targets:")
 	for _, t := range deps.All().Targets {
-		fmt.Printf(indent+"  %v\n", t.Target.FQN)
+		fmt.Fprintf(out, indent+"  %v\n", t.Target.FQN)
 	}
-	fmt.Println(indent + "Files:")
+	fmt.Fprintln(out, indent+"Files:")
 	for _, t := range deps.All().Files {
-		fmt.Printf(indent+"  %v\n", t.RelRoot())
+		fmt.Fprintf(out, indent+"  %v\n", t.RelRoot())
 	}
 }
 
-func printTools(indent string, tools tgt.TargetTools) {
-	fmt.Println(indent + "Tools:")
+func printTools(out io.Writer, indent string, tools tgt.TargetTools) {
+	fmt.Fprintln(out, indent+"Tools:")
 	for _, t := range tools.Targets {
-		fmt.Printf(indent+"  %v\n", t.Target.FQN)
+		fmt.Fprintf(out, indent+"  %v\n", t.Target.FQN)
 	}
-	fmt.Println(indent + "Host tools:")
+	fmt.Fprintln(out, indent+"Host tools:")
 	for _, t := range tools.Hosts {
-		fmt.Printf(indent+"  %v\n", t.Name)
+		fmt.Fprintf(out, indent+"  %v\n", t.Name)
 	}
 }
 
@@ -493,6 +707,8 @@ var pkgsCmd = &cobra.Command{
 	Short: "Prints pkgs details",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		ctx := cmd.Context()
 
 		err := preRunWithGen(ctx)
@@ -514,9 +730,9 @@ var pkgsCmd = &cobra.Command{
 				fullname = "<root>"
 			}
 
-			fmt.Printf("%v\n", fullname)
-			fmt.Printf("  path: %v\n", p.Root.RelRoot())
-			fmt.Println()
+			fmt.Fprintf(out, "%v\n", fullname)
+			fmt.Fprintf(out, "  path: %v\n", p.Root.RelRoot())
+			fmt.Fprintln(out)
 		}
 		return nil
 	},
@@ -527,6 +743,8 @@ var depsCmd = &cobra.Command{
 	Short: "Prints target dependencies",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		target, err := parseTargetFromArgs(cmd.Context(), args)
 		if err != nil {
 			return err
@@ -552,7 +770,7 @@ var depsCmd = &cobra.Command{
 		sort.Strings(ancestors)
 
 		for _, fqn := range ancestors {
-			fmt.Println(fqn)
+			fmt.Fprintln(out, fqn)
 		}
 
 		return nil
@@ -564,6 +782,8 @@ var revdepsCmd = &cobra.Command{
 	Short: "Prints targets dependent on the input",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		ctx := cmd.Context()
 
 		err := preRunWithGenWithOpts(ctx, PreRunOpts{
@@ -604,14 +824,14 @@ var revdepsCmd = &cobra.Command{
 		sort.Strings(descendants)
 
 		for _, fqn := range descendants {
-			fmt.Println(fqn)
+			fmt.Fprintln(out, fqn)
 		}
 
 		return nil
 	},
 }
 
-func printTargetOutput(target *engine.Target, output string) error {
+func printTargetOutput(out io.Writer, target *engine.Target, output string) error {
 	paths := target.ActualOutFiles().All()
 	if output != "" {
 		if !target.ActualOutFiles().HasName(output) {
@@ -623,7 +843,7 @@ func printTargetOutput(target *engine.Target, output string) error {
 	}
 
 	for _, path := range paths {
-		fmt.Println(path.Abs())
+		fmt.Fprintln(out, path.Abs())
 	}
 	return nil
 }
@@ -644,12 +864,12 @@ var outCmd = &cobra.Command{
 			return err
 		}
 
-		err = run(ctx, Engine, []engine.TargetRunRequest{{Target: target, NoCache: *nocache}}, false)
+		err = run(ctx, Engine, []engine.TargetRunRequest{{Target: target, NoCache: *nocache}}, false, commandIO(cmd))
 		if err != nil {
 			return err
 		}
 
-		err = printTargetOutput(target, output)
+		err = printTargetOutput(cmd.OutOrStdout(), target, output)
 		if err != nil {
 			return err
 		}
@@ -665,18 +885,20 @@ var cacheRootCmd = &cobra.Command{
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: ValidArgsFunctionTargets,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		ctx := cmd.Context()
 		target, err := parseTargetFromArgs(ctx, args)
 		if err != nil {
 			return err
 		}
 
-		err = run(ctx, Engine, []engine.TargetRunRequest{{Target: target, NoCache: *nocache}}, false)
+		err = run(ctx, Engine, []engine.TargetRunRequest{{Target: target, NoCache: *nocache}}, false, commandIO(cmd))
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(filepath.Dir(target.OutExpansionRoot.Abs()))
+		fmt.Fprintln(out, filepath.Dir(target.OutExpansionRoot.Abs()))
 
 		return nil
 	},
@@ -689,12 +911,14 @@ var parseTargetCmd = &cobra.Command{
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: ValidArgsFunctionTargets,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		tp, err := targetspec.TargetOutputParse("", args[0])
 		if err != nil {
 			return err
 		}
 
-		enc := json.NewEncoder(os.Stdout)
+		enc := json.NewEncoder(out)
 		enc.SetIndent("", "    ")
 		err = enc.Encode(tp)
 		if err != nil {
@@ -711,32 +935,101 @@ var hashoutCmd = &cobra.Command{
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: ValidArgsFunctionTargets,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		ctx := cmd.Context()
 		target, err := parseTargetFromArgs(ctx, args)
 		if err != nil {
 			return err
 		}
 
-		err = run(ctx, Engine, []engine.TargetRunRequest{{Target: target, NoCache: *nocache}}, false)
+		err = run(ctx, Engine, []engine.TargetRunRequest{{Target: target, NoCache: *nocache}}, false, commandIO(cmd))
 		if err != nil {
 			return err
 		}
 
 		names := targetspec.SortOutputsForHashing(target.ActualOutFiles().Names())
+		if !verify {
+			for _, name := range names {
+				fmt.Fprintln(out, name+":", Engine.HashOutput(target, name))
+			}
+
+			return nil
+		}
+
+		first, err := outputDigests(target, names)
+		if err != nil {
+			return err
+		}
+
+		err = Engine.ResetOutputArchives(target)
+		if err != nil {
+			return err
+		}
+
+		err = run(ctx, Engine, []engine.TargetRunRequest{{Target: target, NoCache: true}}, false, commandIO(cmd))
+		if err != nil {
+			return err
+		}
+
+		second, err := outputDigests(target, names)
+		if err != nil {
+			return err
+		}
+
+		reproducible := true
 		for _, name := range names {
-			fmt.Println(name+":", Engine.HashOutput(target, name))
+			a, b := first[name], second[name]
+			switch {
+			case a.Hash != b.Hash:
+				reproducible = false
+				fmt.Fprintf(out, "%v: %v != %v\n", name, a.Hash, b.Hash)
+			case a.Archive != b.Archive:
+				reproducible = false
+				fmt.Fprintf(out, "%v: %v (archive %v != %v)\n", name, a.Hash, a.Archive, b.Archive)
+			default:
+				fmt.Fprintln(out, name+":", a.Hash)
+			}
+		}
+
+		if !reproducible {
+			return fmt.Errorf("%v outputs are not reproducible", target.FQN)
 		}
 
 		return nil
 	},
 }
 
+type outputDigest struct {
+	Hash    string
+	Archive string
+}
+
+func outputDigests(target *engine.Target, names []string) (map[string]outputDigest, error) {
+	digests := map[string]outputDigest{}
+	for _, name := range names {
+		archive, err := Engine.OutputArchiveDigest(target, name)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+
+		digests[name] = outputDigest{
+			Hash:    Engine.HashOutput(target, name),
+			Archive: archive,
+		}
+	}
+
+	return digests, nil
+}
+
 var hashinCmd = &cobra.Command{
 	Use:               "hashin <target>",
 	Short:             "Prints targets input hash",
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: ValidArgsFunctionTargets,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		ctx := cmd.Context()
 
 		target, err := parseTargetFromArgs(ctx, args)
@@ -754,7 +1047,74 @@ var hashinCmd = &cobra.Command{
 			return err
 		}
 
-		fmt.Println(Engine.HashInput(target))
+		fmt.Fprintln(out, Engine.HashInput(target))
+
+		return nil
+	},
+}
+
+var explainHashCmd = &cobra.Command{
+	Use:   "explain-hash <target> [previous hash]",
+	Short: "Explains why the input hash of a target changed",
+	Long: `Compares the components of the input hash of a target with the ones recorded in the cache entry of a previous hash,
+the latest local entry by default, or the one of the cache named by --remote`,
+	Args:              cobra.RangeArgs(1, 2),
+	ValidArgsFunction: ValidArgsFunctionTargets,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
+		ctx := cmd.Context()
+
+		target, err := parseTargetFromArgs(ctx, args[:1])
+		if err != nil {
+			return err
+		}
+
+		var previousHash string
+		if len(args) > 1 {
+			previousHash = args[1]
+		}
+
+		tdeps, err := Engine.ScheduleTargetsWithDeps(ctx, []*engine.Target{target}, target)
+		if err != nil {
+			return err
+		}
+
+		err = WaitPool("Run", Engine.Pool, tdeps.All())
+		if err != nil {
+			return err
+		}
+
+		manifest, err := Engine.CachedManifest(target, previousHash, explainRemote)
+		if err != nil {
+			return fmt.Errorf("previous hash: %w", err)
+		}
+
+		if len(manifest.InputComponents) == 0 {
+			return fmt.Errorf("the cache entry of %v has no input components recorded", manifest.InputHash)
+		}
+
+		currentHash := Engine.HashInput(target)
+
+		fmt.Fprintln(out, "previous:", manifest.InputHash)
+		fmt.Fprintln(out, "current: ", currentHash)
+
+		diffs := engine.DiffHashInputComponents(manifest.InputComponents, Engine.HashInputComponents(target))
+		if len(diffs) == 0 {
+			fmt.Fprintln(out, "no component changed")
+			return nil
+		}
+
+		for _, diff := range diffs {
+			switch {
+			case diff.Previous == "":
+				fmt.Fprintf(out, "added   %v\n", diff.Name)
+			case diff.Current == "":
+				fmt.Fprintf(out, "removed %v\n", diff.Name)
+			default:
+				fmt.Fprintf(out, "changed %v: %v -> %v\n", diff.Name, diff.Previous, diff.Current)
+			}
+		}
 
 		return nil
 	},
@@ -765,6 +1125,8 @@ var labelsCmd = &cobra.Command{
 	Short: "Prints labels",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		err := preRunWithGen(cmd.Context())
 		if err != nil {
 			return err
@@ -774,7 +1136,49 @@ var labelsCmd = &cobra.Command{
 		sort.Strings(labels)
 
 		for _, label := range labels {
-			fmt.Println(label)
+			fmt.Fprintln(out, label)
+		}
+
+		return nil
+	},
+}
+
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Prints the rules declared with rule() and their attributes",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
+		err := preRunWithGen(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		for _, r := range Engine.Rules() {
+			fmt.Fprintf(out, "%v (%v)\n", r.Name, r.File)
+			if r.Doc != "" {
+				fmt.Fprintf(out, "  %v\n", r.Doc)
+			}
+
+			for _, a := range r.Attrs {
+				details := []string{a.Type}
+				if a.Mandatory {
+					details = append(details, "mandatory")
+				} else {
+					details = append(details, "default: "+a.Default)
+				}
+				if len(a.Values) > 0 {
+					details = append(details, "one of: "+strings.Join(a.Values, ", "))
+				}
+
+				fmt.Fprintf(out, "  %v (%v)", a.Name, strings.Join(details, ", "))
+				if a.Doc != "" {
+					fmt.Fprintf(out, ": %v", a.Doc)
+				}
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintln(out)
 		}
 
 		return nil
@@ -786,6 +1190,8 @@ var outRootCmd = &cobra.Command{
 	Short: "Prints repo root",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		ctx := cmd.Context()
 
 		err := engineInit(ctx)
@@ -793,7 +1199,7 @@ var outRootCmd = &cobra.Command{
 			return err
 		}
 
-		fmt.Println(Engine.Root.Abs())
+		fmt.Fprintln(out, Engine.Root.Abs())
 
 		return nil
 	},
@@ -804,6 +1210,8 @@ var orderedCachesCmd = &cobra.Command{
 	Short: "Prints ordered caches",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
 		ctx := cmd.Context()
 
 		err := engineInit(ctx)
@@ -817,7 +1225,7 @@ var orderedCachesCmd = &cobra.Command{
 		}
 
 		for _, cache := range orderedCaches {
-			fmt.Println(cache.Name, cache.URI)
+			fmt.Fprintln(out, cache.Name, cache.URI)
 		}
 
 		return nil
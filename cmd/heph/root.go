@@ -6,6 +6,7 @@ import (
 	"github.com/spf13/cobra"
 	"heph/config"
 	"heph/engine"
+	"heph/engine/buildevents"
 	log "heph/hlog"
 	"heph/utils"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -31,11 +33,18 @@ var noInline *bool
 var printOutput boolStr
 var ignore *[]string
 var nocache *bool
+var auditInputs *bool
+var buildEvents *string
 var params *[]string
 var summary *bool
 var summaryGen *bool
 var jaegerEndpoint *string
 var ignoreUnknownTarget *bool
+var gcReport *bool
+var gcDryRun *bool
+var gcRemote *string
+var gcKeep *int
+var gcMaxAge *string
 
 func init() {
 	if os.Stderr != nil {
@@ -52,10 +61,18 @@ func init() {
 
 	shell = runCmd.Flags().Bool("shell", false, "Opens a shell with the environment setup")
 	noInline = runCmd.Flags().Bool("no-inline", false, "Force running in workers")
+	auditInputs = runCmd.Flags().Bool("audit-inputs", false, "Traces the files read by the targets, and reports the undeclared ones (linux only)")
+	buildEvents = runCmd.Flags().String("build-events", "", "Streams execution events as newline-delimited JSON to a file or unix socket")
 	runCmd.Flags().AddFlag(NewBoolStrFlag(&printOutput, "print-out", "o", "Prints target output, --print-out=<name> to filter output"))
 
 	ignore = watchCmd.Flags().StringArray("ignore", nil, "Ignore files, supports glob")
 
+	gcReport = gcCmd.Flags().Bool("report", false, "Prints the biggest consumers of the local cache, without deleting anything")
+	gcDryRun = gcCmd.Flags().Bool("dry-run", false, "Prints what would be deleted, without deleting anything")
+	gcRemote = gcCmd.Flags().String("remote", "", "Name of the remote cache to collect, instead of the local cache")
+	gcKeep = gcCmd.Flags().Int("keep", 0, "With --remote, number of entries to keep per target, overrides the cache gc.keep")
+	gcMaxAge = gcCmd.Flags().String("max-age", "", "With --remote, deletes the entries older than this (ex: 30d), overrides the cache gc.max_age")
+
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(watchCmd)
 	rootCmd.AddCommand(cleanCmd)
@@ -88,6 +105,8 @@ var cpuProfileFile *os.File
 
 var Engine *engine.Engine
 
+var postRunOnce sync.Once
+
 func postRun() {
 	defer func() {
 		if cpuProfileFile != nil {
@@ -160,6 +179,15 @@ var rootCmd = &cobra.Command{
 			switchToPorcelain()
 		}
 
+		if daemonServing != nil {
+			err := daemonServing.preRun(cmd)
+			if err != nil {
+				return err
+			}
+		} else if ok, err := runInDaemon(cmd, args); ok || err != nil {
+			return err
+		}
+
 		if *cpuprofile != "" {
 			cpuProfileFile, err = os.Create(*cpuprofile)
 			if err != nil {
@@ -170,7 +198,10 @@ var rootCmd = &cobra.Command{
 			}
 		}
 
-		cobra.OnFinalize(postRun)
+		// The daemon executes the command once per request
+		postRunOnce.Do(func() {
+			cobra.OnFinalize(postRun)
+		})
 
 		return nil
 	},
@@ -209,7 +240,38 @@ var runCmd = &cobra.Command{
 			return nil
 		}
 
-		err = run(cmd.Context(), Engine, rrs, !fromStdin)
+		if *buildEvents != "" {
+			path := *buildEvents
+			if !filepath.IsAbs(path) {
+				// The daemon serves requests from any directory
+				path = filepath.Join(Engine.Cwd, path)
+			}
+
+			w, err := buildevents.Open(path)
+			if err != nil {
+				return err
+			}
+			Engine.BuildEvents = w
+
+			// Uploads keep going in the background after the run
+			Engine.RegisterExitHandler(func() {
+				_ = w.Close()
+			})
+		}
+
+		if *auditInputs {
+			// Targets must run to be audited
+			for i := range rrs {
+				rrs[i].NoCache = true
+			}
+			Engine.AuditInputs = true
+
+			defer func() {
+				PrintInputsAudits(Engine.InputsAudits())
+			}()
+		}
+
+		err = run(cmd.Context(), Engine, rrs, !fromStdin, commandIO(cmd))
 		if err != nil {
 			return err
 		}
@@ -283,10 +345,79 @@ var gcCmd = &cobra.Command{
 			return err
 		}
 
-		return Engine.GC(cmd.Context(), log.Infof, false)
+		if *gcReport {
+			return printGCReport()
+		}
+
+		if *gcRemote != "" {
+			return gcRemoteCache(cmd)
+		}
+
+		return Engine.GC(cmd.Context(), log.Infof, *gcDryRun)
 	},
 }
 
+func gcRemoteCache(cmd *cobra.Command) error {
+	var opts engine.RemoteGCOptions
+	for _, cache := range Engine.Config.Caches {
+		if cache.Name == *gcRemote {
+			opts.Keep = cache.GC.Keep
+			opts.MaxAge = cache.GC.MaxAge
+		}
+	}
+
+	if cmd.Flags().Changed("keep") {
+		opts.Keep = *gcKeep
+	}
+
+	if cmd.Flags().Changed("max-age") {
+		age, err := utils.ParseAge(*gcMaxAge)
+		if err != nil {
+			return fmt.Errorf("max-age: %w", err)
+		}
+		opts.MaxAge = age
+	}
+
+	opts.DryRun = *gcDryRun
+
+	return Engine.GCRemote(cmd.Context(), *gcRemote, opts, log.Infof)
+}
+
+const gcReportTop = 20
+
+func printGCReport() error {
+	entries, err := Engine.GCReport()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	for i, entry := range entries {
+		if i >= gcReportTop {
+			fmt.Printf("... %v more\n", len(entries)-i)
+			break
+		}
+
+		fmt.Printf("%8v  %3v  %v  %v\n", utils.FormatSize(entry.Size), entry.Entries, entry.LastAccess.Format(time.RFC3339), entry.Dir)
+	}
+
+	fmt.Printf("total: %v\n", utils.FormatSize(total))
+
+	cfg := Engine.Config.Engine.GC
+	if cfg.MaxSize > 0 {
+		fmt.Printf("max_size: %v\n", utils.FormatSize(cfg.MaxSize))
+	}
+	if cfg.MaxAge > 0 {
+		fmt.Printf("max_age: %v\n", cfg.MaxAge)
+	}
+
+	return nil
+}
+
 var cleanLockCmd = &cobra.Command{
 	Use:   "lock",
 	Short: "Clean locks",
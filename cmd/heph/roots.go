@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var offline *bool
+
+func init() {
+	rootsCmd.AddCommand(rootsUpdateCmd)
+	rootCmd.AddCommand(rootsCmd)
+
+	offline = rootCmd.PersistentFlags().Bool("offline", false, "Do not fetch roots, use the local copies")
+}
+
+var rootsCmd = &cobra.Command{
+	Use:   "roots",
+	Short: "Manage the roots",
+}
+
+var rootsUpdateCmd = &cobra.Command{
+	Use:   "update [root...]",
+	Short: "Fetch the roots and pin them in heph.lock",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		e, err := engineFactory()
+		if err != nil {
+			return err
+		}
+
+		// Not parsing, the BUILD files could depend on roots not matching the lock
+		e.Config.Profiles = *profiles
+		e.Offline = *offline
+
+		err = e.Init(ctx)
+		if err != nil {
+			return err
+		}
+
+		return e.UpdateRootsLock(ctx, args)
+	},
+}
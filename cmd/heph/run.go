@@ -2,14 +2,13 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"github.com/spf13/cobra"
 	"heph/engine"
 	log "heph/hlog"
+	"heph/platform"
 	"heph/sandbox"
 	"heph/worker"
-	"os"
-	"os/exec"
 )
 
 type ErrorWithExitCode struct {
@@ -25,11 +24,20 @@ func (e ErrorWithExitCode) Unwrap() error {
 	return e.Err
 }
 
-func run(ctx context.Context, e *engine.Engine, rrs engine.TargetRunRequests, inlineSingle bool) error {
-	return runMode(ctx, e, rrs, inlineSingle, "")
+// commandIO returns the streams of the command, the ones of the client when served by the daemon
+func commandIO(cmd *cobra.Command) sandbox.IOConfig {
+	return sandbox.IOConfig{
+		Stdin:  cmd.InOrStdin(),
+		Stdout: cmd.OutOrStdout(),
+		Stderr: cmd.ErrOrStderr(),
+	}
+}
+
+func run(ctx context.Context, e *engine.Engine, rrs engine.TargetRunRequests, inlineSingle bool, iocfg sandbox.IOConfig) error {
+	return runMode(ctx, e, rrs, inlineSingle, "", iocfg)
 }
 
-func runMode(ctx context.Context, e *engine.Engine, rrs engine.TargetRunRequests, inlineSingle bool, mode string) error {
+func runMode(ctx context.Context, e *engine.Engine, rrs engine.TargetRunRequests, inlineSingle bool, mode string, iocfg sandbox.IOConfig) error {
 	shellCount := rrs.Count(func(rr engine.TargetRunRequest) bool {
 		return rr.Shell
 	})
@@ -77,10 +85,12 @@ func runMode(ctx context.Context, e *engine.Engine, rrs engine.TargetRunRequests
 		return err
 	}
 
+	startBackgroundGC(e)
+
 	if inlineInvocationTarget == nil {
 		if printOutput.bool {
 			for _, target := range rrs.Targets() {
-				err = printTargetOutput(target, printOutput.str)
+				err = printTargetOutput(iocfg.Stdout, target, printOutput.str)
 				if err != nil {
 					return err
 				}
@@ -94,23 +104,18 @@ func runMode(ctx context.Context, e *engine.Engine, rrs engine.TargetRunRequests
 		log.Info(s.String(isTerm))
 	})
 
-	cfg := sandbox.IOConfig{
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
-		Stdin:  os.Stdin,
-	}
+	cfg := iocfg
 	if printOutput.bool {
 		log.Debugf("Redirecting stdout to stderr")
-		cfg.Stdout = os.Stderr
+		cfg.Stdout = iocfg.Stderr
 	}
 
 	err = re.Run(ctx, *inlineInvocationTarget, cfg)
 	if err != nil {
-		var eerr *exec.ExitError
-		if errors.As(err, &eerr) {
+		if code, ok := platform.ExitCode(err); ok {
 			return ErrorWithExitCode{
 				Err:      err,
-				ExitCode: eerr.ExitCode(),
+				ExitCode: code,
 			}
 		}
 
@@ -118,7 +123,7 @@ func runMode(ctx context.Context, e *engine.Engine, rrs engine.TargetRunRequests
 	}
 
 	if printOutput.bool {
-		err = printTargetOutput(inlineTarget, printOutput.str)
+		err = printTargetOutput(iocfg.Stdout, inlineTarget, printOutput.str)
 		if err != nil {
 			return err
 		}
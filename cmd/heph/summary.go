@@ -1,12 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"github.com/olekukonko/tablewriter"
+	"heph/engine"
 	"heph/engine/htrace"
+	log "heph/hlog"
+	"heph/testresults"
 	"heph/utils"
 	"heph/utils/sets"
-	"os"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 func summarySpanString(phases ...*htrace.TargetStatsSpan) string {
@@ -141,7 +146,7 @@ func PrintSummary(stats *htrace.Stats, withGen bool) {
 		}
 	}
 
-	table := tablewriter.NewWriter(os.Stderr)
+	table := tablewriter.NewWriter(log.Writer())
 	table.SetAutoFormatHeaders(false)
 	table.SetAutoWrapText(false)
 	table.SetHeader([]string{"Target", "Cache Pull", "Prepare", "Exec", "Collect Output", "Cache Store", "Total"})
@@ -152,3 +157,80 @@ func PrintSummary(stats *htrace.Stats, withGen bool) {
 	table.AppendBulk(data)
 	table.Render()
 }
+
+func PrintInputsAudits(audits []engine.InputsAudit) {
+	data := make([][]string, 0)
+	for _, audit := range audits {
+		if audit.Skipped {
+			data = append(data, []string{audit.Target.FQN, "skipped, runs in heph.sandbox()"})
+			continue
+		}
+
+		data = append(data, []string{audit.Target.FQN, strconv.Itoa(len(audit.Undeclared))})
+
+		for _, p := range audit.Undeclared {
+			data = append(data, []string{"  |" + p, ""})
+		}
+	}
+
+	table := tablewriter.NewWriter(log.Writer())
+	table.SetAutoFormatHeaders(false)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Target", "Undeclared inputs"})
+	table.SetBorder(true)
+	table.AppendBulk(data)
+	table.Render()
+}
+
+// PrintTestResults prints the failed cases and flaky targets followed by a table of counts per target, it returns the count of failed targets
+func PrintTestResults(suites []testresults.Suite) int {
+	failedSuites := 0
+	var passed, failed, skipped int
+	data := make([][]string, 0)
+	for _, suite := range suites {
+		sp, sf, ss := suite.Count(testresults.StatusPassed), suite.Count(testresults.StatusFailed), suite.Count(testresults.StatusSkipped)
+		passed += sp
+		failed += sf
+		skipped += ss
+
+		status := "PASS"
+		if sf > 0 {
+			status = "FAIL"
+			failedSuites++
+		} else if suite.Flaky() {
+			status = "FLAKY"
+			fmt.Fprintf(log.Writer(), "--- FLAKY %v: passed after %v attempts\n", suite.Name, suite.Attempts)
+		} else if suite.Cached {
+			status = "CACHED"
+		}
+
+		data = append(data, []string{status, suite.Name, strconv.Itoa(sp), strconv.Itoa(sf), strconv.Itoa(ss)})
+
+		for _, c := range suite.Cases {
+			if c.Status != testresults.StatusFailed {
+				continue
+			}
+
+			name := c.Name
+			if c.Classname != "" && c.Classname != c.Name {
+				name = c.Classname + " " + c.Name
+			}
+
+			fmt.Fprintf(log.Writer(), "--- FAIL %v: %v\n", suite.Name, name)
+			if output := strings.TrimSpace(c.Output); output != "" {
+				fmt.Fprintf(log.Writer(), "    %v\n", strings.ReplaceAll(output, "\n", "\n    "))
+			}
+		}
+	}
+
+	table := tablewriter.NewWriter(log.Writer())
+	table.SetAutoFormatHeaders(false)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"", "Target", "Passed", "Failed", "Skipped"})
+	table.SetFooter([]string{"", fmt.Sprintf("%v targets", len(suites)), strconv.Itoa(passed), strconv.Itoa(failed), strconv.Itoa(skipped)})
+	table.SetBorder(true)
+	table.AppendBulk(data)
+	table.Render()
+
+	return failedSuites
+}
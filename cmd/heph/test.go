@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"hash/fnv"
+	"heph/engine"
+	log "heph/hlog"
+	"heph/testresults"
+	"heph/worker"
+	"os"
+	"sort"
+)
+
+var junitReport string
+var testRetries int
+var shardIndex int
+var shardCount int
+
+func init() {
+	rootCmd.AddCommand(testCmd)
+
+	testCmd.Flags().StringVar(&junitReport, "junit", "", "Writes the aggregated results as a JUnit XML report")
+	testCmd.Flags().IntVar(&testRetries, "retries", 0, "Reruns failing test targets up to N times, the ones passing are reported as flaky")
+	testCmd.Flags().IntVar(&shardIndex, "shard-index", 0, "Index of the shard of test targets to run, from 0 to --shard-count-1")
+	testCmd.Flags().IntVar(&shardCount, "shard-count", 0, "Splits the test targets in N shards")
+}
+
+var testCmd = &cobra.Command{
+	Use:               "test [selector...]",
+	Aliases:           []string{"t"},
+	Short:             "Run test targets, defaults to //...",
+	SilenceUsage:      true,
+	SilenceErrors:     true,
+	ValidArgsFunction: ValidArgsFunctionTargets,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		if len(args) == 0 {
+			args = []string{"//..."}
+		}
+
+		err := engineInit(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = preRunWithGenWithOpts(ctx, PreRunOpts{
+			Engine: Engine,
+		})
+		if err != nil {
+			return err
+		}
+
+		targets, err := shardTestTargets(selectTestTargets(args), shardIndex, shardCount)
+		if err != nil {
+			return err
+		}
+
+		if len(targets) == 0 {
+			log.Infof("No test targets matching %v", args)
+			return nil
+		}
+
+		Engine.TestPassCache = true
+
+		suites, err := runTests(ctx, Engine, targets, testRetries)
+		if err != nil {
+			return err
+		}
+
+		if junitReport != "" {
+			err := writeJUnitReport(junitReport, suites)
+			if err != nil {
+				return err
+			}
+		}
+
+		failed := PrintTestResults(suites)
+		if failed > 0 {
+			return ErrorWithExitCode{
+				Err:      fmt.Errorf("%v test targets failed", failed),
+				ExitCode: 1,
+			}
+		}
+
+		return nil
+	},
+}
+
+func selectTestTargets(selectors []string) []*engine.Target {
+	matchers := make(engine.TargetMatchers, 0, len(selectors))
+	for _, s := range selectors {
+		matchers = append(matchers, engine.ParseTargetSelector("", s))
+	}
+	matcher := engine.OrMatcher(matchers...)
+
+	targets := make([]*engine.Target, 0)
+	for _, target := range engine.FilterPublicTargets(Engine.Targets.Slice()) {
+		if target.IsTest() && matcher(target) {
+			targets = append(targets, target)
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].FQN < targets[j].FQN
+	})
+
+	return targets
+}
+
+// shardTestTargets returns the targets of the shard, a target is assigned by the hash of its FQN
+// so that adding a target does not move the others across shards
+func shardTestTargets(targets []*engine.Target, index, count int) ([]*engine.Target, error) {
+	if count <= 1 {
+		if index != 0 {
+			return nil, fmt.Errorf("--shard-index requires --shard-count")
+		}
+
+		return targets, nil
+	}
+
+	if index < 0 || index >= count {
+		return nil, fmt.Errorf("--shard-index must be between 0 and %v", count-1)
+	}
+
+	shard := make([]*engine.Target, 0)
+	for _, target := range targets {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(target.FQN))
+
+		if h.Sum32()%uint32(count) == uint32(index) {
+			shard = append(shard, target)
+		}
+	}
+
+	return shard, nil
+}
+
+// runTests runs the targets, rerunning the failing ones up to retries times
+func runTests(ctx context.Context, e *engine.Engine, targets []*engine.Target, retries int) ([]testresults.Suite, error) {
+	return retryTests(targets, retries, *nocache, func(targets []*engine.Target, noCache bool) ([]testresults.Suite, error) {
+		return runTestsAttempt(ctx, e, targets, noCache)
+	}, e.StoreTestPass)
+}
+
+type testAttemptFunc func(targets []*engine.Target, noCache bool) ([]testresults.Suite, error)
+
+// retryTests runs the targets through run, then the failing ones again up to retries times,
+// the passes that did not come from the cache are recorded through storePass
+func retryTests(targets []*engine.Target, retries int, noCache bool, run testAttemptFunc, storePass func(*engine.Target, testresults.Suite) error) ([]testresults.Suite, error) {
+	results := make(map[string]testresults.Suite, len(targets))
+
+	pending := targets
+	for attempt := 1; ; attempt++ {
+		// Retries must not be served from the cache
+		suites, err := run(pending, noCache || attempt > 1)
+		if err != nil {
+			return nil, err
+		}
+
+		failed := make([]*engine.Target, 0)
+		for i, suite := range suites {
+			target := pending[i]
+
+			suite.Attempts = attempt
+			results[target.FQN] = suite
+
+			if suite.Count(testresults.StatusFailed) > 0 {
+				failed = append(failed, target)
+				continue
+			}
+
+			if !suite.Cached {
+				err := storePass(target, suite)
+				if err != nil {
+					log.Errorf("%v: store test pass: %v", target.FQN, err)
+				}
+			}
+		}
+
+		if len(failed) == 0 || attempt > retries {
+			break
+		}
+
+		log.Warnf("Retrying %v failed test targets (%v/%v)", len(failed), attempt, retries)
+		pending = failed
+	}
+
+	suites := make([]testresults.Suite, 0, len(targets))
+	for _, target := range targets {
+		suites = append(suites, results[target.FQN])
+	}
+
+	return suites, nil
+}
+
+func runTestsAttempt(ctx context.Context, e *engine.Engine, targets []*engine.Target, noCache bool) ([]testresults.Suite, error) {
+	rrs := make(engine.TargetRunRequests, 0, len(targets))
+	for _, target := range targets {
+		err := e.LinkTarget(target, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		rrs = append(rrs, engine.TargetRunRequest{
+			Target:  target,
+			NoCache: noCache,
+		})
+	}
+
+	ctx, fgDeps := engine.ContextWithForegroundWaitGroup(ctx)
+	fgDeps.AddSem()
+
+	tdepsMap, err := e.ScheduleTargetRRsWithDeps(ctx, rrs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tdeps := tdepsMap.All()
+	go func() {
+		<-tdeps.Done()
+		fgDeps.DoneSem()
+	}()
+
+	runDeps := &worker.WaitGroup{}
+	runDeps.AddChild(tdeps)
+	runDeps.AddChild(fgDeps)
+
+	// Test failures are reported per target below
+	_ = WaitPool("Test", e.Pool, runDeps)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	suites := make([]testresults.Suite, 0, len(targets))
+	for _, target := range targets {
+		if suite, ok := e.TestCacheHit(target); ok && suite != nil {
+			suite.Cached = true
+			suites = append(suites, *suite)
+			continue
+		}
+
+		suite, err := targetTestSuite(e, target, tdepsMap.Get(target.FQN).Err())
+		if err != nil {
+			return nil, err
+		}
+
+		_, suite.Cached = e.TestCacheHit(target)
+
+		suites = append(suites, suite)
+	}
+
+	return suites, nil
+}
+
+// targetTestSuite builds the suite from the test_results output, or from the run error when the target has none
+func targetTestSuite(e *engine.Engine, target *engine.Target, runErr error) (testresults.Suite, error) {
+	suite := testresults.Suite{Name: target.FQN}
+
+	for _, file := range e.TestResultsFiles(target) {
+		cases, err := testresults.ParseFile(file.Abs())
+		if err != nil {
+			return suite, fmt.Errorf("%v: %w", target.FQN, err)
+		}
+
+		suite.Cases = append(suite.Cases, cases...)
+	}
+
+	for i, c := range suite.Cases {
+		if c.Classname == "" {
+			suite.Cases[i].Classname = target.FQN
+		}
+		suite.Duration += c.Duration
+	}
+
+	if len(suite.Cases) > 0 {
+		if runErr != nil && suite.Count(testresults.StatusFailed) == 0 {
+			// The results do not explain the failure, surface it
+			suite.Cases = append(suite.Cases, testresults.Case{
+				Name:      target.FQN,
+				Classname: target.FQN,
+				Status:    testresults.StatusFailed,
+				Output:    runErr.Error(),
+			})
+		}
+
+		return suite, nil
+	}
+
+	c := testresults.Case{
+		Name:      target.FQN,
+		Classname: target.FQN,
+		Status:    testresults.StatusPassed,
+	}
+	if runErr != nil {
+		c.Status = testresults.StatusFailed
+		c.Output = runErr.Error()
+	}
+	suite.Cases = []testresults.Case{c}
+
+	return suite, nil
+}
+
+func writeJUnitReport(path string, suites []testresults.Suite) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = testresults.WriteJUnit(f, suites)
+	if err != nil {
+		return fmt.Errorf("junit: %w", err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"heph/engine"
+	"heph/targetspec"
+	"heph/testresults"
+	"heph/tgt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testTarget(fqn string) *engine.Target {
+	return &engine.Target{Target: &tgt.Target{TargetSpec: targetspec.TargetSpec{FQN: fqn, Labels: []string{targetspec.TestLabel}}}}
+}
+
+func testSuite(fqn string, status testresults.Status) testresults.Suite {
+	return testresults.Suite{Name: fqn, Cases: []testresults.Case{{Name: fqn, Status: status}}}
+}
+
+func TestRetryTests(t *testing.T) {
+	cached := testTarget("//:cached")
+	stable := testTarget("//:stable")
+	flaky := testTarget("//:flaky")
+	broken := testTarget("//:broken")
+
+	type attempt struct {
+		fqns    []string
+		noCache bool
+	}
+	attempts := make([]attempt, 0)
+
+	run := func(targets []*engine.Target, noCache bool) ([]testresults.Suite, error) {
+		a := attempt{noCache: noCache}
+		suites := make([]testresults.Suite, 0, len(targets))
+		for _, target := range targets {
+			a.fqns = append(a.fqns, target.FQN)
+
+			switch target {
+			case cached:
+				suite := testSuite(target.FQN, testresults.StatusPassed)
+				suite.Cached = true
+				suites = append(suites, suite)
+			case flaky:
+				if len(attempts) == 0 {
+					suites = append(suites, testSuite(target.FQN, testresults.StatusFailed))
+				} else {
+					suites = append(suites, testSuite(target.FQN, testresults.StatusPassed))
+				}
+			case broken:
+				suites = append(suites, testSuite(target.FQN, testresults.StatusFailed))
+			default:
+				suites = append(suites, testSuite(target.FQN, testresults.StatusPassed))
+			}
+		}
+		attempts = append(attempts, a)
+
+		return suites, nil
+	}
+
+	stored := make([]string, 0)
+	storePass := func(target *engine.Target, suite testresults.Suite) error {
+		stored = append(stored, target.FQN)
+		return nil
+	}
+
+	suites, err := retryTests([]*engine.Target{cached, stable, flaky, broken}, 2, false, run, storePass)
+	require.NoError(t, err)
+
+	// Retries bypass the cache, and only rerun what failed
+	assert.Equal(t, []attempt{
+		{fqns: []string{"//:cached", "//:stable", "//:flaky", "//:broken"}},
+		{fqns: []string{"//:flaky", "//:broken"}, noCache: true},
+		{fqns: []string{"//:broken"}, noCache: true},
+	}, attempts)
+
+	require.Len(t, suites, 4)
+	assert.True(t, suites[0].Cached)
+	assert.Equal(t, 1, suites[1].Attempts)
+	assert.False(t, suites[1].Flaky())
+	assert.Equal(t, 2, suites[2].Attempts)
+	assert.True(t, suites[2].Flaky())
+	assert.Equal(t, 3, suites[3].Attempts)
+	assert.False(t, suites[3].Flaky())
+
+	// Cached and failed results are not recorded as passes
+	assert.Equal(t, []string{"//:stable", "//:flaky"}, stored)
+
+	assert.Equal(t, 1, PrintTestResults(suites))
+}
+
+func TestRetryTestsNoRetries(t *testing.T) {
+	broken := testTarget("//:broken")
+
+	calls := 0
+	suites, err := retryTests([]*engine.Target{broken}, 0, true, func(targets []*engine.Target, noCache bool) ([]testresults.Suite, error) {
+		calls++
+		assert.True(t, noCache)
+		return []testresults.Suite{testSuite(broken.FQN, testresults.StatusFailed)}, nil
+	}, func(*engine.Target, testresults.Suite) error {
+		t.Fatal("failure stored as a pass")
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 1, suites[0].Attempts)
+}
+
+func TestRunTestsEngine(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	state := t.TempDir()
+	runs := filepath.Join(state, "runs")
+
+	err := os.WriteFile(filepath.Join(dir, ".hephconfig"), []byte("cache_order: none\ncache_history: 1\n"), os.ModePerm)
+	require.NoError(t, err)
+
+	// Fails on its first run, passes on the next ones
+	build := func(env string) {
+		err := os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+target(
+    name="flaky",
+    run="echo run >> `+runs+`; test -f `+state+`/ok || { touch `+state+`/ok; exit 1; }",
+    env={"V": "`+env+`"},
+    labels=["test"],
+    cache=False,
+)
+`), os.ModePerm)
+		require.NoError(t, err)
+	}
+
+	t.Setenv("HEPH_CWD", dir)
+
+	// Each invocation gets a fresh engine, as a new heph process would
+	invoke := func(retries int) (*engine.Engine, testresults.Suite) {
+		e, err := engineFactory()
+		require.NoError(t, err)
+		t.Cleanup(e.RunExitHandlers)
+
+		err = preRunWithGenWithOpts(ctx, PreRunOpts{
+			Engine:  e,
+			LinkAll: true,
+		})
+		require.NoError(t, err)
+
+		e.TestPassCache = true
+
+		suites, err := runTests(ctx, e, []*engine.Target{e.Targets.Find("//:flaky")}, retries)
+		require.NoError(t, err)
+		require.Len(t, suites, 1)
+
+		return e, suites[0]
+	}
+
+	countRuns := func() int {
+		b, err := os.ReadFile(runs)
+		require.NoError(t, err)
+
+		return strings.Count(string(b), "\n")
+	}
+
+	passes := func(e *engine.Engine) []string {
+		files := make([]string, 0)
+		err := filepath.WalkDir(e.HomeDir.Join("test_results").Abs(), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && filepath.Ext(path) == ".json" {
+				files = append(files, filepath.Base(path))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		return files
+	}
+
+	build("1")
+
+	// The retry runs the target again, its pass is recorded
+	e, suite := invoke(1)
+	assert.Equal(t, 2, countRuns())
+	assert.Equal(t, 2, suite.Attempts)
+	assert.True(t, suite.Flaky())
+	assert.False(t, suite.Cached)
+	first := passes(e)
+	require.Len(t, first, 1)
+
+	// The recorded pass is reused without running the target
+	_, suite = invoke(0)
+	assert.Equal(t, 2, countRuns())
+	assert.True(t, suite.Cached)
+	assert.Equal(t, 0, suite.Count(testresults.StatusFailed))
+
+	// New inputs run the target again, the compiled BUILD files are cached by their mtime in seconds
+	build("2")
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(filepath.Join(dir, "BUILD"), later, later)
+	require.NoError(t, err)
+	e, suite = invoke(0)
+	assert.Equal(t, 3, countRuns())
+	assert.False(t, suite.Cached)
+	require.Len(t, passes(e), 2)
+
+	// Only the latest pass is kept with a history of 1
+	err = e.GC(ctx, nil, false)
+	require.NoError(t, err)
+
+	last := passes(e)
+	require.Len(t, last, 1)
+	assert.NotEqual(t, first, last)
+}
+
+func TestShardTestTargets(t *testing.T) {
+	targets := make([]*engine.Target, 0)
+	for i := 0; i < 20; i++ {
+		targets = append(targets, testTarget(fmt.Sprintf("//pkg:t%v", i)))
+	}
+
+	shardOf := func(targets []*engine.Target, count int) map[string]int {
+		m := map[string]int{}
+		for i := 0; i < count; i++ {
+			shard, err := shardTestTargets(targets, i, count)
+			require.NoError(t, err)
+
+			for _, target := range shard {
+				_, ok := m[target.FQN]
+				assert.False(t, ok, "%v in several shards", target.FQN)
+				m[target.FQN] = i
+			}
+		}
+
+		return m
+	}
+
+	shards := shardOf(targets, 3)
+	assert.Len(t, shards, len(targets))
+
+	// Adding a target does not move the others across shards
+	more := shardOf(append(targets, testTarget("//pkg:new")), 3)
+	for fqn, i := range shards {
+		assert.Equal(t, i, more[fqn], fqn)
+	}
+
+	all, err := shardTestTargets(targets, 0, 1)
+	require.NoError(t, err)
+	assert.Len(t, all, len(targets))
+
+	_, err = shardTestTargets(targets, 1, 0)
+	assert.EqualError(t, err, "--shard-index requires --shard-count")
+
+	_, err = shardTestTargets(targets, 3, 3)
+	assert.EqualError(t, err, "--shard-index must be between 0 and 2")
+}
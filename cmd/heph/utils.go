@@ -52,7 +52,7 @@ func printHumanError(err error) {
 		if skipSpacing {
 			skipSpacing = false
 		} else {
-			fmt.Fprintln(os.Stderr)
+			fmt.Fprintln(log.Writer())
 		}
 	}
 
@@ -68,12 +68,12 @@ func printHumanError(err error) {
 				logFile := lerr.LogFile
 				info, _ := os.Stat(logFile)
 				if info.Size() > 0 {
-					fmt.Fprintln(os.Stderr)
+					fmt.Fprintln(log.Writer())
 					c := exec.Command("cat", logFile)
-					c.Stdout = os.Stderr
+					c.Stdout = log.Writer()
 					_ = c.Run()
-					fmt.Fprintln(os.Stderr)
-					fmt.Fprintf(os.Stderr, "The log file can be found at %v\n", logFile)
+					fmt.Fprintln(log.Writer())
+					fmt.Fprintf(log.Writer(), "The log file can be found at %v\n", logFile)
 				}
 
 				log.Error(lerr.Error())
@@ -103,7 +103,7 @@ func printHumanError(err error) {
 	}
 
 	if len(errs) > 1 || skippedCount > 0 {
-		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(log.Writer())
 		skippedStr := ""
 		if skippedCount > 0 {
 			skippedStr = fmt.Sprintf(" %v skipped", skippedCount)
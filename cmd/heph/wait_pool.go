@@ -10,6 +10,7 @@ import (
 	log "heph/hlog"
 	"heph/utils"
 	"heph/worker"
+	"io"
 	"os"
 	"strings"
 	"time"
@@ -43,7 +44,7 @@ func logPoolUI(name string, deps *worker.WaitGroup, pool *worker.Pool) error {
 
 			runtime := fmt.Sprintf("%v", utils.RoundDuration(duration, 1).String())
 
-			fmt.Fprintf(os.Stderr, " %v %v\n", runtime, status)
+			fmt.Fprintf(log.Writer(), " %v %v\n", runtime, status)
 		}
 	}
 
@@ -240,7 +241,7 @@ func (r *renderer) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 var styleWorkerStart = lipgloss.NewStyle().Bold(true)
 var styleFaint = lipgloss.NewStyle().Faint(true)
 
-func setupPoolStyles(w *os.File) {
+func setupPoolStyles(w io.Writer) {
 	lipgloss.SetColorProfile(termenv.NewOutput(w).ColorProfile())
 }
 
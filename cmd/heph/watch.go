@@ -9,6 +9,7 @@ import (
 	"github.com/spf13/cobra"
 	"heph/engine"
 	log "heph/hlog"
+	"heph/sandbox"
 	"heph/utils/fs"
 	"os"
 	"path/filepath"
@@ -27,6 +28,7 @@ type watchEvent struct {
 
 type watchCtx struct {
 	ctx       context.Context
+	iocfg     sandbox.IOConfig
 	watcher   *fsnotify.Watcher
 	e         *engine.Engine
 	sigsCh    chan watchRun
@@ -99,7 +101,7 @@ func (w *watchCtx) run(r watchRun, fromStdin bool) {
 		}
 	}
 
-	err := runMode(ctx, w.e, r.rrs, !fromStdin, "watch")
+	err := runMode(ctx, w.e, r.rrs, !fromStdin, "watch", w.iocfg)
 
 	if *summary || *summaryGen {
 		PrintSummary(w.e.Stats, *summaryGen)
@@ -399,6 +401,7 @@ var watchCmd = &cobra.Command{
 
 		wctx := &watchCtx{
 			ctx:         ctx,
+			iocfg:       commandIO(cmd),
 			watcher:     watcher,
 			sigsCh:      make(chan watchRun),
 			graphSigsCh: make(chan struct{}),
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	log "heph/hlog"
+	"heph/platform"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var workerAddr string
+var workerRoot string
+var workerToken string
+
+func init() {
+	rootCmd.AddCommand(workerCmd)
+	workerCmd.AddCommand(workerServeCmd)
+
+	workerServeCmd.Flags().StringVar(&workerAddr, "addr", "127.0.0.1:8090", "Address to listen on")
+	workerServeCmd.Flags().StringVar(&workerToken, "token", "", "Token the provider must present, defaults to $"+platform.RemoteTokenEnv)
+	workerServeCmd.Flags().StringVar(&workerRoot, "root", filepath.Join(os.TempDir(), "heph-worker"), "Directory where jobs sandboxes are created")
+}
+
+var workerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "Remote execution worker",
+}
+
+var workerServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve jobs from the remote platform provider",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if workerToken == "" {
+			workerToken = os.Getenv(platform.RemoteTokenEnv)
+		}
+		if workerToken == "" {
+			return fmt.Errorf("a token is required, set --token or $%v", platform.RemoteTokenEnv)
+		}
+
+		w := platform.NewRemoteWorker(workerRoot, workerToken)
+
+		srv := &http.Server{
+			Addr:    workerAddr,
+			Handler: w.Handler(),
+		}
+
+		go func() {
+			<-cmd.Context().Done()
+			_ = srv.Close()
+		}()
+
+		log.Infof("Worker listening on %v", workerAddr)
+
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			return err
+		}
+
+		return nil
+	},
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"time"
 )
 import (
 	"gopkg.in/yaml.v3"
@@ -26,12 +27,16 @@ type Config struct {
 	CacheOrder   string `yaml:"cache_order"`
 	CacheHistory int    `yaml:"cache_history"`
 	Engine       struct {
-		GC           bool `yaml:"gc"`
-		CacheHints   bool `yaml:"cache_hints"`
-		InstallTools bool `yaml:"install_tools"`
-		KeepSandbox  bool `yaml:"keep_sandbox"`
+		GC                    GC     `yaml:"gc"`
+		CacheHints            bool   `yaml:"cache_hints"`
+		InstallTools          bool   `yaml:"install_tools"`
+		KeepSandbox           bool   `yaml:"keep_sandbox"`
+		DeterministicArchives bool   `yaml:"deterministic_archives"`
+		Compression           string `yaml:"compression"`
+		CompressionLevel      int    `yaml:"compression_level"`
 	} `yaml:"engine"`
 	Platforms  map[string]Platform `yaml:"platforms"`
+	HostTools  map[string]HostTool `yaml:"host_tools"`
 	BuildFiles struct {
 		Ignore []string        `yaml:"ignore"`
 		Roots  map[string]Root `yaml:"roots"`
@@ -61,15 +66,43 @@ func (c Config) OrderedPlatforms() []Platform {
 	return platforms
 }
 
+const (
+	HostToolFingerprintNone    = "none"
+	HostToolFingerprintContent = "content"
+	HostToolFingerprintVersion = "version"
+)
+
+// GC configures the local cache garbage collection, entries are evicted least recently used first
+// once the cache goes over MaxSize, or when they have not been used for MaxAge
+type GC struct {
+	Enabled bool          `yaml:"enabled"`
+	MaxSize int64         `yaml:"max_size"`
+	MaxAge  time.Duration `yaml:"max_age"`
+}
+
+// HostTool configures how a host tool is fingerprinted in the input hash of the targets using it
+type HostTool struct {
+	Fingerprint string
+	VersionArgs []string
+}
+
 type Cache struct {
 	URI       string
 	Read      bool
 	Write     bool
 	Secondary bool
+	GC        CacheGC
+}
+
+// CacheGC is the retention policy of a remote cache, applied by `heph gc --remote`
+type CacheGC struct {
+	Keep   int           `yaml:"keep"`
+	MaxAge time.Duration `yaml:"max_age"`
 }
 
 type Root struct {
-	URI string
+	URI     string
+	Version string
 }
 
 type Platform struct {
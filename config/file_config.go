@@ -1,5 +1,12 @@
 package config
 
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"heph/utils"
+	"time"
+)
+
 type Extras map[string]interface{}
 
 type FileConfig struct {
@@ -8,12 +15,16 @@ type FileConfig struct {
 	CacheOrder   string               `yaml:"cache_order"`
 	CacheHistory int                  `yaml:"cache_history"`
 	Engine       struct {
-		GC           *bool `yaml:"gc"`
-		CacheHints   *bool `yaml:"cache_hints"`
-		InstallTools *bool `yaml:"install_tools"`
-		KeepSandbox  *bool `yaml:"keep_sandbox"`
+		GC                    FileGC `yaml:"gc"`
+		CacheHints            *bool  `yaml:"cache_hints"`
+		InstallTools          *bool  `yaml:"install_tools"`
+		KeepSandbox           *bool  `yaml:"keep_sandbox"`
+		DeterministicArchives *bool  `yaml:"deterministic_archives"`
+		Compression           string `yaml:"compression"`
+		CompressionLevel      *int   `yaml:"compression_level"`
 	} `yaml:"engine"`
 	Platforms  map[string]FilePlatform `yaml:"platforms"`
+	HostTools  map[string]FileHostTool `yaml:"host_tools,omitempty"`
 	BuildFiles struct {
 		Ignore []string            `yaml:"ignore,omitempty"`
 		Roots  map[string]FileRoot `yaml:"roots,omitempty"`
@@ -43,9 +54,7 @@ func (fc FileConfig) ApplyTo(c Config) Config {
 		c.Engine.KeepSandbox = *fc.Engine.KeepSandbox
 	}
 
-	if fc.Engine.GC != nil {
-		c.Engine.GC = *fc.Engine.GC
-	}
+	c.Engine.GC = fc.Engine.GC.ApplyTo(c.Engine.GC)
 
 	if fc.Engine.CacheHints != nil {
 		c.Engine.CacheHints = *fc.Engine.CacheHints
@@ -55,6 +64,18 @@ func (fc FileConfig) ApplyTo(c Config) Config {
 		c.Engine.InstallTools = *fc.Engine.InstallTools
 	}
 
+	if fc.Engine.DeterministicArchives != nil {
+		c.Engine.DeterministicArchives = *fc.Engine.DeterministicArchives
+	}
+
+	if fc.Engine.Compression != "" {
+		c.Engine.Compression = fc.Engine.Compression
+	}
+
+	if fc.Engine.CompressionLevel != nil {
+		c.Engine.CompressionLevel = *fc.Engine.CompressionLevel
+	}
+
 	if fc.CacheOrder != "" {
 		c.CacheOrder = fc.CacheOrder
 	}
@@ -85,6 +106,13 @@ func (fc FileConfig) ApplyTo(c Config) Config {
 		c.Platforms[k] = pf
 	}
 
+	if c.HostTools == nil {
+		c.HostTools = map[string]HostTool{}
+	}
+	for k, newHostTool := range fc.HostTools {
+		c.HostTools[k] = newHostTool.ApplyTo(c.HostTools[k])
+	}
+
 	if c.BuildFiles.Roots == nil {
 		c.BuildFiles.Roots = map[string]Root{}
 	}
@@ -114,10 +142,39 @@ func (fc FileConfig) ApplyTo(c Config) Config {
 }
 
 type FileCache struct {
-	URI       string `yaml:"uri"`
-	Read      *bool  `yaml:",omitempty"`
-	Write     *bool  `yaml:",omitempty"`
-	Secondary *bool  `yaml:",omitempty"`
+	URI       string      `yaml:"uri"`
+	Read      *bool       `yaml:",omitempty"`
+	Write     *bool       `yaml:",omitempty"`
+	Secondary *bool       `yaml:",omitempty"`
+	GC        FileCacheGC `yaml:"gc"`
+}
+
+type FileCacheGC struct {
+	Keep   *int
+	MaxAge *time.Duration
+}
+
+func (fc *FileCacheGC) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Keep   *int   `yaml:"keep"`
+		MaxAge string `yaml:"max_age"`
+	}
+	err := value.Decode(&raw)
+	if err != nil {
+		return err
+	}
+
+	fc.Keep = raw.Keep
+
+	if raw.MaxAge != "" {
+		age, err := utils.ParseAge(raw.MaxAge)
+		if err != nil {
+			return fmt.Errorf("max_age: %w", err)
+		}
+		fc.MaxAge = &age
+	}
+
+	return nil
 }
 
 func (fc FileCache) ApplyTo(c Cache) Cache {
@@ -137,11 +194,103 @@ func (fc FileCache) ApplyTo(c Cache) Cache {
 		c.Secondary = *fc.Secondary
 	}
 
+	if fc.GC.Keep != nil {
+		c.GC.Keep = *fc.GC.Keep
+	}
+
+	if fc.GC.MaxAge != nil {
+		c.GC.MaxAge = *fc.GC.MaxAge
+	}
+
+	return c
+}
+
+// FileGC is either a bool enabling the GC, or its settings
+type FileGC struct {
+	Enabled *bool
+	MaxSize *int64
+	MaxAge  *time.Duration
+}
+
+func (fc *FileGC) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var enabled bool
+		err := value.Decode(&enabled)
+		if err != nil {
+			return err
+		}
+
+		fc.Enabled = &enabled
+		return nil
+	}
+
+	var raw struct {
+		Enabled *bool  `yaml:"enabled"`
+		MaxSize string `yaml:"max_size"`
+		MaxAge  string `yaml:"max_age"`
+	}
+	err := value.Decode(&raw)
+	if err != nil {
+		return err
+	}
+
+	fc.Enabled = raw.Enabled
+
+	if raw.MaxSize != "" {
+		size, err := utils.ParseSize(raw.MaxSize)
+		if err != nil {
+			return fmt.Errorf("max_size: %w", err)
+		}
+		fc.MaxSize = &size
+	}
+
+	if raw.MaxAge != "" {
+		age, err := utils.ParseAge(raw.MaxAge)
+		if err != nil {
+			return fmt.Errorf("max_age: %w", err)
+		}
+		fc.MaxAge = &age
+	}
+
+	return nil
+}
+
+func (fc FileGC) ApplyTo(c GC) GC {
+	if fc.Enabled != nil {
+		c.Enabled = *fc.Enabled
+	}
+
+	if fc.MaxSize != nil {
+		c.MaxSize = *fc.MaxSize
+	}
+
+	if fc.MaxAge != nil {
+		c.MaxAge = *fc.MaxAge
+	}
+
+	return c
+}
+
+type FileHostTool struct {
+	Fingerprint string   `yaml:"fingerprint"`
+	VersionArgs []string `yaml:"version_args,omitempty"`
+}
+
+func (fc FileHostTool) ApplyTo(c HostTool) HostTool {
+	if fc.Fingerprint != "" {
+		c.Fingerprint = fc.Fingerprint
+	}
+
+	if fc.VersionArgs != nil {
+		c.VersionArgs = fc.VersionArgs
+	}
+
 	return c
 }
 
 type FileRoot struct {
-	URI string `yaml:"uri"`
+	URI     string `yaml:"uri"`
+	Version string `yaml:"version"`
 }
 
 func (fc FileRoot) ApplyTo(c Root) Root {
@@ -149,6 +298,10 @@ func (fc FileRoot) ApplyTo(c Root) Root {
 		c.URI = fc.URI
 	}
 
+	if fc.Version != "" {
+		c.Version = fc.Version
+	}
+
 	return c
 }
 
@@ -0,0 +1,253 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "heph/hlog"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// ErrNotRunning is returned by Do when no daemon is listening on the socket
+var ErrNotRunning = errors.New("daemon not running")
+
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// Request runs a command in the daemon, as if Args were passed to heph from Cwd
+type Request struct {
+	Args  []string `json:"args,omitempty"`
+	Cwd   string   `json:"cwd,omitempty"`
+	Stdin []byte   `json:"stdin,omitempty"`
+	// Env is the environment of the client, the command runs with it
+	Env []string `json:"env,omitempty"`
+	// Stop shuts the daemon down
+	Stop bool `json:"stop,omitempty"`
+}
+
+// Message is streamed back to the client, the last one carries the exit code
+type Message struct {
+	Stream string `json:"stream,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+	Exit   *int   `json:"exit,omitempty"`
+}
+
+// Handler runs the request, writing its output to stdout and stderr, and returns the exit code.
+// ctx is cancelled when the client goes away.
+type Handler func(ctx context.Context, req Request, stdout, stderr io.Writer) int
+
+// SocketPath returns the path of the socket of the daemon of the repo at root
+func SocketPath(root string) string {
+	return filepath.Join(root, ".heph", "daemon.sock")
+}
+
+type Server struct {
+	l    net.Listener
+	path string
+	h    Handler
+	// Requests run one at a time
+	m      sync.Mutex
+	stopCh chan struct{}
+	once   sync.Once
+}
+
+// Listen creates the socket at path, failing if another daemon is already listening on it
+func Listen(path string, h Handler) (*Server, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %v", path)
+	}
+
+	// Left over by a daemon that did not shut down cleanly
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("daemon: %w", err)
+	}
+
+	return &Server{
+		l:      l,
+		path:   path,
+		h:      h,
+		stopCh: make(chan struct{}),
+	}, nil
+}
+
+// Serve accepts connections until ctx is cancelled or a stop request is received
+func (s *Server) Serve(ctx context.Context) error {
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.stopCh:
+		}
+		_ = s.Close()
+	}()
+
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			select {
+			case <-s.stopCh:
+				return nil
+			default:
+			}
+
+			return err
+		}
+
+		go s.serveConn(ctx, conn)
+	}
+}
+
+func (s *Server) Close() error {
+	s.once.Do(func() {
+		close(s.stopCh)
+	})
+
+	err := s.l.Close()
+	_ = os.Remove(s.path)
+
+	return err
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	var req Request
+	err := json.NewDecoder(conn).Decode(&req)
+	if err != nil {
+		log.Errorf("daemon: %v", err)
+		return
+	}
+
+	enc := &encoder{enc: json.NewEncoder(conn)}
+
+	if req.Stop {
+		enc.exit(0)
+		_ = s.Close()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		// The client does not send anything past the request, the read returns when it goes away
+		_, _ = io.Copy(io.Discard, conn)
+		cancel()
+	}()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	code := s.h(ctx, req, enc.stream(StreamStdout), enc.stream(StreamStderr))
+
+	enc.exit(code)
+}
+
+type encoder struct {
+	m   sync.Mutex
+	enc *json.Encoder
+}
+
+func (e *encoder) send(msg Message) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	return e.enc.Encode(msg)
+}
+
+func (e *encoder) exit(code int) {
+	_ = e.send(Message{Exit: &code})
+}
+
+func (e *encoder) stream(name string) io.Writer {
+	return streamWriter{e: e, name: name}
+}
+
+type streamWriter struct {
+	e    *encoder
+	name string
+}
+
+func (w streamWriter) Write(b []byte) (int, error) {
+	err := w.e.send(Message{Stream: w.name, Data: b})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Do runs the request in the daemon listening on path, copying its output to stdout and stderr,
+// and returns the exit code of the command
+func Do(ctx context.Context, path string, req Request, stdout, stderr io.Writer) (int, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+			return 0, ErrNotRunning
+		}
+		return 0, fmt.Errorf("daemon: %w", err)
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		return 0, fmt.Errorf("daemon: %w", err)
+	}
+
+	dec := json.NewDecoder(conn)
+	for {
+		var msg Message
+		err := dec.Decode(&msg)
+		if err != nil {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+
+			return 0, fmt.Errorf("daemon: %w", err)
+		}
+
+		if msg.Exit != nil {
+			return *msg.Exit, nil
+		}
+
+		switch msg.Stream {
+		case StreamStdout:
+			_, err = stdout.Write(msg.Data)
+		case StreamStderr:
+			_, err = stderr.Write(msg.Data)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
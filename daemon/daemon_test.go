@@ -0,0 +1,49 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "d.sock")
+
+	srv, err := Listen(path, func(ctx context.Context, req Request, stdout, stderr io.Writer) int {
+		fmt.Fprintf(stdout, "%v %s", strings.Join(req.Args, " "), req.Stdin)
+		fmt.Fprint(stderr, req.Cwd, req.Env)
+		return 3
+	})
+	require.NoError(t, err)
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- srv.Serve(ctx)
+	}()
+
+	var stdout, stderr bytes.Buffer
+	code, err := Do(ctx, path, Request{Args: []string{"run", "//:a"}, Cwd: "/some/dir", Stdin: []byte("in"), Env: []string{"A=1"}}, &stdout, &stderr)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "run //:a in", stdout.String())
+	assert.Equal(t, "/some/dir[A=1]", stderr.String())
+
+	code, err = Do(ctx, path, Request{Stop: true}, &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	require.NoError(t, <-errCh)
+
+	_, err = Do(ctx, path, Request{}, &stdout, &stderr)
+	assert.ErrorIs(t, err, ErrNotRunning)
+}
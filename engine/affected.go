@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	log "heph/hlog"
+	"heph/targetspec"
+	"heph/utils"
+	fs2 "heph/utils/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SpecsAtRoot evaluates the BUILD files of a checkout of the repo at root, with the current config,
+// and returns the specs they declare. Generated targets are not included.
+func (e *Engine) SpecsAtRoot(ctx context.Context, root string) (targetspec.TargetSpecs, error) {
+	be := New(root)
+	be.Config = e.Config
+	be.Params = e.Params
+	be.fetchRootCache = e.fetchRootCache
+
+	for name, cfg := range be.Config.BuildFiles.Roots {
+		err := be.runRootBuildFiles(ctx, name, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("root %v: %w", name, err)
+		}
+	}
+
+	err := be.runBuildFiles(be.Root.Abs(), be.createPkg)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = be.configureTargets(be.Targets.Slice())
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make(targetspec.TargetSpecs, 0, len(be.Targets.Slice()))
+	for _, target := range be.Targets.Slice() {
+		// Applies the config defaults, as for the current targets
+		err := be.processTarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", target.FQN, err)
+		}
+
+		specs = append(specs, target.TargetSpec)
+	}
+
+	return specs, nil
+}
+
+// AffectedSince returns the targets affected by the changes from the merge base of ref and HEAD to the working tree,
+// the specs of specTargets are compared with the ones declared at the merge base. Targets must be linked.
+func (e *Engine) AffectedSince(ctx context.Context, ref string, specTargets []*Target) ([]*Target, error) {
+	root := e.Root.Abs()
+
+	base, err := gitOutput(root, "merge-base", ref, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare with the working tree to include uncommitted changes
+	diff, err := gitOutput(root, "diff", "--name-only", "--no-renames", base)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0)
+	for _, file := range strings.Split(diff, "\n") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "heph-affected")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, _ = gitOutput(root, "worktree", "remove", "--force", dir)
+		_ = os.RemoveAll(dir)
+	}()
+
+	_, err = gitOutput(root, "worktree", "add", "--detach", dir, base)
+	if err != nil {
+		return nil, err
+	}
+
+	baseSpecs, err := e.SpecsAtRoot(ctx, dir)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", ref, err)
+	}
+
+	return e.GetAffected(files, specTargets, baseSpecs)
+}
+
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"--no-pager"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		var eerr *exec.ExitError
+		if errors.As(err, &eerr) {
+			return "", fmt.Errorf("git %v: %w: %s", args[0], err, bytes.TrimSpace(eerr.Stderr))
+		}
+		return "", fmt.Errorf("git %v: %w", args[0], err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// GetAffected returns the targets depending on the changed files, and the ones of specTargets whose spec differs
+// from base, along with all their descendants. Targets must be linked.
+func (e *Engine) GetAffected(files []string, specTargets []*Target, base targetspec.TargetSpecs) ([]*Target, error) {
+	affected := NewTargets(0)
+
+	existing := make([]string, 0, len(files))
+	for _, file := range files {
+		if fs2.PathExists(e.Root.Join(file).Abs()) {
+			existing = append(existing, file)
+			continue
+		}
+
+		// Deleted files are not part of the deps anymore, match them against the declared patterns
+		for _, target := range e.Targets.Slice() {
+			if specDepsMatch(target.TargetSpec, file) {
+				log.Tracef("%v (deleted) affects %v", file, target.FQN)
+				affected.Add(target)
+			}
+		}
+	}
+
+	descendants, err := e.GetFileDescendants(existing, e.Targets.Slice())
+	if err != nil {
+		return nil, err
+	}
+	affected.AddAll(descendants)
+
+	for _, target := range specTargets {
+		spec, ok := base.Get(target.FQN)
+		if !ok || !spec.Equal(target.TargetSpec) {
+			log.Tracef("%v spec changed", target.FQN)
+			affected.Add(target)
+		}
+	}
+
+	all, err := e.DAG().GetOrderedDescendants(affected.Slice(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	res := NewTargets(len(all))
+	res.AddAll(all)
+	res.Sort()
+
+	return res.Slice(), nil
+}
+
+func specDepsMatch(spec targetspec.TargetSpec, file string) bool {
+	for _, deps := range []targetspec.TargetSpecDeps{spec.Deps, spec.HashDeps} {
+		for _, dep := range deps.Files {
+			pattern := filepath.Join(spec.Package.FullName, dep.Path)
+			if strings.HasPrefix(dep.Path, "/") {
+				pattern = dep.Path[1:]
+			}
+
+			if depFileMatch(pattern, file) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// depFileMatch returns true if path is dep, is in the dep directory or matches the dep glob
+func depFileMatch(dep, path string) bool {
+	if dep == path || strings.HasPrefix(path, dep+"/") {
+		return true
+	}
+
+	if utils.IsGlob(dep) {
+		match, _ := utils.PathMatch(path, dep)
+		return match
+	}
+
+	return false
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAffectedSince(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=heph", "-c", "user.email=heph@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+
+	write := func(name, content string) {
+		p := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(p), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(p, []byte(content), os.ModePerm)
+		require.NoError(t, err)
+	}
+
+	build := func(d, extra string) {
+		write("BUILD", extra+`
+target(name="a", deps=["src/a.txt"], out="a")
+target(name="b", deps=["//:a"], out="b")
+target(name="c", deps=["src/c.txt"], out="c")
+target(name="d", run="`+d+`", out="d")
+target(name="e", deps=["del/*.txt"], out="e")
+target(name="g", deps=["//:c"], out="g")
+`)
+	}
+
+	git("init", "-q", "-b", "master")
+	build("echo d", "")
+	write("src/a.txt", "a")
+	write("src/c.txt", "c")
+	write("del/keep.txt", "keep")
+	write("del/x.txt", "x")
+	git("add", "-A")
+	git("commit", "-q", "-m", "base")
+
+	// Changes on the base branch after the fork are not part of the diff
+	git("checkout", "-q", "-b", "main")
+	write("src/a.txt", "a2")
+	git("commit", "-q", "-am", "main")
+	git("checkout", "-q", "master")
+
+	build("echo d2", `target(name="f", out="f")`)
+	git("rm", "-q", "del/x.txt")
+	git("add", "-A")
+	git("commit", "-q", "-m", "change")
+
+	// Uncommitted
+	write("src/c.txt", "c2")
+
+	e := New(dir)
+	err := e.runBuildFiles(dir, e.createPkg)
+	require.NoError(t, err)
+
+	for _, target := range e.Targets.Slice() {
+		err := e.processTarget(target)
+		require.NoError(t, err)
+	}
+
+	err = e.LinkTargets(ctx, false, nil)
+	require.NoError(t, err)
+
+	targets, err := e.AffectedSince(ctx, "main", e.Targets.Slice())
+	require.NoError(t, err)
+
+	fqns := make([]string, 0, len(targets))
+	for _, target := range targets {
+		fqns = append(fqns, target.FQN)
+	}
+
+	// c changed in the working tree, g depends on it, d changed spec, e lost a file, f is new
+	assert.Equal(t, []string{"//:c", "//:d", "//:e", "//:f", "//:g"}, fqns)
+
+	// The worktree of the base is removed
+	out, err := exec.Command("git", "-C", dir, "worktree", "list").Output()
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "heph-affected")
+
+	_, err = e.AffectedSince(ctx, "nope", e.Targets.Slice())
+	assert.ErrorContains(t, err, "git merge-base")
+}
@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"debug/elf"
+	log "heph/hlog"
+	"heph/sandbox"
+	"heph/targetspec"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// InputsAudit lists the files read by a target that are not part of its declared inputs
+type InputsAudit struct {
+	Target     *Target
+	Undeclared []string
+	// Skipped is set when the target could not be traced
+	Skipped bool
+}
+
+// auditIgnoredPaths are the kernel interfaces, never reported
+var auditIgnoredPaths = []string{"/proc", "/dev", "/sys"}
+
+// auditRuntimePaths are read by the dynamic loader and the libc name service on behalf of any executable
+var auditRuntimePaths = []string{"/etc/ld.so.cache", "/etc/ld.so.preload", "/etc/nsswitch.conf", "/etc/passwd", "/etc/group"}
+
+func (e *TargetRunEngine) auditTraceFile(target *Target) string {
+	return e.tmpTargetRoot(target).Join("audit_inputs").Abs()
+}
+
+// auditDeclaredPaths returns the paths the target is allowed to read from: its sandbox, deps, tools & outputs
+func (e *TargetRunEngine) auditDeclaredPaths(target *Target) []string {
+	paths := []string{
+		e.sandboxRoot(target).Abs(),
+		e.tmpTargetRoot(target).Abs(),
+	}
+
+	for _, dep := range target.Deps.All().Targets {
+		for _, file := range e.Targets.Find(dep.Target.FQN).ActualOutFiles().Name(dep.Output) {
+			paths = append(paths, file.Abs())
+		}
+	}
+
+	for _, file := range target.Deps.All().Files {
+		paths = append(paths, file.Abs())
+	}
+
+	for _, tool := range target.Tools.Targets {
+		paths = append(paths, e.Targets.Find(tool.Target.FQN).OutExpansionRoot.Abs())
+	}
+
+	for _, root := range []string{target.SandboxRoot.Abs(), target.WorkdirRoot.Abs()} {
+		for _, file := range target.Out.WithRoot(root).All() {
+			paths = append(paths, file.Abs())
+		}
+	}
+
+	return paths
+}
+
+// auditToolchainBins returns the declared tools along with the shell running the target, resolved in path
+func (e *TargetRunEngine) auditToolchainBins(target *Target, bin map[string]string, path string) []string {
+	bins := make([]string, 0, len(bin)+1)
+	for _, p := range bin {
+		bins = append(bins, p)
+	}
+
+	switch target.Entrypoint {
+	case targetspec.EntrypointBash, targetspec.EntrypointSh:
+		if p, err := sandbox.LookPath(target.Entrypoint, path); err == nil {
+			bins = append(bins, p)
+		}
+	}
+
+	return bins
+}
+
+// auditToolchain is what the tools read at runtime: their binary, the install dirs named after them,
+// the interpreter and the shared libraries they load
+type auditToolchain struct {
+	paths []string
+	// libs are the sonames of the shared libraries, wherever the loader finds them
+	libs map[string]struct{}
+}
+
+func newAuditToolchain(bins []string) *auditToolchain {
+	t := &auditToolchain{libs: map[string]struct{}{}}
+
+	for _, p := range bins {
+		ps := []string{p}
+		if rp, err := filepath.EvalSymlinks(p); err == nil && rp != p {
+			ps = append(ps, rp)
+		}
+
+		for _, p := range ps {
+			// ie: /usr/bin/gcc reads from /usr/lib/gcc, /usr/libexec/gcc...
+			name := filepath.Base(p)
+			prefix := filepath.Dir(filepath.Dir(p))
+
+			t.paths = append(t.paths,
+				p,
+				filepath.Join(prefix, "lib", name),
+				filepath.Join(prefix, "libexec", name),
+				filepath.Join(prefix, "share", name),
+			)
+			t.addExecutable(p)
+		}
+	}
+
+	return t
+}
+
+// addExecutable records the interpreter and shared libraries p loads, if it is an ELF file,
+// it returns if new libraries were recorded
+func (t *auditToolchain) addExecutable(p string) bool {
+	f, err := elf.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+
+		b, err := io.ReadAll(prog.Open())
+		if err != nil {
+			continue
+		}
+
+		interp := strings.TrimRight(string(b), "\x00")
+		t.paths = append(t.paths, interp)
+		if rp, err := filepath.EvalSymlinks(interp); err == nil {
+			t.paths = append(t.paths, rp)
+		}
+	}
+
+	libs, err := f.ImportedLibraries()
+	if err != nil {
+		return false
+	}
+
+	added := false
+	for _, lib := range libs {
+		if _, ok := t.libs[lib]; !ok {
+			t.libs[lib] = struct{}{}
+			added = true
+		}
+	}
+
+	return added
+}
+
+func (t *auditToolchain) Has(p string) bool {
+	if _, ok := t.libs[filepath.Base(p)]; ok {
+		return true
+	}
+
+	return hasPathPrefix(p, t.paths)
+}
+
+// Expand records the libraries loaded by the shared libraries & executables of the toolchain found in paths,
+// the files they pull in, ie: /usr/lib/gcc/x86_64-linux-gnu/12/cc1 loading libgmp
+func (t *auditToolchain) Expand(paths []string) {
+	expanded := map[string]struct{}{}
+	for {
+		added := false
+		for _, p := range paths {
+			if _, ok := expanded[p]; ok || !t.Has(p) {
+				continue
+			}
+			expanded[p] = struct{}{}
+
+			if t.addExecutable(p) {
+				added = true
+			}
+		}
+
+		if !added {
+			return
+		}
+	}
+}
+
+func hasPathPrefix(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if p == prefix || strings.HasPrefix(p, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordInputsAudit reads the trace of the run, and records the files read outside the declared paths and the toolchain
+func (e *TargetRunEngine) recordInputsAudit(target *Target, declared []string, bins []string) {
+	traceFile := e.auditTraceFile(target)
+	defer os.Remove(traceFile)
+
+	paths, err := sandbox.ReadTraceFile(traceFile)
+	if err != nil {
+		log.Errorf("%v: audit inputs: %v", target.FQN, err)
+		return
+	}
+
+	toolchain := newAuditToolchain(bins)
+	toolchain.Expand(paths)
+
+	undeclared := make([]string, 0)
+	for _, p := range paths {
+		if hasPathPrefix(p, auditIgnoredPaths) || hasPathPrefix(p, auditRuntimePaths) || hasPathPrefix(p, declared) || toolchain.Has(p) {
+			continue
+		}
+
+		// Directories listing and temporary files are not considered inputs
+		info, err := os.Stat(p)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		undeclared = append(undeclared, p)
+	}
+
+	if len(undeclared) > 0 {
+		log.Warnf("%v read %v undeclared file(s):\n  %v", target.FQN, len(undeclared), strings.Join(undeclared, "\n  "))
+	}
+
+	e.inputsAuditsm.Lock()
+	defer e.inputsAuditsm.Unlock()
+
+	e.inputsAudits = append(e.inputsAudits, InputsAudit{
+		Target:     target,
+		Undeclared: undeclared,
+	})
+}
+
+func (e *TargetRunEngine) recordInputsAuditSkipped(target *Target) {
+	e.inputsAuditsm.Lock()
+	defer e.inputsAuditsm.Unlock()
+
+	e.inputsAudits = append(e.inputsAudits, InputsAudit{
+		Target:  target,
+		Skipped: true,
+	})
+}
+
+// InputsAudits returns the audits of the targets that ran with AuditInputs enabled, sorted by target
+func (e *Engine) InputsAudits() []InputsAudit {
+	e.inputsAuditsm.Lock()
+	defer e.inputsAuditsm.Unlock()
+
+	audits := append([]InputsAudit{}, e.inputsAudits...)
+	sort.Slice(audits, func(i, j int) bool {
+		return audits[i].Target.FQN < audits[j].Target.FQN
+	})
+
+	return audits
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditInputsToolchain(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+
+	f, err := elf.Open(bash)
+	if err != nil {
+		t.Skipf("bash is not an ELF executable: %v", err)
+	}
+	libs, err := f.ImportedLibraries()
+	_ = f.Close()
+	require.NoError(t, err)
+	require.NotEmpty(t, libs)
+
+	dir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+target(name="a", run="cat /usr/include/stdio.h", out="a")
+`), os.ModePerm)
+	require.NoError(t, err)
+
+	e := New(dir)
+	err = e.runBuildFiles(dir, e.createPkg)
+	require.NoError(t, err)
+
+	target := e.Targets.Find("//:a")
+	require.NotNil(t, target)
+
+	err = e.processTarget(target)
+	require.NoError(t, err)
+	err = e.LinkTarget(target, nil)
+	require.NoError(t, err)
+
+	// Files only need to exist, the toolchain matches the libraries by soname
+	sysDir := t.TempDir()
+	lib := filepath.Join(sysDir, libs[0])
+	header := filepath.Join(sysDir, "stdio.h")
+	config := filepath.Join(sysDir, "tool.conf")
+	for _, p := range []string{lib, header, config} {
+		require.NoError(t, os.WriteFile(p, nil, os.ModePerm))
+	}
+
+	re := &TargetRunEngine{Engine: e}
+
+	traceFile := re.auditTraceFile(target)
+	require.NoError(t, os.MkdirAll(filepath.Dir(traceFile), os.ModePerm))
+	trace := bash + "\n" + lib + "\n" + header + "\n" + config + "\n/proc/self/status\n"
+	require.NoError(t, os.WriteFile(traceFile, []byte(trace), os.ModePerm))
+
+	re.recordInputsAudit(target, re.auditDeclaredPaths(target), []string{bash})
+	re.recordInputsAuditSkipped(target)
+
+	audits := e.InputsAudits()
+	require.Len(t, audits, 2)
+	assert.False(t, audits[0].Skipped)
+	assert.ElementsMatch(t, []string{header, config}, audits[0].Undeclared)
+	assert.True(t, audits[1].Skipped)
+
+	toolchain := newAuditToolchain([]string{"/usr/bin/gcc"})
+	assert.True(t, toolchain.Has("/usr/lib/gcc/x86_64-linux-gnu/12/cc1"))
+	assert.True(t, toolchain.Has("/usr/libexec/gcc/x86_64-linux-gnu/12/cc1"))
+	assert.False(t, toolchain.Has("/usr/include/stdio.h"))
+	assert.False(t, toolchain.Has("/usr/lib/x86_64-linux-gnu/libssl.so.3"))
+}
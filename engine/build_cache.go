@@ -0,0 +1,366 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.starlark.net/starlark"
+	"golang.org/x/exp/slices"
+	log "heph/hlog"
+	"heph/packages"
+	"heph/targetspec"
+	"heph/utils"
+	fs2 "heph/utils/fs"
+	"heph/utils/hash"
+	"heph/utils/sets"
+	"io/fs"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// buildInputs is what the evaluation of a BUILD file depends on, along with the targets it registered.
+// The files it loads contribute theirs.
+type buildInputs struct {
+	Files []string
+	Globs []buildGlob
+	Specs []targetspec.TargetSpec
+	Rules []RuleInfo
+	// ProvidersReads are the providers of other targets read through heph.providers()
+	ProvidersReads []buildProvidersRead
+}
+
+type buildGlob struct {
+	Root    string
+	Pattern string
+	Exclude []string
+	Hash    string
+}
+
+func (i *buildInputs) merge(o *buildInputs) {
+	if o == nil {
+		return
+	}
+
+	i.Files = append(i.Files, o.Files...)
+	i.Globs = append(i.Globs, o.Globs...)
+	i.Specs = append(i.Specs, o.Specs...)
+	i.Rules = append(i.Rules, o.Rules...)
+	i.ProvidersReads = append(i.ProvidersReads, o.ProvidersReads...)
+}
+
+func threadBuildInputs(thread *starlark.Thread) *buildInputs {
+	inputs, _ := thread.Local("inputs").(*buildInputs)
+	if inputs == nil {
+		// Not running a BUILD file, nothing to record into
+		return &buildInputs{}
+	}
+
+	return inputs
+}
+
+// recordLoad adds the file loaded by the thread, and its own inputs, to the thread's inputs
+func (e *Engine) recordLoad(thread *starlark.Thread, path string) {
+	inputs := threadBuildInputs(thread)
+	inputs.Files = append(inputs.Files, path)
+
+	if loaded, ok := e.buildFilesInputs.GetOk(path); ok {
+		inputs.merge(loaded)
+	}
+}
+
+type buildCacheEntry struct {
+	Key string
+	// Files is the content hash of the BUILD files and the files they load, by path
+	Files          map[string]string
+	Globs          []buildGlob
+	Specs          []targetspec.TargetSpec
+	Rules          []RuleInfo
+	ProvidersReads []buildProvidersRead
+}
+
+func (e *Engine) buildCachePath(pkg *packages.Package) string {
+	return e.HomeDir.Join("tmp", "__BUILD_specs", hash.HashString(pkg.Root.Abs())+".json").Abs()
+}
+
+// buildCacheKey hashes what every BUILD file evaluation depends on
+func (e *Engine) buildCacheKey() (string, error) {
+	h := hash.NewHash()
+	h.I64(3)
+	h.String(utils.Version)
+	// predeclaredHash is only computed once a BUILD file runs
+	h.String(hash.HashBytes(predeclaredSrc))
+	h.String(runtime.GOOS)
+	h.String(runtime.GOARCH)
+
+	hash.HashMap(h, e.Params, func(k, v string) string {
+		return k + "=" + v
+	})
+
+	h.String(e.Config.Version.String)
+	for _, profile := range e.Config.Profiles {
+		h.String(profile)
+	}
+
+	// Maps keys get sorted
+	b, err := json.Marshal(e.Config.Extras)
+	if err != nil {
+		return "", err
+	}
+	h.String(string(b))
+
+	return h.Sum(), nil
+}
+
+func hashBuildFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return hash.HashBytes(b), nil
+}
+
+func (e *Engine) globFiles(root, pattern string, exclude []string) ([]string, error) {
+	allExclude := append([]string{}, exclude...)
+	allExclude = append(allExclude, "**/.heph")
+	allExclude = append(allExclude, e.Config.BuildFiles.Glob.Exclude...)
+
+	elems := sets.NewStringSet(0)
+	err := utils.StarWalk(root, pattern, allExclude, func(path string, d fs.DirEntry, err error) error {
+		if d.IsDir() {
+			return nil
+		}
+
+		elems.Add(path)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return elems.Slice(), nil
+}
+
+func hashGlob(files []string) string {
+	files = append([]string{}, files...)
+	sort.Strings(files)
+
+	h := hash.NewHash()
+	for _, file := range files {
+		h.String(file)
+	}
+
+	return h.Sum()
+}
+
+// loadBuildCache returns the entry of the package if none of its inputs changed
+func (e *Engine) loadBuildCache(pkg *packages.Package, key string, breadcrumb []string) (*buildCacheEntry, bool) {
+	b, err := os.ReadFile(e.buildCachePath(pkg))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Debugf("BUILD: cache: %v %v", pkg.FullName, err)
+		}
+		return nil, false
+	}
+
+	var entry buildCacheEntry
+	err = json.Unmarshal(b, &entry)
+	if err != nil {
+		log.Debugf("BUILD: cache: %v %v", pkg.FullName, err)
+		return nil, false
+	}
+
+	if entry.Key != key {
+		return nil, false
+	}
+
+	for _, file := range pkg.SourceFiles {
+		if _, ok := entry.Files[file.Path]; !ok {
+			// A BUILD file got added
+			return nil, false
+		}
+	}
+
+	for path, expected := range entry.Files {
+		actual, err := hashBuildFile(path)
+		if err != nil || actual != expected {
+			return nil, false
+		}
+	}
+
+	for _, g := range entry.Globs {
+		files, err := e.globFiles(g.Root, g.Pattern, g.Exclude)
+		if err != nil || hashGlob(files) != g.Hash {
+			return nil, false
+		}
+	}
+
+	for _, r := range entry.ProvidersReads {
+		providers, err := e.targetProviders(r.Target, breadcrumb)
+		if err != nil || hashProviders(providers) != r.Hash {
+			return nil, false
+		}
+	}
+
+	return &entry, true
+}
+
+func (e *Engine) storeBuildCache(pkg *packages.Package, key string) error {
+	inputs := &buildInputs{}
+	for _, file := range pkg.SourceFiles {
+		inputs.Files = append(inputs.Files, file.Path)
+		inputs.merge(e.buildFilesInputs.Get(file.Path))
+	}
+
+	entry := buildCacheEntry{
+		Key:            key,
+		Files:          map[string]string{},
+		Globs:          inputs.Globs,
+		Specs:          inputs.Specs,
+		Rules:          inputs.Rules,
+		ProvidersReads: inputs.ProvidersReads,
+	}
+
+	for _, path := range inputs.Files {
+		h, err := hashBuildFile(path)
+		if err != nil {
+			return err
+		}
+
+		entry.Files[path] = h
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path := e.buildCachePath(pkg)
+
+	err = fs2.CreateParentDir(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := fs2.AtomicCreate(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(b)
+
+	return err
+}
+
+// runBuildFilesForPackageCached registers the specs of the package from the BUILD cache when its inputs
+// did not change since they got stored, and runs its BUILD files otherwise.
+// breadcrumb holds the packages being run by the evaluation reading the providers of this one, if any
+func (e *Engine) runBuildFilesForPackageCached(pkg *packages.Package, key string, breadcrumb []string) error {
+	// Reading providers runs the package of the target, which could be reading providers of this one
+	if slices.Contains(breadcrumb, pkg.FullName) {
+		return fmt.Errorf("//%v: cycle reading providers: %v", pkg.FullName, strings.Join(append(breadcrumb, pkg.FullName), " -> "))
+	}
+
+	// Generated BUILD files get run concurrently, only one evaluation chain runs packages at a time,
+	// the packages it reaches run under its lock
+	if len(breadcrumb) == 0 {
+		e.packagesRunMutex.Lock()
+		defer e.packagesRunMutex.Unlock()
+	}
+
+	if pkg.Globals != nil || e.buildCachedPackages.Has(pkg.FullName) {
+		// Already ran, as loaded by another package, or registered from the cache
+		return nil
+	}
+
+	breadcrumb = append(append([]string{}, breadcrumb...), pkg.FullName)
+
+	if entry, ok := e.loadBuildCache(pkg, key, breadcrumb); ok {
+		log.Tracef("BUILD: cache hit: %v", pkg.FullName)
+
+		e.registerRules(entry.Rules)
+
+		for _, spec := range entry.Specs {
+			spec := spec
+			root := spec.Package.Root
+			spec.Package = e.getOrCreatePkg(spec.Package.FullName, func(fullname, name string) *packages.Package {
+				return &packages.Package{
+					Name:     name,
+					FullName: fullname,
+					Root:     root,
+				}
+			})
+
+			err := e.defaultRegisterTarget(spec)
+			if err != nil {
+				return err
+			}
+		}
+
+		e.buildCachedPackages.Set(pkg.FullName, pkg)
+
+		return nil
+	}
+
+	err := e.runBuildFilesForPackage(pkg, breadcrumb)
+	if err != nil {
+		return err
+	}
+
+	e.buildCachedPackages.Set(pkg.FullName, pkg)
+
+	err = e.storeBuildCache(pkg, key)
+	if err != nil {
+		log.Debugf("BUILD: cache: store %v %v", pkg.FullName, err)
+	}
+
+	return nil
+}
+
+func sourceFilesPaths(files packages.SourceFiles) []string {
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// BuildFilesChanged tells if the parsed graph is stale, returning what changed: a BUILD file got added or removed,
+// or the inputs of the evaluation of a package (BUILD and loaded files, glob results, providers read) changed
+func (e *Engine) BuildFilesChanged() (string, bool, error) {
+	roots := e.buildFilesRoots.Keys()
+	sort.Strings(roots)
+
+	for _, root := range roots {
+		files, err := e.collectBuildFiles(root)
+		if err != nil {
+			return "", false, err
+		}
+
+		if !slices.Equal(sourceFilesPaths(files), e.buildFilesRoots.Get(root)) {
+			return fmt.Sprintf("BUILD files of %v", root), true, nil
+		}
+	}
+
+	key, err := e.buildCacheKey()
+	if err != nil {
+		return "", false, err
+	}
+
+	names := e.buildCachedPackages.Keys()
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := e.loadBuildCache(e.buildCachedPackages.Get(name), key, nil); !ok {
+			return "//" + name, true, nil
+		}
+	}
+
+	return "", false, nil
+}
@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildCache(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, content string) {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), os.ModePerm)
+		require.NoError(t, err)
+	}
+
+	// ran returns whether the BUILD file got evaluated, along with the deps of the target it declares
+	ran := func() (bool, []string) {
+		e := New(dir)
+
+		err := e.runBuildFiles(dir, e.createPkg)
+		require.NoError(t, err)
+
+		target := e.Targets.Find("//:a")
+		require.NotNil(t, target)
+
+		deps := make([]string, 0)
+		for _, dep := range target.TargetSpec.Deps.Files {
+			deps = append(deps, dep.Path)
+		}
+
+		return e.Packages[""].Globals != nil, deps
+	}
+
+	write("BUILD", `target(name="a", run="echo", deps=glob("*.txt"))`)
+	write("a.txt", "")
+
+	evaluated, deps := ran()
+	assert.True(t, evaluated)
+	assert.Equal(t, []string{"a.txt"}, deps)
+
+	evaluated, deps = ran()
+	assert.False(t, evaluated)
+	assert.Equal(t, []string{"a.txt"}, deps)
+
+	// Glob results are part of the key
+	write("b.txt", "")
+
+	evaluated, deps = ran()
+	assert.True(t, evaluated)
+	assert.Equal(t, []string{"a.txt", "b.txt"}, deps)
+
+	write("BUILD", `target(name="a", run="echo")`)
+	// The compiled program is cached by modtime, which has a resolution of a second
+	future := time.Now().Add(time.Minute)
+	err := os.Chtimes(filepath.Join(dir, "BUILD"), future, future)
+	require.NoError(t, err)
+
+	evaluated, deps = ran()
+	assert.True(t, evaluated)
+	assert.Empty(t, deps)
+}
+
+func TestBuildFilesChanged(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, content string) {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), os.ModePerm)
+		require.NoError(t, err)
+	}
+
+	load := func() *Engine {
+		e := New(dir)
+
+		err := e.runBuildFiles(dir, e.createPkg)
+		require.NoError(t, err)
+
+		return e
+	}
+
+	changed := func(e *Engine) string {
+		what, changed, err := e.BuildFilesChanged()
+		require.NoError(t, err)
+		if !changed {
+			return ""
+		}
+
+		return what
+	}
+
+	write("defs.build", `def txt(): return glob("*.txt")`)
+	write("BUILD", `
+load("//defs.build", "txt")
+target(name="a", run="echo", deps=txt())
+`)
+	write("a.txt", "")
+
+	e := load()
+	assert.Empty(t, changed(e))
+
+	// Content changes of globbed files do not change the graph
+	write("a.txt", "content")
+	assert.Empty(t, changed(e))
+
+	write("b.txt", "")
+	assert.Equal(t, "//", changed(e))
+
+	e = load()
+	assert.Empty(t, changed(e))
+
+	write("defs.build", `def txt(): return []`)
+	assert.Equal(t, "//", changed(e))
+
+	e = load()
+	assert.Empty(t, changed(e))
+
+	write("sub/BUILD", `target(name="b", run="echo")`)
+	assert.Equal(t, "BUILD files of "+dir, changed(e))
+}
@@ -0,0 +1,254 @@
+package engine
+
+import (
+	"fmt"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"heph/packages"
+	"heph/targetspec"
+	"runtime"
+	"sort"
+)
+
+func (e *runBuildEngine) configuredOs() string {
+	if e.configuration.Os != "" {
+		return e.configuration.Os
+	}
+
+	return runtime.GOOS
+}
+
+func (e *runBuildEngine) configuredArch() string {
+	if e.configuration.Arch != "" {
+		return e.configuration.Arch
+	}
+
+	return runtime.GOARCH
+}
+
+func configuration(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+
+	e := getEngine(thread)
+
+	tags := make([]starlark.Value, 0, len(e.configuration.Tags))
+	for _, tag := range e.configuration.Tags {
+		tags = append(tags, starlark.String(tag))
+	}
+
+	keys := make([]string, 0, len(e.configuration.Params))
+	for k := range e.configuration.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	params := &starlark.Dict{}
+	for _, k := range keys {
+		err := params.SetKey(starlark.String(k), starlark.String(e.configuration.Params[k]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return starlarkstruct.FromStringDict(starlark.String("configuration"), starlark.StringDict{
+		"name":   starlark.String(e.configuration.String()),
+		"os":     starlark.String(e.configuredOs()),
+		"arch":   starlark.String(e.configuredArch()),
+		"tags":   starlark.NewList(tags),
+		"params": params,
+	}), nil
+}
+
+// configureSpec names the target after the configuration it is declared under, and its deps after the one they get built under.
+// Tools are built for the host
+func (e *runBuildEngine) configureSpec(t targetspec.TargetSpec) (targetspec.TargetSpec, error) {
+	if e.configuration.IsZero() && t.Transition.IsZero() {
+		return t, nil
+	}
+
+	if !e.configuration.IsZero() {
+		t.Configuration = e.configuration
+		t.Name = e.configuration.TargetName(t.Name)
+		t.FQN = t.Package.TargetPath(t.Name)
+	}
+
+	depsCfg := e.configuration.Merge(t.Transition)
+
+	var err error
+	t.Deps, err = e.configureDeps(t.Deps, depsCfg)
+	if err != nil {
+		return t, fmt.Errorf("%v: deps: %w", t.FQN, err)
+	}
+
+	t.HashDeps, err = e.configureDeps(t.HashDeps, depsCfg)
+	if err != nil {
+		return t, fmt.Errorf("%v: hash_deps: %w", t.FQN, err)
+	}
+
+	t.Transitive.Deps, err = e.configureDeps(t.Transitive.Deps, depsCfg)
+	if err != nil {
+		return t, fmt.Errorf("%v: transitive: %w", t.FQN, err)
+	}
+
+	return t, nil
+}
+
+func (e *runBuildEngine) configureDeps(deps targetspec.TargetSpecDeps, cfg targetspec.Configuration) (targetspec.TargetSpecDeps, error) {
+	if len(deps.Targets) == 0 {
+		return deps, nil
+	}
+
+	targets := make([]targetspec.TargetSpecDepTarget, 0, len(deps.Targets))
+	for _, dep := range deps.Targets {
+		tp, err := targetspec.TargetParse("", dep.Target)
+		if err != nil {
+			return deps, err
+		}
+
+		name, depCfg, ok, err := targetspec.ParseConfiguredName(tp.Name)
+		if err != nil {
+			return deps, err
+		}
+
+		// Labels returned by target() carry the current configuration, others are left as declared
+		if !ok || depCfg.Equal(e.configuration) {
+			tp.Name = cfg.TargetName(name)
+			dep.Target = tp.Full()
+		}
+
+		targets = append(targets, dep)
+	}
+
+	deps.Targets = targets
+
+	return deps, nil
+}
+
+// configuredPkg returns the copy of pkg the BUILD files get evaluated into under cfg
+func (e *Engine) configuredPkg(pkg *packages.Package, cfg targetspec.Configuration) *packages.Package {
+	e.packagesMutex.Lock()
+	defer e.packagesMutex.Unlock()
+
+	key := cfg.String() + "//" + pkg.FullName
+
+	if p, ok := e.configuredPackages[key]; ok {
+		return p
+	}
+
+	p := &packages.Package{
+		Name:        pkg.Name,
+		FullName:    pkg.FullName,
+		Root:        pkg.Root,
+		SourceFiles: pkg.SourceFiles,
+	}
+
+	if e.configuredPackages == nil {
+		e.configuredPackages = map[string]*packages.Package{}
+	}
+	e.configuredPackages[key] = p
+
+	return p
+}
+
+// forPackage returns the engine running the BUILD files of pkg under the same configuration
+func (e *runBuildEngine) forPackage(pkg *packages.Package) *runBuildEngine {
+	if e.configuration.IsZero() {
+		return &runBuildEngine{
+			Engine:         e.Engine,
+			pkg:            pkg,
+			registerTarget: e.defaultRegisterTarget,
+			breadcrumb:     e.breadcrumb,
+		}
+	}
+
+	return &runBuildEngine{
+		Engine:         e.Engine,
+		pkg:            e.configuredPkg(pkg, e.configuration),
+		registerTarget: e.registerTarget,
+		breadcrumb:     e.breadcrumb,
+		configuration:  e.configuration,
+	}
+}
+
+// runConfiguredPackage runs the BUILD files of the package under cfg, configured packages are not cached
+func (e *Engine) runConfiguredPackage(name string, cfg targetspec.Configuration, registerTarget func(targetspec.TargetSpec) error) error {
+	pkg, err := e.loadFromRootsOrCreatePackage(name)
+	if err != nil {
+		return err
+	}
+
+	re := &runBuildEngine{
+		Engine:         e,
+		pkg:            e.configuredPkg(pkg, cfg),
+		registerTarget: registerTarget,
+		configuration:  cfg,
+	}
+
+	if re.pkg.Globals != nil {
+		return nil
+	}
+
+	return re.runBuildFiles()
+}
+
+// configureTargets runs the packages of the deps of targets declared under another configuration,
+// along with the ones of the targets they register, and returns the targets registered
+func (e *Engine) configureTargets(targets []*Target) ([]*Target, error) {
+	e.configureMutex.Lock()
+	defer e.configureMutex.Unlock()
+
+	configured := make([]*Target, 0)
+	registerTarget := func(spec targetspec.TargetSpec) error {
+		err := e.defaultRegisterTarget(spec)
+		if err != nil {
+			return err
+		}
+
+		configured = append(configured, e.Targets.Find(spec.FQN))
+		return nil
+	}
+
+	queue := append([]*Target(nil), targets...)
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+
+		deps := make([]targetspec.TargetSpecDepTarget, 0)
+		deps = append(deps, t.TargetSpec.Deps.Targets...)
+		deps = append(deps, t.TargetSpec.HashDeps.Targets...)
+		deps = append(deps, t.TargetSpec.Transitive.Deps.Targets...)
+
+		for _, dep := range deps {
+			if e.Targets.Find(dep.Target) != nil {
+				continue
+			}
+
+			tp, err := targetspec.TargetParse("", dep.Target)
+			if err != nil {
+				return nil, err
+			}
+
+			_, cfg, ok, err := targetspec.ParseConfiguredName(tp.Name)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", t.FQN, err)
+			}
+			if !ok {
+				continue
+			}
+
+			n := len(configured)
+
+			// Not finding the target is left to the linking
+			err = e.runConfiguredPackage(tp.Package, cfg, registerTarget)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", dep.Target, err)
+			}
+
+			queue = append(queue, configured[n:]...)
+		}
+	}
+
+	return configured, nil
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureTargets(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, content string) {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), os.ModePerm)
+		require.NoError(t, err)
+	}
+
+	write("lib/BUILD", `
+src = target(name="src")
+target(
+    name="lib",
+    run=["echo", get_os(), get_arch(), heph.configuration().name],
+    deps=[src],
+    tools=["//:tool"],
+)
+`)
+	write("BUILD", `
+target(name="tool")
+target(
+    name="bin",
+    deps=["//lib:lib"],
+    transition=heph.transition(os="plan9", arch="arm64", params={"mode": "release"}),
+)
+`)
+
+	// The second run is served by the BUILD cache
+	for i := 0; i < 2; i++ {
+		e := New(dir)
+
+		err := e.runBuildFiles(dir, e.createPkg)
+		require.NoError(t, err)
+
+		configured, err := e.configureTargets(e.Targets.Slice())
+		require.NoError(t, err)
+		assert.Len(t, configured, 2)
+
+		bin := e.Targets.Find("//:bin")
+		require.NotNil(t, bin)
+		assert.Equal(t, "//lib:lib{arch=arm64,mode=release,os=plan9}", bin.TargetSpec.Deps.Targets[0].Target)
+
+		lib := e.Targets.Find("//lib:lib{arch=arm64,mode=release,os=plan9}")
+		require.NotNil(t, lib)
+		assert.Equal(t, []string{"echo", "plan9", "arm64", "arch=arm64,mode=release,os=plan9"}, lib.Run)
+		assert.Equal(t, "//lib:src{arch=arm64,mode=release,os=plan9}", lib.TargetSpec.Deps.Targets[0].Target)
+		assert.Equal(t, "//:tool", lib.TargetSpec.Tools.Targets[0].Target)
+		assert.NotNil(t, e.Targets.Find("//lib:src{arch=arm64,mode=release,os=plan9}"))
+
+		host := e.Targets.Find("//lib:lib")
+		require.NotNil(t, host)
+		assert.Equal(t, []string{"echo", runtime.GOOS, runtime.GOARCH, ""}, host.Run)
+	}
+}
@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"heph/engine/buildevents"
+	"heph/platform"
+	"time"
+)
+
+func (e *Engine) emitCacheEvent(target *Target, cache string, hit bool, err error) {
+	event := buildevents.Event{
+		Type:   buildevents.TypeCacheMiss,
+		Target: target.FQN,
+		Cache:  cache,
+	}
+	if hit {
+		event.Type = buildevents.TypeCacheHit
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	e.BuildEvents.Emit(event)
+}
+
+func (e *Engine) emitRunFinished(target *Target, duration time.Duration, logFile string, err error) {
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+
+		if code, ok := platform.ExitCode(err); ok {
+			exitCode = code
+		}
+	}
+
+	event := buildevents.Event{
+		Type:     buildevents.TypeRunFinished,
+		Target:   target.FQN,
+		ExitCode: &exitCode,
+		Duration: duration,
+		LogFile:  logFile,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	e.BuildEvents.Emit(event)
+}
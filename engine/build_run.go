@@ -82,8 +82,21 @@ func (e *Engine) runBuildFiles(root string, pkgProvider func(dir string) *packag
 		return strings.Compare(e.SourceFiles[i].Path, e.SourceFiles[j].Path) < 0
 	})
 
+	e.buildFilesRoots.Set(root, sourceFilesPaths(files))
+
+	key, err := e.buildCacheKey()
+	if err != nil {
+		return err
+	}
+
+	seen := map[*packages.Package]struct{}{}
 	for _, pkg := range pkgs {
-		err := e.runBuildFilesForPackage(pkg)
+		if _, ok := seen[pkg]; ok {
+			continue
+		}
+		seen[pkg] = struct{}{}
+
+		err := e.runBuildFilesForPackageCached(pkg, key, nil)
 		if err != nil {
 			return err
 		}
@@ -92,7 +105,7 @@ func (e *Engine) runBuildFiles(root string, pkgProvider func(dir string) *packag
 	return nil
 }
 
-func (e *Engine) runBuildFilesForPackage(pkg *packages.Package) error {
+func (e *Engine) runBuildFilesForPackage(pkg *packages.Package, breadcrumb []string) error {
 	if pkg.Globals != nil {
 		return nil
 	}
@@ -101,6 +114,7 @@ func (e *Engine) runBuildFilesForPackage(pkg *packages.Package) error {
 		Engine:         e,
 		pkg:            pkg,
 		registerTarget: e.defaultRegisterTarget,
+		breadcrumb:     breadcrumb,
 	}
 
 	err := re.runBuildFiles()
@@ -111,16 +125,6 @@ func (e *Engine) runBuildFilesForPackage(pkg *packages.Package) error {
 	return nil
 }
 
-func (e *Engine) runBuildFileForPackage(pkg *packages.Package, file string) (starlark.StringDict, error) {
-	re := runBuildEngine{
-		Engine:         e,
-		pkg:            pkg,
-		registerTarget: e.defaultRegisterTarget,
-	}
-
-	return re.runBuildFile(file)
-}
-
 func (e *Engine) defaultRegisterTarget(spec targetspec.TargetSpec) error {
 	l := e.TargetsLock.Get(spec.FQN)
 	l.Lock()
@@ -150,6 +154,7 @@ type runBuildEngine struct {
 	pkg            *packages.Package
 	registerTarget func(targetspec.TargetSpec) error
 	breadcrumb     []string
+	configuration  targetspec.Configuration
 }
 
 func (e *Engine) getOrCreatePkg(path string, fn func(fullname, name string) *packages.Package) *packages.Package {
@@ -252,7 +257,14 @@ func (e *runBuildEngine) load(thread *starlark.Thread, module string) (starlark.
 		if fs2.PathExists(p) {
 			info, _ := os.Lstat(p)
 			if info.Mode().IsRegular() {
-				return e.runBuildFileForPackage(pkg, p)
+				globals, err := e.forPackage(pkg).runBuildFile(p)
+				if err != nil {
+					return nil, err
+				}
+
+				e.recordLoad(thread, p)
+
+				return globals, nil
 			}
 		}
 	}
@@ -262,15 +274,22 @@ func (e *runBuildEngine) load(thread *starlark.Thread, module string) (starlark.
 		return nil, err
 	}
 
-	err = e.runBuildFilesForPackage(pkg)
-	if err != nil {
-		return nil, fmt.Errorf("load: %w", err)
+	re := e.forPackage(pkg)
+	if re.pkg.Globals == nil {
+		err = re.runBuildFiles()
+		if err != nil {
+			return nil, fmt.Errorf("load: %w", err)
+		}
+	}
+
+	for _, file := range pkg.SourceFiles {
+		e.recordLoad(thread, file.Path)
 	}
 
-	return pkg.Globals, nil
+	return re.pkg.Globals, nil
 }
 
-func (e *runBuildEngine) loadFromRootsOrCreatePackage(pkgName string) (*packages.Package, error) {
+func (e *Engine) loadFromRootsOrCreatePackage(pkgName string) (*packages.Package, error) {
 	pkg, err := e.loadFromRoots(pkgName)
 	if err != nil {
 		return nil, err
@@ -359,11 +378,14 @@ func newStarlarkThread() *starlark.Thread {
 }
 
 func (e *runBuildEngine) runBuildFile(path string) (starlark.StringDict, error) {
-	if globals, ok := e.cacheRunBuildFileCache.GetOk(path); ok {
+	// Files get evaluated once per configuration
+	cacheKey := e.configuration.TargetName(path)
+
+	if globals, ok := e.cacheRunBuildFileCache.GetOk(cacheKey); ok {
 		return globals, nil
 	}
 
-	lock := e.cacheRunBuildFileLocks.Get(path)
+	lock := e.cacheRunBuildFileLocks.Get(cacheKey)
 	lock.Lock()
 	defer lock.Unlock()
 
@@ -372,6 +394,8 @@ func (e *runBuildEngine) runBuildFile(path string) (starlark.StringDict, error)
 	thread := newStarlarkThread()
 	thread.Load = e.load
 	thread.SetLocal("engine", e)
+	inputs := &buildInputs{}
+	thread.SetLocal("inputs", inputs)
 
 	config := e.config()
 
@@ -397,7 +421,13 @@ func (e *runBuildEngine) runBuildFile(path string) (starlark.StringDict, error)
 		return nil, err
 	}
 
-	e.cacheRunBuildFileCache.Set(path, res)
+	e.exportGlobals(path, res, inputs)
+
+	e.cacheRunBuildFileCache.Set(cacheKey, res)
+	if e.configuration.IsZero() {
+		// Configured packages are not cached
+		e.buildFilesInputs.Set(path, inputs)
+	}
 
 	return res, nil
 }
@@ -0,0 +1,104 @@
+package buildevents
+
+import (
+	"encoding/json"
+	"fmt"
+	log "heph/hlog"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	TypeTargetScheduled  = "target_scheduled"
+	TypeCacheHit         = "cache_hit"
+	TypeCacheMiss        = "cache_miss"
+	TypeRunStarted       = "run_started"
+	TypeRunFinished      = "run_finished"
+	TypeArtifactUploaded = "artifact_uploaded"
+)
+
+// CacheLocal is the cache name of the events about the local cache
+const CacheLocal = "local"
+
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Target   string    `json:"target,omitempty"`
+	Cache    string    `json:"cache,omitempty"`
+	Artifact string    `json:"artifact,omitempty"`
+	// ExitCode is set on run_finished, -1 if the process did not exit by itself
+	ExitCode *int          `json:"exit_code,omitempty"`
+	Duration time.Duration `json:"duration_ns,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	LogFile  string        `json:"log_file,omitempty"`
+}
+
+// Writer streams events as newline-delimited JSON, a nil Writer discards them
+type Writer struct {
+	m   sync.Mutex
+	w   io.WriteCloser
+	enc *json.Encoder
+	err error
+}
+
+// Open writes the events to path, connecting to it if it is a unix socket, creating the file otherwise
+func Open(path string) (*Writer, error) {
+	var w io.WriteCloser
+	if info, err := os.Stat(path); err == nil && info.Mode().Type() == os.ModeSocket {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			return nil, fmt.Errorf("build events: %w", err)
+		}
+		w = conn
+	} else {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("build events: %w", err)
+		}
+		w = f
+	}
+
+	return &Writer{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}, nil
+}
+
+func (w *Writer) Emit(e Event) {
+	if w == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	// Stop at the first error, the consumer went away
+	if w.err != nil {
+		return
+	}
+
+	w.err = w.enc.Encode(e)
+	if w.err != nil {
+		log.Errorf("build events: %v", w.err)
+	}
+}
+
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.err = os.ErrClosed
+
+	return w.w.Close()
+}
@@ -0,0 +1,46 @@
+package buildevents
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "events.json")
+
+	w, err := Open(p)
+	require.NoError(t, err)
+
+	code := 2
+	w.Emit(Event{Type: TypeRunStarted, Target: "//:a"})
+	w.Emit(Event{Type: TypeRunFinished, Target: "//:a", ExitCode: &code})
+	require.NoError(t, w.Close())
+
+	// Emitting after close, or on a nil writer, is a noop
+	w.Emit(Event{Type: TypeRunStarted})
+	var nw *Writer
+	nw.Emit(Event{Type: TypeRunStarted})
+
+	f, err := os.Open(p)
+	require.NoError(t, err)
+	defer f.Close()
+
+	events := make([]Event, 0)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e Event
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &e))
+		events = append(events, e)
+	}
+
+	require.Len(t, events, 2)
+	assert.Equal(t, TypeRunStarted, events[0].Type)
+	assert.False(t, events[0].Time.IsZero())
+	assert.Nil(t, events[0].ExitCode)
+	assert.Equal(t, 2, *events[1].ExitCode)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"go.opentelemetry.io/otel/attribute"
 	"heph/engine/artifacts"
+	"heph/engine/buildevents"
 	"heph/engine/htrace"
 	log "heph/hlog"
 	"heph/rcache"
@@ -44,32 +45,54 @@ func (e *Engine) linkLatestCache(target *Target, from string) error {
 }
 
 func (e *TargetRunEngine) pullOrGetCacheAndPost(ctx context.Context, target *Target, outputs []string, followHint bool) (bool, error) {
-	pulled, cached, err := e.pullOrGetCache(ctx, target, outputs, false, false, followHint)
-	if err != nil {
-		return false, fmt.Errorf("pullorget: %w", err)
-	}
+	for attempt := 1; ; attempt++ {
+		pulled, cached, err := e.pullOrGetCache(ctx, target, outputs, false, false, followHint)
+		if err != nil {
+			return false, fmt.Errorf("pullorget: %w", err)
+		}
 
-	if !cached {
-		return false, nil
-	}
+		if !cached {
+			return false, nil
+		}
 
-	err = e.postRunOrWarm(ctx, target, outputs, pulled)
-	if err != nil {
-		return false, fmt.Errorf("postrunwarm: %w", err)
-	}
+		// Pulled artifacts are verified when downloaded
+		err = e.postRunOrWarm(ctx, target, outputs, pulled, !pulled)
+		if err != nil {
+			// Evict the corrupted entry, to get it from the remote caches again, or rebuild it
+			if errors.Is(err, CorruptedArtifactError{}) && attempt < 2 {
+				log.Warnf("%v, evicting local cache", err)
 
-	return true, nil
+				err := e.evictLocalCache(target)
+				if err != nil {
+					return false, fmt.Errorf("evict: %w", err)
+				}
+
+				continue
+			}
+
+			return false, fmt.Errorf("postrunwarm: %w", err)
+		}
+
+		return true, nil
+	}
 }
 
 func (e *TargetRunEngine) pullOrGetCache(ctx context.Context, target *Target, outputs []string, onlyMeta, onlyMetaLocal, followHint bool) (rpulled bool, rcached bool, rerr error) {
 	e.Status(TargetStatus(target, "Checking local cache..."))
 
+	err := e.checkHostToolFingerprints(target)
+	if err != nil {
+		return false, false, err
+	}
+
 	// We may want to check that the tar.gz data is available locally, if not it will make sure you can acquire it from cache
 	cached, err := e.getLocalCache(ctx, target, outputs, onlyMetaLocal, false)
 	if err != nil {
 		return false, false, fmt.Errorf("getlocal: %w", err)
 	}
 
+	e.emitCacheEvent(target, buildevents.CacheLocal, cached, nil)
+
 	if cached {
 		return false, true, nil
 	}
@@ -96,6 +119,7 @@ func (e *TargetRunEngine) pullOrGetCache(ctx context.Context, target *Target, ou
 
 		externalCached, err := e.pullExternalCache(ctx, target, outputs, onlyMeta, cache)
 		if err != nil {
+			e.emitCacheEvent(target, cache.Name, false, err)
 			log.Warnf("%v: %v", cache.Name, err)
 			continue
 		}
@@ -103,16 +127,21 @@ func (e *TargetRunEngine) pullOrGetCache(ctx context.Context, target *Target, ou
 		if externalCached {
 			cached, err := e.getLocalCache(ctx, target, outputs, onlyMeta, true)
 			if err != nil {
+				e.emitCacheEvent(target, cache.Name, false, err)
 				log.Errorf("local: %v", err)
 				continue
 			}
 
+			e.emitCacheEvent(target, cache.Name, cached, nil)
+
 			if cached {
 				return true, true, nil
 			}
 
 			log.Warnf("%v cache %v: local cache is supposed to exist locally, but failed getLocalCache, this is not supposed to happen", target.FQN, cache.Name)
 		} else {
+			e.emitCacheEvent(target, cache.Name, false, nil)
+
 			if e.Config.Engine.CacheHints {
 				children, err := e.DAG().GetDescendants(target)
 				if err != nil {
@@ -141,7 +170,19 @@ func (e *TargetRunEngine) pullExternalCache(ctx context.Context, target *Target,
 		return false, err
 	}
 
+	// The manifest holds the checksums the artifacts are verified against, entries stored by an older heph may not have one
+	err = e.downloadExternalCache(ctx, target, cache, target.artifacts.Manifest)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
 	for _, output := range outputs {
+		// The output hash is needed to locate the tarball in the cas
+		err = e.downloadExternalCache(ctx, target, cache, target.artifacts.OutHash(output))
+		if err != nil {
+			return false, err
+		}
+
 		tarArtifact := target.artifacts.OutTar(output)
 		if onlyMeta {
 			exists, err := e.existsExternalCache(ctx, target, cache, tarArtifact)
@@ -158,11 +199,6 @@ func (e *TargetRunEngine) pullExternalCache(ctx context.Context, target *Target,
 				return false, err
 			}
 		}
-
-		err = e.downloadExternalCache(ctx, target, cache, target.artifacts.OutHash(output))
-		if err != nil {
-			return false, err
-		}
 	}
 
 	span.SetAttributes(attribute.Bool(htrace.AttrCacheHit, true))
@@ -179,7 +215,7 @@ func (e *Engine) getLocalCacheArtifact(ctx context.Context, target *Target, arti
 
 	cacheDir := e.cacheDir(target)
 
-	p := cacheDir.Join(artifact.Name()).Abs()
+	p := cacheDir.Join(e.artifactNameIn(target, cacheDir, artifact)).Abs()
 	if !fs.PathExists(p) {
 		return false
 	}
@@ -205,5 +241,7 @@ func (e *Engine) getLocalCache(ctx context.Context, target *Target, outputs []st
 		}
 	}
 
+	e.touchLocalCache(target)
+
 	return true, nil
 }
@@ -1,9 +1,17 @@
 package engine
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"heph/engine/artifacts"
 	"heph/targetspec"
 	"heph/utils"
+	"heph/utils/tar"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -59,6 +67,18 @@ func (o *ArtifactOrchestrator) AllStore() []artifacts.Artifact {
 	})
 }
 
+// Checksummed returns the artifacts whose checksum is recorded in the manifest, the ones stored before it
+func (o *ArtifactOrchestrator) Checksummed() []artifacts.Artifact {
+	all := o.AllStore()
+	for i, a := range all {
+		if a.Name() == o.Manifest.Name() {
+			return all[:i]
+		}
+	}
+
+	return nil
+}
+
 func (o *ArtifactOrchestrator) OutHash(name string) artifacts.Artifact {
 	return o.Out[name].Hash()
 }
@@ -67,6 +87,15 @@ func (o *ArtifactOrchestrator) OutTar(name string) artifacts.Artifact {
 	return o.Out[name].Tar()
 }
 
+// targetCompression returns the compression of the target output tarballs, the engine one if not set on the target
+func (e *Engine) targetCompression(target *Target) tar.Compression {
+	if target.Cache.Compression != "" {
+		return tar.Compression(target.Cache.Compression)
+	}
+
+	return tar.Compression(e.Config.Engine.Compression)
+}
+
 func (e *Engine) newArtifactOrchestrator(target *Target) *ArtifactOrchestrator {
 	o := &ArtifactOrchestrator{
 		InputHash: artifacts.New("hash_input", "#input", true, hashInputArtifact{
@@ -84,6 +113,15 @@ func (e *Engine) newArtifactOrchestrator(target *Target) *ArtifactOrchestrator {
 	names := target.OutWithSupport.Names()
 	names = targetspec.SortOutputsForHashing(names)
 
+	compression := e.targetCompression(target)
+	ext := compression.Ext()
+
+	// The level is validated against the engine compression, targets with their own use the codec default
+	var level int
+	if ext == tar.Compression(e.Config.Engine.Compression).Ext() {
+		level = e.Config.Engine.CompressionLevel
+	}
+
 	for _, name := range names {
 		o.Out[name] = ArtifactsOut{
 			artifacts.New("hash_out_"+name, strings.TrimSpace(name+" #out"), true, hashOutputArtifact{
@@ -91,12 +129,179 @@ func (e *Engine) newArtifactOrchestrator(target *Target) *ArtifactOrchestrator {
 				Target: target,
 				Output: name,
 			}),
-			artifacts.New("out_"+name+".tar.gz", strings.TrimSpace(name+" tar.gz"), true, outTarArtifact{
+			artifacts.New(outTarName(name, ext), strings.TrimSpace(name+" "+strings.TrimPrefix(ext, ".")), true, outTarArtifact{
 				Target: target,
 				Output: name,
+				Options: tar.TarOptions{
+					Deterministic: e.Config.Engine.DeterministicArchives,
+					Compression:   compression,
+					Level:         level,
+				},
 			}),
 		}
 	}
 
 	return o
 }
+
+func fileChecksum(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type CorruptedArtifactError struct {
+	Target   string
+	Artifact string
+	Expected string
+	// Actual is empty if the artifact is missing
+	Actual string
+}
+
+func (e CorruptedArtifactError) Error() string {
+	if e.Actual == "" {
+		return fmt.Sprintf("%v %v is missing, expected checksum %v", e.Target, e.Artifact, e.Expected)
+	}
+
+	return fmt.Sprintf("%v %v is corrupted: checksum %v, expected %v", e.Target, e.Artifact, e.Actual, e.Expected)
+}
+
+func (e CorruptedArtifactError) Is(err error) bool {
+	_, ok := err.(CorruptedArtifactError)
+	return ok
+}
+
+// verifyArtifactFile checks the file at p against the checksum of the artifact recorded in the manifest of its entry,
+// entries stored before checksums were recorded are not verified
+func (e *Engine) verifyArtifactFile(target *Target, m ManifestData, artifact artifacts.Artifact, p string) error {
+	// p is named after the artifact in the entry, output tarballs may have been stored with another compression
+	name := filepath.Base(p)
+
+	expected, ok := m.Checksums[name]
+	if !ok {
+		return nil
+	}
+
+	actual, err := fileChecksum(p)
+	if err != nil {
+		return err
+	}
+
+	if actual == expected {
+		return nil
+	}
+
+	// Output tarballs are content addressed by their output hash, the blob may have been stored
+	// by another entry with different archive bytes, the content is what matters
+	if output, ok := target.artifacts.tarOutput(artifact); ok && m.OutHashes[output] != "" {
+		var supportHash string
+		if target.HasSupportFiles && output != targetspec.SupportFilesOutput {
+			supportHash = m.OutHashes[targetspec.SupportFilesOutput]
+		}
+
+		h, err := e.hashOutputArchive(target, output, p, supportHash)
+		if err == nil && h == m.OutHashes[output] {
+			return nil
+		}
+	}
+
+	return CorruptedArtifactError{
+		Target:   target.FQN,
+		Artifact: name,
+		Expected: expected,
+		Actual:   actual,
+	}
+}
+
+// verifyLocalArtifacts checks the artifacts of the local cache entry of the target
+func (e *Engine) verifyLocalArtifacts(target *Target, as []artifacts.Artifact) error {
+	m, err := e.CachedManifest(target, e.hashInput(target), "")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	for _, artifact := range as {
+		err := e.verifyArtifactFile(target, m, artifact, e.cacheDir(target).Join(e.localArtifactName(target, artifact)).Abs())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeLocalArtifact removes a corrupted artifact from the local cache, along with its cas blob,
+// which is hard linked to it and would be linked again
+func (e *Engine) removeLocalArtifact(target *Target, artifact artifacts.Artifact) error {
+	name := e.localArtifactName(target, artifact)
+	p := e.cacheDir(target).Join(name).Abs()
+
+	if output, ext, ok := e.outTarOutput(target, name); ok {
+		outputHash, err := e.casOutputHash(target, output)
+		if err == nil {
+			err := e.removeLocalCasBlob(outputHash, ext, p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := os.Remove(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// removeLocalCasBlob removes the cas blob of the output hash if the tarball at p, with extension ext, is linked to it
+func (e *Engine) removeLocalCasBlob(outputHash, ext, p string) error {
+	blob := e.localCasDir().Join(casBlobName(outputHash, ext)).Abs()
+
+	binfo, err := os.Stat(blob)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if !os.SameFile(info, binfo) {
+		return nil
+	}
+
+	return os.Remove(blob)
+}
+
+// evictLocalCache removes the local cache entry of the target, for it to be fetched or built again
+func (e *Engine) evictLocalCache(target *Target) error {
+	for _, artifact := range target.artifacts.Checksummed() {
+		err := e.removeLocalArtifact(target, artifact)
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(e.cacheDir(target).Abs())
+}
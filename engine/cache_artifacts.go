@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"heph/engine/artifacts"
 	log "heph/hlog"
 	"heph/targetspec"
@@ -11,13 +12,15 @@ import (
 	"heph/utils/tar"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
 type outTarArtifact struct {
-	Target *Target
-	Output string
+	Target  *Target
+	Output  string
+	Options tar.TarOptions
 }
 
 func (a outTarArtifact) Gen(ctx context.Context, gctx artifacts.GenContext) error {
@@ -45,7 +48,7 @@ func (a outTarArtifact) Gen(ctx context.Context, gctx artifacts.GenContext) erro
 		})
 	}
 
-	err := tar.Tar(ctx, files, gctx.ArtifactPath)
+	err := tar.TarWith(ctx, files, gctx.ArtifactPath, a.Options)
 	if err != nil {
 		return err
 	}
@@ -101,6 +104,10 @@ type ManifestData struct {
 	DepsHashes map[string]map[string]string `json:"deps_hashes,omitempty"`
 	OutHashes  map[string]string            `json:"out_hashes,omitempty"`
 	Timestamp  time.Time                    `json:"timestamp"`
+
+	InputComponents []HashInputComponent `json:"input_components,omitempty"`
+	// Checksums are the sha256 of the artifacts stored before the manifest, by name
+	Checksums map[string]string `json:"checksums,omitempty"`
 }
 
 func (a manifestArtifact) git(args ...string) string {
@@ -121,10 +128,11 @@ func (a manifestArtifact) Gen(ctx context.Context, gctx artifacts.GenContext) er
 		GitRef: gitRefOnce.MustDo(func() (string, error) {
 			return a.git("rev-parse", "--abbrev-ref", "HEAD"), nil
 		}),
-		InputHash:  a.Engine.hashInput(a.Target),
-		DepsHashes: map[string]map[string]string{},
-		OutHashes:  map[string]string{},
-		Timestamp:  time.Now(),
+		InputHash:       a.Engine.hashInput(a.Target),
+		DepsHashes:      map[string]map[string]string{},
+		OutHashes:       map[string]string{},
+		Timestamp:       time.Now(),
+		InputComponents: a.Engine.HashInputComponents(a.Target),
 	}
 
 	e := a.Engine
@@ -144,6 +152,21 @@ func (a manifestArtifact) Gen(ctx context.Context, gctx artifacts.GenContext) er
 		d.OutHashes[name] = e.hashOutput(a.Target, name)
 	}
 
+	dir := filepath.Dir(gctx.ArtifactPath)
+	d.Checksums = map[string]string{}
+	for _, artifact := range a.Target.artifacts.Checksummed() {
+		p := filepath.Join(dir, artifact.Name())
+		if !fs.PathExists(p) {
+			continue
+		}
+
+		sum, err := fileChecksum(p)
+		if err != nil {
+			return fmt.Errorf("checksum %v: %w", artifact.Name(), err)
+		}
+		d.Checksums[artifact.Name()] = sum
+	}
+
 	b, err := json.Marshal(d)
 	if err != nil {
 		return err
@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/c2fo/vfs/v6"
+	"heph/engine/artifacts"
+	log "heph/hlog"
+	"heph/utils/fs"
+	"heph/utils/tar"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Output tarballs are stored content-addressed, keyed by their output hash, in the _cas folder of each cache.
+// The per-input-hash folder only holds the small artifacts (hash_input, manifest.json, hash_out_*...),
+// the manifest pointing to the blobs through its out_hashes, see casOutputHash.
+const casDirName = "_cas"
+
+// casBlobName is keyed by the output hash, which does not depend on the compression, hence the extension
+func casBlobName(outputHash, ext string) string {
+	return outputHash + ext
+}
+
+func outTarName(output, ext string) string {
+	return "out_" + output + ext
+}
+
+// outTarExts returns the extensions the output tarballs of the target may have, the configured compression first:
+// entries stored before the compression changed keep theirs, the codec being detected when reading the tarball
+func (e *Engine) outTarExts(target *Target) []string {
+	exts := []string{e.targetCompression(target).Ext()}
+	for _, c := range tar.CompressionValues {
+		ext := tar.Compression(c).Ext()
+		if ext != exts[0] {
+			exts = append(exts, ext)
+		}
+	}
+
+	return exts
+}
+
+// outTarOutput returns the output of the tarball named name, whatever its compression
+func (e *Engine) outTarOutput(target *Target, name string) (string, string, bool) {
+	for output := range target.artifacts.Out {
+		for _, ext := range e.outTarExts(target) {
+			if outTarName(output, ext) == name {
+				return output, ext, true
+			}
+		}
+	}
+
+	return "", "", false
+}
+
+// artifactNameIn returns the name of the artifact in the entry dir, output tarballs may have been stored
+// with another compression than the configured one
+func (e *Engine) artifactNameIn(target *Target, dir fs.Path, artifact artifacts.Artifact) string {
+	output, ok := target.artifacts.tarOutput(artifact)
+	if !ok {
+		return artifact.Name()
+	}
+
+	for _, ext := range e.outTarExts(target) {
+		name := outTarName(output, ext)
+		if fs.PathExists(dir.Join(name).Abs()) {
+			return name
+		}
+	}
+
+	return artifact.Name()
+}
+
+func (e *Engine) localArtifactName(target *Target, artifact artifacts.Artifact) string {
+	return e.artifactNameIn(target, e.cacheDir(target), artifact)
+}
+
+// localOutTarPath returns the path of the output tarball in the local cache entry of the target
+func (e *Engine) localOutTarPath(target *Target, output string) string {
+	return e.cacheDir(target).Join(e.localArtifactName(target, target.artifacts.OutTar(output))).Abs()
+}
+
+func (e *Engine) localCasDir() fs.Path {
+	return e.HomeDir.Join("cache", casDirName)
+}
+
+func (e *Engine) remoteCasLocation(loc vfs.Location) (vfs.Location, error) {
+	return loc.NewLocation(casDirName + "/")
+}
+
+// tarOutput returns the output name if the artifact is an output tarball
+func (o *ArtifactOrchestrator) tarOutput(artifact artifacts.Artifact) (string, bool) {
+	for name, a := range o.Out {
+		if a.Tar().Name() == artifact.Name() {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// localOutputHash reads the output hash from the local cache, without falling back to computing it
+func (e *Engine) localOutputHash(target *Target, output string) (string, error) {
+	b, err := os.ReadFile(e.cacheDir(target).Join(target.artifacts.OutHash(output).Name()).Abs())
+	if err != nil {
+		return "", err
+	}
+
+	h := strings.TrimSpace(string(b))
+	if h == "" {
+		return "", errors.New("output hash is empty")
+	}
+
+	return h, nil
+}
+
+// casOutputHash returns the output hash keying the blob of the output, as recorded in the out_hashes of the manifest
+// of the local entry. Entries stored by an older heph may have no manifest, the output hash artifact is read instead
+func (e *Engine) casOutputHash(target *Target, output string) (string, error) {
+	m, err := e.CachedManifest(target, e.hashInput(target), "")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("manifest: %w", err)
+	}
+
+	if h := m.OutHashes[output]; h != "" {
+		return h, nil
+	}
+
+	return e.localOutputHash(target, output)
+}
+
+type remoteArtifactPath struct {
+	Location vfs.Location
+	Name     string
+	// Artifact is the name of the artifact in the entry, which differs from the configured one
+	// for output tarballs stored with another compression
+	Artifact string
+}
+
+// remoteArtifactPaths returns where an artifact can be found in a remote cache, in order of preference,
+// an artifact being stored at the first one matching its name in the local entry
+func (e *Engine) remoteArtifactPaths(cache CacheConfig, target *Target, artifact artifacts.Artifact) ([]remoteArtifactPath, error) {
+	root, err := e.remoteCacheLocation(cache.Location, target)
+	if err != nil {
+		return nil, err
+	}
+
+	output, ok := target.artifacts.tarOutput(artifact)
+	if !ok {
+		return []remoteArtifactPath{{Location: root, Name: artifact.Name(), Artifact: artifact.Name()}}, nil
+	}
+
+	outputHash, err := e.casOutputHash(target, output)
+	if err != nil {
+		return nil, err
+	}
+
+	casLoc, err := e.remoteCasLocation(cache.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	exts := e.outTarExts(target)
+
+	paths := make([]remoteArtifactPath, 0, 2*len(exts))
+	for _, ext := range exts {
+		paths = append(paths, remoteArtifactPath{Location: casLoc, Name: casBlobName(outputHash, ext), Artifact: outTarName(output, ext)})
+	}
+	// Entries stored before the cas layout have the tarball in the hash folder
+	for _, ext := range exts {
+		paths = append(paths, remoteArtifactPath{Location: root, Name: outTarName(output, ext), Artifact: outTarName(output, ext)})
+	}
+
+	return paths, nil
+}
+
+// dedupLocalTar replaces the output tarball at p, with extension ext, with a hard link to the cas blob,
+// or seeds the cas with it if the blob does not exist yet.
+// This is best effort, failing to link only means the tarball will not be shared
+func (e *Engine) dedupLocalTar(target *Target, output, ext, p string) {
+	outputHash, err := e.casOutputHash(target, output)
+	if err != nil {
+		log.Debugf("dedup %v %v: %v", target.FQN, output, err)
+		return
+	}
+
+	mu := e.casMutex.Get(outputHash)
+	mu.Lock()
+	defer mu.Unlock()
+
+	blob := e.localCasDir().Join(casBlobName(outputHash, ext)).Abs()
+
+	var from, to string
+	if fs.PathExists(blob) {
+		from, to = blob, p
+	} else {
+		from, to = p, blob
+	}
+
+	err = fs.CreateParentDir(to)
+	if err != nil {
+		log.Debugf("dedup %v %v: %v", target.FQN, output, err)
+		return
+	}
+
+	tmp := fs.ProcessUniquePath(to)
+	defer os.Remove(tmp)
+
+	err = os.Link(from, tmp)
+	if err != nil {
+		log.Debugf("dedup %v %v: %v", target.FQN, output, err)
+		return
+	}
+
+	err = os.Rename(tmp, to)
+	if err != nil {
+		log.Debugf("dedup %v %v: %v", target.FQN, output, err)
+		return
+	}
+}
+
+// gcCas removes the local blobs that are not linked from any target cache folder anymore
+func (e *Engine) gcCas(flog func(string, ...interface{}), dryrun bool) error {
+	if flog == nil {
+		flog = func(string, ...interface{}) {}
+	}
+
+	dir := e.localCasDir().Abs()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	flog("%v:", casDirName)
+	for _, entry := range entries {
+		p := filepath.Join(dir, entry.Name())
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		if links, ok := fs.LinkCount(info); ok && links > 1 {
+			continue
+		}
+
+		flog("* Delete %v", entry.Name())
+		if !dryrun {
+			err := os.Remove(p)
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	}
+	flog("")
+
+	return nil
+}
@@ -0,0 +1,314 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"heph/utils/fs"
+	"heph/utils/tar"
+	"heph/vfssimple"
+	"heph/worker"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutTarCompressionSwitch(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+target(name="a", out="a", cache=True)
+`), os.ModePerm)
+	require.NoError(t, err)
+
+	e := New(dir)
+	err = e.runBuildFiles(dir, e.createPkg)
+	require.NoError(t, err)
+
+	// Entries were stored with gzip, the default, before switching to zstd
+	e.Config.Engine.Compression = string(tar.CompressionZstd)
+
+	target := e.Targets.Find("//:a")
+	require.NotNil(t, target)
+	err = e.processTarget(target)
+	require.NoError(t, err)
+	err = e.LinkTarget(target, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, "out_.tar.zst", target.artifacts.OutTar("").Name())
+
+	src := filepath.Join(t.TempDir(), "a")
+	err = os.WriteFile(src, []byte("content"), os.ModePerm)
+	require.NoError(t, err)
+
+	cacheDir := e.cacheDir(target)
+	err = os.MkdirAll(cacheDir.Abs(), os.ModePerm)
+	require.NoError(t, err)
+
+	gzPath := cacheDir.Join("out_.tar.gz").Abs()
+	err = tar.TarWith(ctx, []tar.TarFile{{From: src, To: "a"}}, gzPath, tar.TarOptions{Compression: tar.CompressionGzip})
+	require.NoError(t, err)
+
+	for _, artifact := range []string{target.artifacts.InputHash.Name(), target.artifacts.OutHash("").Name()} {
+		err = os.WriteFile(cacheDir.Join(artifact).Abs(), []byte("outhash"), os.ModePerm)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, "out_.tar.gz", e.localArtifactName(target, target.artifacts.OutTar("")))
+	assert.Equal(t, gzPath, e.localOutTarPath(target, ""))
+
+	ok, err := e.getLocalCache(ctx, target, []string{""}, false, false)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	h, err := e.hashOutputArchive(target, "", e.localOutTarPath(target, ""), "")
+	require.NoError(t, err)
+	assert.NotEmpty(t, h)
+
+	root := t.TempDir()
+	loc, err := vfssimple.NewLocation("file://" + root + "/")
+	require.NoError(t, err)
+
+	paths, err := e.remoteArtifactPaths(CacheConfig{Name: "remote", Location: loc}, target, target.artifacts.OutTar(""))
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(paths))
+	for _, p := range paths {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"outhash.tar.zst", "outhash.tar.gz", "outhash.tar", "out_.tar.zst", "out_.tar.gz", "out_.tar"}, names)
+	assert.Equal(t, "out_.tar.gz", paths[1].Artifact)
+}
+
+func newCasTestEngine(t *testing.T) *Engine {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+target(name="a", out="a", cache=True)
+target(name="b", out="b", cache=True)
+target(name="c", out="c", cache=True)
+`), os.ModePerm)
+	require.NoError(t, err)
+
+	e := New(dir)
+	err = e.runBuildFiles(dir, e.createPkg)
+	require.NoError(t, err)
+
+	for _, target := range e.Targets.Slice() {
+		err := e.processTarget(target)
+		require.NoError(t, err)
+		err = e.LinkTarget(target, nil)
+		require.NoError(t, err)
+	}
+
+	return e
+}
+
+// writeCasTestEntry writes the local cache entry of target, its output tarball holding content, returns the tarball path
+func writeCasTestEntry(t *testing.T, e *Engine, target *Target, outHash, content string) string {
+	cacheDir := e.cacheDir(target)
+	err := os.MkdirAll(cacheDir.Abs(), os.ModePerm)
+	require.NoError(t, err)
+
+	for _, artifact := range []string{target.artifacts.InputHash.Name(), target.artifacts.OutHash("").Name()} {
+		err = os.WriteFile(cacheDir.Join(artifact).Abs(), []byte(outHash), os.ModePerm)
+		require.NoError(t, err)
+	}
+
+	p := cacheDir.Join(target.artifacts.OutTar("").Name()).Abs()
+	err = os.WriteFile(p, []byte(content), os.ModePerm)
+	require.NoError(t, err)
+
+	return p
+}
+
+func fileInode(t *testing.T, p string) fs.Inode {
+	info, err := os.Stat(p)
+	require.NoError(t, err)
+
+	inode, ok := fs.FileInode(info)
+	if !ok {
+		t.Skip("inodes not supported")
+	}
+
+	return inode
+}
+
+func TestCasDedupLocal(t *testing.T) {
+	e := newCasTestEngine(t)
+
+	a := e.Targets.Find("//:a")
+	b := e.Targets.Find("//:b")
+	c := e.Targets.Find("//:c")
+
+	ext := e.outTarExts(a)[0]
+
+	pa := writeCasTestEntry(t, e, a, "shared", "content")
+	pb := writeCasTestEntry(t, e, b, "shared", "content")
+	pc := writeCasTestEntry(t, e, c, "other", "other")
+
+	blob := e.localCasDir().Join(casBlobName("shared", ext)).Abs()
+
+	// The first tarball seeds the cas, the second one is replaced with a link to the blob
+	e.dedupLocalTar(a, "", ext, pa)
+	assert.Equal(t, fileInode(t, blob), fileInode(t, pa))
+
+	e.dedupLocalTar(b, "", ext, pb)
+	assert.Equal(t, fileInode(t, blob), fileInode(t, pb))
+
+	b1, err := os.ReadFile(pb)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(b1))
+
+	e.dedupLocalTar(c, "", ext, pc)
+	otherBlob := e.localCasDir().Join(casBlobName("other", ext)).Abs()
+	assert.Equal(t, fileInode(t, otherBlob), fileInode(t, pc))
+
+	orphan := e.localCasDir().Join(casBlobName("orphan", ext)).Abs()
+	err = os.WriteFile(orphan, []byte("orphan"), os.ModePerm)
+	require.NoError(t, err)
+
+	err = e.gcCas(nil, true)
+	require.NoError(t, err)
+	assert.FileExists(t, orphan)
+
+	err = e.gcCas(nil, false)
+	require.NoError(t, err)
+	assert.NoFileExists(t, orphan)
+	assert.FileExists(t, blob)
+	assert.FileExists(t, otherBlob)
+
+	// The blob stays as long as one entry links to it
+	require.NoError(t, os.Remove(pa))
+	err = e.gcCas(nil, false)
+	require.NoError(t, err)
+	assert.FileExists(t, blob)
+
+	require.NoError(t, os.Remove(pb))
+	err = e.gcCas(nil, false)
+	require.NoError(t, err)
+	assert.NoFileExists(t, blob)
+	assert.FileExists(t, otherBlob)
+}
+
+func TestCasRemote(t *testing.T) {
+	ctx := context.Background()
+
+	e := newCasTestEngine(t)
+	re := &TargetRunEngine{Engine: e, Status: func(worker.Status) {}}
+
+	a := e.Targets.Find("//:a")
+	b := e.Targets.Find("//:b")
+	c := e.Targets.Find("//:c")
+
+	ext := e.outTarExts(a)[0]
+
+	root := t.TempDir()
+	loc, err := vfssimple.NewLocation("file://" + root + "/")
+	require.NoError(t, err)
+
+	cache := CacheConfig{Name: "remote", Location: loc}
+
+	writeCasTestEntry(t, e, a, "shared", "content")
+	pb := writeCasTestEntry(t, e, b, "shared", "content")
+
+	casBlob := filepath.Join(root, casDirName, casBlobName("shared", ext))
+
+	err = re.storeExternalCache(ctx, a, cache, a.artifacts.OutTar(""))
+	require.NoError(t, err)
+	assert.FileExists(t, casBlob)
+
+	// Not in the entry folder, the entries share the blob
+	aRoot, err := e.remoteCacheLocation(loc, a)
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(aRoot.Path(), a.artifacts.OutTar("").Name()))
+
+	// Truncated by an interrupted upload, the blob is uploaded again
+	err = os.WriteFile(casBlob, []byte("cont"), os.ModePerm)
+	require.NoError(t, err)
+
+	err = re.storeExternalCache(ctx, b, cache, b.artifacts.OutTar(""))
+	require.NoError(t, err)
+
+	rb, err := os.ReadFile(casBlob)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(rb))
+
+	// Already there, the blob is not uploaded again
+	err = os.WriteFile(casBlob, []byte("remote!"), os.ModePerm)
+	require.NoError(t, err)
+
+	err = re.storeExternalCache(ctx, b, cache, b.artifacts.OutTar(""))
+	require.NoError(t, err)
+
+	rb, err = os.ReadFile(casBlob)
+	require.NoError(t, err)
+	assert.Equal(t, "remote!", string(rb))
+
+	ok, err := re.existsExternalCache(ctx, b, cache, b.artifacts.OutTar(""))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Downloaded from the blob, then shared with the local cas
+	require.NoError(t, os.Remove(pb))
+
+	err = re.downloadExternalCacheArtifact(ctx, b, cache, b.artifacts.OutTar(""))
+	require.NoError(t, err)
+
+	lb, err := os.ReadFile(pb)
+	require.NoError(t, err)
+	assert.Equal(t, "remote!", string(lb))
+	assert.Equal(t, fileInode(t, e.localCasDir().Join(casBlobName("shared", ext)).Abs()), fileInode(t, pb))
+
+	// Entries stored before the cas layout are found in the entry folder
+	pc := writeCasTestEntry(t, e, c, "legacy", "legacy")
+	require.NoError(t, os.Remove(pc))
+
+	cRoot, err := e.remoteCacheLocation(loc, c)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(cRoot.Path(), os.ModePerm))
+	err = os.WriteFile(filepath.Join(cRoot.Path(), c.artifacts.OutTar("").Name()), []byte("legacy"), os.ModePerm)
+	require.NoError(t, err)
+
+	ok, err = re.existsExternalCache(ctx, c, cache, c.artifacts.OutTar(""))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	err = re.downloadExternalCacheArtifact(ctx, c, cache, c.artifacts.OutTar(""))
+	require.NoError(t, err)
+
+	lc, err := os.ReadFile(pc)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", string(lc))
+
+	// Missing everywhere
+	empty, err := vfssimple.NewLocation("file://" + t.TempDir() + "/")
+	require.NoError(t, err)
+
+	ok, err = re.existsExternalCache(ctx, a, CacheConfig{Name: "empty", Location: empty}, a.artifacts.OutTar(""))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCasOutputHash(t *testing.T) {
+	e := newCasTestEngine(t)
+	a := e.Targets.Find("//:a")
+
+	// Entries stored by an older heph have no manifest
+	writeCasTestEntry(t, e, a, "fromfile", "content")
+
+	h, err := e.casOutputHash(a, "")
+	require.NoError(t, err)
+	assert.Equal(t, "fromfile", h)
+
+	b, err := json.Marshal(ManifestData{OutHashes: map[string]string{"": "frommanifest"}})
+	require.NoError(t, err)
+	err = os.WriteFile(e.cacheDir(a).Join(a.artifacts.Manifest.Name()).Abs(), b, os.ModePerm)
+	require.NoError(t, err)
+
+	h, err = e.casOutputHash(a, "")
+	require.NoError(t, err)
+	assert.Equal(t, "frommanifest", h)
+}
@@ -2,10 +2,12 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/c2fo/vfs/v6"
 	"go.opentelemetry.io/otel/attribute"
 	"heph/engine/artifacts"
+	"heph/engine/buildevents"
 	"heph/engine/htrace"
 	log "heph/hlog"
 	"heph/utils"
@@ -26,13 +28,35 @@ func (e *Engine) localCacheLocation(target *Target) (vfs.Location, error) {
 
 func (e *Engine) remoteCacheLocation(loc vfs.Location, target *Target) (vfs.Location, error) {
 	// TODO: cache
-	inputHash := e.hashInput(target)
+	return remoteCacheEntryLocation(loc, target.Package.FullName, target.Name, e.hashInput(target))
+}
+
+func remoteCacheEntryLocation(loc vfs.Location, pkg, name, inputHash string) (vfs.Location, error) {
+	return loc.NewLocation(filepath.Join(pkg, name, inputHash) + "/")
+}
+
+func (e *Engine) cacheConfig(name string) (CacheConfig, error) {
+	for _, c := range e.Config.Caches {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+
+	return CacheConfig{}, fmt.Errorf("cache %v not found", name)
+}
 
-	return loc.NewLocation(filepath.Join(target.Package.FullName, target.Name, inputHash) + "/")
+func vfsTouch(loc vfs.Location, name string) error {
+	f, err := loc.NewFile(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Touch()
 }
 
-func (e *Engine) vfsCopyFileIfNotExists(ctx context.Context, from, to vfs.Location, path string) (bool, error) {
-	tof, err := to.NewFile(path)
+func (e *Engine) vfsCopyFileIfNotExists(ctx context.Context, from vfs.Location, fromPath string, to vfs.Location, toPath string) (bool, error) {
+	tof, err := to.NewFile(toPath)
 	if err != nil {
 		return false, err
 	}
@@ -48,7 +72,58 @@ func (e *Engine) vfsCopyFileIfNotExists(ctx context.Context, from, to vfs.Locati
 		return false, nil
 	}
 
-	err = e.vfsCopyFile(ctx, from, to, path)
+	err = e.vfsCopyFile(ctx, from, fromPath, to, toPath)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// vfsCopyBlob copies the blob unless it already exists with the same size, a blob left truncated
+// by an interrupted upload is replaced. A blob compressed with another level is replaced too, which is harmless.
+// Returns whether it was copied
+func (e *Engine) vfsCopyBlob(ctx context.Context, from vfs.Location, fromPath string, to vfs.Location, toPath string) (bool, error) {
+	sf, err := from.NewFile(fromPath)
+	if err != nil {
+		return false, err
+	}
+
+	size, err := sf.Size()
+	_ = sf.Close()
+	if err != nil {
+		return false, err
+	}
+
+	tof, err := to.NewFile(toPath)
+	if err != nil {
+		return false, err
+	}
+
+	exists, err := tof.Exists()
+	if err != nil {
+		_ = tof.Close()
+		return false, err
+	}
+
+	if exists {
+		remoteSize, err := tof.Size()
+		_ = tof.Close()
+		if err != nil {
+			return false, err
+		}
+
+		if remoteSize == size {
+			log.Tracef("vfs copy %v to %v: exists", from.URI(), to.URI())
+			return false, nil
+		}
+
+		log.Debugf("vfs copy %v to %v: %v bytes instead of %v, copying again", from.URI(), to.URI(), remoteSize, size)
+	} else {
+		_ = tof.Close()
+	}
+
+	err = e.vfsCopyFile(ctx, from, fromPath, to, toPath)
 	if err != nil {
 		return false, err
 	}
@@ -56,13 +131,13 @@ func (e *Engine) vfsCopyFileIfNotExists(ctx context.Context, from, to vfs.Locati
 	return true, nil
 }
 
-func (e *Engine) vfsCopyFile(ctx context.Context, from, to vfs.Location, path string) error {
+func (e *Engine) vfsCopyFile(ctx context.Context, from vfs.Location, fromPath string, to vfs.Location, toPath string) error {
 	log.Tracef("vfs copy %v to %v", from.URI(), to.URI())
 
 	doneTrace := utils.TraceTimingDone(fmt.Sprintf("vfs copy to %v", to.URI()))
 	defer doneTrace()
 
-	sf, err := from.NewFile(path)
+	sf, err := from.NewFile(fromPath)
 	if err != nil {
 		return fmt.Errorf("NewFile: %w", err)
 	}
@@ -82,12 +157,17 @@ func (e *Engine) vfsCopyFile(ctx context.Context, from, to vfs.Location, path st
 		return fmt.Errorf("copy %v: %w", sf.URI(), os.ErrNotExist)
 	}
 
-	df, err := sf.CopyToLocation(to)
+	df, err := to.NewFile(toPath)
 	if err != nil {
-		return fmt.Errorf("CopyToLocation: %w", err)
+		return fmt.Errorf("NewFile: %w", err)
 	}
 	defer df.Close()
 
+	err = sf.CopyToFile(df)
+	if err != nil {
+		return fmt.Errorf("CopyToFile: %w", err)
+	}
+
 	return nil
 }
 
@@ -147,21 +227,48 @@ func (e *TargetRunEngine) storeExternalCache(ctx context.Context, target *Target
 
 	e.Status(TargetOutputStatus(target, artifact.DisplayName(), fmt.Sprintf("Uploading to %v...", cache.Name)))
 
-	remoteRoot, err := e.remoteCacheLocation(cache.Location, target)
+	remotePaths, err := e.remoteArtifactPaths(cache, target, artifact)
 	if err != nil {
 		return err
 	}
 
+	localName := e.localArtifactName(target, artifact)
+
+	remotePath := remotePaths[0]
+	for _, p := range remotePaths {
+		if p.Artifact == localName {
+			remotePath = p
+			break
+		}
+	}
+
 	span := e.SpanCacheUpload(ctx, target, artifact)
 	defer func() {
 		span.EndError(rerr)
 	}()
 
-	err = e.vfsCopyFile(ctx, localRoot, remoteRoot, artifact.Name())
+	if _, ok := target.artifacts.tarOutput(artifact); ok {
+		// Blobs are content addressed, no need to upload it again if it is already there
+		var copied bool
+		copied, err = e.vfsCopyBlob(ctx, localRoot, localName, remotePath.Location, remotePath.Name)
+		if err == nil && !copied {
+			// Refreshes its mtime, for a concurrent gc not to delete it before the entry points to it
+			err = vfsTouch(remotePath.Location, remotePath.Name)
+		}
+	} else {
+		err = e.vfsCopyFile(ctx, localRoot, localName, remotePath.Location, remotePath.Name)
+	}
 	if err != nil {
 		return err
 	}
 
+	e.BuildEvents.Emit(buildevents.Event{
+		Type:     buildevents.TypeArtifactUploaded,
+		Target:   target.FQN,
+		Cache:    cache.Name,
+		Artifact: artifact.Name(),
+	})
+
 	return nil
 }
 
@@ -185,31 +292,120 @@ func (e *TargetRunEngine) downloadExternalCache(ctx context.Context, target *Tar
 		}
 	}()
 
-	localRoot, err := e.localCacheLocation(target)
+	for attempt := 1; ; attempt++ {
+		err = e.downloadExternalCacheArtifact(ctx, target, cache, artifact)
+		if err != nil {
+			return err
+		}
+
+		err = e.verifyLocalArtifacts(target, []artifacts.Artifact{artifact})
+		if err == nil {
+			return nil
+		}
+
+		if err := e.removeLocalArtifact(target, artifact); err != nil {
+			return err
+		}
+
+		if !errors.Is(err, CorruptedArtifactError{}) {
+			return err
+		}
+
+		// A truncated download is worth another try, a corrupted entry will fail again
+		if attempt >= 2 {
+			var cerr CorruptedArtifactError
+			if errors.As(err, &cerr) {
+				e.evictCorruptedRemoteBlob(cache, target, artifact, cerr.Artifact)
+			}
+			return err
+		}
+
+		log.Warnf("%v, downloading again", err)
+	}
+}
+
+// evictCorruptedRemoteBlob deletes the corrupted blob of an output tarball, stored under name in the entry, from a writable cache,
+// blobs are not uploaded again while they exist, the rebuild would not replace it otherwise
+func (e *TargetRunEngine) evictCorruptedRemoteBlob(cache CacheConfig, target *Target, artifact artifacts.Artifact, name string) {
+	if !cache.Write {
+		return
+	}
+
+	if _, ok := target.artifacts.tarOutput(artifact); !ok {
+		return
+	}
+
+	remotePaths, err := e.remoteArtifactPaths(cache, target, artifact)
 	if err != nil {
-		return err
+		log.Errorf("%v: %v", cache.Name, err)
+		return
 	}
 
-	remoteRoot, err := e.remoteCacheLocation(cache.Location, target)
+	// Blobs come first
+	var blob remoteArtifactPath
+	for _, p := range remotePaths {
+		if p.Artifact == name {
+			blob = p
+			break
+		}
+	}
+	if blob.Location == nil {
+		return
+	}
+
+	exists, err := e.vfsExists(blob.Location, blob.Name)
+	if err != nil || !exists {
+		return
+	}
+
+	err = blob.Location.DeleteFile(blob.Name)
+	if err != nil {
+		log.Errorf("%v: delete %v: %v", cache.Name, blob.Name, err)
+		return
+	}
+
+	log.Warnf("%v: deleted corrupted %v", cache.Name, blob.Name)
+}
+
+func (e *TargetRunEngine) downloadExternalCacheArtifact(ctx context.Context, target *Target, cache CacheConfig, artifact artifacts.Artifact) error {
+	localRoot, err := e.localCacheLocation(target)
 	if err != nil {
 		return err
 	}
 
-	copied, err := e.vfsCopyFileIfNotExists(ctx, remoteRoot, localRoot, artifact.Name())
+	remotePaths, err := e.remoteArtifactPaths(cache, target, artifact)
 	if err != nil {
 		return err
 	}
 
-	// A file may exist locally, but not remotely (coming from another source), make sure that it actually exists there
-	if !copied {
-		remoteExist, err := e.existsExternalCache(ctx, target, cache, artifact)
+	var found remoteArtifactPath
+	for i, remotePath := range remotePaths {
+		copied, err := e.vfsCopyFileIfNotExists(ctx, remotePath.Location, remotePath.Name, localRoot, remotePath.Artifact)
 		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && i < len(remotePaths)-1 {
+				continue
+			}
 			return err
 		}
 
-		if !remoteExist {
-			return fmt.Errorf("%v: %w", filepath.Join(remoteRoot.URI(), artifact.Name()), os.ErrNotExist)
+		// A file may exist locally, but not remotely (coming from another source), make sure that it actually exists there
+		if !copied {
+			remoteExist, err := e.existsExternalCache(ctx, target, cache, artifact)
+			if err != nil {
+				return err
+			}
+
+			if !remoteExist {
+				return fmt.Errorf("%v: %w", filepath.Join(remotePath.Location.URI(), remotePath.Name), os.ErrNotExist)
+			}
 		}
+
+		found = remotePath
+		break
+	}
+
+	if output, ext, ok := e.outTarOutput(target, found.Artifact); ok {
+		e.dedupLocalTar(target, output, ext, e.cacheDir(target).Join(found.Artifact).Abs())
 	}
 
 	return nil
@@ -218,12 +414,27 @@ func (e *TargetRunEngine) downloadExternalCache(ctx context.Context, target *Tar
 func (e *TargetRunEngine) existsExternalCache(ctx context.Context, target *Target, cache CacheConfig, artifact artifacts.Artifact) (bool, error) {
 	e.Status(TargetOutputStatus(target, artifact.DisplayName(), fmt.Sprintf("Checking from %v...", cache.Name)))
 
-	root, err := e.remoteCacheLocation(cache.Location, target)
+	remotePaths, err := e.remoteArtifactPaths(cache, target, artifact)
 	if err != nil {
 		return false, err
 	}
 
-	f, err := root.NewFile(artifact.Name())
+	for _, remotePath := range remotePaths {
+		exists, err := e.vfsExists(remotePath.Location, remotePath.Name)
+		if err != nil {
+			return false, err
+		}
+
+		if exists {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (e *Engine) vfsExists(loc vfs.Location, path string) (bool, error) {
+	f, err := loc.NewFile(path)
 	if err != nil {
 		return false, err
 	}
@@ -238,7 +449,7 @@ func (e *TargetRunEngine) existsLocalCache(ctx context.Context, target *Target,
 		return false, err
 	}
 
-	f, err := root.NewFile(artifact.Name())
+	f, err := root.NewFile(e.localArtifactName(target, artifact))
 	if err != nil {
 		return false, err
 	}
@@ -3,7 +3,10 @@ package engine
 import (
 	"context"
 	"errors"
+	"fmt"
 	log "heph/hlog"
+	"heph/utils"
+	fs2 "heph/utils/fs"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -33,6 +36,10 @@ func (e *Engine) gcCollectTargetDirs(root string) ([]string, error) {
 			return nil
 		}
 
+		if path == e.localCasDir().Abs() {
+			return filepath.SkipDir
+		}
+
 		if strings.HasPrefix(d.Name(), "__target_") {
 			targetDirs = append(targetDirs, path)
 		}
@@ -77,6 +84,7 @@ func (e *Engine) runGc(targetDirs []string, flog func(string, ...interface{}), d
 				if err != nil {
 					log.Error(err)
 				}
+				e.removeTestPasses(dir)
 			}
 
 			continue
@@ -123,6 +131,7 @@ func (e *Engine) runGc(targetDirs []string, flog func(string, ...interface{}), d
 				if err != nil {
 					log.Error(err)
 				}
+				e.removeTestPasses(dir)
 			}
 
 			continue
@@ -168,6 +177,7 @@ func (e *Engine) runGc(targetDirs []string, flog func(string, ...interface{}), d
 				if err != nil {
 					log.Error(err)
 				}
+				e.removeTestPass(entry.HashPath)
 			}
 		}
 		flog("")
@@ -176,6 +186,518 @@ func (e *Engine) runGc(targetDirs []string, flog func(string, ...interface{}), d
 	return nil
 }
 
+// removeTestPasses deletes the test passes recorded for the target of the cache target dir
+func (e *Engine) removeTestPasses(targetDir string) {
+	p, ok := e.testPassPathForCache(targetDir)
+	if !ok {
+		return
+	}
+
+	err := os.RemoveAll(p)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// removeTestPass deletes the test pass recorded for the input hash of the cache hash dir
+func (e *Engine) removeTestPass(hashPath string) {
+	p, ok := e.testPassPathForCache(hashPath)
+	if !ok {
+		return
+	}
+
+	err := os.Remove(p + ".json")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Error(err)
+	}
+}
+
+// gcTestPasses deletes the test passes of the targets no longer in the graph, and the ones beyond the target cache history,
+// the passes of the tests not cached have no hash dir to follow
+func (e *Engine) gcTestPasses(flog func(string, ...interface{}), dryrun bool) error {
+	if flog == nil {
+		flog = func(string, ...interface{}) {}
+	}
+
+	root := e.HomeDir.Join("test_results").Abs()
+
+	targetDirs := map[string]*Target{}
+	for _, target := range e.Targets.Slice() {
+		if target.IsTest() {
+			targetDirs[e.testPassDir(target).Abs()] = target
+		}
+	}
+
+	dirs := make([]string, 0)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && strings.HasPrefix(d.Name(), "__target_") {
+			dirs = append(dirs, path)
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	homeDir := e.HomeDir.Abs()
+	for _, dir := range dirs {
+		reldir, _ := filepath.Rel(homeDir, dir)
+
+		target, ok := targetDirs[dir]
+		if !ok {
+			flog("%v:", reldir)
+			flog("Not part of schema or not a test, delete")
+			flog("")
+			if !dryrun {
+				err := os.RemoveAll(dir)
+				if err != nil {
+					log.Error(err)
+				}
+			}
+
+			continue
+		}
+
+		dirEntries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		type passEntry struct {
+			Path string
+			Time time.Time
+		}
+
+		entries := make([]passEntry, 0, len(dirEntries))
+		for _, entry := range dirEntries {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+
+			entries = append(entries, passEntry{Path: filepath.Join(dir, entry.Name()), Time: info.ModTime()})
+		}
+
+		if len(entries) <= target.Cache.History {
+			continue
+		}
+
+		// Sort fresher first
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Time.After(entries[j].Time)
+		})
+
+		flog("%v:", reldir)
+		for _, entry := range entries[target.Cache.History:] {
+			flog("* Delete %v %v", filepath.Base(entry.Path), entry.Time.Format(time.RFC3339))
+			if !dryrun {
+				err := os.Remove(entry.Path)
+				if err != nil {
+					log.Error(err)
+				}
+			}
+		}
+		flog("")
+	}
+
+	return nil
+}
+
+// touchLocalCache records the access to the cache entry of the target, the GC evicts the least recently used entries first
+func (e *Engine) touchLocalCache(target *Target) {
+	now := time.Now()
+
+	err := os.Chtimes(e.cacheDir(target).Abs(), now, now)
+	if err != nil {
+		log.Debugf("touch %v: %v", target.FQN, err)
+	}
+}
+
+type gcHashEntry struct {
+	TargetDir string
+	HashPath  string
+	// Size counts the files linked from other entries too
+	Size  int64
+	Time  time.Time
+	Files []gcFile
+}
+
+type gcFile struct {
+	// Key is the inode of the file, its path if the platform does not expose it
+	Key   string
+	Size  int64
+	Links uint64
+}
+
+func dirFiles(dir string) ([]gcFile, error) {
+	files := make([]gcFile, 0)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		file := gcFile{Key: path, Size: info.Size(), Links: 1}
+		if inode, ok := fs2.FileInode(info); ok {
+			file.Key = fmt.Sprintf("%v:%v", inode.Dev, inode.Ino)
+		}
+		if links, ok := fs2.LinkCount(info); ok {
+			file.Links = links
+		}
+
+		files = append(files, file)
+
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return files, nil
+	}
+
+	return files, err
+}
+
+// gcUsage accounts for the disk usage of the local cache, the tarballs hard linked from several entries
+// and from the cas being counted once
+type gcUsage struct {
+	Total int64
+	sizes map[string]int64
+	// links is the number of links to the file left, removing an entry frees the files it holds the last links to
+	links map[string]uint64
+	cas   map[string]struct{}
+}
+
+func (e *Engine) gcCollectUsage(entries []gcHashEntry) (*gcUsage, error) {
+	u := &gcUsage{
+		sizes: map[string]int64{},
+		links: map[string]uint64{},
+		cas:   map[string]struct{}{},
+	}
+
+	add := func(file gcFile) {
+		if _, ok := u.sizes[file.Key]; !ok {
+			u.sizes[file.Key] = file.Size
+			u.links[file.Key] = file.Links
+			u.Total += file.Size
+		}
+	}
+
+	for _, entry := range entries {
+		for _, file := range entry.Files {
+			add(file)
+		}
+	}
+
+	casFiles, err := dirFiles(e.localCasDir().Abs())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range casFiles {
+		add(file)
+		u.cas[file.Key] = struct{}{}
+	}
+
+	return u, nil
+}
+
+// isFreed returns if the only link left to the file is from the cas, the blob then gets collected by gcCas
+func (u *gcUsage) isFreed(key string) bool {
+	links := u.links[key]
+	if _, ok := u.cas[key]; ok {
+		return links <= 1
+	}
+
+	return links == 0
+}
+
+// Orphans returns the size of the blobs no entry links to, which gcCas collects
+func (u *gcUsage) Orphans() int64 {
+	var size int64
+	for key := range u.cas {
+		if u.isFreed(key) {
+			size += u.sizes[key]
+		}
+	}
+
+	return size
+}
+
+// Remove accounts for the removal of the entry, returning the size it frees
+func (u *gcUsage) Remove(entry gcHashEntry) int64 {
+	var freed int64
+	for _, file := range entry.Files {
+		if u.links[file.Key] == 0 {
+			continue
+		}
+
+		u.links[file.Key]--
+		if u.isFreed(file.Key) {
+			freed += u.sizes[file.Key]
+		}
+	}
+
+	u.Total -= freed
+
+	return freed
+}
+
+// gcCollectHashEntries returns the hash folders of the target dirs, the size of each counts the tarballs it shares
+func (e *Engine) gcCollectHashEntries(targetDirs []string) ([]gcHashEntry, error) {
+	entries := make([]gcHashEntry, 0)
+	for _, dir := range targetDirs {
+		dirEntries, err := os.ReadDir(dir)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		for _, entry := range dirEntries {
+			if entry.Name() == "latest" || !entry.IsDir() {
+				continue
+			}
+
+			p := filepath.Join(dir, entry.Name())
+
+			info, err := os.Lstat(p)
+			if err != nil {
+				continue
+			}
+
+			files, err := dirFiles(p)
+			if err != nil {
+				continue
+			}
+
+			var size int64
+			for _, file := range files {
+				size += file.Size
+			}
+
+			entries = append(entries, gcHashEntry{
+				TargetDir: dir,
+				HashPath:  p,
+				Size:      size,
+				Time:      info.ModTime(),
+				Files:     files,
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+func (e *Engine) gcRemoveHashEntry(entry gcHashEntry) {
+	err := os.RemoveAll(entry.HashPath)
+	if err != nil {
+		log.Error(err)
+	}
+	e.removeTestPass(entry.HashPath)
+
+	latest := filepath.Join(entry.TargetDir, "latest")
+	if l, _ := os.Readlink(latest); l == entry.HashPath {
+		err := os.Remove(latest)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// runGcBudget evicts the entries not used for engine.gc.max_age, and the least recently used ones
+// until the cache fits in engine.gc.max_size
+func (e *Engine) runGcBudget(targetDirs []string, flog func(string, ...interface{}), dryrun bool) error {
+	if flog == nil {
+		flog = func(string, ...interface{}) {}
+	}
+
+	cfg := e.Config.Engine.GC
+	if cfg.MaxSize <= 0 && cfg.MaxAge <= 0 {
+		return nil
+	}
+
+	entries, err := e.gcCollectHashEntries(targetDirs)
+	if err != nil {
+		return err
+	}
+
+	// Sort least recently used first
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	usage, err := e.gcCollectUsage(entries)
+	if err != nil {
+		return err
+	}
+
+	flog("budget: %v in %v entries", utils.FormatSize(usage.Total), len(entries))
+
+	// gcCas runs after, collecting the blobs no entry links to already
+	total := usage.Total - usage.Orphans()
+
+	homeDir := e.HomeDir.Abs()
+	deadline := time.Now().Add(-cfg.MaxAge)
+	for _, entry := range entries {
+		var reason string
+		switch {
+		case cfg.MaxAge > 0 && entry.Time.Before(deadline):
+			reason = "max_age"
+		case cfg.MaxSize > 0 && total > cfg.MaxSize:
+			reason = "max_size"
+		default:
+			// The next entries are more recent, and the total only goes down
+			continue
+		}
+
+		freed := usage.Remove(entry)
+
+		rel, _ := filepath.Rel(homeDir, entry.HashPath)
+		flog("* Delete %v %v freed %v (%v)", rel, entry.Time.Format(time.RFC3339), utils.FormatSize(freed), reason)
+
+		if !dryrun {
+			e.gcRemoveHashEntry(entry)
+		}
+
+		total -= freed
+	}
+	flog("")
+
+	return nil
+}
+
+func (e *Engine) gcBudgetExceeded() (bool, error) {
+	cfg := e.Config.Engine.GC
+
+	targetDirs, err := e.gcCollectTargetDirs(e.HomeDir.Join("cache").Abs())
+	if err != nil {
+		return false, err
+	}
+
+	entries, err := e.gcCollectHashEntries(targetDirs)
+	if err != nil {
+		return false, err
+	}
+
+	deadline := time.Now().Add(-cfg.MaxAge)
+	for _, entry := range entries {
+		if cfg.MaxAge > 0 && entry.Time.Before(deadline) {
+			return true, nil
+		}
+	}
+
+	if cfg.MaxSize <= 0 {
+		return false, nil
+	}
+
+	usage, err := e.gcCollectUsage(entries)
+	if err != nil {
+		return false, err
+	}
+
+	return usage.Total > cfg.MaxSize, nil
+}
+
+const gcBudgetCheckInterval = 10 * time.Minute
+
+// GCBudgetExceeded returns if the local cache goes over engine.gc.max_size or holds entries older than engine.gc.max_age.
+// Walking the cache is not free, it is checked at most every 10 minutes, false is returned in between
+func (e *Engine) GCBudgetExceeded() (bool, error) {
+	cfg := e.Config.Engine.GC
+	if !cfg.Enabled || (cfg.MaxSize <= 0 && cfg.MaxAge <= 0) {
+		return false, nil
+	}
+
+	stamp := e.HomeDir.Join("tmp", "gc_budget_check").Abs()
+	if info, err := os.Stat(stamp); err == nil && time.Since(info.ModTime()) < gcBudgetCheckInterval {
+		return false, nil
+	}
+
+	err := fs2.CreateParentDir(stamp)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.WriteFile(stamp, nil, os.ModePerm)
+	if err != nil {
+		return false, err
+	}
+
+	return e.gcBudgetExceeded()
+}
+
+type GCReportEntry struct {
+	// Dir is the target cache folder, relative to the cache root
+	Dir        string
+	Size       int64
+	Entries    int
+	LastAccess time.Time
+}
+
+// GCReport returns the local cache usage per target, biggest first
+func (e *Engine) GCReport() ([]GCReportEntry, error) {
+	root := e.HomeDir.Join("cache").Abs()
+
+	targetDirs, err := e.gcCollectTargetDirs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	hashEntries, err := e.gcCollectHashEntries(targetDirs)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := map[string]*GCReportEntry{}
+	for _, entry := range hashEntries {
+		r, ok := reports[entry.TargetDir]
+		if !ok {
+			rel, _ := filepath.Rel(root, entry.TargetDir)
+			r = &GCReportEntry{Dir: rel}
+			reports[entry.TargetDir] = r
+		}
+
+		r.Size += entry.Size
+		r.Entries++
+		if entry.Time.After(r.LastAccess) {
+			r.LastAccess = entry.Time
+		}
+	}
+
+	entries := make([]GCReportEntry, 0, len(reports))
+	for _, r := range reports {
+		entries = append(entries, *r)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Size != entries[j].Size {
+			return entries[i].Size > entries[j].Size
+		}
+
+		return entries[i].Dir < entries[j].Dir
+	})
+
+	return entries, nil
+}
+
 func (e *Engine) GC(ctx context.Context, flog func(string, ...interface{}), dryrun bool) error {
 	err := e.gcLock.Lock(ctx)
 	if err != nil {
@@ -194,5 +716,21 @@ func (e *Engine) GC(ctx context.Context, flog func(string, ...interface{}), dryr
 		return err
 	}
 
-	return e.runGc(targetDirs, flog, dryrun)
+	err = e.runGc(targetDirs, flog, dryrun)
+	if err != nil {
+		return err
+	}
+
+	err = e.runGcBudget(targetDirs, flog, dryrun)
+	if err != nil {
+		return err
+	}
+
+	err = e.gcTestPasses(flog, dryrun)
+	if err != nil {
+		return err
+	}
+
+	// Deleting hash folders may have left blobs unreferenced
+	return e.gcCas(flog, dryrun)
 }
@@ -0,0 +1,322 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/c2fo/vfs/v6"
+	"heph/targetspec"
+	"heph/vfssimple"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// remoteGCGracePeriod protects what an upload running concurrently may be writing:
+// incomplete entries and unreferenced blobs are only deleted once older than it
+const remoteGCGracePeriod = time.Hour
+
+type remoteCacheEntry struct {
+	FQN       string
+	Package   string
+	Name      string
+	InputHash string
+	// Files are relative to the cache root
+	Files []string
+	// Complete is set once hash_input, uploaded last, exists
+	Complete bool
+	// Time is the upload time of hash_input, or of the newest file if incomplete
+	Time time.Time
+}
+
+type remoteCasBlob struct {
+	Name    string
+	ModTime time.Time
+}
+
+// walkRemoteCache walks the `<pkg>/<name>/<input hash>/` layout of the cache, along with its blobs
+func walkRemoteCache(ctx context.Context, root vfs.Location) ([]remoteCacheEntry, []remoteCasBlob, error) {
+	entries := map[string]*remoteCacheEntry{}
+	blobs := make([]remoteCasBlob, 0)
+
+	err := vfssimple.Walk(ctx, root, func(name string, modTime time.Time) error {
+		if strings.HasPrefix(name, casDirName+"/") {
+			blobName := strings.TrimPrefix(name, casDirName+"/")
+			if !strings.Contains(blobName, "/") {
+				blobs = append(blobs, remoteCasBlob{Name: blobName, ModTime: modTime})
+			}
+			return nil
+		}
+
+		dir := path.Dir(name)
+		targetDir := path.Dir(dir)
+		if dir == "." || targetDir == "." {
+			return nil
+		}
+
+		entry, ok := entries[dir]
+		if !ok {
+			pkg := path.Dir(targetDir)
+			if pkg == "." {
+				pkg = ""
+			}
+
+			tp := targetspec.TargetPath{Package: pkg, Name: path.Base(targetDir)}
+
+			entry = &remoteCacheEntry{
+				FQN:       tp.Full(),
+				Package:   tp.Package,
+				Name:      tp.Name,
+				InputHash: path.Base(dir),
+			}
+			entries[dir] = entry
+		}
+
+		entry.Files = append(entry.Files, name)
+
+		if path.Base(name) == "hash_input" {
+			entry.Complete = true
+			entry.Time = modTime
+		} else if !entry.Complete && modTime.After(entry.Time) {
+			entry.Time = modTime
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sorted := make([]remoteCacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		sort.Strings(entry.Files)
+		sorted = append(sorted, *entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].FQN != sorted[j].FQN {
+			return sorted[i].FQN < sorted[j].FQN
+		}
+		return sorted[i].InputHash < sorted[j].InputHash
+	})
+
+	return sorted, blobs, nil
+}
+
+func readVfsFile(loc vfs.Location, name string) ([]byte, error) {
+	f, err := loc.NewFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// remoteEntryOutputHashes returns the output hashes recorded in the out_hashes of the entry manifest, the cas blobs it points to.
+// Entries stored by an older heph may have no manifest, their hash_out_* files are read instead
+func remoteEntryOutputHashes(root vfs.Location, entry remoteCacheEntry) ([]string, error) {
+	for _, name := range entry.Files {
+		if path.Base(name) != "manifest.json" {
+			continue
+		}
+
+		b, err := readVfsFile(root, name)
+		if err != nil {
+			return nil, err
+		}
+
+		var m ManifestData
+		err = json.Unmarshal(b, &m)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+
+		if len(m.OutHashes) > 0 {
+			hashes := make([]string, 0, len(m.OutHashes))
+			for _, h := range m.OutHashes {
+				hashes = append(hashes, h)
+			}
+
+			return hashes, nil
+		}
+	}
+
+	hashes := make([]string, 0)
+	for _, name := range entry.Files {
+		if !strings.HasPrefix(path.Base(name), "hash_out_") {
+			continue
+		}
+
+		b, err := readVfsFile(root, name)
+		if err != nil {
+			return nil, err
+		}
+
+		hashes = append(hashes, strings.TrimSpace(string(b)))
+	}
+
+	return hashes, nil
+}
+
+type RemoteGCOptions struct {
+	// Keep is the number of entries kept per target, all if 0
+	Keep int
+	// MaxAge deletes the entries stored earlier, if set
+	MaxAge time.Duration
+	DryRun bool
+}
+
+// GCRemote deletes from the cache the entries of the targets no longer in the graph, or not cached anymore,
+// along with the ones out of the retention policy, then the blobs no remaining entry references.
+// The cache has to be listable
+func (e *Engine) GCRemote(ctx context.Context, cacheName string, opts RemoteGCOptions, flog func(string, ...interface{})) error {
+	if flog == nil {
+		flog = func(string, ...interface{}) {}
+	}
+
+	cache, err := e.cacheConfig(cacheName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	entries, blobs, err := walkRemoteCache(ctx, cache.Location)
+	if err != nil {
+		return fmt.Errorf("%v: gc requires listing the cache: %w", cache.Name, err)
+	}
+
+	byTarget := map[string][]remoteCacheEntry{}
+	fqns := make([]string, 0)
+	for _, entry := range entries {
+		if _, ok := byTarget[entry.FQN]; !ok {
+			fqns = append(fqns, entry.FQN)
+		}
+		byTarget[entry.FQN] = append(byTarget[entry.FQN], entry)
+	}
+
+	deadline := now.Add(-opts.MaxAge)
+
+	referenced := map[string]struct{}{}
+	for _, fqn := range fqns {
+		entries := byTarget[fqn]
+
+		// Sort fresher first
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Time.After(entries[j].Time)
+		})
+
+		flog("%v:", fqn)
+
+		target := e.Targets.Find(fqn)
+		inGraph := target != nil && target.Cache.Enabled
+		if !inGraph {
+			flog("Not part of schema or not cached, delete")
+		}
+
+		i := 0
+		for _, entry := range entries {
+			var keep bool
+			if entry.Complete {
+				keep = inGraph &&
+					(opts.Keep <= 0 || i < opts.Keep) &&
+					(opts.MaxAge <= 0 || entry.Time.After(deadline))
+				i++
+			} else {
+				// Either being uploaded, or left over by an interrupted upload
+				keep = entry.Time.After(now.Add(-remoteGCGracePeriod))
+			}
+
+			actionStr := "Delete"
+			if keep {
+				actionStr = "Keep  "
+			}
+			suffix := ""
+			if !entry.Complete {
+				suffix = " incomplete"
+			}
+			flog("* %v %v %v%v", actionStr, entry.InputHash, entry.Time.Format(time.RFC3339), suffix)
+
+			if keep {
+				hashes, err := remoteEntryOutputHashes(cache.Location, entry)
+				if err != nil {
+					return fmt.Errorf("%v %v: %w", entry.FQN, entry.InputHash, err)
+				}
+
+				for _, h := range hashes {
+					referenced[h] = struct{}{}
+				}
+
+				continue
+			}
+
+			if !opts.DryRun {
+				err := e.deleteRemoteEntry(cache.Location, entry)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		flog("")
+	}
+
+	return e.gcRemoteCas(cache.Location, blobs, referenced, now, opts.DryRun, flog)
+}
+
+func (e *Engine) deleteRemoteEntry(root vfs.Location, entry remoteCacheEntry) error {
+	names := make([]string, 0, len(entry.Files))
+	for _, name := range entry.Files {
+		// hash_input goes first, an interrupted deletion leaves an incomplete entry, collected later
+		if path.Base(name) == "hash_input" {
+			names = append([]string{name}, names...)
+		} else {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		err := root.DeleteFile(name)
+		if err != nil {
+			return fmt.Errorf("%v: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// gcRemoteCas deletes the blobs no remaining entry references, unless written within the grace period
+func (e *Engine) gcRemoteCas(root vfs.Location, blobs []remoteCasBlob, referenced map[string]struct{}, now time.Time, dryrun bool, flog func(string, ...interface{})) error {
+	casLoc, err := e.remoteCasLocation(root)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].Name < blobs[j].Name
+	})
+
+	flog("%v:", casDirName)
+	for _, blob := range blobs {
+		outputHash, _, _ := strings.Cut(blob.Name, ".")
+
+		if _, ok := referenced[outputHash]; ok {
+			continue
+		}
+		if blob.ModTime.After(now.Add(-remoteGCGracePeriod)) {
+			continue
+		}
+
+		flog("* Delete %v", blob.Name)
+		if !dryrun {
+			err := casLoc.DeleteFile(blob.Name)
+			if err != nil {
+				return fmt.Errorf("%v: %w", blob.Name, err)
+			}
+		}
+	}
+	flog("")
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"heph/vfssimple"
+	"heph/vfssimple/backend/mem"
+	"heph/vfssimple/objfs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c2fo/vfs/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCRemote(t *testing.T) {
+	t.Run("file", func(t *testing.T) {
+		root := t.TempDir()
+		loc, err := vfssimple.NewLocation("file://" + root + "/")
+		require.NoError(t, err)
+
+		testGCRemote(t, loc, func(loc vfs.Location, name string, mtime time.Time) error {
+			return os.Chtimes(filepath.Join(loc.Path(), name), mtime, mtime)
+		})
+	})
+
+	t.Run("mem", func(t *testing.T) {
+		loc, err := vfssimple.NewLocation(fmt.Sprintf("mem://gc%v/cache/", time.Now().UnixNano()))
+		require.NoError(t, err)
+
+		s, err := loc.FileSystem().(*objfs.FileSystem).Store(loc.Volume())
+		require.NoError(t, err)
+
+		testGCRemote(t, loc, func(loc vfs.Location, name string, mtime time.Time) error {
+			return s.(*mem.Store).Chtimes(path.Join(loc.Path(), name), mtime)
+		})
+	})
+}
+
+func testGCRemote(t *testing.T, loc vfs.Location, chtimes func(loc vfs.Location, name string, mtime time.Time) error) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+target(name="a", out="a", cache=True)
+target(name="nocache", out="b", cache=False)
+`), os.ModePerm)
+	require.NoError(t, err)
+
+	e := New(dir)
+	err = e.runBuildFiles(dir, e.createPkg)
+	require.NoError(t, err)
+
+	e.Config.Caches = []CacheConfig{{Name: "remote", Location: loc}}
+
+	now := time.Now()
+
+	write := func(loc vfs.Location, name, content string, age time.Duration) {
+		f, err := loc.NewFile(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		err = chtimes(loc, name, now.Add(-age))
+		require.NoError(t, err)
+	}
+	blob := func(outHash string, age time.Duration) {
+		write(loc, casDirName+"/"+outHash+".tar.gz", "blob", age)
+	}
+
+	entry := func(pkg, name, hash, outHash string, age time.Duration, complete bool) vfs.Location {
+		l, err := remoteCacheEntryLocation(loc, pkg, name, hash)
+		require.NoError(t, err)
+
+		write(l, "hash_out_", outHash, age)
+		if complete {
+			write(l, "hash_input", hash, age)
+		}
+		blob(outHash, 48*time.Hour)
+
+		return l
+	}
+
+	exists := func(l vfs.Location, name string) bool {
+		f, err := l.NewFile(name)
+		require.NoError(t, err)
+		defer f.Close()
+
+		ok, err := f.Exists()
+		require.NoError(t, err)
+
+		return ok
+	}
+
+	a1 := entry("", "a", "1", "o1", time.Hour, true)
+	// The blobs are found from the manifest, when there is one
+	write(a1, "hash_out_", "stale", time.Hour)
+	write(a1, "manifest.json", `{"out_hashes":{"":"o1"}}`, time.Hour)
+	a2 := entry("", "a", "2", "o2", 2*time.Hour, true)
+	a3 := entry("", "a", "3", "shared", 30*24*time.Hour, true)
+	gone := entry("some/pkg", "gone", "1", "shared", time.Hour, true)
+	nocache := entry("", "nocache", "1", "o3", time.Hour, true)
+	uploading := entry("", "a", "4", "o4", time.Minute, false)
+	interrupted := entry("", "a", "5", "o5", 2*time.Hour, false)
+	blob("orphan", 48*time.Hour)
+	blob("fresh", time.Minute)
+
+	entries, _, err := walkRemoteCache(ctx, loc)
+	require.NoError(t, err)
+	require.Len(t, entries, 7)
+	assert.Equal(t, "//some/pkg:gone", entries[len(entries)-1].FQN)
+
+	err = e.GCRemote(ctx, "remote", RemoteGCOptions{Keep: 2, DryRun: true}, nil)
+	require.NoError(t, err)
+	assert.True(t, exists(gone, "hash_input"))
+	assert.True(t, exists(loc, casDirName+"/orphan.tar.gz"))
+
+	err = e.GCRemote(ctx, "remote", RemoteGCOptions{Keep: 2}, nil)
+	require.NoError(t, err)
+
+	assert.True(t, exists(a1, "hash_input"))
+	assert.True(t, exists(a2, "hash_input"))
+	assert.False(t, exists(a3, "hash_input"))
+	assert.False(t, exists(gone, "hash_input"))
+	assert.False(t, exists(nocache, "hash_out_"))
+	assert.True(t, exists(uploading, "hash_out_"))
+	assert.False(t, exists(interrupted, "hash_out_"))
+
+	assert.True(t, exists(loc, casDirName+"/o1.tar.gz"))
+	assert.True(t, exists(loc, casDirName+"/o4.tar.gz"))
+	assert.True(t, exists(loc, casDirName+"/fresh.tar.gz"))
+	assert.False(t, exists(loc, casDirName+"/shared.tar.gz"))
+	assert.False(t, exists(loc, casDirName+"/o3.tar.gz"))
+	assert.False(t, exists(loc, casDirName+"/o5.tar.gz"))
+	assert.False(t, exists(loc, casDirName+"/orphan.tar.gz"))
+
+	err = e.GCRemote(ctx, "remote", RemoteGCOptions{MaxAge: 90 * time.Minute}, nil)
+	require.NoError(t, err)
+
+	assert.True(t, exists(a1, "hash_input"))
+	assert.False(t, exists(a2, "hash_input"))
+	assert.False(t, exists(loc, casDirName+"/o2.tar.gz"))
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCBudget(t *testing.T) {
+	dir := t.TempDir()
+	e := New(dir)
+
+	now := time.Now()
+
+	entry := func(target, hash string, age time.Duration) string {
+		p := e.HomeDir.Join("cache", "pkg", "__target_"+target, hash).Abs()
+		err := os.MkdirAll(p, os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(p, "out_.tar"), make([]byte, 100), os.ModePerm)
+		require.NoError(t, err)
+		err = os.Chtimes(p, now.Add(-age), now.Add(-age))
+		require.NoError(t, err)
+
+		return p
+	}
+
+	a1 := entry("a", "1", 3*time.Hour)
+	a2 := entry("a", "2", time.Hour)
+	b1 := entry("b", "1", 2*time.Hour)
+
+	err := os.Symlink(a1, filepath.Join(filepath.Dir(a1), "latest"))
+	require.NoError(t, err)
+
+	targetDirs, err := e.gcCollectTargetDirs(e.HomeDir.Join("cache").Abs())
+	require.NoError(t, err)
+
+	e.Config.Engine.GC.MaxSize = 250
+	err = e.runGcBudget(targetDirs, nil, false)
+	require.NoError(t, err)
+
+	// Least recently used first, the dangling latest link goes with it
+	assert.NoDirExists(t, a1)
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(a1), "latest"))
+	assert.DirExists(t, b1)
+	assert.DirExists(t, a2)
+
+	e.Config.Engine.GC.MaxSize = 0
+	e.Config.Engine.GC.MaxAge = 90 * time.Minute
+	err = e.runGcBudget(targetDirs, nil, false)
+	require.NoError(t, err)
+
+	assert.NoDirExists(t, b1)
+	assert.DirExists(t, a2)
+}
+
+func TestGCBudgetSharedTarballs(t *testing.T) {
+	dir := t.TempDir()
+	e := New(dir)
+
+	now := time.Now()
+
+	blob := e.localCasDir().Join("shared.tar").Abs()
+	err := os.MkdirAll(filepath.Dir(blob), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(blob, make([]byte, 100), os.ModePerm)
+	require.NoError(t, err)
+
+	// Collected by gcCas, not by evicting entries
+	err = os.WriteFile(e.localCasDir().Join("orphan.tar").Abs(), make([]byte, 1000), os.ModePerm)
+	require.NoError(t, err)
+
+	entry := func(target, hash string, age time.Duration, shared bool) string {
+		p := e.HomeDir.Join("cache", "pkg", "__target_"+target, hash).Abs()
+		err := os.MkdirAll(p, os.ModePerm)
+		require.NoError(t, err)
+		if shared {
+			err = os.Link(blob, filepath.Join(p, "out_.tar"))
+		} else {
+			err = os.WriteFile(filepath.Join(p, "out_.tar"), make([]byte, 100), os.ModePerm)
+		}
+		require.NoError(t, err)
+		err = os.Chtimes(p, now.Add(-age), now.Add(-age))
+		require.NoError(t, err)
+
+		return p
+	}
+
+	a1 := entry("a", "1", 3*time.Hour, true)
+	b1 := entry("b", "1", 2*time.Hour, false)
+	a2 := entry("a", "2", time.Hour, true)
+
+	targetDirs, err := e.gcCollectTargetDirs(e.HomeDir.Join("cache").Abs())
+	require.NoError(t, err)
+
+	entries, err := e.gcCollectHashEntries(targetDirs)
+	require.NoError(t, err)
+	usage, err := e.gcCollectUsage(entries)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1200), usage.Total)
+	assert.Equal(t, int64(1000), usage.Orphans())
+
+	// The shared tarball is counted once
+	e.Config.Engine.GC.MaxSize = 250
+	err = e.runGcBudget(targetDirs, nil, false)
+	require.NoError(t, err)
+
+	assert.DirExists(t, a1)
+	assert.DirExists(t, b1)
+	assert.DirExists(t, a2)
+
+	// Evicting a1 frees nothing, a2 still links the tarball
+	e.Config.Engine.GC.MaxSize = 150
+	err = e.runGcBudget(targetDirs, nil, false)
+	require.NoError(t, err)
+
+	assert.NoDirExists(t, a1)
+	assert.NoDirExists(t, b1)
+	assert.DirExists(t, a2)
+}
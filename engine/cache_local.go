@@ -7,6 +7,7 @@ import (
 	log "heph/hlog"
 	"heph/utils/fs"
 	"os"
+	"path/filepath"
 )
 
 func (e *TargetRunEngine) storeCache(ctx context.Context, target *Target, outRoot string, logFilePath string) (rerr error) {
@@ -58,6 +59,16 @@ func (e *TargetRunEngine) storeCache(ctx context.Context, target *Target, outRoo
 	}
 
 	for _, artifact := range allArtifacts {
+		if artifact.Name() == target.artifacts.Manifest.Name() {
+			// The tarballs may be replaced by their cas blob, which the manifest checksums must match
+			for name, a := range target.artifacts.Out {
+				p := filepath.Join(dir, a.Tar().Name())
+				if fs.PathExists(p) {
+					e.dedupLocalTar(target, name, e.targetCompression(target).Ext(), p)
+				}
+			}
+		}
+
 		_, err := artifacts.GenArtifact(ctx, dir, artifact, artifacts.GenContext{
 			OutRoot:     outRoot,
 			LogFilePath: logFilePath,
@@ -0,0 +1,316 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/c2fo/vfs/v6"
+	"heph/engine/artifacts"
+	"heph/utils/tar"
+	"heph/vfssimple"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CacheVerifyResult is the verification of a cache entry
+type CacheVerifyResult struct {
+	Target    string
+	Cache     string
+	InputHash string
+	// Err is set if the entry is corrupted
+	Err error
+	// Unverified is set if the entry has no checksums recorded
+	Unverified bool
+	Evicted    bool
+}
+
+// checkManifest records on the result what the manifest of the entry tells, returning false if the artifacts cannot be verified
+func checkManifest(res *CacheVerifyResult, m ManifestData, err error) (bool, error) {
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			res.Unverified = true
+			return false, nil
+		}
+
+		var serr *json.SyntaxError
+		if errors.As(err, &serr) {
+			res.Err = fmt.Errorf("manifest: %w", err)
+			return false, nil
+		}
+
+		return false, fmt.Errorf("%v %v: manifest: %w", res.Target, res.InputHash, err)
+	}
+
+	if len(m.Checksums) == 0 {
+		res.Unverified = true
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// VerifyLocalCache verifies the local cache entries of the target, evicting the corrupted ones if fix is set
+func (e *Engine) VerifyLocalCache(target *Target, fix bool) ([]CacheVerifyResult, error) {
+	targetDir := filepath.Dir(e.cacheDirForHash(target, "latest").Abs())
+
+	dirEntries, err := os.ReadDir(targetDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	results := make([]CacheVerifyResult, 0)
+	for _, dirEntry := range dirEntries {
+		if dirEntry.Name() == "latest" || !dirEntry.IsDir() {
+			continue
+		}
+
+		res, err := e.verifyLocalEntry(target, dirEntry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		if res.Err != nil && fix {
+			err := e.evictCorruptedLocalCas(target, dirEntry.Name(), res.Err)
+			if err != nil {
+				return nil, err
+			}
+
+			e.gcRemoveHashEntry(gcHashEntry{
+				TargetDir: targetDir,
+				HashPath:  filepath.Join(targetDir, dirEntry.Name()),
+			})
+			res.Evicted = true
+		}
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+func (e *Engine) verifyLocalEntry(target *Target, inputHash string) (CacheVerifyResult, error) {
+	res := CacheVerifyResult{Target: target.FQN, Cache: "local", InputHash: inputHash}
+
+	m, err := e.CachedManifest(target, inputHash, "")
+	ok, err := checkManifest(&res, m, err)
+	if !ok || err != nil {
+		return res, err
+	}
+
+	dir := e.cacheDirForHash(target, inputHash)
+	for _, artifact := range target.artifacts.Checksummed() {
+		p := dir.Join(e.artifactNameIn(target, dir, artifact)).Abs()
+
+		// Pulling only the metadata leaves the tarballs out
+		if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		err := e.verifyArtifactFile(target, m, artifact, p)
+		if err != nil {
+			if !errors.Is(err, CorruptedArtifactError{}) {
+				return res, err
+			}
+
+			res.Err = err
+			break
+		}
+	}
+
+	return res, nil
+}
+
+// evictCorruptedLocalCas removes the cas blob of a corrupted tarball of the entry
+func (e *Engine) evictCorruptedLocalCas(target *Target, inputHash string, err error) error {
+	var cerr CorruptedArtifactError
+	if !errors.As(err, &cerr) {
+		return nil
+	}
+
+	output, ext, ok := e.outTarOutput(target, cerr.Artifact)
+	if !ok {
+		return nil
+	}
+
+	dir := e.cacheDirForHash(target, inputHash)
+
+	b, err := os.ReadFile(dir.Join(target.artifacts.OutHash(output).Name()).Abs())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	return e.removeLocalCasBlob(strings.TrimSpace(string(b)), ext, dir.Join(cerr.Artifact).Abs())
+}
+
+// VerifyRemoteCache verifies the complete entries of the targets found in the cache,
+// deleting the corrupted ones if fix is set. The cache has to be listable
+func (e *Engine) VerifyRemoteCache(ctx context.Context, cacheName string, targets []*Target, fix bool) ([]CacheVerifyResult, error) {
+	cache, err := e.cacheConfig(cacheName)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, _, err := walkRemoteCache(ctx, cache.Location)
+	if err != nil {
+		return nil, fmt.Errorf("verify requires listing the cache: %w", err)
+	}
+
+	byFQN := map[string]*Target{}
+	for _, target := range targets {
+		byFQN[target.FQN] = target
+	}
+
+	tmpDir := e.HomeDir.Join("tmp", "cache_verify").Abs()
+	err = os.MkdirAll(tmpDir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpLoc, err := vfssimple.NewLocation("file://" + tmpDir + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	casLoc, err := e.remoteCasLocation(cache.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]CacheVerifyResult, 0)
+	for _, entry := range entries {
+		target := byFQN[entry.FQN]
+		if target == nil || !entry.Complete {
+			continue
+		}
+
+		loc, err := remoteCacheEntryLocation(cache.Location, entry.Package, entry.Name, entry.InputHash)
+		if err != nil {
+			return nil, err
+		}
+
+		res, corrupted, err := e.verifyRemoteEntry(ctx, target, cache, entry.InputHash, loc, casLoc, tmpLoc)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.Err != nil && fix {
+			// The blob would not be uploaded again while it exists
+			if corrupted.Location != nil && corrupted.Location.URI() == casLoc.URI() {
+				err := casLoc.DeleteFile(corrupted.Name)
+				if err != nil {
+					return nil, fmt.Errorf("%v: %w", corrupted.Name, err)
+				}
+			}
+
+			err := e.deleteRemoteEntry(cache.Location, entry)
+			if err != nil {
+				return nil, err
+			}
+			res.Evicted = true
+		}
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// verifyRemoteEntry downloads the artifacts of the entry to verify them, returning the path of the corrupted one
+func (e *Engine) verifyRemoteEntry(ctx context.Context, target *Target, cache CacheConfig, inputHash string, loc, casLoc, tmpLoc vfs.Location) (CacheVerifyResult, remoteArtifactPath, error) {
+	res := CacheVerifyResult{Target: target.FQN, Cache: cache.Name, InputHash: inputHash}
+
+	m, err := e.CachedManifest(target, inputHash, cache.Name)
+	ok, err := checkManifest(&res, m, err)
+	if !ok || err != nil {
+		return res, remoteArtifactPath{}, err
+	}
+
+	for _, artifact := range target.artifacts.Checksummed() {
+		name, ok := manifestArtifactName(target, m, artifact)
+		if !ok {
+			continue
+		}
+
+		paths := []remoteArtifactPath{{Location: loc, Name: name, Artifact: name}}
+		if output, ext, ok := e.outTarOutput(target, name); ok && m.OutHashes[output] != "" {
+			blob := remoteArtifactPath{Location: casLoc, Name: casBlobName(m.OutHashes[output], ext), Artifact: name}
+			paths = append([]remoteArtifactPath{blob}, paths...)
+		}
+
+		path, err := e.verifyRemoteArtifact(ctx, target, m, artifact, paths, tmpLoc)
+		if err != nil {
+			if !errors.Is(err, CorruptedArtifactError{}) {
+				return res, remoteArtifactPath{}, err
+			}
+
+			res.Err = err
+			return res, path, nil
+		}
+	}
+
+	return res, remoteArtifactPath{}, nil
+}
+
+// manifestArtifactName returns the name the artifact has in the entry of the manifest, if it has a checksum:
+// output tarballs are named after the compression they were stored with, which may not be the configured one
+func manifestArtifactName(target *Target, m ManifestData, artifact artifacts.Artifact) (string, bool) {
+	if _, ok := m.Checksums[artifact.Name()]; ok {
+		return artifact.Name(), true
+	}
+
+	output, ok := target.artifacts.tarOutput(artifact)
+	if !ok {
+		return "", false
+	}
+
+	for _, c := range tar.CompressionValues {
+		name := outTarName(output, tar.Compression(c).Ext())
+		if _, ok := m.Checksums[name]; ok {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// verifyRemoteArtifact verifies the first of the paths that exists, returning it.
+// The artifact has a checksum in the manifest, not finding it means the entry is incomplete
+func (e *Engine) verifyRemoteArtifact(ctx context.Context, target *Target, m ManifestData, artifact artifacts.Artifact, paths []remoteArtifactPath, tmpLoc vfs.Location) (remoteArtifactPath, error) {
+	for _, path := range paths {
+		exists, err := e.vfsExists(path.Location, path.Name)
+		if err != nil {
+			return path, err
+		}
+
+		if !exists {
+			continue
+		}
+
+		err = e.vfsCopyFile(ctx, path.Location, path.Name, tmpLoc, path.Artifact)
+		if err != nil {
+			return path, err
+		}
+
+		p := filepath.Join(tmpLoc.Path(), path.Artifact)
+		defer os.Remove(p)
+
+		return path, e.verifyArtifactFile(target, m, artifact, p)
+	}
+
+	name := paths[len(paths)-1].Artifact
+
+	return remoteArtifactPath{}, CorruptedArtifactError{
+		Target:   target.FQN,
+		Artifact: name,
+		Expected: m.Checksums[name],
+	}
+}
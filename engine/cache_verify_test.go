@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"heph/utils/tar"
+	"heph/vfssimple"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyLocalCache(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+target(name="a", out="a", cache=True)
+`), os.ModePerm)
+	require.NoError(t, err)
+
+	e := New(dir)
+	err = e.runBuildFiles(dir, e.createPkg)
+	require.NoError(t, err)
+
+	target := e.Targets.Find("//:a")
+	require.NotNil(t, target)
+	err = e.processTarget(target)
+	require.NoError(t, err)
+	err = e.LinkTarget(target, nil)
+	require.NoError(t, err)
+
+	src := filepath.Join(t.TempDir(), "a")
+	err = os.WriteFile(src, []byte("content"), os.ModePerm)
+	require.NoError(t, err)
+
+	tarArtifact := target.artifacts.OutTar("")
+	opts := tar.TarOptions{Compression: e.targetCompression(target)}
+
+	entry := func(hash string, checksums bool) string {
+		p := e.cacheDirForHash(target, hash).Abs()
+		err := os.MkdirAll(p, os.ModePerm)
+		require.NoError(t, err)
+
+		tarPath := filepath.Join(p, tarArtifact.Name())
+		err = tar.TarWith(ctx, []tar.TarFile{{From: src, To: "a"}}, tarPath, opts)
+		require.NoError(t, err)
+
+		outHash, err := e.hashOutputArchive(target, "", tarPath, "")
+		require.NoError(t, err)
+
+		err = os.WriteFile(filepath.Join(p, target.artifacts.OutHash("").Name()), []byte(outHash), os.ModePerm)
+		require.NoError(t, err)
+
+		m := ManifestData{InputHash: hash, OutHashes: map[string]string{"": outHash}}
+		if checksums {
+			m.Checksums = map[string]string{}
+			for _, artifact := range target.artifacts.Checksummed() {
+				sum, err := fileChecksum(filepath.Join(p, artifact.Name()))
+				if err != nil {
+					continue
+				}
+				m.Checksums[artifact.Name()] = sum
+			}
+		}
+
+		b, err := json.Marshal(m)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(p, target.artifacts.Manifest.Name()), b, os.ModePerm)
+		require.NoError(t, err)
+
+		return p
+	}
+
+	intact := entry("intact", true)
+	rearchived := entry("rearchived", true)
+	truncated := entry("truncated", true)
+	legacy := entry("legacy", false)
+
+	// Same content, different archive bytes, as a cas blob stored by another entry
+	err = os.Chtimes(src, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	err = tar.TarWith(ctx, []tar.TarFile{{From: src, To: "a"}}, filepath.Join(rearchived, tarArtifact.Name()), opts)
+	require.NoError(t, err)
+	sum, err := fileChecksum(filepath.Join(rearchived, tarArtifact.Name()))
+	require.NoError(t, err)
+	m, err := e.CachedManifest(target, "rearchived", "")
+	require.NoError(t, err)
+	require.NotEqual(t, m.Checksums[tarArtifact.Name()], sum)
+
+	err = os.Truncate(filepath.Join(truncated, tarArtifact.Name()), 10)
+	require.NoError(t, err)
+
+	results, err := e.VerifyLocalCache(target, true)
+	require.NoError(t, err)
+
+	byHash := map[string]CacheVerifyResult{}
+	for _, res := range results {
+		byHash[res.InputHash] = res
+	}
+	require.Len(t, byHash, 4)
+
+	assert.NoError(t, byHash["intact"].Err)
+	assert.NoError(t, byHash["rearchived"].Err)
+	assert.True(t, byHash["legacy"].Unverified)
+	assert.ErrorIs(t, byHash["truncated"].Err, CorruptedArtifactError{})
+	assert.True(t, byHash["truncated"].Evicted)
+
+	assert.DirExists(t, intact)
+	assert.DirExists(t, rearchived)
+	assert.DirExists(t, legacy)
+	assert.NoDirExists(t, truncated)
+}
+
+func TestVerifyRemoteCache(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+target(name="a", out="a", cache=True)
+`), os.ModePerm)
+	require.NoError(t, err)
+
+	e := New(dir)
+	err = e.runBuildFiles(dir, e.createPkg)
+	require.NoError(t, err)
+
+	// The entries were stored with gzip, before switching to zstd
+	e.Config.Engine.Compression = string(tar.CompressionZstd)
+
+	target := e.Targets.Find("//:a")
+	require.NotNil(t, target)
+	err = e.processTarget(target)
+	require.NoError(t, err)
+	err = e.LinkTarget(target, nil)
+	require.NoError(t, err)
+
+	root := t.TempDir()
+	loc, err := vfssimple.NewLocation("file://" + root + "/")
+	require.NoError(t, err)
+	e.Config.Caches = []CacheConfig{{Name: "remote", Location: loc}}
+
+	src := filepath.Join(t.TempDir(), "a")
+	err = os.WriteFile(src, []byte("content"), os.ModePerm)
+	require.NoError(t, err)
+
+	tarPath := filepath.Join(t.TempDir(), "out_.tar.gz")
+	err = tar.TarWith(ctx, []tar.TarFile{{From: src, To: "a"}}, tarPath, tar.TarOptions{Compression: tar.CompressionGzip})
+	require.NoError(t, err)
+
+	outHash, err := e.hashOutputArchive(target, "", tarPath, "")
+	require.NoError(t, err)
+
+	tarSum, err := fileChecksum(tarPath)
+	require.NoError(t, err)
+
+	entry := func(hash, outHash string) {
+		p := filepath.Join(root, "a", hash)
+		err := os.MkdirAll(p, os.ModePerm)
+		require.NoError(t, err)
+
+		err = os.WriteFile(filepath.Join(p, target.artifacts.OutHash("").Name()), []byte(outHash), os.ModePerm)
+		require.NoError(t, err)
+		hashSum, err := fileChecksum(filepath.Join(p, target.artifacts.OutHash("").Name()))
+		require.NoError(t, err)
+
+		b, err := json.Marshal(ManifestData{
+			InputHash: hash,
+			OutHashes: map[string]string{"": outHash},
+			Checksums: map[string]string{
+				"out_.tar.gz":                       tarSum,
+				target.artifacts.OutHash("").Name(): hashSum,
+			},
+		})
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(p, target.artifacts.Manifest.Name()), b, os.ModePerm)
+		require.NoError(t, err)
+
+		err = os.WriteFile(filepath.Join(p, target.artifacts.InputHash.Name()), []byte(hash), os.ModePerm)
+		require.NoError(t, err)
+	}
+
+	entry("intact", outHash)
+	entry("corrupted", "truncated")
+
+	results, err := e.VerifyRemoteCache(ctx, "remote", []*Target{target}, false)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	// Without the blob, the tarball with a checksum is missing
+	for _, res := range results {
+		assert.ErrorIs(t, res.Err, CorruptedArtifactError{}, res.InputHash)
+		assert.ErrorContains(t, res.Err, "out_.tar.gz is missing")
+	}
+
+	b, err := os.ReadFile(tarPath)
+	require.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(root, casDirName), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(root, casDirName, outHash+".tar.gz"), b, os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(root, casDirName, "truncated.tar.gz"), b[:10], os.ModePerm)
+	require.NoError(t, err)
+
+	results, err = e.VerifyRemoteCache(ctx, "remote", []*Target{target}, true)
+	require.NoError(t, err)
+
+	byHash := map[string]CacheVerifyResult{}
+	for _, res := range results {
+		byHash[res.InputHash] = res
+	}
+	require.Len(t, byHash, 2)
+
+	assert.NoError(t, byHash["intact"].Err)
+	assert.ErrorIs(t, byHash["corrupted"].Err, CorruptedArtifactError{})
+	assert.True(t, byHash["corrupted"].Evicted)
+
+	assert.FileExists(t, filepath.Join(root, "a", "intact", "hash_input"))
+	assert.NoFileExists(t, filepath.Join(root, "a", "corrupted", "hash_input"))
+	assert.NoFileExists(t, filepath.Join(root, casDirName, "truncated.tar.gz"))
+}
@@ -11,6 +11,7 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"go.starlark.net/starlark"
 	"heph/config"
+	"heph/engine/buildevents"
 	"heph/engine/htrace"
 	log "heph/hlog"
 	"heph/packages"
@@ -18,6 +19,7 @@ import (
 	"heph/rcache"
 	"heph/sandbox"
 	"heph/targetspec"
+	"heph/testresults"
 	"heph/tgt"
 	"heph/utils"
 	"heph/utils/flock"
@@ -52,6 +54,19 @@ type Engine struct {
 	RemoteCacheHints  *rcache.HintStore
 
 	DisableNamedCacheWrite bool
+	// AuditInputs traces the files read by the targets being run, see InputsAudits
+	AuditInputs bool
+	// BuildEvents receives the execution events as they happen, nil to disable
+	BuildEvents *buildevents.Writer
+	// TestPassCache skips the test targets which passed with the same input hash, see TestCacheHit
+	TestPassCache bool
+	// Offline prevents fetching roots, the copies in HomeDir/root are used
+	Offline bool
+
+	testCacheHits maps.Map[string, *testresults.Suite]
+
+	inputsAuditsm sync.Mutex
+	inputsAudits  []InputsAudit
 
 	SourceFiles   packages.SourceFiles
 	packagesMutex sync.Mutex
@@ -71,6 +86,8 @@ type Engine struct {
 	cacheHashInput             *maps.Map[string, string]
 	cacheHashOutputTargetMutex maps.KMutex
 	cacheHashOutput            *maps.Map[string, string] // TODO: LRU
+	cacheHostToolFingerprint   *maps.Map[string, *utils.Once[string]]
+	casMutex                   maps.KMutex
 	RanGenPass                 bool
 	RanInit                    bool
 	codegenPaths               map[string]*Target
@@ -78,6 +95,14 @@ type Engine struct {
 	fetchRootCache             map[string]fs2.Path
 	cacheRunBuildFileCache     *maps.Map[string, starlark.StringDict]
 	cacheRunBuildFileLocks     *maps.Map[string, *sync.Mutex]
+	buildFilesInputs           maps.Map[string, *buildInputs]
+	buildFilesRoots            maps.Map[string, []string]
+	buildCachedPackages        maps.Map[string, *packages.Package]
+	linkEnv                    maps.Map[string, linkEnvValue]
+	rules                      maps.Map[string, RuleInfo]
+	packagesRunMutex           sync.Mutex
+	configuredPackages         map[string]*packages.Package
+	configureMutex             sync.Mutex
 	Pool                       *worker.Pool
 
 	exitHandlersm       sync.Mutex
@@ -203,6 +228,9 @@ func New(rootPath string) *Engine {
 		cacheRunBuildFileLocks: &maps.Map[string, *sync.Mutex]{Default: func(k string) *sync.Mutex {
 			return &sync.Mutex{}
 		}},
+		cacheHostToolFingerprint: &maps.Map[string, *utils.Once[string]]{Default: func(k string) *utils.Once[string] {
+			return &utils.Once[string]{}
+		}},
 		Labels:                sets.NewStringSet(0),
 		gcLock:                flock.NewFlock("Global GC", homeDir.Join("tmp", "gc.lock").Abs()),
 		toolsLock:             flock.NewFlock("Tools", homeDir.Join("tmp", "tools.lock").Abs()),
@@ -412,7 +440,7 @@ func (e *Engine) collectNamedOutFromTar(target *Target, namedPaths *tgt.OutNamed
 	tp := &ActualOutNamedPaths{}
 
 	for name := range namedPaths.Named() {
-		p := e.cacheDir(target).Join(target.artifacts.OutTar(name).Name()).Abs()
+		p := e.localOutTarPath(target, name)
 		if !fs2.PathExists(p) {
 			continue
 		}
@@ -518,7 +546,7 @@ func (e *Engine) populateActualFilesFromTar(target *Target) error {
 	}
 
 	if target.HasSupportFiles {
-		p := e.cacheDir(target).Join(target.artifacts.OutTar(targetspec.SupportFilesOutput).Name()).Abs()
+		p := e.localOutTarPath(target, targetspec.SupportFilesOutput)
 
 		target.actualSupportFiles, err = e.collectOutFromTar(target, p)
 		if err != nil {
@@ -625,8 +653,10 @@ func (e *Engine) parseConfigs() error {
 	cfg := config.Config{}
 	cfg.BuildFiles.Ignore = append(cfg.BuildFiles.Ignore, "**/.heph")
 	cfg.CacheHistory = 3
-	cfg.Engine.GC = true
+	cfg.Engine.GC.Enabled = true
 	cfg.Engine.CacheHints = true
+	cfg.Engine.DeterministicArchives = true
+	cfg.Engine.Compression = string(tar.CompressionGzip)
 	cfg.CacheOrder = config.CacheOrderLatency
 	cfg.Platforms = map[string]config.Platform{
 		"local": {
@@ -659,6 +689,24 @@ func (e *Engine) parseConfigs() error {
 		}
 	}
 
+	err = tar.Compression(cfg.Engine.Compression).Validate()
+	if err != nil {
+		return fmt.Errorf("engine: %w", err)
+	}
+
+	err = tar.Compression(cfg.Engine.Compression).ValidateLevel(cfg.Engine.CompressionLevel)
+	if err != nil {
+		return fmt.Errorf("engine: %w", err)
+	}
+
+	for name, tool := range cfg.HostTools {
+		switch tool.Fingerprint {
+		case "", config.HostToolFingerprintNone, config.HostToolFingerprintContent, config.HostToolFingerprintVersion:
+		default:
+			return fmt.Errorf("host_tools: %v: fingerprint must be one of none, content, version, got %v", name, tool.Fingerprint)
+		}
+	}
+
 	e.Config.Config = cfg
 
 	for name, cache := range cfg.Caches {
@@ -771,7 +819,7 @@ func (e *Engine) GetFileDescendants(paths []string, targets []*Target) ([]*Targe
 	for _, path := range paths {
 		for _, target := range targets {
 			for _, file := range target.HashDeps.Files {
-				if file.RelRoot() == path {
+				if depFileMatch(file.RelRoot(), path) {
 					descendants.Add(target)
 					break
 				}
@@ -840,6 +888,30 @@ func (e *Engine) RunExitHandlers() {
 	e.exitHandlers = nil
 }
 
+// ResetRun clears the state left by a run, for the engine to be reused by the next one.
+// The files may have changed in between, so the hashes are computed again.
+func (e *Engine) ResetRun() {
+	e.cacheHashInput = &maps.Map[string, string]{}
+	e.cacheHashOutput = &maps.Map[string, string]{}
+	e.RemoteCacheHints.Reset()
+	e.Stats.Reset()
+
+	e.AuditInputs = false
+	e.BuildEvents = nil
+	e.TestPassCache = false
+	for _, k := range e.testCacheHits.Keys() {
+		e.testCacheHits.Delete(k)
+	}
+
+	e.inputsAuditsm.Lock()
+	e.inputsAudits = nil
+	e.inputsAuditsm.Unlock()
+
+	for _, target := range e.Targets.Slice() {
+		target.resetRun()
+	}
+}
+
 func (e *Engine) GetCodegenOrigin(path string) (*Target, bool) {
 	if dep, ok := e.codegenPaths[path]; ok {
 		return dep, ok
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"heph/utils/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResetRun(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, content string) {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), os.ModePerm)
+		require.NoError(t, err)
+	}
+
+	write("BUILD", `target(name="a", run="echo", deps="a.txt", out="a", cache=True)`)
+	write("a.txt", "1")
+
+	e := New(dir)
+	err := e.runBuildFiles(dir, e.createPkg)
+	require.NoError(t, err)
+
+	target := e.Targets.Find("//:a")
+	require.NotNil(t, target)
+	err = e.processTarget(target)
+	require.NoError(t, err)
+	err = e.LinkTarget(target, nil)
+	require.NoError(t, err)
+
+	hash := e.hashInput(target)
+
+	// State left by a run
+	outDir := fs.NewPath(dir, "")
+	target.OutExpansionRoot = &outDir
+	target.actualOutFiles = &ActualOutNamedPaths{}
+	target.actualSupportFiles = fs.Paths{}
+	e.AuditInputs = true
+
+	write("a.txt", "2")
+	assert.Equal(t, hash, e.hashInput(target))
+
+	e.ResetRun()
+
+	assert.Nil(t, target.OutExpansionRoot)
+	assert.Nil(t, target.actualOutFiles)
+	assert.Nil(t, target.actualSupportFiles)
+	assert.False(t, e.AuditInputs)
+	assert.NotEqual(t, hash, e.hashInput(target))
+}
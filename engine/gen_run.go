@@ -129,6 +129,12 @@ func (e *runGenEngine) ScheduleGeneratedPipeline(ctx context.Context, targets []
 
 			log.Tracef("run generated %v got %v targets in %v", e.Name, newTargets.Len(), time.Since(start))
 
+			configured, err := e.configureTargets(newTargets.Slice())
+			if err != nil {
+				return err
+			}
+			newTargets.AddAll(configured)
+
 			genTargets := make([]*Target, 0)
 			for _, t := range newTargets.Slice() {
 				err := e.processTarget(t)
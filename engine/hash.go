@@ -13,6 +13,7 @@ import (
 	"heph/utils/tar"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -168,6 +169,20 @@ func (e *Engine) ResetCacheHashInput(target *Target) {
 	}
 }
 
+func (e *Engine) ResetCacheHashOutput(target *Target) {
+	ks := make([]string, 0)
+
+	for _, k := range e.cacheHashOutput.Keys() {
+		if strings.HasPrefix(k, target.FQN+"|") {
+			ks = append(ks, k)
+		}
+	}
+
+	for _, k := range ks {
+		e.cacheHashOutput.Delete(k)
+	}
+}
+
 func (e *Engine) hashInputFiles(h hash.Hash, target *Target) error {
 	e.hashFiles(h, targetspec.HashFileModTime, target.Deps.All().Files)
 
@@ -213,39 +228,77 @@ func (e *Engine) hashInput(target *Target) string {
 	}()
 
 	h := hash.NewDebuggableHash(target.FQN + "_hash_input")
+	e.writeHashInput(&hashInputHasher{Hash: h}, target)
+
+	sh := h.Sum()
+
+	e.cacheHashInput.Set(cacheId, sh)
+
+	return sh
+}
+
+// writeHashInput writes the inputs of the target to h, split into the components reported by HashInputComponents
+func (e *Engine) writeHashInput(h *hashInputHasher, target *Target) {
+	h.component("version")
 	h.I64(8) // Force break all caches
 
+	h.component("tools")
 	h.String("=")
 	for _, dep := range target.Tools.Targets {
+		h.component("tools " + dep.Name)
 		h.String(dep.Name)
 
 		dh := e.hashOutput(e.Targets.Find(dep.Target.FQN), dep.Output)
 		h.String(dh)
 	}
 
+	h.component("host_tools")
 	h.String("=")
 	hash.HashArray(h, target.Tools.Hosts, func(tool targetspec.TargetSpecHostTool) string {
 		return tool.Name
 	})
+	hosts := append([]targetspec.TargetSpecHostTool(nil), target.Tools.Hosts...)
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Name < hosts[j].Name
+	})
+	for _, tool := range hosts {
+		fp, err := e.hostToolFingerprint(tool)
+		if err != nil {
+			// Run paths report it through checkHostToolFingerprints first
+			panic(fmt.Errorf("hashInput: %v: host tool %v: fingerprint: %w", target.FQN, tool.Name, err))
+		}
+
+		if fp != "" {
+			h.component("host_tools " + tool.Name)
+			h.String(tool.Name)
+			h.String(fp)
+		}
+	}
 
-	h.String("=")
 	if target.DifferentHashDeps {
+		h.component("hash_deps")
+		h.String("=")
 		h.String("=")
-		e.hashDepsTargets(h, target.HashDeps.Targets)
-		e.hashFiles(h, target.HashFile, target.HashDeps.Files)
+		e.hashInputDeps(h, "hash_deps", target.HashFile, target.HashDeps)
 	} else {
+		h.component("deps")
+		h.String("=")
 		h.String("=")
 		for _, name := range target.Deps.Names() {
+			prefix := "deps"
+			if name != "" {
+				prefix += "[" + name + "]"
+				h.component(prefix)
+			}
+
 			h.String("=")
 			h.String(name)
 
-			deps := target.Deps.Name(name)
-
-			e.hashDepsTargets(h, deps.Targets)
-			e.hashFiles(h, target.HashFile, deps.Files)
+			e.hashInputDeps(h, prefix, target.HashFile, target.Deps.Name(name))
 		}
 	}
 
+	h.component("run")
 	h.String("=")
 	for _, cmd := range target.Run {
 		h.String(cmd)
@@ -253,10 +306,12 @@ func (e *Engine) hashInput(target *Target) string {
 	h.String(target.Entrypoint)
 
 	if target.IsTextFile() {
+		h.component("file_content")
 		h.String("=")
 		h.Write(target.FileContent)
 	}
 
+	h.component("out")
 	h.String("=")
 	hash.HashArray(h, target.TargetSpec.Out, func(file targetspec.TargetSpecOutFile) string {
 		return file.Name + file.Path
@@ -266,26 +321,43 @@ func (e *Engine) hashInput(target *Target) string {
 		h.Bool(target.OutInSandbox)
 	}
 
+	h.component("env")
 	h.String("=")
 	hash.HashMap(h, target.Env, func(k, v string) string {
 		return k + v
 	})
 
+	h.component("gen")
 	h.String("=")
 	h.Bool(target.Gen)
 
+	h.component("src_env")
 	h.String("=")
 	h.String(target.SrcEnv.All)
 	hash.HashMap(h, target.SrcEnv.Named, func(k, v string) string {
 		return k + v
 	})
+	h.component("out_env")
 	h.String(target.OutEnv)
 
-	sh := h.Sum()
+	if !target.Configuration.IsZero() {
+		h.component("configuration")
+		h.String("=")
+		h.String(target.Configuration.String())
+	}
+}
 
-	e.cacheHashInput.Set(cacheId, sh)
+// hashInputDeps writes each target and file of deps as its own component
+func (e *Engine) hashInputDeps(h *hashInputHasher, prefix, hashMethod string, deps tgt.TargetDeps) {
+	for _, dep := range deps.Targets {
+		h.component(prefix + " " + dep.Full())
+		e.hashDepsTargets(h, []tgt.TargetWithOutput{dep})
+	}
 
-	return sh
+	for _, file := range deps.Files {
+		h.component(prefix + " " + file.RelRoot())
+		e.hashFiles(h, hashMethod, fs.Paths{file})
+	}
 }
 
 func (e *Engine) HashOutput(target *Target, output string) string {
@@ -328,24 +400,36 @@ func (e *Engine) hashOutput(target *Target, output string) string {
 	// Sanity check, will bomb if not called in the right order
 	_ = target.ActualOutFiles()
 
+	var supportHash string
+	if target.HasSupportFiles && output != targetspec.SupportFilesOutput {
+		supportHash = e.hashOutput(target, targetspec.SupportFilesOutput)
+	}
+
+	tarPath := e.localOutTarPath(target, output)
+	sh, err := e.hashOutputArchive(target, output, tarPath, supportHash)
+	if err != nil {
+		panic(fmt.Errorf("hashOutput: %v: hashTar %v %w", target.FQN, tarPath, err))
+	}
+
+	e.cacheHashOutput.Set(cacheId, sh)
+
+	return sh
+}
+
+// hashOutputArchive computes the output hash from the content of the output tarball
+func (e *Engine) hashOutputArchive(target *Target, output, tarPath, supportHash string) (string, error) {
 	h := hash.NewDebuggableHash(target.FQN + "_hash_out_" + output)
 
 	h.String(output)
 
-	tarPath := e.cacheDir(target).Join(target.artifacts.OutTar(output).Name()).Abs()
-	err = e.hashTar(h, tarPath)
+	err := e.hashTar(h, tarPath)
 	if err != nil {
-		panic(fmt.Errorf("hashOutput: %v: hashTar %v %w", target.FQN, tarPath, err))
+		return "", err
 	}
 
-	if target.HasSupportFiles && output != targetspec.SupportFilesOutput {
-		sh := e.hashOutput(target, targetspec.SupportFilesOutput)
-		h.String(sh)
+	if supportHash != "" {
+		h.String(supportHash)
 	}
 
-	sh := h.Sum()
-
-	e.cacheHashOutput.Set(cacheId, sh)
-
-	return sh
+	return h.Sum(), nil
 }
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"heph/utils/hash"
+	"io"
+	"os"
+)
+
+// HashInputComponent is a part of the input hash of a target, such as a dep or the env, hashed on its own
+type HashInputComponent struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// hashInputHasher writes to the input hash, and to the hash of the current component when recording
+type hashInputHasher struct {
+	hash.Hash
+
+	record     bool
+	name       string
+	current    hash.Hash
+	components []HashInputComponent
+}
+
+func (h *hashInputHasher) component(name string) {
+	if !h.record {
+		return
+	}
+
+	h.end()
+	h.name = name
+	h.current = hash.NewHash()
+}
+
+func (h *hashInputHasher) end() {
+	if h.current == nil {
+		return
+	}
+
+	h.components = append(h.components, HashInputComponent{Name: h.name, Hash: h.current.Sum()})
+	h.current = nil
+}
+
+func (h *hashInputHasher) String(val string) {
+	h.Hash.String(val)
+	if h.current != nil {
+		h.current.String(val)
+	}
+}
+
+func (h *hashInputHasher) I64(val int64) {
+	h.Hash.I64(val)
+	if h.current != nil {
+		h.current.I64(val)
+	}
+}
+
+func (h *hashInputHasher) UI32(val uint32) {
+	h.Hash.UI32(val)
+	if h.current != nil {
+		h.current.UI32(val)
+	}
+}
+
+func (h *hashInputHasher) Bool(val bool) {
+	h.Hash.Bool(val)
+	if h.current != nil {
+		h.current.Bool(val)
+	}
+}
+
+func (h *hashInputHasher) Write(p []byte) (int, error) {
+	if h.current != nil {
+		_, _ = h.current.Write(p)
+	}
+
+	return h.Hash.Write(p)
+}
+
+// HashInputComponents returns the components of the input hash of the target, in hashing order.
+// Deps must have run
+func (e *Engine) HashInputComponents(target *Target) []HashInputComponent {
+	h := &hashInputHasher{Hash: hash.NewHash(), record: true}
+	e.writeHashInput(h, target)
+	h.end()
+
+	return h.components
+}
+
+// CachedManifest returns the manifest of the cache entry of the target for inputHash,
+// from the cache named cacheName, or the local cache if empty. An empty inputHash is the latest local entry
+func (e *Engine) CachedManifest(target *Target, inputHash, cacheName string) (ManifestData, error) {
+	var d ManifestData
+	var r io.ReadCloser
+
+	name := target.artifacts.Manifest.Name()
+	if cacheName == "" {
+		if inputHash == "" {
+			inputHash = "latest"
+		}
+
+		f, err := os.Open(e.cacheDirForHash(target, inputHash).Join(name).Abs())
+		if err != nil {
+			return d, err
+		}
+		r = f
+	} else {
+		if inputHash == "" {
+			return d, fmt.Errorf("a hash is required to read from %v", cacheName)
+		}
+
+		cache, err := e.cacheConfig(cacheName)
+		if err != nil {
+			return d, err
+		}
+
+		loc, err := remoteCacheEntryLocation(cache.Location, target.Package.FullName, target.Name, inputHash)
+		if err != nil {
+			return d, err
+		}
+
+		f, err := loc.NewFile(name)
+		if err != nil {
+			return d, err
+		}
+
+		exists, err := f.Exists()
+		if err != nil {
+			_ = f.Close()
+			return d, err
+		}
+		if !exists {
+			_ = f.Close()
+			return d, fmt.Errorf("%v: %w", f.URI(), os.ErrNotExist)
+		}
+		r = f
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return d, err
+	}
+
+	err = json.Unmarshal(b, &d)
+	if err != nil {
+		return d, fmt.Errorf("%v: %w", name, err)
+	}
+
+	return d, nil
+}
+
+// HashInputComponentDiff is a component whose hash differs, Previous or Current being empty if it was added or removed
+type HashInputComponentDiff struct {
+	Name     string
+	Previous string
+	Current  string
+}
+
+// DiffHashInputComponents returns the components that differ, in the current hashing order followed by the removed ones
+func DiffHashInputComponents(previous, current []HashInputComponent) []HashInputComponentDiff {
+	prev := map[string]string{}
+	for _, c := range previous {
+		prev[c.Name] = c.Hash
+	}
+
+	cur := map[string]struct{}{}
+
+	diffs := make([]HashInputComponentDiff, 0)
+	for _, c := range current {
+		cur[c.Name] = struct{}{}
+
+		if p, ok := prev[c.Name]; !ok || p != c.Hash {
+			diffs = append(diffs, HashInputComponentDiff{Name: c.Name, Previous: p, Current: c.Hash})
+		}
+	}
+
+	for _, c := range previous {
+		if _, ok := cur[c.Name]; !ok {
+			diffs = append(diffs, HashInputComponentDiff{Name: c.Name, Previous: c.Hash})
+		}
+	}
+
+	return diffs
+}
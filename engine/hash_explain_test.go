@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"heph/utils/hash"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashInputHasher(t *testing.T) {
+	expected := hash.NewHash()
+	expected.String("a")
+	expected.String("b")
+	expected.I64(1)
+
+	h := &hashInputHasher{Hash: hash.NewHash(), record: true}
+	h.component("first")
+	h.String("a")
+	h.component("second")
+	h.String("b")
+	h.I64(1)
+	h.end()
+
+	// Recording must not change the hash
+	assert.Equal(t, expected.Sum(), h.Sum())
+
+	second := hash.NewHash()
+	second.String("b")
+	second.I64(1)
+
+	assert.Equal(t, []HashInputComponent{
+		{Name: "first", Hash: hash.HashString("a")},
+		{Name: "second", Hash: second.Sum()},
+	}, h.components)
+}
+
+func TestDiffHashInputComponents(t *testing.T) {
+	previous := []HashInputComponent{
+		{Name: "run", Hash: "1"},
+		{Name: "deps //:a", Hash: "2"},
+		{Name: "env", Hash: "3"},
+	}
+	current := []HashInputComponent{
+		{Name: "run", Hash: "1"},
+		{Name: "deps //:b", Hash: "4"},
+		{Name: "env", Hash: "5"},
+	}
+
+	assert.Equal(t, []HashInputComponentDiff{
+		{Name: "deps //:b", Current: "4"},
+		{Name: "env", Previous: "3", Current: "5"},
+		{Name: "deps //:a", Previous: "2"},
+	}, DiffHashInputComponents(previous, current))
+
+	assert.Empty(t, DiffHashInputComponents(previous, previous))
+}
@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"fmt"
+	"heph/config"
+	log "heph/hlog"
+	"heph/targetspec"
+	"heph/utils/fs"
+	"heph/utils/hash"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// hostToolFingerprint returns the fingerprint of the host tool as configured in host_tools, empty if not fingerprinted.
+// It is cached in HomeDir per path and modtime, so that upgrading the tool invalidates it
+func (e *Engine) hostToolFingerprint(tool targetspec.TargetSpecHostTool) (string, error) {
+	cfg, ok := e.Config.HostTools[tool.BinName]
+	if !ok || cfg.Fingerprint == "" || cfg.Fingerprint == config.HostToolFingerprintNone || tool.BinName == "heph" {
+		return "", nil
+	}
+
+	path, err := tool.ResolvedPath()
+	if err != nil {
+		return "", err
+	}
+
+	// Multi-call binaries dispatch on argv[0], the tool runs through its own link,
+	// only the modtime and size of what it points to are part of the key
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(realPath)
+	if err != nil {
+		return "", err
+	}
+
+	key := strings.Join(append([]string{
+		cfg.Fingerprint,
+		path,
+		realPath,
+		fmt.Sprint(info.ModTime().UnixNano()),
+		fmt.Sprint(info.Size()),
+	}, cfg.VersionArgs...), "|")
+
+	once := e.cacheHostToolFingerprint.Get(key)
+
+	return once.Do(func() (string, error) {
+		p := e.HomeDir.Join("tmp", "host_tools", hash.HashString(key)).Abs()
+
+		if b, err := os.ReadFile(p); err == nil && len(b) > 0 {
+			return string(b), nil
+		}
+
+		h := hash.NewHash()
+
+		switch cfg.Fingerprint {
+		case config.HostToolFingerprintContent:
+			err := e.hashFilePath(h, realPath)
+			if err != nil {
+				return "", err
+			}
+		case config.HostToolFingerprintVersion:
+			args := cfg.VersionArgs
+			if len(args) == 0 {
+				args = []string{"--version"}
+			}
+
+			b, err := exec.Command(path, args...).CombinedOutput()
+			if err != nil {
+				return "", fmt.Errorf("%v %v: %w: %s", path, strings.Join(args, " "), err, b)
+			}
+
+			h.Write(b)
+		}
+
+		fp := h.Sum()
+
+		err := storeHostToolFingerprint(p, fp)
+		if err != nil {
+			log.Debugf("host tool %v: store fingerprint: %v", tool.Name, err)
+		}
+
+		return fp, nil
+	})
+}
+
+// checkHostToolFingerprints fails when a host tool of the target cannot be fingerprinted,
+// before its hash is needed: hashing only the name would serve outputs built with another version of the tool
+func (e *Engine) checkHostToolFingerprints(target *Target) error {
+	for _, tool := range target.Tools.Hosts {
+		_, err := e.hostToolFingerprint(tool)
+		if err != nil {
+			return fmt.Errorf("host tool %v: fingerprint: %w", tool.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func storeHostToolFingerprint(p, fp string) error {
+	err := fs.CreateParentDir(p)
+	if err != nil {
+		return err
+	}
+
+	f, err := fs.AtomicCreate(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte(fp))
+
+	return err
+}
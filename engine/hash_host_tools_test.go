@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"heph/config"
+	"heph/targetspec"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostToolFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tool")
+
+	write := func(version string, mtime time.Time) {
+		err := os.WriteFile(path, []byte("#!/bin/sh\necho "+version+"\n"), 0755)
+		require.NoError(t, err)
+		err = os.Chtimes(path, mtime, mtime)
+		require.NoError(t, err)
+	}
+
+	e := New(dir)
+	e.Config.HostTools = map[string]config.HostTool{
+		"tool":    {Fingerprint: config.HostToolFingerprintVersion},
+		"content": {Fingerprint: config.HostToolFingerprintContent},
+	}
+
+	fingerprint := func(bin string) string {
+		fp, err := e.hostToolFingerprint(targetspec.TargetSpecHostTool{Name: bin, BinName: bin, Path: path})
+		require.NoError(t, err)
+		return fp
+	}
+
+	now := time.Now()
+	write("1.0", now)
+
+	v1 := fingerprint("tool")
+	c1 := fingerprint("content")
+	assert.NotEmpty(t, v1)
+	assert.NotEmpty(t, c1)
+	assert.Empty(t, fingerprint("other"))
+
+	// Persisted, a new engine does not run the tool again while its path and modtime are the same
+	write("3.0", now)
+	e = New(dir)
+	e.Config.HostTools = map[string]config.HostTool{
+		"tool": {Fingerprint: config.HostToolFingerprintVersion},
+	}
+	assert.Equal(t, v1, fingerprint("tool"))
+
+	write("2.0", now.Add(time.Minute))
+
+	assert.NotEqual(t, v1, fingerprint("tool"))
+	e.Config.HostTools["content"] = config.HostTool{Fingerprint: config.HostToolFingerprintContent}
+	assert.NotEqual(t, c1, fingerprint("content"))
+}
+
+func TestHostToolFingerprintMissing(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+target(name="a", run="missing", tools=["missing"])
+`), os.ModePerm)
+	require.NoError(t, err)
+
+	e := New(dir)
+	e.Config.HostTools = map[string]config.HostTool{
+		"missing": {Fingerprint: config.HostToolFingerprintVersion},
+	}
+	err = e.runBuildFiles(dir, e.createPkg)
+	require.NoError(t, err)
+
+	target := e.Targets.Find("//:a")
+	require.NotNil(t, target)
+	target.Tools.Hosts = []targetspec.TargetSpecHostTool{{Name: "missing", BinName: "missing", Path: filepath.Join(dir, "missing")}}
+
+	_, err = e.hostToolFingerprint(target.Tools.Hosts[0])
+	assert.Error(t, err)
+
+	// Hashing only the name would serve stale outputs, the target fails instead
+	assert.EqualError(t, e.checkHostToolFingerprints(target), "host tool missing: fingerprint: "+err.Error())
+	assert.PanicsWithError(t, "hashInput: //:a: host tool missing: fingerprint: "+err.Error(), func() {
+		e.hashInput(target)
+	})
+}
+
+func TestHostToolFingerprintMultiCall(t *testing.T) {
+	dir := t.TempDir()
+
+	// Prints the name it is called with, like busybox picks the applet
+	multi := filepath.Join(dir, "multi")
+	err := os.WriteFile(multi, []byte("#!/bin/sh\nbasename \"$0\"\n"), 0755)
+	require.NoError(t, err)
+
+	e := New(dir)
+	e.Config.HostTools = map[string]config.HostTool{}
+
+	fingerprint := func(bin string) string {
+		p := filepath.Join(dir, bin)
+		require.NoError(t, os.Symlink(multi, p))
+		e.Config.HostTools[bin] = config.HostTool{Fingerprint: config.HostToolFingerprintVersion}
+
+		fp, err := e.hostToolFingerprint(targetspec.TargetSpecHostTool{Name: bin, BinName: bin, Path: p})
+		require.NoError(t, err)
+		return fp
+	}
+
+	assert.NotEqual(t, fingerprint("a"), fingerprint("b"))
+}
@@ -51,6 +51,11 @@ func (e *Engine) Parse(ctx context.Context) error {
 	}
 	log.Debugf("RunBuildFiles took %v", time.Since(runStartTime))
 
+	_, err = e.configureTargets(e.Targets.Slice())
+	if err != nil {
+		return err
+	}
+
 	e.Targets.Sort()
 
 	processStartTime := time.Now()
@@ -11,10 +11,7 @@ import (
 	"heph/targetspec"
 	"heph/utils"
 	"heph/utils/hash"
-	"heph/utils/sets"
-	"io/fs"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"sync"
 )
@@ -63,15 +60,22 @@ func predeclared_functions() starlark.StringDict {
 		p["to_json"] = starlark.NewBuiltin("to_json", to_json)
 		p["fail"] = starlark.NewBuiltin("fail", fail)
 		p["struct"] = starlark.NewBuiltin("struct", starlarkstruct.Make)
+		p["rule"] = starlark.NewBuiltin("rule", rule)
+		p["attr"] = attrModule()
+		p["provider"] = starlark.NewBuiltin("provider", provider)
 		p["heph"] = &starlarkstruct.Module{
 			Name: "heph",
 			Members: starlark.StringDict{
-				"canonicalize": starlark.NewBuiltin("heph.canonicalize", canonicalize),
-				"is_target":    starlark.NewBuiltin("heph.is_target", is_target),
-				"split":        starlark.NewBuiltin("heph.split", split),
-				"param":        starlark.NewBuiltin("heph.param", param),
-				"cache":        starlark.NewBuiltin("heph.cache", starlarkstruct.Make),
-				"target_spec":  starlark.NewBuiltin("heph.target_spec", starlarkstruct.Make),
+				"canonicalize":  starlark.NewBuiltin("heph.canonicalize", canonicalize),
+				"is_target":     starlark.NewBuiltin("heph.is_target", is_target),
+				"split":         starlark.NewBuiltin("heph.split", split),
+				"param":         starlark.NewBuiltin("heph.param", param),
+				"cache":         starlark.NewBuiltin("heph.cache", starlarkstruct.Make),
+				"sandbox":       starlark.NewBuiltin("heph.sandbox", starlarkstruct.Make),
+				"target_spec":   starlark.NewBuiltin("heph.target_spec", starlarkstruct.Make),
+				"providers":     starlark.NewBuiltin("heph.providers", providers),
+				"transition":    starlark.NewBuiltin("heph.transition", starlarkstruct.Make),
+				"configuration": starlark.NewBuiltin("heph.configuration", configuration),
 				//"normalize_target_name": starlark.NewBuiltin("heph.normalize_target_name", normalize_target_name),
 				//"normalize_pkg_name":    starlark.NewBuiltin("heph.normalize_target_name", normalize_pkg_name),
 				"pkg": &starlarkstruct.Module{
@@ -186,7 +190,7 @@ func internal_target(thread *starlark.Thread, fn *starlark.Builtin, args starlar
 		"hash_deps?", &sargs.HashDeps,
 		"cache?", &sargs.Cache,
 		"restore_cache?", &sargs.RestoreCache,
-		"sandbox?", &sargs.SandboxEnabled,
+		"sandbox?", &sargs.Sandbox,
 		"out_in_sandbox?", &sargs.OutInSandbox,
 		"codegen?", &sargs.Codegen,
 		"tools?", &sargs.Tools,
@@ -201,6 +205,8 @@ func internal_target(thread *starlark.Thread, fn *starlark.Builtin, args starlar
 		"hash_file?", &sargs.HashFile,
 		"transitive?", &sargs.Transitive,
 		"timeout?", &sargs.Timeout,
+		"providers?", &sargs.Providers,
+		"transition?", &sargs.Transition,
 	); err != nil {
 		if sargs.Name != "" {
 			return nil, fmt.Errorf("%v: %w", pkg.TargetPath(sargs.Name), err)
@@ -225,11 +231,19 @@ func internal_target(thread *starlark.Thread, fn *starlark.Builtin, args starlar
 
 	t.Source = stackTrace(thread)
 
+	t, err = e.configureSpec(t)
+	if err != nil {
+		return nil, err
+	}
+
 	err = e.registerTarget(t)
 	if err != nil {
 		return nil, err
 	}
 
+	inputs := threadBuildInputs(thread)
+	inputs.Specs = append(inputs.Specs, t)
+
 	return starlark.String(t.FQN), nil
 }
 
@@ -250,25 +264,20 @@ func glob(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kw
 		return nil, err
 	}
 
-	allExclude := exclude.Array
-	allExclude = append(allExclude, "**/.heph")
-	allExclude = append(allExclude, e.Config.BuildFiles.Glob.Exclude...)
-
-	elems := sets.NewStringSet(0)
-	err := utils.StarWalk(pkg.Root.Abs(), pattern, allExclude, func(path string, d fs.DirEntry, err error) error {
-		if d.IsDir() {
-			return nil
-		}
-
-		elems.Add(path)
-
-		return nil
-	})
+	files, err := e.globFiles(pkg.Root.Abs(), pattern, exclude.Array)
 	if err != nil {
 		return nil, err
 	}
 
-	return starlark.NewList(utils.Map(elems.Slice(), func(p string) starlark.Value {
+	inputs := threadBuildInputs(thread)
+	inputs.Globs = append(inputs.Globs, buildGlob{
+		Root:    pkg.Root.Abs(),
+		Pattern: pattern,
+		Exclude: exclude.Array,
+		Hash:    hashGlob(files),
+	})
+
+	return starlark.NewList(utils.Map(files, func(p string) starlark.Value {
 		return starlark.String(p)
 	})), nil
 }
@@ -292,11 +301,11 @@ func package_fqn(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tu
 }
 
 func get_os(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	return starlark.String(runtime.GOOS), nil
+	return starlark.String(getEngine(thread).configuredOs()), nil
 }
 
 func get_arch(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	return starlark.String(runtime.GOARCH), nil
+	return starlark.String(getEngine(thread).configuredArch()), nil
 }
 
 func to_json(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
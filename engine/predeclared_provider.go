@@ -0,0 +1,282 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/starlarkstruct"
+	"heph/targetspec"
+	"heph/utils/hash"
+	"sort"
+	"strings"
+)
+
+// starlarkProvider is the value returned by provider(), it is named after the global it gets assigned to,
+// calling it creates a struct to pass to target(providers=[...])
+type starlarkProvider struct {
+	name   string
+	doc    string
+	fields []string
+}
+
+var _ starlark.Callable = (*starlarkProvider)(nil)
+
+func (p *starlarkProvider) Name() string          { return p.name }
+func (p *starlarkProvider) String() string        { return p.name }
+func (p *starlarkProvider) Type() string          { return "provider" }
+func (p *starlarkProvider) Freeze()               {}
+func (p *starlarkProvider) Truth() starlark.Bool  { return starlark.True }
+func (p *starlarkProvider) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: %v", p.Type()) }
+
+func provider(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		name   string
+		doc    string
+		fields Array
+	)
+
+	if err := starlark.UnpackArgs(
+		fn.Name(), args, kwargs,
+		"name?", &name,
+		"fields?", &fields,
+		"doc?", &doc,
+	); err != nil {
+		return nil, err
+	}
+
+	return &starlarkProvider{
+		name:   name,
+		doc:    doc,
+		fields: fields,
+	}, nil
+}
+
+func (p *starlarkProvider) CallInternal(thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if p.name == "" {
+		return nil, fmt.Errorf("provider must be assigned to a global or declared with a name before being used")
+	}
+
+	if len(args) > 0 {
+		return nil, fmt.Errorf("%v: only keyword arguments are allowed", p.name)
+	}
+
+	values := starlark.StringDict{}
+	for _, field := range p.fields {
+		values[field] = starlark.None
+	}
+
+	for _, kwarg := range kwargs {
+		k := string(kwarg[0].(starlark.String))
+
+		if len(p.fields) > 0 && !contains(p.fields, k) {
+			return nil, fmt.Errorf("%v: unknown field %v, expected one of: %v", p.name, k, strings.Join(p.fields, ", "))
+		}
+
+		values[k] = kwarg[1]
+	}
+
+	return starlarkstruct.FromStringDict(p, values), nil
+}
+
+type TargetArgsProviders []targetspec.TargetSpecProvider
+
+func (c *TargetArgsProviders) Unpack(v starlark.Value) error {
+	if _, ok := v.(starlark.NoneType); ok {
+		return nil
+	}
+
+	l, ok := v.(*starlark.List)
+	if !ok {
+		return fmt.Errorf("providers must be a list, got %v", v.Type())
+	}
+
+	thread := newStarlarkThread()
+
+	providers := make([]targetspec.TargetSpecProvider, 0, l.Len())
+	err := listForeach(l, func(i int, v starlark.Value) error {
+		s, ok := v.(*starlarkstruct.Struct)
+		if !ok {
+			return fmt.Errorf("providers: element %v: expected provider, got %v", i, v.Type())
+		}
+
+		p, ok := s.Constructor().(*starlarkProvider)
+		if !ok {
+			return fmt.Errorf("providers: element %v: expected provider, got %v", i, s.Constructor())
+		}
+
+		for _, o := range providers {
+			if o.Name == p.name {
+				return fmt.Errorf("providers: %v is set twice", p.name)
+			}
+		}
+
+		provider := targetspec.TargetSpecProvider{
+			Name:   p.name,
+			Fields: map[string]json.RawMessage{},
+		}
+
+		for _, name := range s.AttrNames() {
+			fv, err := s.Attr(name)
+			if err != nil {
+				return err
+			}
+
+			b, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{fv}, nil)
+			if err != nil {
+				return fmt.Errorf("providers: %v.%v: %w", p.name, name, err)
+			}
+
+			provider.Fields[name] = json.RawMessage(b.(starlark.String))
+		}
+
+		providers = append(providers, provider)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].Name < providers[j].Name
+	})
+
+	*c = providers
+
+	return nil
+}
+
+// providersValue turns the providers of a spec back into the structs they got created from
+func providersValue(thread *starlark.Thread, providers []targetspec.TargetSpecProvider) (starlark.Value, error) {
+	d := &starlark.Dict{}
+	for _, provider := range providers {
+		values := starlark.StringDict{}
+		for name, b := range provider.Fields {
+			v, err := starlark.Call(thread, starlarkjson.Module.Members["decode"], starlark.Tuple{starlark.String(b)}, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			values[name] = v
+		}
+
+		err := d.SetKey(starlark.String(provider.Name), starlarkstruct.FromStringDict(&starlarkProvider{name: provider.Name}, values))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+type buildProvidersRead struct {
+	Target string
+	Hash   string
+}
+
+func hashProviders(providers []targetspec.TargetSpecProvider) string {
+	b, err := json.Marshal(providers)
+	if err != nil {
+		panic(err)
+	}
+
+	return hash.HashBytes(b)
+}
+
+// targetProviders returns the providers of the target, running the BUILD files of its package if it is not registered yet,
+// as part of the evaluation chain of breadcrumb
+func (e *Engine) targetProviders(fqn string, breadcrumb []string) ([]targetspec.TargetSpecProvider, error) {
+	if t := e.Targets.Find(fqn); t != nil {
+		return t.Providers, nil
+	}
+
+	tp, err := targetspec.TargetParse("", fqn)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg, err := e.loadFromRootsOrCreatePackage(tp.Package)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := e.buildCacheKey()
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.runBuildFilesForPackageCached(pkg, key, breadcrumb)
+	if err != nil {
+		return nil, err
+	}
+
+	t := e.Targets.Find(fqn)
+	if t == nil {
+		return nil, NewTargetNotFoundError(fqn)
+	}
+
+	return t.Providers, nil
+}
+
+func providers(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		target string
+	)
+
+	if err := starlark.UnpackArgs(
+		fn.Name(), args, kwargs,
+		"target", &target,
+	); err != nil {
+		return nil, err
+	}
+
+	pkg := getPackage(thread)
+	e := getEngine(thread)
+
+	tp, err := targetspec.TargetParse(pkg.FullName, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// Under a configuration, the providers are the ones of the target declared under it
+	tp.Name = e.configuration.TargetName(tp.Name)
+
+	// Targets of the package being run must be declared before being read, they are covered by its BUILD files
+	if tp.Package == pkg.FullName {
+		t := e.Targets.Find(tp.Full())
+		if t == nil {
+			return nil, fmt.Errorf("%v: %v must be declared before reading its providers", fn.Name(), tp.Full())
+		}
+
+		return providersValue(thread, t.Providers)
+	}
+
+	if !e.configuration.IsZero() {
+		// Configured packages are not cached, there is no read to record
+		err := e.runConfiguredPackage(tp.Package, e.configuration, e.registerTarget)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", fn.Name(), err)
+		}
+
+		t := e.Targets.Find(tp.Full())
+		if t == nil {
+			return nil, fmt.Errorf("%v: %w", fn.Name(), NewTargetNotFoundError(tp.Full()))
+		}
+
+		return providersValue(thread, t.Providers)
+	}
+
+	providers, err := e.targetProviders(tp.Full(), e.breadcrumb)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", fn.Name(), err)
+	}
+
+	inputs := threadBuildInputs(thread)
+	inputs.ProvidersReads = append(inputs.ProvidersReads, buildProvidersRead{
+		Target: tp.Full(),
+		Hash:   hashProviders(providers),
+	})
+
+	return providersValue(thread, providers)
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"fmt"
+	"heph/packages"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviders(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, content string) {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), os.ModePerm)
+		require.NoError(t, err)
+	}
+
+	write("defs.build", `GoInfo = provider(fields=["import_path"])`)
+	write("lib/BUILD", `
+load("//defs.build", "GoInfo")
+target(name="a", providers=[GoInfo(import_path="example.com/a")])
+`)
+	// The root package runs first, the providers of //lib:a are read before its package is
+	write("BUILD", `
+info = heph.providers("//lib:a")["GoInfo"]
+target(name="b", run=["echo", info.import_path])
+`)
+
+	run := func() []string {
+		e := New(dir)
+
+		err := e.runBuildFiles(dir, e.createPkg)
+		require.NoError(t, err)
+
+		target := e.Targets.Find("//:b")
+		require.NotNil(t, target)
+
+		return target.Run
+	}
+
+	assert.Equal(t, []string{"echo", "example.com/a"}, run())
+	// From the BUILD cache
+	assert.Equal(t, []string{"echo", "example.com/a"}, run())
+
+	write("lib/BUILD", `
+load("//defs.build", "GoInfo")
+target(name="a", providers=[GoInfo(import_path="example.com/b")])
+`)
+	// The compiled program is cached by modtime, which has a resolution of a second
+	future := time.Now().Add(time.Minute)
+	err := os.Chtimes(filepath.Join(dir, "lib/BUILD"), future, future)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"echo", "example.com/b"}, run())
+}
+
+func TestProvidersCycle(t *testing.T) {
+	dir := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(dir, "a"), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "a/BUILD"), []byte(`heph.providers("//:b")`), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+heph.providers("//a:x")
+target(name="b")
+`), os.ModePerm)
+	require.NoError(t, err)
+
+	e := New(dir)
+	err = e.runBuildFiles(dir, e.createPkg)
+	assert.ErrorContains(t, err, "cycle reading providers")
+}
+
+func TestProvidersConcurrent(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, content string) {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), os.ModePerm)
+		require.NoError(t, err)
+	}
+
+	write("defs.build", `GoInfo = provider(fields=["import_path"])`)
+	write("lib/BUILD", `
+load("//defs.build", "GoInfo")
+target(name="a", providers=[GoInfo(import_path="example.com/a")])
+`)
+
+	// As generated BUILD files, run concurrently, all reading the providers of the same package
+	gen := make([]string, 0)
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("gen%v/BUILD.gen", i)
+		write(name, fmt.Sprintf(`
+info = heph.providers("//lib:a")["GoInfo"]
+target(name="t%v", run=["echo", info.import_path])
+`, i))
+		gen = append(gen, name)
+	}
+
+	// Second round reads from the BUILD cache
+	for round := 0; round < 2; round++ {
+		e := New(dir)
+		// Known, not run yet, as a package from a root
+		lib := e.createPkg("lib")
+		lib.SourceFiles = packages.SourceFiles{{Path: filepath.Join(dir, "lib/BUILD")}}
+
+		var wg sync.WaitGroup
+		errs := make([]error, len(gen))
+		for i, name := range gen {
+			i, name := i, name
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				re := &runBuildEngine{
+					Engine:         e,
+					pkg:            e.createPkg(filepath.Dir(name)),
+					registerTarget: e.defaultRegisterTarget,
+				}
+
+				_, errs[i] = re.runBuildFile(filepath.Join(dir, name))
+			}()
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			require.NoError(t, err)
+
+			target := e.Targets.Find(fmt.Sprintf("//gen%v:t%v", i, i))
+			require.NotNil(t, target)
+			assert.Equal(t, []string{"echo", "example.com/a"}, target.Run)
+		}
+	}
+}
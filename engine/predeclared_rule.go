@@ -0,0 +1,426 @@
+package engine
+
+import (
+	"fmt"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"heph/targetspec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	AttrLabel     = "label"
+	AttrLabelList = "label_list"
+	AttrString    = "string"
+	AttrBool      = "bool"
+	AttrDict      = "dict"
+)
+
+// RuleInfo documents a rule, see `heph query rules`
+type RuleInfo struct {
+	Name  string
+	File  string
+	Doc   string
+	Attrs []RuleAttrInfo
+}
+
+type RuleAttrInfo struct {
+	Name      string
+	Type      string
+	Doc       string
+	Mandatory bool
+	Default   string
+	Values    []string
+}
+
+func attrModule() *starlarkstruct.Module {
+	members := starlark.StringDict{}
+	for _, typ := range []string{AttrLabel, AttrLabelList, AttrString, AttrBool, AttrDict} {
+		typ := typ
+		members[typ] = starlark.NewBuiltin("attr."+typ, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return newRuleAttr(fn, typ, args, kwargs)
+		})
+	}
+
+	return &starlarkstruct.Module{
+		Name:    "attr",
+		Members: members,
+	}
+}
+
+// ruleAttr is the value returned by attr.*
+type ruleAttr struct {
+	typ       string
+	doc       string
+	mandatory bool
+	def       starlark.Value
+	values    []string
+}
+
+var _ starlark.Value = (*ruleAttr)(nil)
+
+func (a *ruleAttr) String() string        { return fmt.Sprintf("<attr.%v>", a.typ) }
+func (a *ruleAttr) Type() string          { return "attr" }
+func (a *ruleAttr) Freeze()               { a.def.Freeze() }
+func (a *ruleAttr) Truth() starlark.Bool  { return starlark.True }
+func (a *ruleAttr) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: %v", a.Type()) }
+
+func newRuleAttr(fn *starlark.Builtin, typ string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	a := &ruleAttr{typ: typ}
+
+	var values Array
+	pairs := []interface{}{
+		"doc?", &a.doc,
+		"default?", &a.def,
+		"mandatory?", &a.mandatory,
+	}
+	if typ == AttrString {
+		pairs = append(pairs, "values?", &values)
+	}
+
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, pairs...); err != nil {
+		return nil, err
+	}
+	a.values = values
+
+	if a.def == nil {
+		switch typ {
+		case AttrLabel:
+			a.def = starlark.None
+		case AttrLabelList:
+			a.def = starlark.NewList(nil)
+		case AttrString:
+			a.def = starlark.String("")
+		case AttrBool:
+			a.def = starlark.False
+		case AttrDict:
+			a.def = &starlark.Dict{}
+		}
+	} else {
+		// Labels get canonicalized against the package of the target
+		_, err := a.check(a.def, func(s string) (string, error) { return s, nil })
+		if err != nil {
+			return nil, fmt.Errorf("%v: default: %w", fn.Name(), err)
+		}
+	}
+
+	return a, nil
+}
+
+// check validates v against the type of the attribute, and canonicalizes its labels
+func (a *ruleAttr) check(v starlark.Value, canonicalize func(string) (string, error)) (starlark.Value, error) {
+	switch a.typ {
+	case AttrLabel:
+		if v == starlark.None {
+			return v, nil
+		}
+
+		s, ok := v.(starlark.String)
+		if !ok {
+			break
+		}
+
+		l, err := canonicalize(string(s))
+		if err != nil {
+			return nil, err
+		}
+
+		return starlark.String(l), nil
+	case AttrLabelList:
+		seq, ok := v.(starlark.Indexable)
+		if !ok {
+			break
+		}
+		if _, ok := v.(starlark.String); ok {
+			break
+		}
+
+		labels := make([]starlark.Value, 0, seq.Len())
+		for i := 0; i < seq.Len(); i++ {
+			s, ok := seq.Index(i).(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("element %v: expected label, got %v", i, seq.Index(i).Type())
+			}
+
+			l, err := canonicalize(string(s))
+			if err != nil {
+				return nil, err
+			}
+
+			labels = append(labels, starlark.String(l))
+		}
+
+		return starlark.NewList(labels), nil
+	case AttrString:
+		s, ok := v.(starlark.String)
+		if !ok {
+			break
+		}
+
+		if len(a.values) > 0 && !contains(a.values, string(s)) {
+			return nil, fmt.Errorf("got %v, expected one of %v", string(s), strings.Join(a.values, ", "))
+		}
+
+		return s, nil
+	case AttrBool:
+		if _, ok := v.(starlark.Bool); ok {
+			return v, nil
+		}
+	case AttrDict:
+		d, ok := v.(*starlark.Dict)
+		if !ok {
+			break
+		}
+
+		for _, k := range d.Keys() {
+			if _, ok := k.(starlark.String); !ok {
+				return nil, fmt.Errorf("keys must be string, got %v", k.Type())
+			}
+		}
+
+		return d, nil
+	}
+
+	return nil, fmt.Errorf("expected %v, got %v", a.typ, v.Type())
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// starlarkRule is the value returned by rule(), it is named after the global it gets assigned to
+type starlarkRule struct {
+	name      string
+	file      string
+	impl      starlark.Callable
+	doc       string
+	attrs     map[string]*ruleAttr
+	attrNames []string
+}
+
+var _ starlark.Callable = (*starlarkRule)(nil)
+
+func (r *starlarkRule) String() string        { return fmt.Sprintf("<rule %v>", r.Name()) }
+func (r *starlarkRule) Type() string          { return "rule" }
+func (r *starlarkRule) Truth() starlark.Bool  { return starlark.True }
+func (r *starlarkRule) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: %v", r.Type()) }
+
+func (r *starlarkRule) Name() string {
+	if r.name == "" {
+		return r.impl.Name()
+	}
+
+	return r.name
+}
+
+func (r *starlarkRule) Freeze() {
+	r.impl.Freeze()
+	for _, a := range r.attrs {
+		a.Freeze()
+	}
+}
+
+func rule(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		impl  starlark.Callable
+		attrs *starlark.Dict
+		doc   string
+	)
+
+	if err := starlark.UnpackArgs(
+		fn.Name(), args, kwargs,
+		"implementation", &impl,
+		"attrs?", &attrs,
+		"doc?", &doc,
+	); err != nil {
+		return nil, err
+	}
+
+	r := &starlarkRule{
+		impl:  impl,
+		doc:   doc,
+		attrs: map[string]*ruleAttr{},
+	}
+
+	if attrs != nil {
+		for _, item := range attrs.Items() {
+			name, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("%v: attrs: keys must be string, got %v", fn.Name(), item[0].Type())
+			}
+
+			if name == "name" {
+				return nil, fmt.Errorf("%v: attrs: name is implicitly declared", fn.Name())
+			}
+
+			a, ok := item[1].(*ruleAttr)
+			if !ok {
+				return nil, fmt.Errorf("%v: attrs: %v must be declared with attr.*, got %v", fn.Name(), name, item[1].Type())
+			}
+
+			r.attrs[string(name)] = a
+			r.attrNames = append(r.attrNames, string(name))
+		}
+	}
+	sort.Strings(r.attrNames)
+
+	return r, nil
+}
+
+func (r *starlarkRule) CallInternal(thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	pkg := getPackage(thread)
+
+	if len(args) > 0 {
+		return nil, fmt.Errorf("%v: only keyword arguments are allowed", r.Name())
+	}
+
+	values := map[string]starlark.Value{}
+	var name string
+	for _, kwarg := range kwargs {
+		k := string(kwarg[0].(starlark.String))
+
+		if k == "name" {
+			s, ok := kwarg[1].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("%v: name: expected string, got %v", r.Name(), kwarg[1].Type())
+			}
+			name = string(s)
+			continue
+		}
+
+		if _, ok := r.attrs[k]; !ok {
+			return nil, fmt.Errorf("%v: unknown attribute %v, expected one of: %v", r.Name(), k, strings.Join(append([]string{"name"}, r.attrNames...), ", "))
+		}
+
+		values[k] = kwarg[1]
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("%v: missing mandatory attribute name", r.Name())
+	}
+
+	// Like deps, labels not starting with : or // are files of the package
+	canonicalize := func(s string) (string, error) {
+		if !strings.HasPrefix(s, ":") && !strings.HasPrefix(s, "//") {
+			return s, nil
+		}
+
+		tp, err := targetspec.TargetOutputParse(pkg.FullName, s)
+		if err != nil {
+			return "", err
+		}
+
+		return tp.Full(), nil
+	}
+
+	attrs := starlark.StringDict{}
+	for _, k := range r.attrNames {
+		a := r.attrs[k]
+
+		v, ok := values[k]
+		if !ok {
+			if a.mandatory {
+				return nil, fmt.Errorf("%v: %v: missing mandatory attribute %v", pkg.TargetPath(name), r.Name(), k)
+			}
+
+			v = a.def
+		}
+
+		v, err := a.check(v, canonicalize)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v: attribute %v: %w", pkg.TargetPath(name), r.Name(), k, err)
+		}
+
+		attrs[k] = v
+	}
+
+	ctx := starlarkstruct.FromStringDict(starlark.String("ctx"), starlark.StringDict{
+		"name":  starlark.String(name),
+		"label": starlark.String(pkg.TargetPath(name)),
+		"attr":  starlarkstruct.FromStringDict(starlark.String("attr"), attrs),
+	})
+
+	return starlark.Call(thread, r.impl, starlark.Tuple{ctx}, nil)
+}
+
+func (r *starlarkRule) info() RuleInfo {
+	info := RuleInfo{
+		Name: r.name,
+		File: r.file,
+		Doc:  r.doc,
+	}
+
+	for _, k := range r.attrNames {
+		a := r.attrs[k]
+
+		info.Attrs = append(info.Attrs, RuleAttrInfo{
+			Name:      k,
+			Type:      a.typ,
+			Doc:       a.doc,
+			Mandatory: a.mandatory,
+			Default:   a.def.String(),
+			Values:    a.values,
+		})
+	}
+
+	return info
+}
+
+// exportGlobals names the rules and providers assigned to the globals of a file, and records the rules
+func (e *Engine) exportGlobals(path string, globals starlark.StringDict, inputs *buildInputs) {
+	file := path
+	if rel, err := filepath.Rel(e.Root.Abs(), path); err == nil && !strings.HasPrefix(rel, "..") {
+		file = rel
+	}
+
+	for _, name := range globals.Keys() {
+		if p, ok := globals[name].(*starlarkProvider); ok && p.name == "" {
+			p.name = name
+			continue
+		}
+
+		r, ok := globals[name].(*starlarkRule)
+		if !ok || r.name != "" {
+			continue
+		}
+
+		r.name = name
+		r.file = file
+
+		inputs.Rules = append(inputs.Rules, r.info())
+	}
+
+	e.registerRules(inputs.Rules)
+}
+
+func (e *Engine) registerRules(rules []RuleInfo) {
+	for _, r := range rules {
+		e.rules.Set(r.File+":"+r.Name, r)
+	}
+}
+
+// Rules returns the rules declared in the files that got evaluated
+func (e *Engine) Rules() []RuleInfo {
+	rules := make([]RuleInfo, 0)
+	for _, k := range e.rules.Keys() {
+		rules = append(rules, e.rules.Get(k))
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Name != rules[j].Name {
+			return rules[i].Name < rules[j].Name
+		}
+
+		return rules[i].File < rules[j].File
+	})
+
+	return rules
+}
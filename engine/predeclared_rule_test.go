@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const ruleTestSrc = `
+def _impl(ctx):
+    return target(name=ctx.name, run="echo", deps=ctx.attr.srcs)
+
+lib = rule(
+    implementation=_impl,
+    doc="Builds a lib",
+    attrs={
+        "srcs": attr.label_list(mandatory=True, doc="Sources"),
+        "mode": attr.string(default="a", values=["a", "b"]),
+    },
+)
+`
+
+func TestRuleErrors(t *testing.T) {
+	tests := []struct {
+		call string
+		err  string
+	}{
+		{`lib(name="x")`, "//:x: lib: missing mandatory attribute srcs"},
+		{`lib(name="x", srcs=[], other=1)`, "lib: unknown attribute other, expected one of: name, mode, srcs"},
+		{`lib(name="x", srcs="a")`, "//:x: lib: attribute srcs: expected label_list, got string"},
+		{`lib(name="x", srcs=[], mode="c")`, "//:x: lib: attribute mode: got c, expected one of a, b"},
+		{`lib(srcs=[])`, "lib: missing mandatory attribute name"},
+	}
+	for _, test := range tests {
+		t.Run(test.call, func(t *testing.T) {
+			dir := t.TempDir()
+
+			err := os.WriteFile(filepath.Join(dir, "rules.build"), []byte(ruleTestSrc), os.ModePerm)
+			require.NoError(t, err)
+			err = os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`load("//rules.build", "lib")`+"\n"+test.call), os.ModePerm)
+			require.NoError(t, err)
+
+			e := New(dir)
+			err = e.runBuildFiles(dir, e.createPkg)
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestRuleInfo(t *testing.T) {
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "rules.build"), []byte(ruleTestSrc), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`load("//rules.build", "lib")`+"\n"+`lib(name="x", srcs=["a.txt"])`), os.ModePerm)
+	require.NoError(t, err)
+
+	expected := []RuleInfo{{
+		Name: "lib",
+		File: "rules.build",
+		Doc:  "Builds a lib",
+		Attrs: []RuleAttrInfo{
+			{Name: "mode", Type: "string", Default: `"a"`, Values: []string{"a", "b"}},
+			{Name: "srcs", Type: "label_list", Doc: "Sources", Mandatory: true, Default: "[]"},
+		},
+	}}
+
+	// The second run is served by the BUILD cache
+	for i := 0; i < 2; i++ {
+		e := New(dir)
+		err = e.runBuildFiles(dir, e.createPkg)
+		require.NoError(t, err)
+
+		assert.Equal(t, expected, e.Rules())
+		assert.NotNil(t, e.Targets.Find("//:x"))
+	}
+}
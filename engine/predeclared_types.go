@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
+	"heph/targetspec"
 )
 
 type TargetArgs struct {
@@ -21,7 +22,7 @@ type TargetArgs struct {
 	Cache               TargetArgsCache
 	RestoreCache        bool
 	SupportFiles        Array
-	SandboxEnabled      bool
+	Sandbox             TargetArgsSandbox
 	OutInSandbox        bool
 	Gen                 bool
 	Codegen             string
@@ -39,6 +40,8 @@ type TargetArgs struct {
 	HashFile            string
 	Transitive          TargetArgsTransitive
 	Timeout             string
+	Providers           TargetArgsProviders
+	Transition          TargetArgsTransition
 }
 
 type TargetArgsPlatforms []*starlark.Dict
@@ -137,9 +140,10 @@ func (c *TargetArgsTransitive) Unpack(v starlark.Value) error {
 }
 
 type TargetArgsCache struct {
-	Enabled bool
-	Named   Array
-	History int
+	Enabled     bool
+	Named       Array
+	History     int
+	Compression string
 }
 
 func (c *TargetArgsCache) Unpack(v starlark.Value) error {
@@ -170,6 +174,13 @@ func (c *TargetArgsCache) Unpack(v starlark.Value) error {
 				vi, _ := vsi.Int64()
 
 				cs.History = int(vi)
+			case "compression":
+				vs, ok := starlark.AsString(v)
+				if !ok {
+					return fmt.Errorf("compression must be a string, got %v", v.Type())
+				}
+
+				cs.Compression = vs
 			default:
 				return fmt.Errorf("invalid arg %v, call heph.cache()", n)
 			}
@@ -190,6 +201,125 @@ func (c *TargetArgsCache) Unpack(v starlark.Value) error {
 	return fmt.Errorf("cache must be bool or call heph.cache(), got %v", v.Type())
 }
 
+type TargetArgsSandbox struct {
+	Enabled   bool
+	Isolation bool
+	Network   bool
+	Fs        string
+	Allow     Array
+}
+
+func (c *TargetArgsSandbox) Unpack(v starlark.Value) error {
+	d, ok := v.(*starlarkstruct.Struct)
+	if ok {
+		cs := TargetArgsSandbox{
+			Enabled:   true,
+			Isolation: true,
+			Network:   true,
+		}
+
+		for _, n := range d.AttrNames() {
+			v, err := d.Attr(n)
+			if err != nil {
+				return err
+			}
+
+			switch n {
+			case "network":
+				b, ok := v.(starlark.Bool)
+				if !ok {
+					return fmt.Errorf("network must be bool, got %v", v.Type())
+				}
+
+				cs.Network = bool(b)
+			case "fs":
+				s, ok := v.(starlark.String)
+				if !ok {
+					return fmt.Errorf("fs must be string, got %v", v.Type())
+				}
+
+				cs.Fs = string(s)
+			case "allow":
+				err := cs.Allow.Unpack(v)
+				if err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("invalid arg %v, call heph.sandbox()", n)
+			}
+		}
+
+		*c = cs
+		return nil
+	}
+
+	b, ok := v.(starlark.Bool)
+	if ok {
+		*c = TargetArgsSandbox{
+			Enabled: bool(b),
+		}
+		return nil
+	}
+
+	return fmt.Errorf("sandbox must be bool or call heph.sandbox(), got %v", v.Type())
+}
+
+type TargetArgsTransition targetspec.Configuration
+
+func (c *TargetArgsTransition) Unpack(v starlark.Value) error {
+	if _, ok := v.(starlark.NoneType); ok {
+		return nil
+	}
+
+	d, ok := v.(*starlarkstruct.Struct)
+	if !ok {
+		return fmt.Errorf("transition must call heph.transition(), got %v", v.Type())
+	}
+
+	var cs TargetArgsTransition
+	for _, n := range d.AttrNames() {
+		v, err := d.Attr(n)
+		if err != nil {
+			return err
+		}
+
+		switch n {
+		case "os", "arch":
+			s, ok := v.(starlark.String)
+			if !ok {
+				return fmt.Errorf("%v must be string, got %v", n, v.Type())
+			}
+
+			if n == "os" {
+				cs.Os = string(s)
+			} else {
+				cs.Arch = string(s)
+			}
+		case "tags":
+			var tags Array
+			err := tags.Unpack(v)
+			if err != nil {
+				return err
+			}
+
+			cs.Tags = tags
+		case "params":
+			var params ArrayMap
+			err := params.Unpack(v)
+			if err != nil {
+				return err
+			}
+
+			cs.Params = params.StrMap
+		default:
+			return fmt.Errorf("invalid arg %v, call heph.transition()", n)
+		}
+	}
+
+	*c = cs
+	return nil
+}
+
 type BoolArray struct {
 	Bool  bool
 	Array []string
@@ -0,0 +1,473 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"heph/query"
+	"heph/targetspec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query evaluates expr over the DAG, targets must be linked. The targets are sorted by FQN
+func (e *Engine) Query(expr query.Expr) ([]*Target, error) {
+	qe := &queryEngine{
+		Engine: e,
+		specs:  map[string]map[string]interface{}{},
+	}
+
+	targets, err := qe.eval(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	targets.Sort()
+
+	return targets.Slice(), nil
+}
+
+type queryEngine struct {
+	*Engine
+	specs map[string]map[string]interface{}
+}
+
+type queryFunc struct {
+	minArgs, maxArgs int
+	f                func(qe *queryEngine, args []query.Expr) (*Targets, error)
+}
+
+var queryFuncs map[string]queryFunc
+
+func init() {
+	queryFuncs = map[string]queryFunc{
+		"deps":     {1, 2, queryDeps},
+		"rdeps":    {2, 3, queryRdeps},
+		"somepath": {2, 2, querySomepath},
+		"allpaths": {2, 2, queryAllpaths},
+		"label":    {1, 2, queryLabel},
+		"attr":     {3, 3, queryAttr},
+		"filter":   {2, 2, queryFilter},
+	}
+}
+
+func (qe *queryEngine) eval(expr query.Expr) (*Targets, error) {
+	switch expr := expr.(type) {
+	case query.Word:
+		return qe.evalWord(expr.Value)
+	case query.Binary:
+		left, err := qe.eval(expr.Left)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := qe.eval(expr.Right)
+		if err != nil {
+			return nil, err
+		}
+
+		targets := NewTargets(0)
+		switch expr.Op {
+		case query.OpUnion:
+			targets.AddAll(left.Slice())
+			targets.AddAll(right.Slice())
+		case query.OpIntersect:
+			for _, t := range left.Slice() {
+				if right.Find(t.FQN) != nil {
+					targets.Add(t)
+				}
+			}
+		case query.OpExcept:
+			for _, t := range left.Slice() {
+				if right.Find(t.FQN) == nil {
+					targets.Add(t)
+				}
+			}
+		}
+
+		return targets, nil
+	case query.Call:
+		fn, ok := queryFuncs[expr.Func]
+		if !ok {
+			names := make([]string, 0, len(queryFuncs))
+			for name := range queryFuncs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			return nil, fmt.Errorf("unknown function %v, expected one of: %v", expr.Func, strings.Join(names, ", "))
+		}
+
+		if len(expr.Args) < fn.minArgs || len(expr.Args) > fn.maxArgs {
+			if fn.minArgs == fn.maxArgs {
+				return nil, fmt.Errorf("%v: expected %v args, got %v", expr.Func, fn.minArgs, len(expr.Args))
+			}
+
+			return nil, fmt.Errorf("%v: expected %v to %v args, got %v", expr.Func, fn.minArgs, fn.maxArgs, len(expr.Args))
+		}
+
+		targets, err := fn.f(qe, expr.Args)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", expr.Func, err)
+		}
+
+		return targets, nil
+	}
+
+	return nil, fmt.Errorf("unhandled expression %v", expr)
+}
+
+// evalWord matches a target, a pattern such as //some/pkg/... or a label, like ParseTargetSelector
+func (qe *queryEngine) evalWord(s string) (*Targets, error) {
+	isPattern := strings.HasSuffix(s, ".")
+
+	if !isPattern {
+		if tp, err := targetspec.TargetParse("", s); err == nil {
+			t := qe.Targets.Find(tp.Full())
+			if t == nil {
+				return nil, NewTargetNotFoundError(tp.Full())
+			}
+
+			targets := NewTargets(1)
+			targets.Add(t)
+
+			return targets, nil
+		}
+	}
+
+	m := ParseTargetSelector("", s)
+
+	targets := NewTargets(0)
+	for _, t := range qe.Targets.Slice() {
+		if m(t) {
+			targets.Add(t)
+		}
+	}
+
+	return targets, nil
+}
+
+func wordArg(expr query.Expr) (string, error) {
+	w, ok := expr.(query.Word)
+	if !ok {
+		return "", fmt.Errorf("expected a word, got %v", expr)
+	}
+
+	return w.Value, nil
+}
+
+func depthArg(args []query.Expr, i int) (int, error) {
+	if len(args) <= i {
+		return -1, nil
+	}
+
+	s, err := wordArg(args[i])
+	if err != nil {
+		return 0, err
+	}
+
+	depth, err := strconv.Atoi(s)
+	if err != nil || depth < 0 {
+		return 0, fmt.Errorf("depth must be a non-negative integer, got %v", s)
+	}
+
+	return depth, nil
+}
+
+// walk returns the targets reachable from roots through rel, roots included, up to depth if not negative
+func walk(roots []*Target, depth int, rel func(*Target) ([]*Target, error), keep func(*Target) bool) (*Targets, error) {
+	targets := NewTargets(len(roots))
+	targets.AddAll(roots)
+
+	current := roots
+	for d := 0; depth < 0 || d < depth; d++ {
+		next := make([]*Target, 0)
+		for _, t := range current {
+			rs, err := rel(t)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, r := range rs {
+				if keep(r) && targets.Add(r) {
+					next = append(next, r)
+				}
+			}
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		current = next
+	}
+
+	return targets, nil
+}
+
+func queryDeps(qe *queryEngine, args []query.Expr) (*Targets, error) {
+	roots, err := qe.eval(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	depth, err := depthArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return walk(roots.Slice(), depth, qe.DAG().GetParents, func(*Target) bool { return true })
+}
+
+func queryRdeps(qe *queryEngine, args []query.Expr) (*Targets, error) {
+	universe, err := qe.eval(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	roots, err := qe.eval(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	depth, err := depthArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	inUniverse := func(t *Target) bool {
+		return universe.Find(t.FQN) != nil
+	}
+
+	start := make([]*Target, 0)
+	for _, t := range roots.Slice() {
+		if inUniverse(t) {
+			start = append(start, t)
+		}
+	}
+
+	return walk(start, depth, qe.DAG().GetChildren, inUniverse)
+}
+
+func querySomepath(qe *queryEngine, args []query.Expr) (*Targets, error) {
+	from, err := qe.eval(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := qe.eval(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	from.Sort()
+
+	// Breadth first, to return one of the shortest paths
+	prev := map[string]*Target{}
+	queue := make([]*Target, 0)
+	for _, t := range from.Slice() {
+		prev[t.FQN] = nil
+		queue = append(queue, t)
+	}
+
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+
+		if to.Find(t.FQN) != nil {
+			path := NewTargets(0)
+			for c := t; c != nil; c = prev[c.FQN] {
+				path.Add(c)
+			}
+
+			return path, nil
+		}
+
+		parents, err := qe.DAG().GetParents(t)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range parents {
+			if _, ok := prev[p.FQN]; ok {
+				continue
+			}
+
+			prev[p.FQN] = t
+			queue = append(queue, p)
+		}
+	}
+
+	return NewTargets(0), nil
+}
+
+func queryAllpaths(qe *queryEngine, args []query.Expr) (*Targets, error) {
+	from, err := qe.eval(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := qe.eval(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	all := func(*Target) bool { return true }
+
+	deps, err := walk(from.Slice(), -1, qe.DAG().GetParents, all)
+	if err != nil {
+		return nil, err
+	}
+
+	rdeps, err := walk(to.Slice(), -1, qe.DAG().GetChildren, all)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := NewTargets(0)
+	for _, t := range deps.Slice() {
+		if rdeps.Find(t.FQN) != nil {
+			targets.Add(t)
+		}
+	}
+
+	return targets, nil
+}
+
+func (qe *queryEngine) evalOrAll(args []query.Expr, i int) (*Targets, error) {
+	if len(args) <= i {
+		return qe.Targets, nil
+	}
+
+	return qe.eval(args[i])
+}
+
+func queryLabel(qe *queryEngine, args []query.Expr) (*Targets, error) {
+	label, err := wordArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := qe.evalOrAll(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := NewTargets(0)
+	for _, t := range input.Slice() {
+		if t.HasAnyLabel([]string{label}) {
+			targets.Add(t)
+		}
+	}
+
+	return targets, nil
+}
+
+func queryFilter(qe *queryEngine, args []query.Expr) (*Targets, error) {
+	pattern, err := wordArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := qe.eval(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	targets := NewTargets(0)
+	for _, t := range input.Slice() {
+		if r.MatchString(t.FQN) {
+			targets.Add(t)
+		}
+	}
+
+	return targets, nil
+}
+
+// specAttr returns the value of the spec field matching name, `run_in_cwd` matching RunInCwd
+func (qe *queryEngine) specAttr(t *Target, name string) (interface{}, bool) {
+	spec, ok := qe.specs[t.FQN]
+	if !ok {
+		err := json.Unmarshal(t.TargetSpec.Json(), &spec)
+		if err != nil {
+			panic(err)
+		}
+		qe.specs[t.FQN] = spec
+	}
+
+	name = strings.ReplaceAll(name, "_", "")
+	for k, v := range spec {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+func attrMatches(r *regexp.Regexp, v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return r.MatchString("")
+	case string:
+		return r.MatchString(v)
+	case bool, float64:
+		return r.MatchString(fmt.Sprint(v))
+	case []interface{}:
+		for _, v := range v {
+			if attrMatches(r, v) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, v := range v {
+			if attrMatches(r, v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func queryAttr(qe *queryEngine, args []query.Expr) (*Targets, error) {
+	name, err := wordArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	pattern, err := wordArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	// The whole value has to match
+	r, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := qe.eval(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	targets := NewTargets(0)
+	for _, t := range input.Slice() {
+		v, ok := qe.specAttr(t, name)
+		if !ok {
+			return nil, fmt.Errorf("unknown attribute %v", name)
+		}
+
+		if attrMatches(r, v) {
+			targets.Add(t)
+		}
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"context"
+	"heph/query"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+target(name="a", out="a", labels=["lib"])
+target(name="b", deps=["//:a"], out="b")
+target(name="c", deps=["//:b"], out="c", entrypoint="sh")
+target(name="d", deps=["//:a"], out="d")
+target(name="e", out="e", labels=["lib"])
+target(name="f", deps=["//:c", "//:d"], out="f")
+`), os.ModePerm)
+	require.NoError(t, err)
+
+	e := New(dir)
+	err = e.runBuildFiles(dir, e.createPkg)
+	require.NoError(t, err)
+
+	for _, target := range e.Targets.Slice() {
+		err := e.processTarget(target)
+		require.NoError(t, err)
+	}
+
+	err = e.LinkTargets(context.Background(), false, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		q   string
+		res []string
+		err string
+	}{
+		{q: `deps(//:c)`, res: []string{"//:a", "//:b", "//:c"}},
+		{q: `deps(//:f, 1)`, res: []string{"//:c", "//:d", "//:f"}},
+		{q: `deps(//:f, 0)`, res: []string{"//:f"}},
+		{q: `rdeps(//..., //:a)`, res: []string{"//:a", "//:b", "//:c", "//:d", "//:f"}},
+		{q: `rdeps(//..., //:a, 1)`, res: []string{"//:a", "//:b", "//:d"}},
+		{q: `rdeps(//:a + //:b + //:f, //:a)`, res: []string{"//:a", "//:b"}},
+		{q: `somepath(//:f, //:a)`, res: []string{"//:a", "//:d", "//:f"}},
+		{q: `somepath(//:e, //:a)`, res: []string{}},
+		{q: `allpaths(//:f, //:b)`, res: []string{"//:b", "//:c", "//:f"}},
+		{q: `allpaths(//:f, //:e)`, res: []string{}},
+		{q: `label(lib)`, res: []string{"//:a", "//:e"}},
+		{q: `label(lib, deps(//:f))`, res: []string{"//:a"}},
+		{q: `attr(entrypoint, sh, //...)`, res: []string{"//:c"}},
+		{q: `attr(labels, "l.b", //...)`, res: []string{"//:a", "//:e"}},
+		{q: `filter(":[ab]$", //...)`, res: []string{"//:a", "//:b"}},
+		{q: `deps(//:c) ^ deps(//:d)`, res: []string{"//:a"}},
+		{q: `deps(//:c) - //:b`, res: []string{"//:a", "//:c"}},
+		{q: `//:a + //:e`, res: []string{"//:a", "//:e"}},
+		{q: `deps(//:f) except (deps(//:c) union //:d)`, res: []string{"//:f"}},
+
+		{q: `deps(//:f, -1)`, err: "deps: depth must be a non-negative integer, got -1"},
+		{q: `deps(//:f, x)`, err: "deps: depth must be a non-negative integer, got x"},
+		{q: `deps(//:f, 1, 2)`, err: "deps: expected 1 to 2 args, got 3"},
+		{q: `attr(nope, x, //:a)`, err: "attr: unknown attribute nope"},
+		{q: `nope(//:a)`, err: "unknown function nope, expected one of: allpaths, attr, deps, filter, label, rdeps, somepath"},
+		{q: `//:nope`, err: "target //:nope not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.q, func(t *testing.T) {
+			expr, err := query.Parse(test.q)
+			require.NoError(t, err)
+
+			targets, err := e.Query(expr)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+
+			fqns := make([]string, 0, len(targets))
+			for _, t := range targets {
+				fqns = append(fqns, t.FQN)
+			}
+
+			assert.Equal(t, test.res, fqns)
+		})
+	}
+}
@@ -48,7 +48,7 @@ func TestTargetSpec(t *testing.T) {
 	require.NoError(t, err)
 
 	// Just sanity check
-	assert.Equal(t, 7, len(files))
+	assert.Equal(t, 12, len(files))
 
 	for _, file := range files {
 		t.Log(file)
@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// OutputArchiveDigest returns the sha256 of the output tarball in the local cache,
+// two builds producing the same archive bytes are reproducible
+func (e *Engine) OutputArchiveDigest(target *Target, output string) (string, error) {
+	return fileChecksum(e.localOutTarPath(target, output))
+}
+
+// ResetOutputArchives prepares the target for a rebuild whose archives can be compared with the current ones:
+// the memoized output hashes are dropped, and so are the local cas blobs, which would otherwise replace the new archives
+func (e *Engine) ResetOutputArchives(target *Target) error {
+	for _, output := range target.OutWithSupport.Names() {
+		outputHash, err := e.localOutputHash(target, output)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("%v: %w", output, err)
+		}
+
+		for _, ext := range e.outTarExts(target) {
+			err = os.Remove(e.localCasDir().Join(casBlobName(outputHash, ext)).Abs())
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	e.ResetCacheHashOutput(target)
+
+	return nil
+}
@@ -1,8 +1,9 @@
 package engine
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,21 +12,17 @@ import (
 	"heph/packages"
 	"heph/utils/flock"
 	fs2 "heph/utils/fs"
+	"heph/utils/tar"
+	"io"
 	"io/fs"
+	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
-	"text/template"
 )
 
-var cloneScript = `
-git clone {{.URL}} {{.Tmp}}
-cd {{.Tmp}} && git reset --hard {{.Ref}}
-cp -r {{.Tmp}}/{{.Dir}}/. {{.Into}}
-`
-
 type RootConfig struct {
 	*url.URL
 }
@@ -34,66 +31,66 @@ func (e *Engine) rootRoot(name string) fs2.Path {
 	return e.HomeDir.Join("root", name)
 }
 
-type GitURI struct {
-	Repo string
-	Ref  string
-	Path string
-}
+// fetchTarballRoot downloads the archive and extracts the directory in the URI fragment into srcRoot,
+// the archive sha256 is returned, it is verified against expected before extracting if set
+func (e *Engine) fetchTarballRoot(ctx context.Context, uri *url.URL, expected string, root, srcRoot fs2.Path) (string, error) {
+	dl := *uri
+	dl.Fragment = ""
 
-func parseGitURI(uri *url.URL) (GitURI, error) {
-	parts1 := strings.SplitN(uri.Path, "@", 2)
-	parts2 := strings.SplitN(parts1[1], ":", 2)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dl.String(), nil)
+	if err != nil {
+		return "", err
+	}
 
-	var path string
-	if len(parts2) > 1 {
-		path = parts2[1]
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
 	}
+	defer res.Body.Close()
 
-	return GitURI{
-		Repo: fmt.Sprintf("https://%v%v", uri.Host, parts1[0]),
-		Ref:  parts2[0],
-		Path: path,
-	}, nil
-}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%v: status %v", dl.String(), res.Status)
+	}
 
-func (e *Engine) fetchGitRoot(ctx context.Context, uri *url.URL, srcRoot fs2.Path) (fs2.Path, error) {
-	err := os.MkdirAll(srcRoot.Abs(), os.ModePerm)
+	archivePath := root.Join("archive").Abs()
+	f, err := os.Create(archivePath)
 	if err != nil {
-		return fs2.Path{}, err
+		return "", err
 	}
+	defer os.Remove(archivePath)
 
-	guri, err := parseGitURI(uri)
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), res.Body)
+	_ = f.Close()
 	if err != nil {
-		return fs2.Path{}, err
+		return "", err
 	}
 
-	tpl, err := template.New("clone").Parse(cloneScript)
-	if err != nil {
-		return fs2.Path{}, err
+	sum := hex.EncodeToString(h.Sum(nil))
+	if expected != "" && sum != expected {
+		return "", fmt.Errorf("sha256 mismatch: expected %v, got %v", expected, sum)
 	}
 
-	tmp := fs2.RandPath(os.TempDir(), "heph_root", "")
-	defer os.RemoveAll(tmp)
+	extractPath := root.Join("extract").Abs()
+	defer os.RemoveAll(extractPath)
 
-	var b bytes.Buffer
-	err = tpl.Execute(&b, map[string]interface{}{
-		"Tmp":  tmp,
-		"URL":  guri.Repo,
-		"Into": srcRoot.Abs(),
-		"Dir":  guri.Path,
-		"Ref":  guri.Ref,
-	})
+	err = tar.Untar(ctx, archivePath, extractPath, false)
 	if err != nil {
-		return fs2.Path{}, err
+		return "", err
+	}
+
+	// Entries escaping the extract dir are rejected by Untar, so must be the directory picked from it
+	dir := filepath.Join(extractPath, strings.TrimPrefix(uri.Fragment, "/"))
+	if rel, err := filepath.Rel(extractPath, dir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%v: outside of the archive", uri.Fragment)
 	}
 
-	cmd := exec.CommandContext(ctx, "bash", "-eux", "-c", b.String())
-	ob, err := cmd.CombinedOutput()
+	err = os.Rename(dir, srcRoot.Abs())
 	if err != nil {
-		return fs2.Path{}, fmt.Errorf("%v: %s", err, ob)
+		return "", err
 	}
 
-	return srcRoot, nil
+	return sum, nil
 }
 
 func (e *Engine) fetchFsRoot(uri *url.URL) fs2.Path {
@@ -148,21 +145,55 @@ func (e *Engine) loadFromRoot(pkgName, rootName string, cfg config.Root) (*packa
 	return pkg, nil
 }
 
+func (e *Engine) lockRoot(ctx context.Context, name string) (flock.Locker, error) {
+	lock := flock.NewFlock("root "+name, filepath.Join(e.HomeDir.Abs(), "root_"+name+".lock"))
+	err := lock.Lock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to lock %v", err)
+	}
+
+	return lock, nil
+}
+
+func rootURI(cfg config.Root) string {
+	return strings.ReplaceAll(cfg.URI, "{version}", cfg.Version)
+}
+
+func (e *Engine) readRootMeta(name string) RootLock {
+	var meta RootLock
+
+	b, err := os.ReadFile(e.rootRoot(name).Join("meta").Abs())
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Errorf("reading %v root meta: %v", name, err)
+		}
+		return meta
+	}
+
+	err = json.Unmarshal(b, &meta)
+	if err != nil {
+		log.Errorf("reading %v root meta: %v", name, err)
+	}
+
+	return meta
+}
+
 func (e *Engine) fetchRoot(ctx context.Context, name string, cfg config.Root) (fs2.Path, error) {
 	if p, ok := e.fetchRootCache[name]; ok {
 		return p, nil
 	}
 
-	lock := flock.NewFlock("root "+name, filepath.Join(e.HomeDir.Abs(), "root_"+name+".lock"))
-	err := lock.Lock(ctx)
+	lock, err := e.lockRoot(ctx, name)
 	if err != nil {
-		return fs2.Path{}, fmt.Errorf("Failed to lock %v", err)
+		return fs2.Path{}, err
 	}
 	defer lock.Unlock()
 
 	log.Tracef("fetchRoot %v", name)
 
-	u, err := url.Parse(cfg.URI)
+	uri := rootURI(cfg)
+
+	u, err := url.Parse(uri)
 	if err != nil {
 		return fs2.Path{}, err
 	}
@@ -174,70 +205,184 @@ func (e *Engine) fetchRoot(ctx context.Context, name string, cfg config.Root) (f
 		return root, nil
 	}
 
-	root := e.rootRoot(name)
-	srcRoot := root.Join("src")
-	metaPath := root.Join("meta").Abs()
-
-	b, err := os.ReadFile(metaPath)
-	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+	rootsLock, err := ReadRootsLock(e.rootsLockPath())
+	if err != nil {
 		return fs2.Path{}, err
 	}
 
-	var fileCfg config.Root
-	if len(b) > 0 {
-		err = json.Unmarshal(b, &fileCfg)
-		if err != nil {
-			log.Errorf("reading %v root meta: %v", name, err)
-		}
+	// Only `heph roots update` fetches what it has not verified yet
+	pin, locked := rootsLock.Roots[name]
+	if !locked {
+		return fs2.Path{}, fmt.Errorf("root %v is not pinned in %v, run `heph roots update`", name, RootsLockFile)
+	}
+	if pin.URI != uri || pin.Version != cfg.Version {
+		return fs2.Path{}, fmt.Errorf("root %v does not match %v, run `heph roots update`", name, RootsLockFile)
 	}
 
-	if fileCfg.URI == cfg.URI {
+	srcRoot := e.rootRoot(name).Join("src")
+
+	meta := e.readRootMeta(name)
+	if meta.URI == uri && meta.Version == cfg.Version && meta.Sha256 == pin.Sha256 {
 		e.fetchRootCache[name] = srcRoot
 		return srcRoot, nil
 	}
 
-	log.Infof("Fetch root %v from %v", name, cfg.URI)
+	if e.Offline {
+		return fs2.Path{}, fmt.Errorf("root %v: not available offline", name)
+	}
 
-	err = os.RemoveAll(root.Abs())
+	_, err = e.downloadRoot(ctx, name, cfg, &pin)
 	if err != nil {
 		return fs2.Path{}, err
 	}
 
+	e.fetchRootCache[name] = srcRoot
+
+	return srcRoot, nil
+}
+
+// downloadRoot fetches the root into HomeDir/root, and verifies its content against pin if set
+func (e *Engine) downloadRoot(ctx context.Context, name string, cfg config.Root, pin *RootLock) (RootLock, error) {
+	uri := rootURI(cfg)
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return RootLock{}, err
+	}
+
+	log.Infof("Fetch root %v from %v", name, uri)
+
+	root := e.rootRoot(name)
+	srcRoot := root.Join("src")
+
+	err = os.RemoveAll(root.Abs())
+	if err != nil {
+		return RootLock{}, err
+	}
+
 	err = os.MkdirAll(root.Abs(), os.ModePerm)
 	if err != nil {
-		return fs2.Path{}, err
+		return RootLock{}, err
+	}
+
+	res := RootLock{
+		URI:     uri,
+		Version: cfg.Version,
 	}
 
-	var backendRoot fs2.Path
-	switch u.Scheme {
-	case "git":
-		backendRoot, err = e.fetchGitRoot(ctx, u, srcRoot)
+	var expected string
+	if pin != nil {
+		expected = pin.Sha256
+	}
+
+	switch {
+	case isGitScheme(u.Scheme):
+		var ref string
+		if pin != nil {
+			ref = pin.Ref
+		}
+
+		res.Ref, err = e.fetchGitRoot(ctx, u, ref, srcRoot)
+		if err != nil {
+			return RootLock{}, fmt.Errorf("root %v: %w", name, err)
+		}
+
+		res.Sha256, err = hashRootDir(srcRoot.Abs())
+		if err != nil {
+			return RootLock{}, fmt.Errorf("root %v: %w", name, err)
+		}
+
+		if expected != "" && res.Sha256 != expected {
+			_ = os.RemoveAll(root.Abs())
+			return RootLock{}, fmt.Errorf("root %v: sha256 mismatch: expected %v, got %v", name, expected, res.Sha256)
+		}
+	case u.Scheme == "http" || u.Scheme == "https":
+		res.Sha256, err = e.fetchTarballRoot(ctx, u, expected, root, srcRoot)
 		if err != nil {
-			return fs2.Path{}, err
+			_ = os.RemoveAll(root.Abs())
+			return RootLock{}, fmt.Errorf("root %v: %w", name, err)
 		}
-	case "file":
-		backendRoot = e.fetchFsRoot(u)
 	default:
-		return fs2.Path{}, fmt.Errorf("unsupported scheme %v", u.Scheme)
+		return RootLock{}, fmt.Errorf("unsupported scheme %v", u.Scheme)
 	}
 
-	metaFile, err := os.Create(metaPath)
+	metaFile, err := os.Create(root.Join("meta").Abs())
 	if err != nil {
-		return fs2.Path{}, err
+		return RootLock{}, err
 	}
 	defer metaFile.Close()
 
 	enc := json.NewEncoder(metaFile)
 	enc.SetEscapeHTML(false)
 
-	err = enc.Encode(cfg)
+	err = enc.Encode(res)
 	if err != nil {
-		return fs2.Path{}, err
+		return RootLock{}, err
+	}
+
+	return res, nil
+}
+
+// UpdateRootsLock fetches the roots, all of them if names is empty, and pins them in heph.lock
+func (e *Engine) UpdateRootsLock(ctx context.Context, names []string) error {
+	if e.Offline {
+		return fmt.Errorf("cannot update roots offline")
 	}
 
-	e.fetchRootCache[name] = backendRoot
+	rootsLock, err := ReadRootsLock(e.rootsLockPath())
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		for name := range rootsLock.Roots {
+			if _, ok := e.Config.BuildFiles.Roots[name]; !ok {
+				delete(rootsLock.Roots, name)
+			}
+		}
+
+		for name := range e.Config.BuildFiles.Roots {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		cfg, ok := e.Config.BuildFiles.Roots[name]
+		if !ok {
+			return fmt.Errorf("unknown root %v", name)
+		}
+
+		u, err := url.Parse(rootURI(cfg))
+		if err != nil {
+			return err
+		}
+
+		if u.Scheme == "file" {
+			continue
+		}
+
+		pin, err := e.updateRoot(ctx, name, cfg)
+		if err != nil {
+			return err
+		}
+
+		rootsLock.Roots[name] = pin
+	}
+
+	return rootsLock.Write(e.rootsLockPath())
+}
+
+func (e *Engine) updateRoot(ctx context.Context, name string, cfg config.Root) (RootLock, error) {
+	lock, err := e.lockRoot(ctx, name)
+	if err != nil {
+		return RootLock{}, err
+	}
+	defer lock.Unlock()
+
+	delete(e.fetchRootCache, name)
 
-	return backendRoot, nil
+	return e.downloadRoot(ctx, name, cfg, nil)
 }
 
 func (e *Engine) splitRootNameFromPkgName(pkgName string) (string, string) {
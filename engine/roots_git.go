@@ -0,0 +1,299 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	log "heph/hlog"
+	"heph/utils/flock"
+	fs2 "heph/utils/fs"
+	"heph/utils/hash"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type GitURI struct {
+	Repo string
+	Ref  string
+	Path string
+}
+
+func isGitScheme(scheme string) bool {
+	return scheme == "git" || strings.HasPrefix(scheme, "git+")
+}
+
+// parseGitURI parses `git://host/repo@ref:/path`, which is fetched over https,
+// or `git+<scheme>://...` to use another transport, such as `git+ssh` or `git+file`
+func parseGitURI(uri *url.URL) (GitURI, error) {
+	var scheme string
+	switch {
+	case uri.Scheme == "git":
+		scheme = "https"
+	case strings.HasPrefix(uri.Scheme, "git+"):
+		scheme = strings.TrimPrefix(uri.Scheme, "git+")
+	default:
+		return GitURI{}, fmt.Errorf("%v: not a git uri", uri)
+	}
+
+	repoPath, rest, ok := strings.Cut(uri.Path, "@")
+	if !ok {
+		return GitURI{}, fmt.Errorf("%v: missing @ref", uri)
+	}
+
+	ref, path, _ := strings.Cut(rest, ":")
+	if ref == "" {
+		return GitURI{}, fmt.Errorf("%v: missing @ref", uri)
+	}
+
+	repo := url.URL{
+		Scheme: scheme,
+		User:   uri.User,
+		Host:   uri.Host,
+		Path:   repoPath,
+	}
+
+	return GitURI{
+		Repo: repo.String(),
+		Ref:  ref,
+		Path: strings.Trim(path, "/"),
+	}, nil
+}
+
+// gitRepo opens the bare repository caching the objects fetched from repoURL, shared by all the roots
+func (e *Engine) gitRepo(repoURL string) (*git.Repository, error) {
+	dir := e.HomeDir.Join("git", hash.HashString(repoURL)).Abs()
+
+	repo, err := git.PlainOpen(dir)
+	if err == nil {
+		return repo, nil
+	}
+	if !errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, err
+	}
+
+	repo, err = git.PlainInit(dir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{repoURL},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+func gitFetch(ctx context.Context, repo *git.Repository, depth int, refspecs ...gitconfig.RefSpec) error {
+	err := repo.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: refspecs,
+		Depth:    depth,
+		Tags:     git.NoTags,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return err
+	}
+
+	return nil
+}
+
+func hasCommit(repo *git.Repository, h plumbing.Hash) bool {
+	_, err := repo.CommitObject(h)
+	return err == nil
+}
+
+// resolveGitRef fetches ref, a branch, a tag or a commit, and returns its commit
+func resolveGitRef(ctx context.Context, repo *git.Repository, ref string) (plumbing.Hash, error) {
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("list refs: %w", err)
+	}
+
+	candidates := []string{ref, "refs/heads/" + ref, "refs/tags/" + ref}
+
+	var found *plumbing.Reference
+	for _, name := range candidates {
+		for _, r := range refs {
+			if r.Name().String() == name && r.Type() == plumbing.HashReference {
+				found = r
+				break
+			}
+		}
+		if found != nil {
+			break
+		}
+	}
+
+	if found == nil {
+		if plumbing.IsHash(ref) {
+			return fetchGitCommit(ctx, repo, plumbing.NewHash(ref))
+		}
+
+		return plumbing.ZeroHash, fmt.Errorf("ref %v not found", ref)
+	}
+
+	h := found.Hash()
+	if !hasCommit(repo, h) {
+		if _, err := repo.TagObject(h); err != nil {
+			spec := gitconfig.RefSpec(fmt.Sprintf("+%v:%v", found.Name(), found.Name()))
+
+			err := gitFetch(ctx, repo, 1, spec)
+			if err != nil {
+				return plumbing.ZeroHash, fmt.Errorf("fetch %v: %w", found.Name(), err)
+			}
+		}
+	}
+
+	// Annotated tags point to a tag object
+	if tag, err := repo.TagObject(h); err == nil {
+		commit, err := tag.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("tag %v: %w", ref, err)
+		}
+
+		return commit.Hash, nil
+	}
+
+	return h, nil
+}
+
+// fetchGitCommit fetches a single commit, servers not allowing to fetch commits by hash get all their branches fetched
+func fetchGitCommit(ctx context.Context, repo *git.Repository, h plumbing.Hash) (plumbing.Hash, error) {
+	if hasCommit(repo, h) {
+		return h, nil
+	}
+
+	spec := gitconfig.RefSpec(fmt.Sprintf("%v:refs/heph/%v", h, h))
+
+	err := gitFetch(ctx, repo, 1, spec)
+	if err != nil {
+		log.Debugf("fetch %v: %v, fetching all branches", h, err)
+
+		err = gitFetch(ctx, repo, 0, "+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*")
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("fetch %v: %w", h, err)
+		}
+	}
+
+	if !hasCommit(repo, h) {
+		return plumbing.ZeroHash, fmt.Errorf("commit %v not found", h)
+	}
+
+	return h, nil
+}
+
+func writeGitTree(tree *object.Tree, into string) error {
+	err := os.MkdirAll(into, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return tree.Files().ForEach(func(f *object.File) error {
+		dest := filepath.Join(into, f.Name)
+
+		err := fs2.CreateParentDir(dest)
+		if err != nil {
+			return err
+		}
+
+		if f.Mode == filemode.Symlink {
+			target, err := f.Contents()
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(target, dest)
+		}
+
+		perm := os.FileMode(0644)
+		if f.Mode == filemode.Executable {
+			perm = 0755
+		}
+
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		_, err = io.Copy(out, r)
+
+		return err
+	})
+}
+
+// fetchGitRoot shallow fetches the ref of the URI, or commit if set, and writes its tree into srcRoot, the commit is returned
+func (e *Engine) fetchGitRoot(ctx context.Context, uri *url.URL, commit string, srcRoot fs2.Path) (string, error) {
+	guri, err := parseGitURI(uri)
+	if err != nil {
+		return "", err
+	}
+
+	lock := flock.NewFlock("git "+guri.Repo, e.HomeDir.Join("git", hash.HashString(guri.Repo)+".lock").Abs())
+	err = lock.Lock(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Failed to lock %v", err)
+	}
+	defer lock.Unlock()
+
+	repo, err := e.gitRepo(guri.Repo)
+	if err != nil {
+		return "", fmt.Errorf("%v: %w", guri.Repo, err)
+	}
+
+	var h plumbing.Hash
+	if commit != "" {
+		h, err = fetchGitCommit(ctx, repo, plumbing.NewHash(commit))
+	} else {
+		h, err = resolveGitRef(ctx, repo, guri.Ref)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%v: %w", guri.Repo, err)
+	}
+
+	c, err := repo.CommitObject(h)
+	if err != nil {
+		return "", fmt.Errorf("%v: %w", guri.Repo, err)
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return "", fmt.Errorf("%v: %w", guri.Repo, err)
+	}
+
+	if guri.Path != "" {
+		tree, err = tree.Tree(guri.Path)
+		if err != nil {
+			return "", fmt.Errorf("%v: %v at %v: %w", guri.Repo, guri.Path, h, err)
+		}
+	}
+
+	err = writeGitTree(tree, srcRoot.Abs())
+	if err != nil {
+		return "", err
+	}
+
+	return h.String(), nil
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	fs2 "heph/utils/fs"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const RootsLockFile = "heph.lock"
+
+// RootLock pins the content of a root
+type RootLock struct {
+	URI     string `yaml:"uri"`
+	Version string `yaml:"version,omitempty"`
+	// Ref is the commit of git roots
+	Ref    string `yaml:"ref,omitempty"`
+	Sha256 string `yaml:"sha256"`
+}
+
+type RootsLock struct {
+	Roots map[string]RootLock `yaml:"roots"`
+}
+
+// ReadRootsLock returns an empty lock if the file does not exist
+func ReadRootsLock(path string) (RootsLock, error) {
+	lock := RootsLock{Roots: map[string]RootLock{}}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return lock, nil
+		}
+		return lock, err
+	}
+
+	err = yaml.Unmarshal(b, &lock)
+	if err != nil {
+		return lock, fmt.Errorf("%v: %w", path, err)
+	}
+
+	if lock.Roots == nil {
+		lock.Roots = map[string]RootLock{}
+	}
+
+	return lock, nil
+}
+
+func (l RootsLock) Write(path string) error {
+	b, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	f, err := fs2.AtomicCreate(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (e *Engine) rootsLockPath() string {
+	return e.Root.Join(RootsLockFile).Abs()
+}
+
+// hashRootDir hashes the paths, executable bits and content of the files of a root, ignoring .git
+func hashRootDir(dir string) (string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		paths = append(paths, path)
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return "", err
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(h, "%v\x00", filepath.ToSlash(rel))
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return "", err
+			}
+
+			fmt.Fprintf(h, "l%v\x00", link)
+		default:
+			fmt.Fprintf(h, "f%v\x00", info.Mode().Perm()&0111 != 0)
+
+			f, err := os.Open(path)
+			if err != nil {
+				return "", err
+			}
+
+			_, err = io.Copy(h, f)
+			_ = f.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,220 @@
+package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"heph/config"
+	fs2 "heph/utils/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarballRoot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	var archive bytes.Buffer
+	gw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gw)
+	content := []byte(`target(name="a", run="echo")`)
+	err := tw.WriteHeader(&tar.Header{Name: "pkg-1/BUILD", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	require.NoError(t, err)
+	_, err = tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	sum := sha256.Sum256(archive.Bytes())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1.tar.gz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(archive.Bytes())
+	}))
+	defer srv.Close()
+
+	cfg := config.Root{URI: srv.URL + "/{version}.tar.gz#pkg-1", Version: "1"}
+
+	newEngine := func(offline bool) *Engine {
+		e := New(dir)
+		e.Offline = offline
+		e.Config.BuildFiles.Roots = map[string]config.Root{"ext": cfg}
+		return e
+	}
+
+	// Only `heph roots update` fetches a root not pinned yet
+	_, err = newEngine(false).fetchRoot(ctx, "ext", cfg)
+	assert.ErrorContains(t, err, "is not pinned")
+
+	err = newEngine(false).UpdateRootsLock(ctx, nil)
+	require.NoError(t, err)
+
+	lock, err := ReadRootsLock(newEngine(false).rootsLockPath())
+	require.NoError(t, err)
+	assert.Equal(t, RootLock{URI: srv.URL + "/1.tar.gz#pkg-1", Version: "1", Sha256: hex.EncodeToString(sum[:])}, lock.Roots["ext"])
+
+	// The local copy is used offline
+	p, err := newEngine(true).fetchRoot(ctx, "ext", cfg)
+	require.NoError(t, err)
+	assert.FileExists(t, p.Join("BUILD").Abs())
+
+	e := newEngine(false)
+	require.NoError(t, os.RemoveAll(e.rootRoot("ext").Abs()))
+
+	_, err = newEngine(true).fetchRoot(ctx, "ext", cfg)
+	assert.ErrorContains(t, err, "not available offline")
+
+	pin := lock.Roots["ext"]
+	pin.Sha256 = "abc"
+	lock.Roots["ext"] = pin
+	require.NoError(t, lock.Write(e.rootsLockPath()))
+
+	_, err = newEngine(false).fetchRoot(ctx, "ext", cfg)
+	assert.ErrorContains(t, err, "sha256 mismatch")
+
+	cfg.Version = "2"
+	_, err = newEngine(false).fetchRoot(ctx, "ext", cfg)
+	assert.ErrorContains(t, err, "does not match heph.lock")
+}
+
+func TestTarballRootEscape(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	archive := func(name string) []byte {
+		var archive bytes.Buffer
+		gw := gzip.NewWriter(&archive)
+		tw := tar.NewWriter(gw)
+		content := []byte("evil")
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		require.NoError(t, err)
+		_, err = tw.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		require.NoError(t, gw.Close())
+
+		return archive.Bytes()
+	}
+
+	archives := map[string][]byte{
+		"/escape.tar.gz": archive("pkg-1/../../../evil"),
+		"/ok.tar.gz":     archive("pkg-1/BUILD"),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archives[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	for _, uri := range []string{srv.URL + "/escape.tar.gz#pkg-1", srv.URL + "/ok.tar.gz#../.."} {
+		e := New(dir)
+		e.Config.BuildFiles.Roots = map[string]config.Root{"ext": {URI: uri}}
+
+		err := e.UpdateRootsLock(ctx, nil)
+		assert.ErrorContains(t, err, "outside of")
+	}
+
+	assert.NoFileExists(t, New(dir).HomeDir.Join("evil").Abs())
+	assert.NoFileExists(t, New(dir).HomeDir.Join("root", "evil").Abs())
+}
+
+func TestParseGitURI(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected GitURI
+	}{
+		{"git://github.com/hephbuild/heph.git@master:/backend/go", GitURI{Repo: "https://github.com/hephbuild/heph.git", Ref: "master", Path: "backend/go"}},
+		{"git+ssh://git@github.com/hephbuild/heph.git@v1", GitURI{Repo: "ssh://git@github.com/hephbuild/heph.git", Ref: "v1"}},
+		{"git+file:///some/repo@main:/a", GitURI{Repo: "file:///some/repo", Ref: "main", Path: "a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.uri, func(t *testing.T) {
+			u, err := url.Parse(test.uri)
+			require.NoError(t, err)
+
+			actual, err := parseGitURI(u)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+
+	u, _ := url.Parse("git://github.com/hephbuild/heph.git")
+	_, err := parseGitURI(u)
+	assert.ErrorContains(t, err, "missing @ref")
+}
+
+func TestGitRoot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repoDir := filepath.Join(dir, "repo")
+
+	repo, err := git.PlainInit(repoDir, false)
+	require.NoError(t, err)
+
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	commit := func(content string) plumbing.Hash {
+		err := os.MkdirAll(filepath.Join(repoDir, "sub"), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(repoDir, "sub", "BUILD"), []byte(content), 0644)
+		require.NoError(t, err)
+
+		_, err = wt.Add("sub/BUILD")
+		require.NoError(t, err)
+
+		h, err := wt.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{Name: "heph", Email: "heph@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+
+		return h
+	}
+
+	c1 := commit("v1")
+	_, err = repo.CreateTag("v1", c1, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "heph", Email: "heph@example.com", When: time.Now()},
+		Message: "v1",
+	})
+	require.NoError(t, err)
+	c2 := commit("v2")
+
+	e := New(filepath.Join(dir, "e"))
+
+	fetch := func(ref, commit string) string {
+		u, err := url.Parse("git+file://" + repoDir + "@" + ref + ":/sub")
+		require.NoError(t, err)
+
+		into := fs2.RandPath(t.TempDir(), "root", "")
+		h, err := e.fetchGitRoot(ctx, u, commit, fs2.NewPath(into, ""))
+		require.NoError(t, err)
+
+		b, err := os.ReadFile(filepath.Join(into, "BUILD"))
+		require.NoError(t, err)
+		assert.Equal(t, h, map[string]string{"v1": c1.String(), "v2": c2.String()}[string(b)])
+
+		return string(b)
+	}
+
+	assert.Equal(t, "v1", fetch("v1", ""))
+	assert.Equal(t, "v2", fetch("master", ""))
+	assert.Equal(t, "v1", fetch("master", c1.String()))
+	assert.Equal(t, "v1", fetch(c1.String(), ""))
+}
@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"fmt"
+	"heph/engine/buildevents"
 	"heph/utils/maps"
 	"heph/utils/sets"
 	"heph/worker"
@@ -90,6 +91,11 @@ func (s *schedulerv2) schedule() error {
 	for _, target := range s.toAssess {
 		target := target
 
+		s.BuildEvents.Emit(buildevents.Event{
+			Type:   buildevents.TypeTargetScheduled,
+			Target: target.FQN,
+		})
+
 		targetDeps := s.deps.Get(target.FQN)
 		targetDeps.AddSem()
 
@@ -261,6 +267,10 @@ func (s *schedulerv2) ScheduleTargetGetCacheOrRunOnce(ctx context.Context, targe
 				}
 
 				if cached {
+					if s.isTestPassCached(target) {
+						s.testCacheHits.Set(target.FQN, nil)
+					}
+
 					if pullIfCached {
 						j, err := s.ScheduleTargetCacheGetOnce(ctx, target, outputs)
 						if err != nil {
@@ -272,6 +282,18 @@ func (s *schedulerv2) ScheduleTargetGetCacheOrRunOnce(ctx context.Context, targe
 				}
 			}
 
+			if useCached && s.isTestPassCached(target) {
+				suite, err := s.loadTestPass(target)
+				if err != nil {
+					return err
+				}
+
+				if suite != nil {
+					s.testCacheHits.Set(target.FQN, suite)
+					return nil
+				}
+			}
+
 			j, err := s.ScheduleTargetRunOnce(ctx, target)
 			if err != nil {
 				return err
@@ -286,6 +308,27 @@ func (s *schedulerv2) ScheduleTargetGetCacheOrRunOnce(ctx context.Context, targe
 	return group, nil
 }
 
+// isTestPassCached returns true if the target is a requested test that no other scheduled target depends on,
+// it can then be skipped on a recorded pass since its outputs are not needed
+func (s *schedulerv2) isTestPassCached(target *Target) bool {
+	if !s.TestPassCache || !target.IsTest() || !s.targetsSet.Has(target) {
+		return false
+	}
+
+	children, err := s.DAG().GetChildren(target)
+	if err != nil {
+		return false
+	}
+
+	for _, child := range children {
+		if s.outputs.Has(child.FQN) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (s *schedulerv2) ScheduleTargetRunOnce(ctx context.Context, target *Target) (*worker.Job, error) {
 	lock := s.targetSchedLock.Get(target.FQN)
 	lock.Lock()
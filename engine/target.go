@@ -85,6 +85,13 @@ func (t *Target) resetLinking() {
 	}
 }
 
+// resetRun forgets the outputs collected by the last run, for the next one to collect them again
+func (t *Target) resetRun() {
+	t.actualOutFiles = nil
+	t.actualSupportFiles = nil
+	t.OutExpansionRoot = nil
+}
+
 func (t *Target) ID() string {
 	return t.FQN
 }
@@ -81,6 +81,9 @@ func (e *Engine) LinkTargets(ctx context.Context, ignoreNotFoundError bool, targ
 		targets = e.Targets.Slice()
 	}
 
+	// Host tools are looked up in it
+	e.lookupLinkEnv("PATH")
+
 	for _, target := range targets {
 		if err := ctx.Err(); err != nil {
 			return err
@@ -576,13 +579,19 @@ func (e *Engine) linkTargetTools(t *Target, toolsSpecs targetspec.TargetSpecTool
 	return tt, nil
 }
 
-var allEnv = map[string]string{}
+// linkEnvAll is recorded in linkEnv when a target passes the whole environment
+const linkEnvAll = "*"
 
-func init() {
-	for _, e := range os.Environ() {
-		parts := strings.SplitN(e, "=", 2)
-		allEnv[parts[0]] = parts[1]
-	}
+type linkEnvValue struct {
+	Value string
+	Set   bool
+}
+
+func (e *Engine) lookupLinkEnv(name string) (string, bool) {
+	value, ok := os.LookupEnv(name)
+	e.linkEnv.Set(name, linkEnvValue{Value: value, Set: ok})
+
+	return value, ok
 }
 
 func (e *Engine) applyEnv(t *Target, passEnv []string, env map[string]string) {
@@ -591,14 +600,18 @@ func (e *Engine) applyEnv(t *Target, passEnv []string, env map[string]string) {
 	}
 
 	for _, name := range passEnv {
-		if name == "*" {
-			for k, v := range allEnv {
-				t.Env[k] = v
+		if name == linkEnvAll {
+			e.linkEnv.Set(linkEnvAll, linkEnvValue{Set: true})
+			for _, kv := range os.Environ() {
+				k, _, _ := strings.Cut(kv, "=")
+				if v, ok := e.lookupLinkEnv(k); ok {
+					t.Env[k] = v
+				}
 			}
 			break
 		}
 
-		value, ok := os.LookupEnv(name)
+		value, ok := e.lookupLinkEnv(name)
 		if !ok {
 			continue
 		}
@@ -609,6 +622,32 @@ func (e *Engine) applyEnv(t *Target, passEnv []string, env map[string]string) {
 	}
 }
 
+// LinkEnvChanged tells if the environment variables the targets were linked with changed, returning the first one that did.
+// The graph has to be linked again for the targets to pick them up
+func (e *Engine) LinkEnvChanged() (string, bool) {
+	for _, name := range e.linkEnv.Keys() {
+		if name == linkEnvAll {
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if linked := e.linkEnv.Get(name); linked.Set != ok || linked.Value != value {
+			return name, true
+		}
+	}
+
+	if e.linkEnv.Has(linkEnvAll) {
+		for _, kv := range os.Environ() {
+			k, _, _ := strings.Cut(kv, "=")
+			if !e.linkEnv.Has(k) {
+				return k, true
+			}
+		}
+	}
+
+	return "", false
+}
+
 func (e *Engine) collectDeepTransitive(tr tgt.TargetTransitive, breadcrumb *sets.StringSet) (tgt.TargetTransitive, error) {
 	targets := sets.NewSet(func(t *Target) string {
 		return t.FQN
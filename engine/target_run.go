@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"heph/engine/artifacts"
+	"heph/engine/buildevents"
 	"heph/exprs"
 	"heph/hephprovider"
 	log "heph/hlog"
@@ -88,6 +90,7 @@ func (e *TargetRunEngine) createFile(target *Target, name, path string, rec *Src
 type runPrepare struct {
 	Env      map[string]string
 	BinDir   string
+	Bin      map[string]string
 	Executor platform.Executor
 }
 
@@ -166,7 +169,7 @@ func (e *TargetRunEngine) runPrepare(ctx context.Context, target *Target, mode s
 			done := utils.TraceTiming("Restoring cache")
 
 			for _, name := range target.OutWithSupport.Names() {
-				p := latestDir.Join(target.artifacts.OutTar(name).Name()).Abs()
+				p := latestDir.Join(e.artifactNameIn(target, latestDir, target.artifacts.OutTar(name))).Abs()
 				if !fs.PathExists(p) {
 					log.Errorf("restore cache: out %v|%v: tar does not exist", target.FQN, name)
 					continue
@@ -205,7 +208,7 @@ func (e *TargetRunEngine) runPrepare(ctx context.Context, target *Target, mode s
 					linkSrcRec.Add("", file.Abs(), file.RelRoot(), "")
 				}
 			} else {
-				tarFile := e.cacheDir(dept).Join(dept.artifacts.OutTar(dep.Output).Name()).Abs()
+				tarFile := e.localOutTarPath(dept, dep.Output)
 				srcRec.AddTar(tarFile)
 			}
 
@@ -445,6 +448,7 @@ func (e *TargetRunEngine) runPrepare(ctx context.Context, target *Target, mode s
 	return &runPrepare{
 		Env:      env,
 		BinDir:   binDir,
+		Bin:      bin,
 		Executor: executor,
 	}, nil
 }
@@ -462,6 +466,11 @@ func (e *TargetRunEngine) Run(ctx context.Context, rr TargetRunRequest, iocfg sa
 		return err
 	}
 
+	err = e.checkHostToolFingerprints(target)
+	if err != nil {
+		return err
+	}
+
 	log.Tracef("%v locking run", target.FQN)
 	err = target.runLock.Lock(ctx)
 	if err != nil {
@@ -610,6 +619,36 @@ func (e *TargetRunEngine) Run(ctx context.Context, rr TargetRunRequest, iocfg sa
 			defer cancel()
 		}
 
+		var isolation *sandbox.Isolation
+		if target.Isolation.Enabled {
+			isolation = &sandbox.Isolation{
+				Network:  target.Isolation.Network,
+				Fs:       target.Isolation.Fs,
+				Allow:    target.Isolation.Allow,
+				Writable: []string{e.sandboxRoot(target).Abs()},
+			}
+			if rr.Shell {
+				// The interactive entrypoint lives in the tmp dir
+				isolation.Writable = append(isolation.Writable, e.tmpTargetRoot(target).Abs())
+			}
+		}
+
+		var traceFile string
+		if e.AuditInputs && !rr.Shell {
+			if isolation != nil {
+				log.Warnf("%v: inputs audit is not supported in heph.sandbox(), skipping", target.FQN)
+				e.recordInputsAuditSkipped(target)
+			} else {
+				traceFile = e.auditTraceFile(target)
+			}
+		}
+
+		e.BuildEvents.Emit(buildevents.Event{
+			Type:   buildevents.TypeRunStarted,
+			Target: target.FQN,
+		})
+
+		execStart := time.Now()
 		espan := e.SpanRunExec(ctx, target)
 		err = platform.Exec(
 			execCtx,
@@ -617,21 +656,27 @@ func (e *TargetRunEngine) Run(ctx context.Context, rr TargetRunRequest, iocfg sa
 			entrypoint,
 			e.tmpTargetRoot(target).Abs(),
 			platform.ExecOptions{
-				WorkDir:  dir,
-				BinDir:   binDir,
-				HomeDir:  e.HomeDir.Abs(),
-				Target:   target.TargetSpec,
-				Env:      env,
-				Run:      run,
-				TermArgs: rr.Args,
-				IOCfg:    iocfg,
+				WorkDir:   dir,
+				BinDir:    binDir,
+				HomeDir:   e.HomeDir.Abs(),
+				Target:    target.TargetSpec,
+				Env:       env,
+				Run:       run,
+				TermArgs:  rr.Args,
+				IOCfg:     iocfg,
+				Isolation: isolation,
+				TraceFile: traceFile,
 			},
 			rr.Shell,
 		)
 		if logFile != nil {
 			_ = logFile.Close()
 		}
+		if traceFile != "" {
+			e.recordInputsAudit(target, e.auditDeclaredPaths(target), e.auditToolchainBins(target, rp.Bin, env["PATH"]))
+		}
 		espan.EndError(err)
+		e.emitRunFinished(target, time.Since(execStart), logFilePath, err)
 		if err != nil {
 			if rr.Shell {
 				log.Debugf("exec: %v", err)
@@ -716,7 +761,7 @@ func (e *TargetRunEngine) Run(ctx context.Context, rr TargetRunRequest, iocfg sa
 		})
 	}
 
-	err = e.postRunOrWarm(ctx, target, target.OutWithSupport.Names(), true)
+	err = e.postRunOrWarm(ctx, target, target.OutWithSupport.Names(), true, false)
 	if err != nil {
 		return err
 	}
@@ -742,7 +787,8 @@ func (e *TargetRunEngine) chooseExecutor(labels map[string]string, options map[s
 	return nil, fmt.Errorf("no platform available for %v", labels)
 }
 
-func (e *TargetRunEngine) postRunOrWarm(ctx context.Context, target *Target, outputs []string, runGc bool) error {
+// postRunOrWarm expands the outputs of the cache entry, verify checks the tarballs against the manifest beforehand
+func (e *TargetRunEngine) postRunOrWarm(ctx context.Context, target *Target, outputs []string, runGc, verify bool) error {
 	err := target.postRunWarmLock.Lock(ctx)
 	if err != nil {
 		return fmt.Errorf("lock postrunwarm: %w", err)
@@ -770,7 +816,7 @@ func (e *TargetRunEngine) postRunOrWarm(ctx context.Context, target *Target, out
 
 	// sanity check
 	for _, name := range outputs {
-		p := cacheDir.Join(target.artifacts.OutTar(name).Name()).Abs()
+		p := e.localOutTarPath(target, name)
 		if !fs.PathExists(p) {
 			panic(fmt.Errorf("%v does not exist", p))
 		}
@@ -796,6 +842,20 @@ func (e *TargetRunEngine) postRunOrWarm(ctx context.Context, target *Target, out
 	}
 
 	if shouldExpand {
+		if verify {
+			e.Status(TargetStatus(target, "Verifying cache..."))
+
+			as := make([]artifacts.Artifact, 0, len(outputs))
+			for _, name := range outputs {
+				as = append(as, target.artifacts.OutTar(name))
+			}
+
+			err := e.verifyLocalArtifacts(target, as)
+			if err != nil {
+				return err
+			}
+		}
+
 		e.Status(TargetStatus(target, "Expanding cache..."))
 		tmpOutDir := e.cacheDir(target).Join("_output_tmp").Abs()
 
@@ -812,7 +872,7 @@ func (e *TargetRunEngine) postRunOrWarm(ctx context.Context, target *Target, out
 		untarDedup := sets.NewStringSet(0)
 
 		for _, name := range outputs {
-			tarf := cacheDir.Join(target.artifacts.OutTar(name).Name()).Abs()
+			tarf := e.localOutTarPath(target, name)
 			err := tar.UntarWith(ctx, tarf, tmpOutDir, tar.UntarOptions{
 				List:  true,
 				Dedup: untarDedup,
@@ -870,7 +930,7 @@ func (e *TargetRunEngine) postRunOrWarm(ctx context.Context, target *Target, out
 }
 
 func (e *TargetRunEngine) gc(ctx context.Context, target *Target) error {
-	if target.Cache.Enabled && e.Config.Engine.GC {
+	if target.Cache.Enabled && e.Config.Engine.GC.Enabled {
 		e.Status(TargetStatus(target, "GC..."))
 
 		err := e.GCTargets([]*Target{target}, nil, false)
@@ -896,7 +956,7 @@ func (e *TargetRunEngine) codegenLink(ctx context.Context, target *Target) error
 
 		switch target.Codegen {
 		case targetspec.CodegenCopy:
-			tarf := e.cacheDir(target).Join(target.artifacts.OutTar(name).Name()).Abs()
+			tarf := e.localOutTarPath(target, name)
 			err := tar.Untar(ctx, tarf, e.Root.Abs(), false)
 			if err != nil {
 				return err
@@ -59,10 +59,11 @@ func ParseTargetSelector(pkg, s string) TargetMatcher {
 	if strings.HasSuffix(s, "...") {
 		isAllDeep = true
 		s = strings.TrimSuffix(s, "...")
-		s = strings.TrimSuffix(s, "/")
 	} else if strings.HasSuffix(s, ".") {
 		isAll = true
 		s = strings.TrimSuffix(s, ".")
+	}
+	if (isAllDeep || isAll) && s != "//" {
 		s = strings.TrimSuffix(s, "/")
 	}
 
@@ -71,7 +72,7 @@ func ParseTargetSelector(pkg, s string) TargetMatcher {
 		if isAllDeep {
 			return func(target *Target) bool {
 				pkg := target.Package.FullName
-				return pkg == tp.Package || strings.HasPrefix(pkg, tp.Package+"/")
+				return tp.Package == "" || pkg == tp.Package || strings.HasPrefix(pkg, tp.Package+"/")
 			}
 		} else if isAll {
 			return func(target *Target) bool {
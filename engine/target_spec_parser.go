@@ -5,8 +5,11 @@ import (
 	"go.starlark.net/starlark"
 	"heph/exprs"
 	"heph/packages"
+	"heph/sandbox"
 	"heph/targetspec"
 	"heph/utils"
+	"heph/utils/tar"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strconv"
@@ -50,12 +53,19 @@ func specFromArgs(args TargetArgs, pkg *packages.Package) (targetspec.TargetSpec
 		PassArgs: args.PassArgs,
 		Quiet:    args.Quiet,
 		Cache: targetspec.TargetSpecCache{
-			Enabled: args.Cache.Enabled,
-			Named:   args.Cache.Named,
-			History: args.Cache.History,
+			Enabled:     args.Cache.Enabled,
+			Named:       args.Cache.Named,
+			History:     args.Cache.History,
+			Compression: args.Cache.Compression,
+		},
+		Isolation: targetspec.TargetSpecIsolation{
+			Enabled: args.Sandbox.Isolation,
+			Network: args.Sandbox.Network,
+			Fs:      args.Sandbox.Fs,
+			Allow:   args.Sandbox.Allow,
 		},
 		RestoreCache:   args.RestoreCache,
-		Sandbox:        args.SandboxEnabled,
+		Sandbox:        args.Sandbox.Enabled,
 		OutInSandbox:   args.OutInSandbox,
 		Codegen:        args.Codegen,
 		Labels:         args.Labels.Array,
@@ -204,6 +214,25 @@ func specFromArgs(args TargetArgs, pkg *packages.Package) (targetspec.TargetSpec
 		return targetspec.TargetSpec{}, fmt.Errorf("entrypoint must be one of %v, got %v", printOneOf(targetspec.EntrypointValues), t.Entrypoint)
 	}
 
+	if t.Cache.Compression != "" && !validate(t.Cache.Compression, tar.CompressionValues) {
+		return targetspec.TargetSpec{}, fmt.Errorf("cache compression must be one of %v, got %v", printOneOf(tar.CompressionValues), t.Cache.Compression)
+	}
+
+	if t.Isolation.Enabled {
+		if t.Isolation.Fs == "" {
+			t.Isolation.Fs = sandbox.IsolationFsHost
+		}
+		if !validate(t.Isolation.Fs, sandbox.IsolationFsValues) {
+			return targetspec.TargetSpec{}, fmt.Errorf("sandbox fs must be one of %v, got %v", printOneOf(sandbox.IsolationFsValues), t.Isolation.Fs)
+		}
+
+		for _, p := range t.Isolation.Allow {
+			if !filepath.IsAbs(p) {
+				return targetspec.TargetSpec{}, fmt.Errorf("sandbox allow must be absolute paths, got %v", p)
+			}
+		}
+	}
+
 	if len(t.Platforms) == 0 {
 		t.Platforms = []targetspec.TargetPlatform{{
 			Labels: map[string]string{
@@ -248,6 +277,14 @@ func specFromArgs(args TargetArgs, pkg *packages.Package) (targetspec.TargetSpec
 		}
 	}
 
+	t.Providers = args.Providers
+
+	t.Transition = targetspec.Configuration(args.Transition)
+	err = t.Transition.Validate()
+	if err != nil {
+		return targetspec.TargetSpec{}, fmt.Errorf("transition: %w", err)
+	}
+
 	if args.Cache.Enabled && args.ConcurrentExecution {
 		return targetspec.TargetSpec{}, fmt.Errorf("concurrent_execution and cache are incompatible")
 	}
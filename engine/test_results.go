@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"heph/targetspec"
+	"heph/testresults"
+	fs2 "heph/utils/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TestResultsFiles returns the files of the test_results output, from the sandbox if the run failed
+func (e *Engine) TestResultsFiles(target *Target) fs2.Paths {
+	if target.actualOutFiles != nil {
+		return target.actualOutFiles.Name(targetspec.TestResultsOutput)
+	}
+
+	if target.Out == nil || !target.Out.HasName(targetspec.TestResultsOutput) {
+		return nil
+	}
+
+	outRoot := target.WorkdirRoot
+	if target.OutInSandbox {
+		outRoot = target.SandboxRoot
+	}
+
+	files, err := e.collectOut(target, target.Out.Name(targetspec.TestResultsOutput), outRoot.Abs())
+	if err != nil {
+		// The test most likely failed before writing its results
+		return nil
+	}
+
+	return files
+}
+
+func (e *Engine) testPassDir(target *Target) fs2.Path {
+	return e.HomeDir.Join("test_results", target.Package.FullName, "__target_"+target.Name)
+}
+
+func (e *Engine) testPassPath(target *Target) fs2.Path {
+	return e.testPassDir(target).Join(e.hashInput(target) + ".json")
+}
+
+// testPassPathForCache returns the test passes matching p in the cache, a target dir or one of its hash dirs
+func (e *Engine) testPassPathForCache(p string) (string, bool) {
+	rel, err := filepath.Rel(e.HomeDir.Join("cache").Abs(), p)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+
+	return e.HomeDir.Join("test_results", rel).Abs(), true
+}
+
+// StoreTestPass records the passing results of the target for its current input hash
+func (e *Engine) StoreTestPass(target *Target, suite testresults.Suite) error {
+	b, err := json.Marshal(suite)
+	if err != nil {
+		return err
+	}
+
+	p := e.testPassPath(target).Abs()
+
+	err = fs2.CreateParentDir(p)
+	if err != nil {
+		return err
+	}
+
+	return fs2.WriteFileSync(p, b, os.ModePerm)
+}
+
+func (e *Engine) loadTestPass(target *Target) (*testresults.Suite, error) {
+	b, err := os.ReadFile(e.testPassPath(target).Abs())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var suite testresults.Suite
+	err = json.Unmarshal(b, &suite)
+	if err != nil {
+		return nil, fmt.Errorf("%v: test pass: %w", target.FQN, err)
+	}
+
+	return &suite, nil
+}
+
+// TestCacheHit returns true if the target was not run as it passed previously,
+// the suite is set if it comes from StoreTestPass, otherwise the results are in the cached outputs
+func (e *Engine) TestCacheHit(target *Target) (*testresults.Suite, bool) {
+	return e.testCacheHits.GetOk(target.FQN)
+}
@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"context"
+	"heph/testresults"
+	"heph/utils/maps"
+	"heph/utils/sets"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestResultsEngine(t *testing.T) *Engine {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "BUILD"), []byte(`
+target(name="lib", out="lib")
+target(name="t", deps=["//:lib"], labels=["test"], cache=False)
+target(name="used", out="used", labels=["test"], cache=True)
+target(name="consumer", deps=["//:used"], out="consumer")
+`), os.ModePerm)
+	require.NoError(t, err)
+
+	e := New(dir)
+	err = e.runBuildFiles(dir, e.createPkg)
+	require.NoError(t, err)
+
+	for _, target := range e.Targets.Slice() {
+		err := e.processTarget(target)
+		require.NoError(t, err)
+	}
+
+	err = e.LinkTargets(context.Background(), false, nil)
+	require.NoError(t, err)
+
+	return e
+}
+
+func TestIsTestPassCached(t *testing.T) {
+	e := newTestResultsEngine(t)
+
+	lib := e.Targets.Find("//:lib")
+	test := e.Targets.Find("//:t")
+	used := e.Targets.Find("//:used")
+	consumer := e.Targets.Find("//:consumer")
+
+	requested := NewTargets(0)
+	requested.AddAll([]*Target{lib, test, used, consumer})
+
+	outputs := &maps.Map[string, *sets.Set[string, string]]{}
+	outputs.Set(consumer.FQN, sets.NewStringSet(0))
+
+	s := &schedulerv2{
+		Engine:     e,
+		targetsSet: requested,
+		outputs:    outputs,
+	}
+
+	assert.False(t, s.isTestPassCached(test))
+
+	e.TestPassCache = true
+
+	assert.True(t, s.isTestPassCached(test))
+	// Not a test
+	assert.False(t, s.isTestPassCached(lib))
+	// Its outputs are needed by a scheduled target
+	assert.False(t, s.isTestPassCached(used))
+
+	s.targetsSet = NewTargets(0)
+	s.targetsSet.Add(used)
+
+	// Only scheduled as a dependency
+	assert.False(t, s.isTestPassCached(test))
+}
+
+func TestStoreTestPass(t *testing.T) {
+	e := newTestResultsEngine(t)
+
+	test := e.Targets.Find("//:used")
+
+	suite, err := e.loadTestPass(test)
+	require.NoError(t, err)
+	assert.Nil(t, suite)
+
+	err = e.StoreTestPass(test, testresults.Suite{Name: test.FQN, Cases: []testresults.Case{{Name: "a", Status: testresults.StatusPassed}}})
+	require.NoError(t, err)
+
+	suite, err = e.loadTestPass(test)
+	require.NoError(t, err)
+	require.NotNil(t, suite)
+	assert.Equal(t, test.FQN, suite.Name)
+	assert.Len(t, suite.Cases, 1)
+}
+
+func TestGCTestPasses(t *testing.T) {
+	e := newTestResultsEngine(t)
+
+	test := e.Targets.Find("//:t")
+	used := e.Targets.Find("//:used")
+	used.Cache.History = 1
+	test.Cache.History = 2
+
+	now := time.Now()
+
+	write := func(p string, age time.Duration) string {
+		err := os.MkdirAll(filepath.Dir(p), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(p, []byte("{}"), os.ModePerm)
+		require.NoError(t, err)
+		err = os.Chtimes(p, now.Add(-age), now.Add(-age))
+		require.NoError(t, err)
+
+		return p
+	}
+
+	// The passes of the cached test follow its hash dirs
+	hashDir := func(hash string, age time.Duration) string {
+		p := e.cacheDirForHash(used, hash).Abs()
+		write(filepath.Join(p, "hash_input"), age)
+		require.NoError(t, os.Chtimes(p, now.Add(-age), now.Add(-age)))
+
+		return write(e.testPassDir(used).Join(hash+".json").Abs(), age)
+	}
+	used1 := hashDir("1", time.Hour)
+	used2 := hashDir("2", 2*time.Hour)
+
+	// The test not cached has no hash dir
+	test1 := write(e.testPassDir(test).Join("1.json").Abs(), time.Hour)
+	test2 := write(e.testPassDir(test).Join("2.json").Abs(), 2*time.Hour)
+	test3 := write(e.testPassDir(test).Join("3.json").Abs(), 3*time.Hour)
+
+	gone := write(e.HomeDir.Join("test_results", "some", "pkg", "__target_renamed", "1.json").Abs(), time.Hour)
+	goneCache := e.HomeDir.Join("cache", "some", "pkg", "__target_gone").Abs()
+	write(filepath.Join(goneCache, "1", "hash_input"), time.Hour)
+	goneCachePass := write(e.HomeDir.Join("test_results", "some", "pkg", "__target_gone", "2.json").Abs(), time.Hour)
+
+	err := e.GC(context.Background(), nil, true)
+	require.NoError(t, err)
+	assert.FileExists(t, used2)
+	assert.FileExists(t, test3)
+	assert.FileExists(t, gone)
+
+	err = e.GC(context.Background(), nil, false)
+	require.NoError(t, err)
+
+	assert.FileExists(t, used1)
+	assert.NoFileExists(t, used2)
+	assert.NoDirExists(t, e.cacheDirForHash(used, "2").Abs())
+
+	assert.FileExists(t, test1)
+	assert.FileExists(t, test2)
+	assert.NoFileExists(t, test3)
+
+	assert.NoFileExists(t, gone)
+	assert.NoFileExists(t, goneCachePass)
+	assert.NoDirExists(t, goneCache)
+}
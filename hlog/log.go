@@ -2,6 +2,7 @@ package hlog
 
 import (
 	"heph/hlog/log"
+	"io"
 	"os"
 )
 
@@ -28,11 +29,24 @@ func IsLevelEnabled(lvl log.Level) bool {
 }
 
 func Setup() {
-	tuiInterceptCore = newInterceptCore(os.Stderr, log.NewLock(log.NewCore(log.NewConsole(os.Stderr))))
+	SetOutput(os.Stderr)
+}
+
+var output io.Writer = os.Stderr
+
+// SetOutput directs the logs to w
+func SetOutput(w io.Writer) {
+	output = w
+	tuiInterceptCore = newInterceptCore(w, log.NewLock(log.NewCore(log.NewConsole(w))))
 
 	defaultLogger = log.NewLogger(log.NewLevelEnabler(tuiInterceptCore, IsLevelEnabled))
 }
 
+// Writer returns where the logs go, for output meant to be interleaved with them
+func Writer() io.Writer {
+	return output
+}
+
 func Cleanup() {
 	//defaultLogger.Sync()
 }
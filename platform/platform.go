@@ -2,8 +2,10 @@ package platform
 
 import (
 	"context"
+	"errors"
 	"heph/sandbox"
 	"heph/targetspec"
+	"os/exec"
 )
 
 type Provider interface {
@@ -19,6 +21,10 @@ type ExecOptions struct {
 	Run      []string
 	TermArgs []string
 	IOCfg    sandbox.IOConfig
+	// Isolation is set when the target runs in a heph.sandbox()
+	Isolation *sandbox.Isolation
+	// TraceFile is set when auditing inputs, see sandbox.ExecConfig
+	TraceFile string
 }
 
 type Executor interface {
@@ -26,3 +32,18 @@ type Executor interface {
 	Os() string
 	Arch() string
 }
+
+// ExitCode returns the exit code of the command that failed with err, run locally or by a remote worker
+func ExitCode(err error) (int, bool) {
+	var eerr *exec.ExitError
+	if errors.As(err, &eerr) {
+		return eerr.ExitCode(), true
+	}
+
+	var rerr RemoteExitError
+	if errors.As(err, &rerr) {
+		return rerr.ExitCode, true
+	}
+
+	return 0, false
+}
@@ -44,6 +44,11 @@ func (d *dockerExecutor) remap(p string) string {
 }
 
 func (d *dockerExecutor) Exec(ctx context.Context, o ExecOptions, execArgs []string) error {
+	// The local executor would isolate the docker client, not the container
+	if o.Isolation != nil {
+		return fmt.Errorf("heph.sandbox() isolation is not supported on the docker platform %v", d.platform)
+	}
+
 	dockerArgs := []string{d.exe, "run", "--rm"}
 	for k, v := range o.Env {
 		dockerArgs = append(dockerArgs, "-e", k+"="+v)
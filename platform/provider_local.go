@@ -43,14 +43,19 @@ func (p *localExecutor) Exec(ctx context.Context, o ExecOptions, execArgs []stri
 		return err
 	}
 
-	cmd := sandbox.Exec(sandbox.ExecConfig{
-		Context:  ctx,
-		BinDir:   o.BinDir,
-		Dir:      o.WorkDir,
-		Env:      env,
-		IOConfig: o.IOCfg,
-		ExecArgs: execArgs,
+	cmd, err := sandbox.Exec(sandbox.ExecConfig{
+		Context:   ctx,
+		BinDir:    o.BinDir,
+		Dir:       o.WorkDir,
+		Env:       env,
+		IOConfig:  o.IOCfg,
+		ExecArgs:  execArgs,
+		Isolation: o.Isolation,
+		TraceFile: o.TraceFile,
 	})
+	if err != nil {
+		return fmt.Errorf("local: %w", err)
+	}
 
 	err = cmd.Run()
 	if err != nil {
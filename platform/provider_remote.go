@@ -0,0 +1,346 @@
+package platform
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "heph/hlog"
+	"heph/sandbox"
+	"heph/utils/fs"
+	"heph/utils/tar"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RemoteJob is sent to the worker, followed by the sandbox tar.gz
+type RemoteJob struct {
+	// Root is the sandbox root on the client, paths in Env & Args are rewritten to the worker's root
+	Root string            `json:"root"`
+	Dir  string            `json:"dir"` // Relative to Root
+	Env  map[string]string `json:"env"`
+	Args []string          `json:"args"`
+	// Isolation is applied by the worker, its writable paths being replaced with the job root
+	Isolation *sandbox.Isolation `json:"isolation,omitempty"`
+}
+
+// RemoteFrame is streamed back by the worker, as newline delimited json
+type RemoteFrame struct {
+	Stdout []byte `json:"stdout,omitempty"`
+	Stderr []byte `json:"stderr,omitempty"`
+	// Output is a chunk of the tar.gz containing the files created or modified by the run
+	Output []byte `json:"output,omitempty"`
+	Exit   *int   `json:"exit,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+}
+
+type RemoteInfo struct {
+	Os   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+type RemoteExitError struct {
+	ExitCode int
+	Err      string
+}
+
+func (e RemoteExitError) Error() string {
+	return fmt.Sprintf("remote: exit code %v: %v", e.ExitCode, e.Err)
+}
+
+// RemoteTokenEnv holds the token shared with the worker, when not set in the provider options
+const RemoteTokenEnv = "HEPH_WORKER_TOKEN"
+
+type remoteClient struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+func (c remoteClient) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	return c.client.Do(req)
+}
+
+type remoteExecutor struct {
+	remoteClient
+	os   string
+	arch string
+}
+
+func (r *remoteExecutor) Os() string {
+	return r.os
+}
+
+func (r *remoteExecutor) Arch() string {
+	return r.arch
+}
+
+// sandboxFiles lists the files to ship to the worker: the sandbox, and the bin dir with tools links resolved
+func sandboxFiles(root, binDir, sandboxDir string) ([]tar.TarFile, error) {
+	files := make([]tar.TarFile, 0)
+
+	entries, err := os.ReadDir(binDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		p, err := filepath.EvalSymlinks(filepath.Join(binDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		rel, err := filepath.Rel(root, filepath.Join(binDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, tar.TarFile{From: p, To: rel})
+	}
+
+	if fs.PathExists(sandboxDir) {
+		rel, err := filepath.Rel(root, sandboxDir)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, tar.TarFile{From: sandboxDir, To: rel})
+	}
+
+	return files, nil
+}
+
+func (r *remoteExecutor) Exec(ctx context.Context, o ExecOptions, execArgs []string) error {
+	if !o.Target.Sandbox {
+		return fmt.Errorf("remote: %v must be sandboxed to run remotely", o.Target.FQN)
+	}
+
+	// The bin dir lives next to the sandbox dir, ship their parent
+	root := filepath.Dir(o.BinDir)
+	sandboxDir := o.Env["SANDBOX"]
+
+	for _, p := range []string{sandboxDir, o.WorkDir} {
+		rel, err := filepath.Rel(root, p)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("remote: %v must be inside %v", p, root)
+		}
+	}
+
+	dir, _ := filepath.Rel(root, o.WorkDir)
+
+	if o.TraceFile != "" {
+		log.Warnf("remote: %v: inputs audit is not supported on remote platforms", o.Target.FQN)
+	}
+
+	files, err := sandboxFiles(root, o.BinDir, sandboxDir)
+	if err != nil {
+		return fmt.Errorf("remote: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "heph_remote")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	inTar := filepath.Join(tmpDir, "in.tar.gz")
+	err = tar.Tar(ctx, files, inTar)
+	if err != nil {
+		return fmt.Errorf("remote: %w", err)
+	}
+
+	jobb, err := json.Marshal(RemoteJob{
+		Root:      root,
+		Dir:       dir,
+		Env:       o.Env,
+		Args:      execArgs,
+		Isolation: o.Isolation,
+	})
+	if err != nil {
+		return err
+	}
+
+	inf, err := os.Open(inTar)
+	if err != nil {
+		return err
+	}
+	defer inf.Close()
+
+	body := io.MultiReader(strings.NewReader(string(jobb)+"\n"), inf)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.addr+"/v1/exec", body)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("remote exec %v on %v: %v", o.Target.FQN, r.addr, execArgs)
+
+	res, err := r.do(req)
+	if err != nil {
+		return fmt.Errorf("remote: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("remote: %v: %s", res.Status, strings.TrimSpace(string(b)))
+	}
+
+	outTar := filepath.Join(tmpDir, "out.tar.gz")
+	outf, err := os.Create(outTar)
+	if err != nil {
+		return err
+	}
+	defer outf.Close()
+
+	stdout, stderr := o.IOCfg.Stdout, o.IOCfg.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	sc := bufio.NewScanner(res.Body)
+	sc.Buffer(nil, 16*1024*1024)
+	for sc.Scan() {
+		var frame RemoteFrame
+		err := json.Unmarshal(sc.Bytes(), &frame)
+		if err != nil {
+			return fmt.Errorf("remote: frame: %w", err)
+		}
+
+		_, _ = stdout.Write(frame.Stdout)
+		_, _ = stderr.Write(frame.Stderr)
+
+		if frame.Exit != nil && *frame.Exit != 0 {
+			return RemoteExitError{ExitCode: *frame.Exit, Err: frame.Error}
+		}
+
+		if frame.Error != "" {
+			return fmt.Errorf("remote: %v", frame.Error)
+		}
+
+		if len(frame.Output) > 0 {
+			_, err := outf.Write(frame.Output)
+			if err != nil {
+				return err
+			}
+		}
+
+		if frame.Done {
+			err := outf.Close()
+			if err != nil {
+				return err
+			}
+
+			err = tar.Untar(ctx, outTar, root, false)
+			if err != nil {
+				return fmt.Errorf("remote: outputs: %w", err)
+			}
+
+			return nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("remote: %w", err)
+	}
+
+	return fmt.Errorf("remote: stream ended unexpectedly")
+}
+
+func newRemoteExecutor(c remoteClient, os, arch string) Executor {
+	return &remoteExecutor{
+		remoteClient: c,
+		os:           os,
+		arch:         arch,
+	}
+}
+
+type remoteProvider struct {
+	remoteClient
+	name string
+	info RemoteInfo
+}
+
+func (p *remoteProvider) NewExecutor(labels map[string]string, _ map[string]interface{}) (Executor, error) {
+	if !HasAllLabels(labels, map[string]string{
+		"name": p.name,
+		"os":   p.info.Os,
+		"arch": p.info.Arch,
+	}) {
+		return nil, nil
+	}
+
+	return newRemoteExecutor(p.remoteClient, p.info.Os, p.info.Arch), nil
+}
+
+func fetchRemoteInfo(c remoteClient) (RemoteInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, c.addr+"/v1/info", nil)
+	if err != nil {
+		return RemoteInfo{}, err
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return RemoteInfo{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return RemoteInfo{}, fmt.Errorf("info: %v", res.Status)
+	}
+
+	var info RemoteInfo
+	err = json.NewDecoder(res.Body).Decode(&info)
+	if err != nil {
+		return RemoteInfo{}, fmt.Errorf("info: %w", err)
+	}
+
+	return info, nil
+}
+
+func NewRemoteProvider(name string, options map[string]interface{}) (Provider, error) {
+	addr, _ := options["addr"].(string)
+	if addr == "" {
+		return nil, fmt.Errorf("addr option missing")
+	}
+
+	token, _ := options["token"].(string)
+	if token == "" {
+		token = os.Getenv(RemoteTokenEnv)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("token option or %v missing", RemoteTokenEnv)
+	}
+
+	c := remoteClient{
+		addr:   strings.TrimSuffix(addr, "/"),
+		token:  token,
+		client: http.DefaultClient,
+	}
+
+	info, err := fetchRemoteInfo(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteProvider{
+		remoteClient: c,
+		name:         name,
+		info:         info,
+	}, nil
+}
+
+func init() {
+	RegisterProvider("remote", func(name string, options map[string]interface{}) (Provider, error) {
+		return NewRemoteProvider(name, options)
+	})
+}
@@ -0,0 +1,120 @@
+package platform
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"heph/sandbox"
+	"heph/targetspec"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func setupRemote(t *testing.T) Executor {
+	srv := httptest.NewServer(NewRemoteWorker(t.TempDir(), "secret").Handler())
+	t.Cleanup(srv.Close)
+
+	provider, err := NewRemoteProvider("remote", map[string]interface{}{"addr": srv.URL, "token": "secret"})
+	require.NoError(t, err)
+
+	executor, err := provider.NewExecutor(map[string]string{"name": "remote", "os": runtime.GOOS}, nil)
+	require.NoError(t, err)
+	require.NotNil(t, executor)
+
+	return executor
+}
+
+type remoteSandbox struct {
+	BinDir, Dir, WorkDir string
+}
+
+func setupRemoteSandbox(t *testing.T) remoteSandbox {
+	base := t.TempDir()
+
+	s := remoteSandbox{
+		BinDir:  filepath.Join(base, "_bin"),
+		Dir:     filepath.Join(base, "_dir"),
+		WorkDir: filepath.Join(base, "_dir", "pkg"),
+	}
+
+	tool := filepath.Join(t.TempDir(), "tool")
+	require.NoError(t, os.WriteFile(tool, []byte("#!/bin/sh\necho from tool\n"), 0755))
+
+	require.NoError(t, os.MkdirAll(s.BinDir, os.ModePerm))
+	require.NoError(t, os.Symlink(tool, filepath.Join(s.BinDir, "mytool")))
+
+	require.NoError(t, os.MkdirAll(s.WorkDir, os.ModePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(s.WorkDir, "in.txt"), []byte("input\n"), os.ModePerm))
+
+	return s
+}
+
+func (s remoteSandbox) Exec(executor Executor, stdout *bytes.Buffer, script string) error {
+	return executor.Exec(context.Background(), ExecOptions{
+		WorkDir: s.WorkDir,
+		BinDir:  s.BinDir,
+		Target:  targetspec.TargetSpec{FQN: "//pkg:test", Sandbox: true},
+		Env: map[string]string{
+			"SANDBOX": s.Dir,
+			"ROOT":    s.Dir,
+			"PATH":    s.BinDir + ":/usr/bin:/bin",
+		},
+		IOCfg: sandbox.IOConfig{Stdout: stdout, Stderr: stdout},
+	}, []string{"sh", "-c", script})
+}
+
+func TestRemoteExec(t *testing.T) {
+	executor := setupRemote(t)
+	s := setupRemoteSandbox(t)
+
+	var stdout bytes.Buffer
+	err := s.Exec(executor, &stdout, `mytool > out.txt; cat in.txt >> out.txt; echo $SANDBOX`)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(s.WorkDir, "out.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "from tool\ninput\n", string(b))
+
+	// The sandbox path must have been rewritten to the worker's
+	assert.NotContains(t, stdout.String(), s.Dir)
+	assert.Contains(t, stdout.String(), "/_dir")
+}
+
+func TestRemoteExecFailure(t *testing.T) {
+	executor := setupRemote(t)
+	s := setupRemoteSandbox(t)
+
+	var stdout bytes.Buffer
+	err := s.Exec(executor, &stdout, `echo failing; exit 3`)
+
+	var eerr RemoteExitError
+	require.True(t, errors.As(err, &eerr))
+	assert.Equal(t, 3, eerr.ExitCode)
+	assert.Equal(t, "failing\n", stdout.String())
+
+	code, ok := ExitCode(fmt.Errorf("exec: %w", err))
+	assert.True(t, ok)
+	assert.Equal(t, 3, code)
+	assert.NoFileExists(t, filepath.Join(s.WorkDir, "out.txt"))
+}
+
+func TestRemoteUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(NewRemoteWorker(t.TempDir(), "secret").Handler())
+	t.Cleanup(srv.Close)
+
+	_, err := NewRemoteProvider("remote", map[string]interface{}{"addr": srv.URL, "token": "wrong"})
+	assert.ErrorContains(t, err, "401")
+
+	// A worker without token serves nothing
+	srv = httptest.NewServer(NewRemoteWorker(t.TempDir(), "").Handler())
+	t.Cleanup(srv.Close)
+
+	_, err = NewRemoteProvider("remote", map[string]interface{}{"addr": srv.URL, "token": "secret"})
+	assert.ErrorContains(t, err, "401")
+}
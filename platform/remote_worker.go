@@ -0,0 +1,351 @@
+package platform
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "heph/hlog"
+	"heph/sandbox"
+	"heph/utils/tar"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const remoteOutputChunkSize = 1024 * 1024
+
+// RemoteWorker executes jobs sent by the remote provider, each in its own directory under Root
+type RemoteWorker struct {
+	Root string
+	// Token is the secret shared with the provider, required on every request
+	Token string
+}
+
+func NewRemoteWorker(root, token string) *RemoteWorker {
+	return &RemoteWorker{Root: root, Token: token}
+}
+
+func (w *RemoteWorker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/info", w.handleInfo)
+	mux.HandleFunc("/v1/exec", w.handleExec)
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !w.authorized(req) {
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		mux.ServeHTTP(rw, req)
+	})
+}
+
+func (w *RemoteWorker) authorized(req *http.Request) bool {
+	// Running arbitrary commands, no token means no access
+	if w.Token == "" {
+		return false
+	}
+
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(w.Token)) == 1
+}
+
+func (w *RemoteWorker) handleInfo(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(RemoteInfo{
+		Os:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+	})
+}
+
+type frameWriter struct {
+	m   sync.Mutex
+	enc *json.Encoder
+	f   http.Flusher
+}
+
+func (fw *frameWriter) Send(frame RemoteFrame) error {
+	fw.m.Lock()
+	defer fw.m.Unlock()
+
+	err := fw.enc.Encode(frame)
+	if err != nil {
+		return err
+	}
+
+	if fw.f != nil {
+		fw.f.Flush()
+	}
+
+	return nil
+}
+
+type frameStream struct {
+	fw     *frameWriter
+	stderr bool
+}
+
+func (s frameStream) Write(p []byte) (int, error) {
+	frame := RemoteFrame{Stdout: p}
+	if s.stderr {
+		frame = RemoteFrame{Stderr: p}
+	}
+
+	err := s.fw.Send(frame)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func snapshotDir(root string) (map[string]fileState, error) {
+	m := map[string]fileState{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		m[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+
+		return nil
+	})
+
+	return m, err
+}
+
+func (w *RemoteWorker) handleExec(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := os.MkdirAll(w.Root, os.ModePerm)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dir, err := os.MkdirTemp(w.Root, "job")
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	br := bufio.NewReader(req.Body)
+
+	jobb, err := br.ReadBytes('\n')
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("job: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var job RemoteJob
+	err = json.Unmarshal(jobb, &job)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("job: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	root := filepath.Join(dir, "root")
+
+	err = w.receiveSandbox(req.Context(), br, dir, root)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("sandbox: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.WriteHeader(http.StatusOK)
+
+	flusher, _ := rw.(http.Flusher)
+	fw := &frameWriter{enc: json.NewEncoder(rw), f: flusher}
+
+	err = w.exec(req.Context(), fw, job, dir, root)
+	if err != nil {
+		log.Errorf("worker: %v", err)
+		_ = fw.Send(RemoteFrame{Error: err.Error()})
+	}
+}
+
+func (w *RemoteWorker) receiveSandbox(ctx context.Context, r io.Reader, dir, root string) error {
+	inTar := filepath.Join(dir, "in.tar.gz")
+
+	f, err := os.Create(inTar)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(inTar)
+
+	_, err = io.Copy(f, r)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(root, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return tar.Untar(ctx, inTar, root, false)
+}
+
+func (w *RemoteWorker) exec(ctx context.Context, fw *frameWriter, job RemoteJob, dir, root string) error {
+	rewrite := func(s string) string {
+		return strings.ReplaceAll(s, job.Root, root)
+	}
+
+	env := make(map[string]string, len(job.Env))
+	for k, v := range job.Env {
+		env[k] = rewrite(v)
+	}
+
+	args := make([]string, 0, len(job.Args))
+	for _, arg := range job.Args {
+		args = append(args, rewrite(arg))
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("no args")
+	}
+
+	before, err := snapshotDir(root)
+	if err != nil {
+		return err
+	}
+
+	exPath, err := sandbox.LookPath(args[0], env["PATH"])
+	if err != nil {
+		return err
+	}
+	args[0] = exPath
+
+	workdir := filepath.Join(root, job.Dir)
+
+	var isolation *sandbox.Isolation
+	if job.Isolation != nil {
+		iso := *job.Isolation
+		iso.Writable = []string{root}
+		isolation = &iso
+	}
+
+	cmd, err := sandbox.Exec(sandbox.ExecConfig{
+		Context: ctx,
+		BinDir:  filepath.Join(root, "_bin"),
+		Dir:     workdir,
+		Env:     env,
+		IOConfig: sandbox.IOConfig{
+			Stdout: frameStream{fw: fw},
+			Stderr: frameStream{fw: fw, stderr: true},
+		},
+		ExecArgs:  args,
+		Isolation: isolation,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Run()
+	if err != nil {
+		code := -1
+		var eerr *exec.ExitError
+		if errors.As(err, &eerr) {
+			code = eerr.ExitCode()
+		}
+
+		return fw.Send(RemoteFrame{Exit: &code, Error: err.Error()})
+	}
+
+	code := 0
+	err = fw.Send(RemoteFrame{Exit: &code})
+	if err != nil {
+		return err
+	}
+
+	return w.sendOutputs(ctx, fw, dir, root, before)
+}
+
+// sendOutputs sends back all files created or modified by the run
+func (w *RemoteWorker) sendOutputs(ctx context.Context, fw *frameWriter, dir, root string, before map[string]fileState) error {
+	after, err := snapshotDir(root)
+	if err != nil {
+		return err
+	}
+
+	files := make([]tar.TarFile, 0)
+	for p, state := range after {
+		if prev, ok := before[p]; ok && prev.size == state.size && prev.modTime.Equal(state.modTime) {
+			continue
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, tar.TarFile{From: p, To: rel})
+	}
+
+	outTar := filepath.Join(dir, "out.tar.gz")
+	err = tar.Tar(ctx, files, outTar)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(outTar)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, remoteOutputChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			serr := fw.Send(RemoteFrame{Output: buf[:n]})
+			if serr != nil {
+				return serr
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+	}
+
+	return fw.Send(RemoteFrame{Done: true})
+}
@@ -0,0 +1,283 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a node of a query, such as `deps(//app:bin) intersect label(go) except //thirdparty/...`
+type Expr interface {
+	String() string
+}
+
+// Word is a target pattern, or a plain argument to a function such as a label or a depth
+type Word struct {
+	Value string
+}
+
+func (w Word) String() string {
+	if w.Value == "" || strings.ContainsAny(w.Value, " \t\n(),\"") || isOperator(w.Value) {
+		return strconv.Quote(w.Value)
+	}
+
+	return w.Value
+}
+
+type Call struct {
+	Func string
+	Args []Expr
+}
+
+func (c Call) String() string {
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		args = append(args, arg.String())
+	}
+
+	return c.Func + "(" + strings.Join(args, ", ") + ")"
+}
+
+const (
+	OpIntersect = "intersect"
+	OpExcept    = "except"
+	OpUnion     = "union"
+)
+
+var operators = map[string]string{
+	OpIntersect: OpIntersect,
+	"^":         OpIntersect,
+	OpExcept:    OpExcept,
+	"-":         OpExcept,
+	OpUnion:     OpUnion,
+	"+":         OpUnion,
+}
+
+func isOperator(s string) bool {
+	_, ok := operators[s]
+	return ok
+}
+
+type Binary struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+func (b Binary) String() string {
+	return "(" + b.Left.String() + " " + b.Op + " " + b.Right.String() + ")"
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "EOF"
+	case tokenString:
+		return strconv.Quote(t.value)
+	}
+
+	return t.value
+}
+
+func lex(s string) ([]token, error) {
+	tokens := make([]token, 0)
+
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, value: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, value: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokenComma, value: ",", pos: i})
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			var b strings.Builder
+			for {
+				if i >= len(rs) {
+					return nil, fmt.Errorf("unterminated string at %v", start)
+				}
+				if rs[i] == '\\' && i+1 < len(rs) {
+					b.WriteRune(rs[i+1])
+					i += 2
+					continue
+				}
+				if rs[i] == r {
+					i++
+					break
+				}
+				b.WriteRune(rs[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenString, value: b.String(), pos: start})
+		default:
+			start := i
+			// Names of configured targets contain commas, between braces
+			braces := 0
+			for i < len(rs) && !unicode.IsSpace(rs[i]) && !strings.ContainsRune("()\"'", rs[i]) {
+				if rs[i] == '{' {
+					braces++
+				} else if rs[i] == '}' && braces > 0 {
+					braces--
+				} else if rs[i] == ',' && braces == 0 {
+					break
+				}
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, value: string(rs[start:i]), pos: start})
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(rs)}), nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) cur() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokenEOF {
+		p.i++
+	}
+
+	return t
+}
+
+func unexpected(t token) error {
+	return fmt.Errorf("unexpected %v at %v", t, t.pos)
+}
+
+// Parse parses a query, the operators are left associative and share the same precedence
+func Parse(s string) (Expr, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.cur(); t.kind != tokenEOF {
+		return nil, unexpected(t)
+	}
+
+	return expr, nil
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.cur()
+		if t.kind != tokenWord {
+			return left, nil
+		}
+
+		op, ok := operators[t.value]
+		if !ok {
+			return nil, unexpected(t)
+		}
+		p.next()
+
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		left = Binary{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseTerm() (Expr, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenLParen:
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, unexpected(t)
+		}
+
+		return expr, nil
+	case tokenString:
+		return Word{Value: t.value}, nil
+	case tokenWord:
+		if isOperator(t.value) {
+			return nil, unexpected(t)
+		}
+
+		if p.cur().kind != tokenLParen {
+			return Word{Value: t.value}, nil
+		}
+		p.next()
+
+		call := Call{Func: t.value}
+		if p.cur().kind == tokenRParen {
+			p.next()
+			return call, nil
+		}
+
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+
+			switch t := p.next(); t.kind {
+			case tokenComma:
+				continue
+			case tokenRParen:
+				return call, nil
+			default:
+				return nil, unexpected(t)
+			}
+		}
+	}
+
+	return nil, unexpected(t)
+}
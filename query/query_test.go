@@ -0,0 +1,41 @@
+package query
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		q   string
+		s   string
+		err string
+	}{
+		{q: `//app:bin`, s: `//app:bin`},
+		{q: `deps(//app:bin)`, s: `deps(//app:bin)`},
+		{q: `deps(//app:bin) intersect label(go) except //thirdparty/...`, s: `((deps(//app:bin) intersect label(go)) except //thirdparty/...)`},
+		{q: `a ^ (b + c) - d`, s: `((a intersect (b union c)) except d)`},
+		{q: `rdeps(//..., //lib:x, 2)`, s: `rdeps(//..., //lib:x, 2)`},
+		{q: `attr(entrypoint, "ba sh", //...)`, s: `attr(entrypoint, "ba sh", //...)`},
+		{q: `deps(//lib:lib{arch=arm64,os=linux}, 1)`, s: `deps("//lib:lib{arch=arm64,os=linux}", 1)`},
+		{q: `somepath(//a-b:c+d, //e)`, s: `somepath(//a-b:c+d, //e)`},
+		{q: `f()`, s: `f()`},
+
+		{q: `deps(//app:bin`, err: `unexpected EOF at 14`},
+		{q: `a b`, err: `unexpected b at 2`},
+		{q: `a intersect`, err: `unexpected EOF at 11`},
+		{q: `attr(a, "b`, err: `unterminated string at 8`},
+	}
+	for _, test := range tests {
+		t.Run(test.q, func(t *testing.T) {
+			expr, err := Parse(test.q)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.s, expr.String())
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	IsolationFsHost     = "host"
+	IsolationFsReadOnly = "ro"
+	IsolationFsStrict   = "strict"
+)
+
+var IsolationFsValues = []string{IsolationFsHost, IsolationFsReadOnly, IsolationFsStrict}
+
+// DefaultStrictAllow are the host paths always exposed in strict fs mode, so that shells & coreutils keep working
+var DefaultStrictAllow = []string{"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/etc"}
+
+// Isolation runs the command in its own user, mount, pid and optionally net namespaces
+type Isolation struct {
+	Network bool   `json:"network"`
+	Fs      string `json:"fs"`
+	// Allow is the list of host paths exposed read-only in strict fs mode, on top of DefaultStrictAllow
+	Allow []string `json:"allow,omitempty"`
+	// Writable is the list of paths the command can write to, typically the sandbox
+	Writable []string `json:"writable,omitempty"`
+}
+
+const isolationInitArg0 = "heph-sandbox-init"
+
+// Init takes over the process when it has been started as a sandbox helper (isolation init or tracer),
+// it must be called first thing in main
+func Init() {
+	if len(os.Args) < 4 || os.Args[2] != "--" {
+		return
+	}
+
+	var err error
+	switch os.Args[0] {
+	case isolationInitArg0:
+		err = isolationInit(os.Args[1], os.Args[3:])
+	case traceArg0:
+		var code int
+		code, err = traceInit(os.Args[1], os.Args[3:])
+		if err == nil {
+			os.Exit(code)
+		}
+	default:
+		return
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "heph sandbox: %v\n", err)
+	os.Exit(127)
+}
@@ -0,0 +1,476 @@
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type isolationBind struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+type isolationConfig struct {
+	Isolation
+	Dir string `json:"dir"`
+	// Tools are the targets of the bin dir links, exposed read-only in strict fs mode
+	Tools []isolationBind `json:"tools,omitempty"`
+}
+
+// toolBinds resolves the bin dir links, each link is bound at the path it points to
+func toolBinds(binDir string) ([]isolationBind, error) {
+	if binDir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	binds := make([]isolationBind, 0, len(entries))
+	for _, entry := range entries {
+		p := filepath.Join(binDir, entry.Name())
+
+		dst, err := os.Readlink(p)
+		if err != nil || !filepath.IsAbs(dst) {
+			continue
+		}
+
+		src, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return nil, fmt.Errorf("tool %v: %w", entry.Name(), err)
+		}
+
+		binds = append(binds, isolationBind{Src: src, Dst: dst})
+	}
+
+	return binds, nil
+}
+
+// isolate makes cmd go through the sandbox init, which sets up the mounts from within the namespaces
+func isolate(cmd *exec.Cmd, cfg ExecConfig) error {
+	iso := *cfg.Isolation
+
+	tools, err := toolBinds(cfg.BinDir)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(isolationConfig{
+		Isolation: iso,
+		Dir:       cfg.Dir,
+		Tools:     tools,
+	})
+	if err != nil {
+		return err
+	}
+
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = append([]string{isolationInitArg0, string(b), "--"}, cmd.Args...)
+
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID
+	if !iso.Network {
+		flags |= syscall.CLONE_NEWNET
+	}
+
+	// The init needs to be root in the user namespace to be able to mount,
+	// the command then runs as root, mapped to the current user
+	cmd.SysProcAttr.Cloneflags = uintptr(flags)
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+
+	return nil
+}
+
+func isolationInit(cfgs string, args []string) error {
+	runtime.LockOSThread()
+
+	var cfg isolationConfig
+	err := json.Unmarshal([]byte(cfgs), &cfg)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	if !cfg.Network {
+		err := loopbackUp()
+		if err != nil {
+			return fmt.Errorf("loopback: %w", err)
+		}
+	}
+
+	err = syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("private mount: %w", err)
+	}
+
+	switch cfg.Fs {
+	case IsolationFsHost:
+		err = syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+		if err != nil {
+			return fmt.Errorf("proc: %w", err)
+		}
+	case IsolationFsReadOnly, IsolationFsStrict:
+		err = setupRoot(cfg)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown fs mode %v", cfg.Fs)
+	}
+
+	err = os.Chdir(cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	err = syscall.Exec(args[0], args, os.Environ())
+	return fmt.Errorf("exec %v: %w", args[0], err)
+}
+
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+
+	ifr.SetUint16(unix.IFF_UP | unix.IFF_RUNNING)
+
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
+
+const (
+	isolationBase    = "/tmp"
+	isolationNewRoot = "/newroot"
+	isolationOldRoot = "/oldroot"
+)
+
+// setupRoot builds the new root in a tmpfs, with the host root reachable in /oldroot while binding paths,
+// then pivots into it
+func setupRoot(cfg isolationConfig) error {
+	err := syscall.Mount("tmpfs", isolationBase, "tmpfs", syscall.MS_NODEV|syscall.MS_NOSUID, "")
+	if err != nil {
+		return fmt.Errorf("tmpfs: %w", err)
+	}
+
+	for _, dir := range []string{isolationNewRoot, isolationOldRoot} {
+		err := os.Mkdir(isolationBase+dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = syscall.PivotRoot(isolationBase, isolationBase+isolationOldRoot)
+	if err != nil {
+		return fmt.Errorf("pivot: %w", err)
+	}
+
+	err = os.Chdir("/")
+	if err != nil {
+		return err
+	}
+
+	if cfg.Fs == IsolationFsReadOnly {
+		err = bindMount(isolationOldRoot, isolationNewRoot, true)
+	} else {
+		// The new root must be a mount point to pivot into it
+		err = syscall.Mount(isolationNewRoot, isolationNewRoot, "", syscall.MS_BIND|syscall.MS_REC, "")
+	}
+	if err != nil {
+		return fmt.Errorf("root: %w", err)
+	}
+
+	err = mountSpecial(cfg.Fs == IsolationFsStrict)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Fs == IsolationFsStrict {
+		allow := append(append([]string{}, DefaultStrictAllow...), cfg.Allow...)
+		sort.Strings(allow)
+
+		for _, p := range allow {
+			err := bindHost(p, p, true)
+			if err != nil {
+				return fmt.Errorf("allow %v: %w", p, err)
+			}
+		}
+
+		for _, tool := range cfg.Tools {
+			err := bindHost(tool.Src, tool.Dst, true)
+			if err != nil {
+				return fmt.Errorf("tool %v: %w", tool.Dst, err)
+			}
+		}
+	}
+
+	for _, p := range cfg.Writable {
+		err := bindHost(p, p, false)
+		if err != nil {
+			return fmt.Errorf("writable %v: %w", p, err)
+		}
+	}
+
+	err = syscall.Unmount(isolationOldRoot, syscall.MNT_DETACH)
+	if err != nil {
+		return fmt.Errorf("umount old root: %w", err)
+	}
+
+	err = os.Chdir(isolationNewRoot)
+	if err != nil {
+		return err
+	}
+
+	err = syscall.PivotRoot(".", ".")
+	if err != nil {
+		return fmt.Errorf("pivot: %w", err)
+	}
+
+	err = syscall.Unmount(".", syscall.MNT_DETACH)
+	if err != nil {
+		return fmt.Errorf("umount base: %w", err)
+	}
+
+	return os.Chdir("/")
+}
+
+// mountSpecial sets up /proc, /tmp, and in strict mode a minimal /dev
+func mountSpecial(strict bool) error {
+	newRoot := func(p string) string {
+		return filepath.Join(isolationNewRoot, p)
+	}
+
+	for _, dir := range []string{"proc", "tmp"} {
+		err := os.MkdirAll(newRoot(dir), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := syscall.Mount("proc", newRoot("proc"), "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	if err != nil {
+		return fmt.Errorf("proc: %w", err)
+	}
+
+	err = syscall.Mount("tmpfs", newRoot("tmp"), "tmpfs", syscall.MS_NODEV|syscall.MS_NOSUID, "")
+	if err != nil {
+		return fmt.Errorf("tmp: %w", err)
+	}
+
+	if !strict {
+		return nil
+	}
+
+	err = os.MkdirAll(newRoot("dev"), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = syscall.Mount("tmpfs", newRoot("dev"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NOEXEC, "mode=0755")
+	if err != nil {
+		return fmt.Errorf("dev: %w", err)
+	}
+
+	for _, dev := range []string{"null", "zero", "full", "random", "urandom", "tty"} {
+		p := filepath.Join("/dev", dev)
+		err := bindHost(p, p, false)
+		if err != nil {
+			return fmt.Errorf("dev: %w", err)
+		}
+	}
+
+	for link, to := range map[string]string{
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	} {
+		err := os.Symlink(to, newRoot(filepath.Join("dev", link)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bindHost exposes the host path src at dst in the new root, missing sources are ignored,
+// read-only binds are skipped if dst is already visible through a parent bind
+func bindHost(src, dst string, readonly bool) error {
+	hsrc := filepath.Join(isolationOldRoot, src)
+	ndst := filepath.Join(isolationNewRoot, dst)
+
+	info, err := os.Lstat(hsrc)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if readonly {
+		if _, err := os.Lstat(ndst); err == nil {
+			return nil
+		}
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		// Merged /usr systems have /lib -> usr/lib, keep the link
+		to, err := os.Readlink(hsrc)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(filepath.Dir(ndst), 0755)
+		if err != nil {
+			return err
+		}
+
+		return os.Symlink(to, ndst)
+	}
+
+	if info.IsDir() {
+		err = os.MkdirAll(ndst, 0755)
+	} else {
+		err = os.MkdirAll(filepath.Dir(ndst), 0755)
+		if err == nil {
+			var f *os.File
+			f, err = os.OpenFile(ndst, os.O_CREATE|os.O_WRONLY, 0644)
+			if err == nil {
+				_ = f.Close()
+			}
+		}
+	}
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	return bindMount(hsrc, ndst, readonly)
+}
+
+func bindMount(src, dst string, readonly bool) error {
+	err := syscall.Mount(src, dst, "", syscall.MS_BIND|syscall.MS_REC, "")
+	if err != nil {
+		return err
+	}
+
+	if !readonly {
+		return nil
+	}
+
+	mounts, err := submounts(dst)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mounts {
+		err := remountReadOnly(m)
+		if err != nil {
+			return fmt.Errorf("remount %v read-only: %w", m, err)
+		}
+	}
+
+	return nil
+}
+
+// remountReadOnly keeps the flags locked by the user namespace, the remount is denied otherwise
+func remountReadOnly(p string) error {
+	var st unix.Statfs_t
+	err := unix.Statfs(p, &st)
+	if err != nil {
+		return err
+	}
+
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for stf, ms := range map[int64]uintptr{
+		unix.ST_NOSUID:      syscall.MS_NOSUID,
+		unix.ST_NODEV:       syscall.MS_NODEV,
+		unix.ST_NOEXEC:      syscall.MS_NOEXEC,
+		unix.ST_NOATIME:     syscall.MS_NOATIME,
+		unix.ST_NODIRATIME:  syscall.MS_NODIRATIME,
+		unix.ST_RELATIME:    syscall.MS_RELATIME,
+		unix.ST_SYNCHRONOUS: syscall.MS_SYNCHRONOUS,
+	} {
+		if int64(st.Flags)&stf != 0 {
+			flags |= ms
+		}
+	}
+
+	return syscall.Mount("", p, "", flags, "")
+}
+
+// submounts lists the mount points at or below root, parents first.
+// It runs after the first pivot, the host proc is still reachable through the old root
+func submounts(root string) ([]string, error) {
+	f, err := os.Open(filepath.Join(isolationOldRoot, "/proc/self/mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mounts := make([]string, 0)
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 {
+			continue
+		}
+
+		p := unescapeMountPath(fields[4])
+		if p == root || strings.HasPrefix(p, root+"/") {
+			mounts = append(mounts, p)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i]) < len(mounts[j])
+	})
+
+	return mounts, nil
+}
+
+// unescapeMountPath decodes the octal escapes (\040 for space...) of mountinfo
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,93 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	Init()
+
+	os.Exit(m.Run())
+}
+
+func execIsolated(t *testing.T, iso Isolation, script string) (string, error) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "_dir")
+
+	iso.Writable = []string{root}
+
+	var out bytes.Buffer
+	cmd, err := Exec(ExecConfig{
+		Context:   context.Background(),
+		Dir:       dir,
+		Env:       map[string]string{"PATH": "/usr/bin:/bin"},
+		IOConfig:  IOConfig{Stdout: &out, Stderr: &out},
+		ExecArgs:  []string{"/bin/sh", "-c", script},
+		Isolation: &iso,
+	})
+	require.NoError(t, err)
+
+	err = cmd.Run()
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EINVAL) {
+		t.Skipf("namespaces unavailable: %v", err)
+	}
+
+	return out.String(), err
+}
+
+func TestIsolationStrict(t *testing.T) {
+	// The host /tmp is replaced, use a file from the repo
+	outside, err := filepath.Abs("isolation.go")
+	require.NoError(t, err)
+
+	out, err := execIsolated(t, Isolation{Fs: IsolationFsStrict}, `
+		echo hello > out.txt && cat out.txt
+		test -e `+outside+` && echo visible
+		echo $$
+	`)
+	require.NoError(t, err, out)
+
+	assert.Equal(t, "hello\n1\n", out)
+}
+
+func TestIsolationReadOnly(t *testing.T) {
+	outside, err := filepath.Abs("isolation.go")
+	require.NoError(t, err)
+
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	out, err := execIsolated(t, Isolation{Fs: IsolationFsReadOnly}, `
+		head -n 1 `+outside+`
+		touch `+home+`/heph_isolation_test 2>/dev/null && echo written
+		echo ok > out.txt && cat out.txt
+	`)
+	require.NoError(t, err, out)
+
+	assert.Equal(t, "package sandbox\nok\n", out)
+	assert.NoFileExists(t, filepath.Join(home, "heph_isolation_test"))
+}
+
+func TestIsolationNoNetwork(t *testing.T) {
+	out, err := execIsolated(t, Isolation{Fs: IsolationFsHost}, `grep -o "^ *[a-z0-9]*:" /proc/net/dev | tr -d " "`)
+	require.NoError(t, err, out)
+
+	assert.Equal(t, "lo:\n", out)
+}
+
+func TestIsolationExitCode(t *testing.T) {
+	_, err := execIsolated(t, Isolation{Fs: IsolationFsStrict}, `exit 3`)
+
+	var eerr *exec.ExitError
+	require.ErrorAs(t, err, &eerr)
+	assert.Equal(t, 3, eerr.ExitCode())
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+func isolate(cmd *exec.Cmd, cfg ExecConfig) error {
+	return fmt.Errorf("sandbox isolation is not supported on %v", runtime.GOOS)
+}
+
+func isolationInit(string, []string) error {
+	return fmt.Errorf("sandbox isolation is not supported on %v", runtime.GOOS)
+}
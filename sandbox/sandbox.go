@@ -163,6 +163,10 @@ type ExecConfig struct {
 	Dir      string
 	Env      map[string]string
 	ExecArgs []string
+	// Isolation runs the command in namespaces, only supported on linux
+	Isolation *Isolation
+	// TraceFile records the files read by the command, see ReadTraceFile, only supported on linux
+	TraceFile string
 }
 
 func AddPathEnv(env map[string]string, binDir string, isolatePath bool) {
@@ -189,7 +193,7 @@ func envMapToArray(m map[string]string) []string {
 	return env
 }
 
-func Exec(cfg ExecConfig) *exec.Cmd {
+func Exec(cfg ExecConfig) (*exec.Cmd, error) {
 	args := cfg.ExecArgs
 
 	err := os.MkdirAll(cfg.Dir, os.ModePerm)
@@ -212,5 +216,23 @@ func Exec(cfg ExecConfig) *exec.Cmd {
 		cmd.SysProcAttr.Setpgid = false
 	}
 
-	return cmd
+	if cfg.Isolation != nil && cfg.TraceFile != "" {
+		return nil, fmt.Errorf("isolation and tracing are incompatible")
+	}
+
+	if cfg.Isolation != nil {
+		err := isolate(cmd, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("isolation: %w", err)
+		}
+	}
+
+	if cfg.TraceFile != "" {
+		err := traceCmd(cmd, cfg.TraceFile)
+		if err != nil {
+			return nil, fmt.Errorf("trace: %w", err)
+		}
+	}
+
+	return cmd, nil
 }
@@ -0,0 +1,46 @@
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"os/exec"
+)
+
+const traceArg0 = "heph-sandbox-trace"
+
+// traceCmd makes cmd run through the tracer helper, which writes the files opened for reading
+// by the command and its children to traceFile, one per line
+func traceCmd(cmd *exec.Cmd, traceFile string) error {
+	if !traceSupported {
+		return errTraceNotSupported
+	}
+
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = append([]string{traceArg0, traceFile, "--"}, cmd.Args...)
+
+	return nil
+}
+
+// ReadTraceFile returns the paths recorded by a traced Exec
+func ReadTraceFile(traceFile string) ([]string, error) {
+	f, err := os.Open(traceFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	paths := make([]string, 0)
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if p := sc.Text(); p != "" {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths, sc.Err()
+}
@@ -0,0 +1,257 @@
+//go:build linux && (amd64 || arm64)
+
+package sandbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const traceSupported = true
+
+var errTraceNotSupported error
+
+type traceState struct {
+	// path is recorded on syscall exit, if it succeeded
+	path string
+}
+
+// traceInit runs the command under ptrace, following forks, and records the files opened for reading
+func traceInit(traceFile string, args []string) (int, error) {
+	runtime.LockOSThread()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Args = args
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
+
+	err := cmd.Start()
+	if err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+
+	// The child stops with SIGTRAP once exec'ed
+	var ws unix.WaitStatus
+	_, err = unix.Wait4(pid, &ws, unix.WALL, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	err = unix.PtraceSetOptions(pid, unix.PTRACE_O_TRACESYSGOOD|unix.PTRACE_O_TRACEFORK|unix.PTRACE_O_TRACEVFORK|
+		unix.PTRACE_O_TRACECLONE|unix.PTRACE_O_TRACEEXEC|unix.PTRACE_O_EXITKILL)
+	if err != nil {
+		return 0, fmt.Errorf("ptrace: %w", err)
+	}
+
+	err = unix.PtraceSyscall(pid, 0)
+	if err != nil {
+		return 0, fmt.Errorf("ptrace: %w", err)
+	}
+
+	tracees := map[int]*traceState{pid: {}}
+	paths := map[string]struct{}{}
+	code := 0
+
+	for {
+		wpid, err := unix.Wait4(-1, &ws, unix.WALL, nil)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			if errors.Is(err, unix.ECHILD) {
+				break
+			}
+			return 0, err
+		}
+
+		if ws.Exited() || ws.Signaled() {
+			if wpid == pid {
+				if ws.Exited() {
+					code = ws.ExitStatus()
+				} else {
+					code = 128 + int(ws.Signal())
+				}
+			}
+			delete(tracees, wpid)
+			continue
+		}
+
+		if !ws.Stopped() {
+			continue
+		}
+
+		st, known := tracees[wpid]
+		if !known {
+			st = &traceState{}
+			tracees[wpid] = st
+		}
+
+		inject := 0
+		switch sig := ws.StopSignal(); {
+		case sig == unix.SIGTRAP|0x80:
+			traceSyscall(wpid, st, paths)
+		case sig == unix.SIGTRAP:
+			// ptrace event (fork, exec...)
+		case sig == unix.SIGSTOP && !known:
+			// New tracees start with a SIGSTOP
+		default:
+			inject = int(sig)
+		}
+
+		// The tracee may have been killed in the meantime
+		_ = unix.PtraceSyscall(wpid, inject)
+	}
+
+	err = writeTraceFile(traceFile, paths)
+	if err != nil {
+		return 0, err
+	}
+
+	return code, nil
+}
+
+func writeTraceFile(traceFile string, paths map[string]struct{}) error {
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var sb strings.Builder
+	for _, p := range sorted {
+		sb.WriteString(p)
+		sb.WriteString("\n")
+	}
+
+	return os.WriteFile(traceFile, []byte(sb.String()), os.ModePerm)
+}
+
+// ptrace_syscall_info, entry and exit share the union at offset 24
+type syscallInfo [88]byte
+
+const (
+	syscallInfoOp   = 0
+	syscallInfoData = 24
+)
+
+func (i *syscallInfo) u64(offset int) uint64 {
+	return binary.LittleEndian.Uint64(i[offset : offset+8])
+}
+
+func (i *syscallInfo) arg(n int) uint64 {
+	return i.u64(syscallInfoData + 8 + n*8)
+}
+
+func getSyscallInfo(pid int) (*syscallInfo, error) {
+	var info syscallInfo
+	_, _, errno := unix.Syscall6(unix.SYS_PTRACE, unix.PTRACE_GET_SYSCALL_INFO, uintptr(pid), unsafe.Sizeof(info), uintptr(unsafe.Pointer(&info)), 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	return &info, nil
+}
+
+func traceSyscall(pid int, st *traceState, paths map[string]struct{}) {
+	info, err := getSyscallInfo(pid)
+	if err != nil {
+		return
+	}
+
+	switch info[syscallInfoOp] {
+	case unix.PTRACE_SYSCALL_INFO_ENTRY:
+		st.path = syscallPath(pid, info)
+	case unix.PTRACE_SYSCALL_INFO_EXIT:
+		if st.path != "" && int64(info.u64(syscallInfoData)) >= 0 {
+			paths[st.path] = struct{}{}
+		}
+		st.path = ""
+	}
+}
+
+// syscallPath returns the path being opened for reading or executed, if any
+func syscallPath(pid int, info *syscallInfo) string {
+	dirfd := int32(unix.AT_FDCWD)
+	var addr, flags uint64
+
+	switch int64(info.u64(syscallInfoData)) {
+	case sysOpen:
+		addr, flags = info.arg(0), info.arg(1)
+	case unix.SYS_OPENAT:
+		dirfd, addr, flags = int32(info.arg(0)), info.arg(1), info.arg(2)
+	case unix.SYS_OPENAT2:
+		// flags is the first field of open_how
+		var how [8]byte
+		_, err := unix.PtracePeekData(pid, uintptr(info.arg(2)), how[:])
+		if err != nil {
+			return ""
+		}
+		dirfd, addr, flags = int32(info.arg(0)), info.arg(1), binary.LittleEndian.Uint64(how[:])
+	case unix.SYS_EXECVE:
+		addr = info.arg(0)
+	case unix.SYS_EXECVEAT:
+		dirfd, addr = int32(info.arg(0)), info.arg(1)
+	default:
+		return ""
+	}
+
+	if flags&unix.O_ACCMODE == unix.O_WRONLY {
+		return ""
+	}
+
+	p := peekString(pid, uintptr(addr))
+	if p == "" {
+		return ""
+	}
+
+	if !filepath.IsAbs(p) {
+		link := filepath.Join("/proc", strconv.Itoa(pid), "cwd")
+		if dirfd != unix.AT_FDCWD {
+			link = filepath.Join("/proc", strconv.Itoa(pid), "fd", strconv.Itoa(int(dirfd)))
+		}
+
+		base, err := os.Readlink(link)
+		if err != nil {
+			return ""
+		}
+
+		p = filepath.Join(base, p)
+	}
+
+	return filepath.Clean(p)
+}
+
+func peekString(pid int, addr uintptr) string {
+	var sb strings.Builder
+	buf := make([]byte, 64)
+
+	for sb.Len() < unix.PathMax {
+		n, err := unix.PtracePeekData(pid, addr, buf)
+
+		if i := strings.IndexByte(string(buf[:n]), 0); i >= 0 {
+			sb.Write(buf[:i])
+			return sb.String()
+		}
+
+		if n == 0 || err != nil {
+			break
+		}
+
+		sb.Write(buf[:n])
+		addr += uintptr(n)
+	}
+
+	return ""
+}
@@ -0,0 +1,7 @@
+package sandbox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const sysOpen = unix.SYS_OPEN
@@ -0,0 +1,4 @@
+package sandbox
+
+// arm64 only has openat
+const sysOpen = -1
@@ -0,0 +1,71 @@
+//go:build linux && (amd64 || arm64)
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func execTraced(t *testing.T, dir, script string) ([]string, string, error) {
+	traceFile := filepath.Join(t.TempDir(), "trace")
+
+	var out bytes.Buffer
+	cmd, err := Exec(ExecConfig{
+		Context:   context.Background(),
+		Dir:       dir,
+		Env:       map[string]string{"PATH": "/usr/bin:/bin"},
+		IOConfig:  IOConfig{Stdout: &out, Stderr: &out},
+		ExecArgs:  []string{"/bin/sh", "-c", script},
+		TraceFile: traceFile,
+	})
+	require.NoError(t, err)
+
+	err = cmd.Run()
+	if errors.Is(err, syscall.EPERM) {
+		t.Skipf("ptrace unavailable: %v", err)
+	}
+
+	paths, rerr := ReadTraceFile(traceFile)
+	require.NoError(t, rerr)
+
+	return paths, out.String(), err
+}
+
+func TestTrace(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "in.txt"), []byte("in"), os.ModePerm))
+
+	other := filepath.Join(t.TempDir(), "other.txt")
+	require.NoError(t, os.WriteFile(other, []byte("other"), os.ModePerm))
+
+	paths, out, err := execTraced(t, dir, `
+		cat in.txt
+		(cat `+other+`)
+		cat missing.txt 2>/dev/null
+		echo out > out.txt
+	`)
+	require.NoError(t, err, out)
+
+	assert.Equal(t, "inother", out)
+	assert.Contains(t, paths, filepath.Join(dir, "in.txt"))
+	assert.Contains(t, paths, other)
+	assert.NotContains(t, paths, filepath.Join(dir, "missing.txt"))
+	assert.NotContains(t, paths, filepath.Join(dir, "out.txt"))
+}
+
+func TestTraceExitCode(t *testing.T) {
+	_, _, err := execTraced(t, t.TempDir(), `exit 3`)
+
+	var eerr *exec.ExitError
+	require.ErrorAs(t, err, &eerr)
+	assert.Equal(t, 3, eerr.ExitCode())
+}
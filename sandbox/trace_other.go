@@ -0,0 +1,16 @@
+//go:build !linux || !(amd64 || arm64)
+
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+)
+
+const traceSupported = false
+
+var errTraceNotSupported = fmt.Errorf("tracing is not supported on %v/%v", runtime.GOOS, runtime.GOARCH)
+
+func traceInit(string, []string) (int, error) {
+	return 0, errTraceNotSupported
+}
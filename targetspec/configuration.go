@@ -0,0 +1,171 @@
+package targetspec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	ConfigurationOs   = "os"
+	ConfigurationArch = "arch"
+	ConfigurationTags = "tags"
+)
+
+var configurationValueRegex = []byte(Alphanum + `-._`)
+
+// Configuration is what the BUILD files of a package get evaluated under, the zero value being the host.
+// Targets evaluated under a configuration are named `name{arch=arm64,os=linux}`
+type Configuration struct {
+	Os     string
+	Arch   string
+	Tags   []string
+	Params map[string]string
+}
+
+func (c Configuration) IsZero() bool {
+	return c.Os == "" && c.Arch == "" && len(c.Tags) == 0 && len(c.Params) == 0
+}
+
+// Merge returns c with the values set in o applied
+func (c Configuration) Merge(o Configuration) Configuration {
+	r := Configuration{
+		Os:   c.Os,
+		Arch: c.Arch,
+		Tags: c.Tags,
+	}
+
+	if o.Os != "" {
+		r.Os = o.Os
+	}
+	if o.Arch != "" {
+		r.Arch = o.Arch
+	}
+	if o.Tags != nil {
+		r.Tags = o.Tags
+	}
+
+	if len(c.Params) > 0 || len(o.Params) > 0 {
+		r.Params = map[string]string{}
+		for k, v := range c.Params {
+			r.Params[k] = v
+		}
+		for k, v := range o.Params {
+			r.Params[k] = v
+		}
+	}
+
+	return r
+}
+
+func (c Configuration) Validate() error {
+	values := map[string]string{
+		ConfigurationOs:   c.Os,
+		ConfigurationArch: c.Arch,
+	}
+	for k, v := range c.Params {
+		if k == ConfigurationOs || k == ConfigurationArch || k == ConfigurationTags {
+			return fmt.Errorf("param %v is reserved", k)
+		}
+		if k == "" || !ContainsOnly(k, configurationValueRegex) {
+			return fmt.Errorf("param name must match: %s (got %v)", configurationValueRegex, k)
+		}
+
+		values[k] = v
+	}
+	for _, tag := range c.Tags {
+		if tag == "" || !ContainsOnly(tag, configurationValueRegex) {
+			return fmt.Errorf("tags must match: %s (got %v)", configurationValueRegex, tag)
+		}
+	}
+
+	for k, v := range values {
+		if !ContainsOnly(v, configurationValueRegex) {
+			return fmt.Errorf("%v must match: %s (got %v)", k, configurationValueRegex, v)
+		}
+	}
+
+	return nil
+}
+
+// String returns the sorted `key=value` pairs of the configuration, tags are joined with `+`
+func (c Configuration) String() string {
+	values := map[string]string{}
+	if c.Os != "" {
+		values[ConfigurationOs] = c.Os
+	}
+	if c.Arch != "" {
+		values[ConfigurationArch] = c.Arch
+	}
+	if len(c.Tags) > 0 {
+		tags := append([]string(nil), c.Tags...)
+		sort.Strings(tags)
+		values[ConfigurationTags] = strings.Join(tags, "+")
+	}
+	for k, v := range c.Params {
+		values[k] = v
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+values[k])
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// TargetName returns the name of the target declared as name under the configuration
+func (c Configuration) TargetName(name string) string {
+	if c.IsZero() {
+		return name
+	}
+
+	return name + "{" + c.String() + "}"
+}
+
+// ParseConfiguredName splits a name returned by Configuration.TargetName, ok is false if it is not configured
+func ParseConfiguredName(name string) (_ string, _ Configuration, ok bool, _ error) {
+	i := strings.LastIndex(name, "{")
+	if i < 0 || !strings.HasSuffix(name, "}") {
+		return name, Configuration{}, false, nil
+	}
+
+	var c Configuration
+	for _, part := range strings.Split(name[i+1:len(name)-1], ",") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return "", Configuration{}, false, fmt.Errorf("%v: invalid configuration, expected key=value, got %v", name, part)
+		}
+
+		switch k {
+		case ConfigurationOs:
+			c.Os = v
+		case ConfigurationArch:
+			c.Arch = v
+		case ConfigurationTags:
+			c.Tags = strings.Split(v, "+")
+		default:
+			if c.Params == nil {
+				c.Params = map[string]string{}
+			}
+			c.Params[k] = v
+		}
+	}
+
+	err := c.Validate()
+	if err != nil {
+		return "", Configuration{}, false, fmt.Errorf("%v: %w", name, err)
+	}
+
+	return name[:i], c, true, nil
+}
+
+func (c Configuration) Equal(o Configuration) bool {
+	return c.String() == o.String()
+}
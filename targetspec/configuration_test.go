@@ -0,0 +1,48 @@
+package targetspec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConfigurationTargetName(t *testing.T) {
+	t.Parallel()
+
+	c := Configuration{
+		Os:     "linux",
+		Arch:   "arm64",
+		Tags:   []string{"osusergo", "netgo"},
+		Params: map[string]string{"mode": "release"},
+	}
+
+	name := c.TargetName("bin")
+	assert.Equal(t, "bin{arch=arm64,mode=release,os=linux,tags=netgo+osusergo}", name)
+
+	tp, err := TargetParse("some/pkg", ":"+name)
+	assert.NoError(t, err)
+
+	base, pc, ok, err := ParseConfiguredName(tp.Name)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bin", base)
+	assert.True(t, c.Equal(pc))
+
+	_, _, ok, err = ParseConfiguredName("bin")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, _, err = ParseConfiguredName("bin{os}")
+	assert.ErrorContains(t, err, "expected key=value")
+
+	assert.Equal(t, "bin", Configuration{}.TargetName("bin"))
+}
+
+func TestConfigurationMerge(t *testing.T) {
+	t.Parallel()
+
+	c := Configuration{Os: "linux", Arch: "amd64", Params: map[string]string{"a": "1"}}
+	m := c.Merge(Configuration{Arch: "arm64", Tags: []string{"netgo"}, Params: map[string]string{"b": "2"}})
+
+	assert.Equal(t, "a=1,arch=arm64,b=2,os=linux,tags=netgo", m.String())
+	assert.Equal(t, "a=1,arch=amd64,os=linux", c.String())
+}
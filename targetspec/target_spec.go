@@ -58,6 +58,12 @@ func (e TargetSpecSrcEnv) Get(name string) string {
 
 const SupportFilesOutput = "@support_files"
 
+const (
+	TestLabel = "test"
+	// TestResultsOutput is the named output holding the go test -json, JUnit or TAP results of a test target
+	TestResultsOutput = "test_results"
+)
+
 func SortOutputsForHashing(names []string) []string {
 	names = utils.CopyArray(names)
 	sort.Slice(names, func(i, j int) bool {
@@ -113,6 +119,7 @@ type TargetSpec struct {
 	RestoreCache        bool
 	HasSupportFiles     bool
 	Sandbox             bool
+	Isolation           TargetSpecIsolation
 	OutInSandbox        bool
 	Codegen             string
 	Labels              []string
@@ -128,6 +135,17 @@ type TargetSpec struct {
 	HashFile            string
 	Transitive          TargetSpecTransitive
 	Timeout             time.Duration
+	Providers           []TargetSpecProvider
+	// Configuration is the one the target got declared under
+	Configuration Configuration
+	// Transition is applied to the configuration of the deps, see heph.transition()
+	Transition Configuration
+}
+
+// TargetSpecProvider is data attached to the target for its dependents, see provider()
+type TargetSpecProvider struct {
+	Name   string
+	Fields map[string]json.RawMessage
 }
 
 type TargetPlatform struct {
@@ -156,6 +174,10 @@ func (t TargetSpec) IsTool() bool {
 	return len(t.Run) > 0 && t.Run[0] == "heph_tool"
 }
 
+func (t TargetSpec) IsTest() bool {
+	return utils.Contains(t.Labels, TestLabel)
+}
+
 func (t TargetSpec) IsTextFile() bool {
 	return len(t.Run) == 2 && t.Run[0] == "text_file"
 }
@@ -275,10 +297,19 @@ type TargetSpecOutFile struct {
 	Path string
 }
 
-type TargetSpecCache struct {
+// TargetSpecIsolation configures the namespaces the sandbox runs in, see heph.sandbox()
+type TargetSpecIsolation struct {
 	Enabled bool
-	Named   []string
-	History int
+	Network bool
+	Fs      string
+	Allow   []string
+}
+
+type TargetSpecCache struct {
+	Enabled     bool
+	Named       []string
+	History     int
+	Compression string
 }
 
 func (c TargetSpecCache) NamedEnabled(name string) bool {
@@ -1,5 +1,7 @@
 package targetspec
 
+import "bytes"
+
 func basicArrEqual[T any](a, b []T) bool {
 	if (a == nil || b == nil) && (a != nil || b != nil) {
 		return false
@@ -127,6 +129,10 @@ func (t TargetSpec) equalStruct(spec TargetSpec) bool {
 		return false
 	}
 
+	if !t.Isolation.Equal(spec.Isolation) {
+		return false
+	}
+
 	if t.Codegen != spec.Codegen {
 		return false
 	}
@@ -175,6 +181,36 @@ func (t TargetSpec) equalStruct(spec TargetSpec) bool {
 		return false
 	}
 
+	if !arrEqualFunc(t.Providers, spec.Providers) {
+		return false
+	}
+
+	if !t.Configuration.Equal(spec.Configuration) {
+		return false
+	}
+
+	if !t.Transition.Equal(spec.Transition) {
+		return false
+	}
+
+	return true
+}
+
+func (this TargetSpecProvider) Equal(that TargetSpecProvider) bool {
+	if this.Name != that.Name {
+		return false
+	}
+
+	if len(this.Fields) != len(that.Fields) {
+		return false
+	}
+
+	for k, v := range this.Fields {
+		if !bytes.Equal(v, that.Fields[k]) {
+			return false
+		}
+	}
+
 	return true
 }
 
@@ -187,6 +223,10 @@ func (this TargetSpecCache) Equal(that TargetSpecCache) bool {
 		return false
 	}
 
+	if this.Compression != that.Compression {
+		return false
+	}
+
 	if !arrEqual(this.Named, that.Named) {
 		return false
 	}
@@ -194,6 +234,26 @@ func (this TargetSpecCache) Equal(that TargetSpecCache) bool {
 	return true
 }
 
+func (this TargetSpecIsolation) Equal(that TargetSpecIsolation) bool {
+	if this.Enabled != that.Enabled {
+		return false
+	}
+
+	if this.Network != that.Network {
+		return false
+	}
+
+	if this.Fs != that.Fs {
+		return false
+	}
+
+	if !arrEqual(this.Allow, that.Allow) {
+		return false
+	}
+
+	return true
+}
+
 func (this TargetSpecTools) Equal(that TargetSpecTools) bool {
 	if !arrEqual(this.Targets, that.Targets) {
 		return false
@@ -17,7 +17,6 @@ func ValidateRemoteCache(root, tgt string, outputs []string, expectLogs bool) er
 	}
 	for _, output := range outputs {
 		expected = append(expected, "hash_out_"+output)
-		expected = append(expected, "out_.tar.gz"+output)
 	}
 
 	tp, err := ParseTargetPath(tgt)
@@ -32,5 +31,23 @@ func ValidateRemoteCache(root, tgt string, outputs []string, expectLogs bool) er
 
 	tgtroot := filepath.Join(root, tp.Package, tp.Name, hash)
 
-	return validateFolderContent(tgtroot, expected)
+	err = validateFolderContent(tgtroot, expected)
+	if err != nil {
+		return err
+	}
+
+	// Output tarballs are stored content-addressed
+	for _, output := range outputs {
+		outHash, err := FileContent(filepath.Join(tgtroot, "hash_out_"+output))
+		if err != nil {
+			return err
+		}
+
+		blob := filepath.Join(root, "_cas", outHash+".tar.gz")
+		if !PathExists(blob) {
+			return fmt.Errorf("%v doesnt exist", blob)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,113 @@
+package testresults
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// goTestEvent is the event emitted by go test -json, see go doc test2json
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+func ParseGoTestJSON(r io.Reader) ([]Case, error) {
+	type key struct{ pkg, test string }
+
+	cases := make([]*Case, 0)
+	index := map[key]*Case{}
+	output := map[key]*strings.Builder{}
+
+	get := func(k key) *Case {
+		if c, ok := index[k]; ok {
+			return c
+		}
+
+		c := &Case{Name: k.test, Classname: k.pkg}
+		index[k] = c
+		output[k] = &strings.Builder{}
+		cases = append(cases, c)
+
+		return c
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		var e goTestEvent
+		err := json.Unmarshal([]byte(line), &e)
+		if err != nil {
+			return nil, fmt.Errorf("go test json: %w", err)
+		}
+
+		k := key{e.Package, e.Test}
+		c := get(k)
+
+		switch e.Action {
+		case "output":
+			output[k].WriteString(e.Output)
+		case "pass":
+			c.Status = StatusPassed
+		case "fail":
+			c.Status = StatusFailed
+		case "skip":
+			c.Status = StatusSkipped
+		}
+
+		switch e.Action {
+		case "pass", "fail", "skip":
+			c.Duration = time.Duration(e.Elapsed * float64(time.Second))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	res := make([]Case, 0, len(cases))
+	for _, c := range cases {
+		k := key{c.Classname, c.Name}
+
+		if c.Name == "" {
+			// The package result is only a case if it failed outside of its tests (build, panic in init...)
+			if c.Status != StatusFailed || hasFailure(cases, c.Classname) {
+				continue
+			}
+			c.Name = c.Classname
+		}
+
+		if c.Status == "" {
+			// Started but never finished, the binary probably crashed
+			c.Status = StatusFailed
+		}
+
+		if c.Status == StatusFailed {
+			c.Output = output[k].String()
+		}
+
+		res = append(res, *c)
+	}
+
+	return res, nil
+}
+
+func hasFailure(cases []*Case, pkg string) bool {
+	for _, c := range cases {
+		if c.Classname == pkg && c.Name != "" && c.Status != StatusPassed && c.Status != StatusSkipped {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,183 @@
+package testresults
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	XMLName  xml.Name         `xml:"testsuite"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Cases    []junitTestCase  `xml:"testcase"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func junitTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+func parseJunitTime(s string) time.Duration {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+
+	return time.Duration(f * float64(time.Second))
+}
+
+func ParseJUnit(r io.Reader) ([]Case, error) {
+	dec := xml.NewDecoder(r)
+
+	cases := make([]Case, 0)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				return cases, nil
+			}
+			return nil, fmt.Errorf("junit: %w", err)
+		}
+
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "testsuite" {
+			continue
+		}
+
+		var suite junitTestSuite
+		err = dec.DecodeElement(&suite, &se)
+		if err != nil {
+			return nil, fmt.Errorf("junit: %w", err)
+		}
+
+		cases = append(cases, junitSuiteCases(suite)...)
+	}
+}
+
+func junitSuiteCases(suite junitTestSuite) []Case {
+	cases := make([]Case, 0, len(suite.Cases))
+	for _, tc := range suite.Cases {
+		c := Case{
+			Name:      tc.Name,
+			Classname: tc.Classname,
+			Status:    StatusPassed,
+			Duration:  parseJunitTime(tc.Time),
+		}
+		if c.Classname == "" {
+			c.Classname = suite.Name
+		}
+
+		for _, m := range []*junitMessage{tc.Failure, tc.Error} {
+			if m == nil {
+				continue
+			}
+
+			parts := make([]string, 0, 3)
+			for _, s := range []string{m.Message, m.Text, tc.SystemOut} {
+				if s := strings.TrimSpace(s); s != "" {
+					parts = append(parts, s)
+				}
+			}
+
+			c.Status = StatusFailed
+			c.Output = strings.Join(parts, "\n")
+			break
+		}
+
+		if c.Status == StatusPassed && tc.Skipped != nil {
+			c.Status = StatusSkipped
+		}
+
+		cases = append(cases, c)
+	}
+
+	for _, s := range suite.Suites {
+		cases = append(cases, junitSuiteCases(s)...)
+	}
+
+	return cases
+}
+
+// WriteJUnit writes the suites as a JUnit XML report
+func WriteJUnit(w io.Writer, suites []Suite) error {
+	report := junitTestSuites{}
+
+	var total time.Duration
+	for _, s := range suites {
+		js := junitTestSuite{
+			Name:     s.Name,
+			Tests:    len(s.Cases),
+			Failures: s.Count(StatusFailed),
+			Skipped:  s.Count(StatusSkipped),
+			Time:     junitTime(s.Duration),
+		}
+
+		for _, c := range s.Cases {
+			jc := junitTestCase{
+				Name:      c.Name,
+				Classname: c.Classname,
+				Time:      junitTime(c.Duration),
+			}
+
+			switch c.Status {
+			case StatusFailed:
+				jc.Failure = &junitMessage{Text: c.Output}
+			case StatusSkipped:
+				jc.Skipped = &junitMessage{}
+			}
+
+			js.Cases = append(js.Cases, jc)
+		}
+
+		report.Tests += js.Tests
+		report.Failures += js.Failures
+		report.Skipped += js.Skipped
+		total += s.Duration
+		report.Suites = append(report.Suites, js)
+	}
+	report.Time = junitTime(total)
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(report)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,67 @@
+package testresults
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var tapResultRegex = regexp.MustCompile(`^(not ok|ok)\b\s*(\d+)?\s*(?:-\s*)?([^#]*)(?:#\s*(\w+)\b.*)?$`)
+
+// ParseTAP reads the Test Anything Protocol, diagnostics following a failed test are used as its output
+func ParseTAP(r io.Reader) ([]Case, error) {
+	cases := make([]Case, 0)
+	var diag *strings.Builder
+
+	flush := func() {
+		if diag != nil && len(cases) > 0 {
+			cases[len(cases)-1].Output = strings.TrimSpace(diag.String())
+		}
+		diag = nil
+	}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+
+		m := tapResultRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil || strings.HasPrefix(line, " ") {
+			if diag != nil {
+				diag.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "#"), "  "))
+				diag.WriteString("\n")
+			}
+			continue
+		}
+
+		flush()
+
+		c := Case{
+			Name:   strings.TrimSpace(m[3]),
+			Status: StatusPassed,
+		}
+		if c.Name == "" {
+			c.Name = "test " + m[2]
+		}
+
+		switch strings.ToUpper(m[4]) {
+		case "SKIP":
+			c.Status = StatusSkipped
+		case "TODO":
+			// Failures of TODO tests are expected
+		default:
+			if m[1] == "not ok" {
+				c.Status = StatusFailed
+				diag = &strings.Builder{}
+			}
+		}
+
+		cases = append(cases, c)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	return cases, nil
+}
@@ -0,0 +1,94 @@
+package testresults
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPassed  Status = "passed"
+	StatusFailed  Status = "failed"
+	StatusSkipped Status = "skipped"
+)
+
+type Case struct {
+	Name      string
+	Classname string
+	Status    Status
+	Duration  time.Duration
+	// Output is the failure message and output of the case
+	Output string
+}
+
+// Suite holds the cases of a target
+type Suite struct {
+	Name     string
+	Cases    []Case
+	Duration time.Duration
+	// Attempts is the number of runs it took to get the results, a suite passing after more than one is flaky
+	Attempts int
+	// Cached is true when the suite comes from a previous pass
+	Cached bool
+}
+
+func (s Suite) Flaky() bool {
+	return s.Attempts > 1 && s.Count(StatusFailed) == 0
+}
+
+func (s Suite) Count(status Status) int {
+	c := 0
+	for _, tc := range s.Cases {
+		if tc.Status == status {
+			c++
+		}
+	}
+
+	return c
+}
+
+// Parse reads go test -json, JUnit XML or TAP, detected from the content
+func Parse(r io.Reader) ([]Case, error) {
+	br := bufio.NewReader(r)
+
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = br.ReadByte()
+			continue
+		case '<':
+			return ParseJUnit(br)
+		case '{':
+			return ParseGoTestJSON(br)
+		default:
+			return ParseTAP(br)
+		}
+	}
+}
+
+func ParseFile(path string) ([]Case, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cases, err := Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	return cases, nil
+}
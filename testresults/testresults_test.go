@@ -0,0 +1,108 @@
+package testresults
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func statuses(cases []Case) map[string]Status {
+	m := map[string]Status{}
+	for _, c := range cases {
+		m[c.Name] = c.Status
+	}
+
+	return m
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected map[string]Status
+		failure  string
+	}{
+		{
+			name: "go test json",
+			input: `
+{"Action":"run","Package":"pkg","Test":"TestA"}
+{"Action":"output","Package":"pkg","Test":"TestA","Output":"=== RUN   TestA\n"}
+{"Action":"pass","Package":"pkg","Test":"TestA","Elapsed":0.1}
+{"Action":"run","Package":"pkg","Test":"TestB"}
+{"Action":"output","Package":"pkg","Test":"TestB","Output":"    b_test.go:12: boom\n"}
+{"Action":"fail","Package":"pkg","Test":"TestB","Elapsed":0.2}
+{"Action":"run","Package":"pkg","Test":"TestC"}
+{"Action":"skip","Package":"pkg","Test":"TestC","Elapsed":0}
+{"Action":"fail","Package":"pkg","Elapsed":0.3}
+`,
+			expected: map[string]Status{"TestA": StatusPassed, "TestB": StatusFailed, "TestC": StatusSkipped},
+			failure:  "boom",
+		},
+		{
+			name: "junit",
+			input: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="suite">
+    <testcase name="a" time="0.1"/>
+    <testcase name="b" classname="cls"><failure message="expected 1">got 2</failure></testcase>
+    <testcase name="c"><skipped/></testcase>
+  </testsuite>
+</testsuites>`,
+			expected: map[string]Status{"a": StatusPassed, "b": StatusFailed, "c": StatusSkipped},
+			failure:  "got 2",
+		},
+		{
+			name: "tap",
+			input: `TAP version 13
+1..4
+ok 1 - a
+not ok 2 - b
+  ---
+  message: boom
+  ...
+ok 3 - c # SKIP not today
+not ok 4 - d # TODO later
+`,
+			expected: map[string]Status{"a": StatusPassed, "b": StatusFailed, "c": StatusSkipped, "d": StatusPassed},
+			failure:  "boom",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cases, err := Parse(strings.NewReader(test.input))
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expected, statuses(cases))
+
+			for _, c := range cases {
+				if c.Status == StatusFailed {
+					assert.Contains(t, c.Output, test.failure)
+				}
+			}
+		})
+	}
+}
+
+func TestJUnitRoundTrip(t *testing.T) {
+	suites := []Suite{{
+		Name: "//some:test",
+		Cases: []Case{
+			{Name: "a", Classname: "pkg", Status: StatusPassed, Duration: time.Second},
+			{Name: "b", Classname: "pkg", Status: StatusFailed, Output: "boom"},
+			{Name: "c", Classname: "pkg", Status: StatusSkipped},
+		},
+	}}
+
+	var buf bytes.Buffer
+	err := WriteJUnit(&buf, suites)
+	require.NoError(t, err)
+
+	cases, err := Parse(&buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, suites[0].Cases, cases)
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"syscall"
 )
 
 func RandPath(base, prefix, suffix string) string {
@@ -72,3 +73,29 @@ func WriteFileSync(name string, data []byte, perm os.FileMode) error {
 	}
 	return err
 }
+
+// LinkCount returns the number of hard links pointing to the file, if the platform exposes it
+func LinkCount(info os.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(st.Nlink), true
+}
+
+// Inode identifies a file, shared by its hard links
+type Inode struct {
+	Dev uint64
+	Ino uint64
+}
+
+// FileInode returns the inode of the file, if the platform exposes it
+func FileInode(info os.FileInfo) (Inode, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return Inode{}, false
+	}
+
+	return Inode{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, true
+}
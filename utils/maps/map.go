@@ -82,7 +82,7 @@ func (m *Map[K, V]) Keys() []K {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	ks := make([]K, len(m.m))
+	ks := make([]K, 0, len(m.m))
 
 	for k := range m.m {
 		ks = append(ks, k)
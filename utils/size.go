@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []string{"B", "KB", "MB", "GB", "TB"}
+
+// ParseSize parses sizes such as `512MB` or `10G`, units are powers of 1024
+func ParseSize(s string) (int64, error) {
+	v := strings.TrimSuffix(strings.TrimSpace(strings.ToUpper(s)), "B")
+	v = strings.TrimSuffix(v, "I")
+
+	mult := int64(1)
+	if len(v) > 0 {
+		if i := strings.IndexByte("KMGT", v[len(v)-1]); i >= 0 {
+			v = v[:len(v)-1]
+			for ; i >= 0; i-- {
+				mult *= 1024
+			}
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size `%v`", s)
+	}
+
+	return int64(n * float64(mult)), nil
+}
+
+func FormatSize(n int64) string {
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(sizeUnits)-1 {
+		f /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+
+	return strconv.FormatFloat(f, 'f', 1, 64) + sizeUnits[i]
+}
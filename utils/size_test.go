@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s string
+		e int64
+	}{
+		{"100", 100},
+		{"100B", 100},
+		{"2K", 2048},
+		{"1.5MB", 1536 * 1024},
+		{"10G", 10 * 1024 * 1024 * 1024},
+		{"1GiB", 1024 * 1024 * 1024},
+		{"1tb", 1024 * 1024 * 1024 * 1024},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			actual, err := ParseSize(test.s)
+			require.NoError(t, err)
+			assert.Equal(t, test.e, actual)
+		})
+	}
+
+	_, err := ParseSize("10X")
+	assert.Error(t, err)
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "100B", FormatSize(100))
+	assert.Equal(t, "1.5KB", FormatSize(1536))
+	assert.Equal(t, "10.0GB", FormatSize(10*1024*1024*1024))
+}
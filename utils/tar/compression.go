@@ -0,0 +1,141 @@
+package tar
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+type Compression string
+
+const (
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+	// CompressionNone is meant for outputs already compressed
+	CompressionNone Compression = "none"
+)
+
+var CompressionValues = []string{string(CompressionGzip), string(CompressionZstd), string(CompressionNone)}
+
+// Ext returns the extension of archives compressed with c, so that they can be told apart in a cache
+func (c Compression) Ext() string {
+	switch c {
+	case CompressionZstd:
+		return ".tar.zst"
+	case CompressionNone:
+		return ".tar"
+	default:
+		return ".tar.gz"
+	}
+}
+
+func (c Compression) Validate() error {
+	switch c {
+	case "", CompressionGzip, CompressionZstd, CompressionNone:
+		return nil
+	}
+
+	return fmt.Errorf("invalid compression %v, must be one of %v", c, CompressionValues)
+}
+
+// ValidateLevel checks that c supports the compression level, 0 being the codec default
+func (c Compression) ValidateLevel(level int) error {
+	if level == 0 {
+		return nil
+	}
+
+	switch c {
+	case CompressionZstd:
+		if level < 1 || level > 22 {
+			return fmt.Errorf("invalid zstd compression level %v, must be between 1 and 22", level)
+		}
+	case CompressionNone:
+		return fmt.Errorf("compression level %v requires a compression", level)
+	default:
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return fmt.Errorf("invalid gzip compression level %v, must be between %v and %v", level, gzip.HuffmanOnly, gzip.BestCompression)
+		}
+	}
+
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func compressWriter(w io.Writer, o TarOptions) (io.WriteCloser, error) {
+	switch o.Compression {
+	case CompressionZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if o.Level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(o.Level)))
+		}
+
+		return zstd.NewWriter(w, opts...)
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	default:
+		level := gzip.DefaultCompression
+		if o.Level != 0 {
+			level = o.Level
+		}
+
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+
+		if o.Deterministic {
+			// No name, no mtime, unknown OS
+			gw.Header = gzip.Header{OS: 255}
+		}
+
+		return gw, nil
+	}
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (r zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+// decompressReader detects the compression from the magic bytes, archives of any codec can be read regardless of their name
+func decompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+
+		return zstdReadCloser{zr}, nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
@@ -2,10 +2,10 @@ package tar
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
+	"golang.org/x/exp/slices"
 	log "heph/hlog"
 	fs2 "heph/utils/fs"
 	"heph/utils/sets"
@@ -13,6 +13,9 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 type TarFile struct {
@@ -20,7 +23,50 @@ type TarFile struct {
 	To   string
 }
 
-func tarWriteEntry(file TarFile, tw *tar.Writer, info os.FileInfo) error {
+type TarOptions struct {
+	// Deterministic produces the same archive for the same content: entries are sorted,
+	// mtimes zeroed, ownership dropped and permissions normalised to 0644/0755
+	Deterministic bool
+	// Compression defaults to gzip
+	Compression Compression
+	// Level of compression, the codec default if 0
+	Level int
+}
+
+// DeterministicModTime is the mtime of the entries of deterministic archives
+var DeterministicModTime = time.Unix(0, 0)
+
+type tarEntry struct {
+	file TarFile
+	info os.FileInfo
+}
+
+func normalizeHeader(hdr *tar.Header) *tar.Header {
+	mode := int64(0644)
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		mode = 0777
+	case tar.TypeDir:
+		mode = 0755
+	default:
+		if hdr.Mode&0111 != 0 {
+			mode = 0755
+		}
+	}
+
+	return &tar.Header{
+		Typeflag: hdr.Typeflag,
+		Name:     hdr.Name,
+		Linkname: hdr.Linkname,
+		Size:     hdr.Size,
+		Mode:     mode,
+		ModTime:  DeterministicModTime,
+	}
+}
+
+func tarWriteEntry(entry tarEntry, tw *tar.Writer, o TarOptions) error {
+	file, info := entry.file, entry.info
+
 	var link string
 	if info.Mode().Type() == os.ModeSymlink {
 		l, err := os.Readlink(file.From)
@@ -42,6 +88,10 @@ func tarWriteEntry(file TarFile, tw *tar.Writer, info os.FileInfo) error {
 
 	hdr.Name = file.To
 
+	if o.Deterministic {
+		hdr = normalizeHeader(hdr)
+	}
+
 	if err := tw.WriteHeader(hdr); err != nil {
 		return err
 	}
@@ -63,8 +113,9 @@ func tarWriteEntry(file TarFile, tw *tar.Writer, info os.FileInfo) error {
 	return nil
 }
 
-func tarWriteDir(file TarFile, tw *tar.Writer) error {
-	return filepath.WalkDir(file.From, func(path string, d fs.DirEntry, err error) error {
+func tarDirEntries(file TarFile) ([]tarEntry, error) {
+	entries := make([]tarEntry, 0)
+	err := filepath.WalkDir(file.From, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -83,14 +134,25 @@ func tarWriteDir(file TarFile, tw *tar.Writer) error {
 			return err
 		}
 
-		return tarWriteEntry(TarFile{
-			From: path,
-			To:   filepath.Join(file.To, rel),
-		}, tw, info)
+		entries = append(entries, tarEntry{
+			file: TarFile{
+				From: path,
+				To:   filepath.Join(file.To, rel),
+			},
+			info: info,
+		})
+
+		return nil
 	})
+
+	return entries, err
 }
 
 func Tar(ctx context.Context, files []TarFile, out string) error {
+	return TarWith(ctx, files, out, TarOptions{})
+}
+
+func TarWith(ctx context.Context, files []TarFile, out string, o TarOptions) error {
 	outTmp := out + ".tmp"
 
 	tarf, err := os.Create(outTmp)
@@ -104,14 +166,22 @@ func Tar(ctx context.Context, files []TarFile, out string) error {
 		tarf.Close()
 	}()
 
-	gw := gzip.NewWriter(tarf)
-	defer gw.Close()
+	cw, err := compressWriter(tarf, o)
+	if err != nil {
+		return fmt.Errorf("tar: %w", err)
+	}
 
-	err = doTar(gw, files)
+	err = doTar(cw, files, o)
 	if err != nil {
+		_ = cw.Close()
 		return err
 	}
 
+	err = cw.Close()
+	if err != nil {
+		return fmt.Errorf("tar: %w", err)
+	}
+
 	err = os.Rename(outTmp, out)
 	if err != nil {
 		return err
@@ -120,10 +190,11 @@ func Tar(ctx context.Context, files []TarFile, out string) error {
 	return nil
 }
 
-func doTar(w io.Writer, files []TarFile) error {
+func doTar(w io.Writer, files []TarFile, o TarOptions) error {
 	tw := tar.NewWriter(w)
 	defer tw.Close()
 
+	entries := make([]tarEntry, 0, len(files))
 	for _, file := range files {
 		info, err := os.Lstat(file.From)
 		if err != nil {
@@ -131,14 +202,26 @@ func doTar(w io.Writer, files []TarFile) error {
 		}
 
 		if info.IsDir() {
-			err := tarWriteDir(file, tw)
+			dirEntries, err := tarDirEntries(file)
 			if err != nil {
 				return fmt.Errorf("tar: %w", err)
 			}
+
+			entries = append(entries, dirEntries...)
 			continue
 		}
 
-		err = tarWriteEntry(file, tw, info)
+		entries = append(entries, tarEntry{file: file, info: info})
+	}
+
+	if o.Deterministic {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].file.To < entries[j].file.To
+		})
+	}
+
+	for _, entry := range entries {
+		err := tarWriteEntry(entry, tw, o)
 		if err != nil {
 			return fmt.Errorf("tar: %w", err)
 		}
@@ -200,8 +283,14 @@ func UntarWith(ctx context.Context, in, to string, o UntarOptions) (err error) {
 		}()
 	}
 
+	// Symlinks extracted so far, an entry must not be written through one of them
+	links := make([]string, 0)
+
 	return Walk(ctx, in, func(hdr *tar.Header, tr *tar.Reader) error {
-		dest := filepath.Join(to, hdr.Name)
+		dest, err := untarDest(to, hdr.Name, links)
+		if err != nil {
+			return err
+		}
 
 		if o.Dedup != nil {
 			if o.Dedup.Has(dest) {
@@ -210,11 +299,16 @@ func UntarWith(ctx context.Context, in, to string, o UntarOptions) (err error) {
 			o.Dedup.Add(dest)
 		}
 
-		err := fs2.CreateParentDir(dest)
+		err = fs2.CreateParentDir(dest)
 		if err != nil {
 			return err
 		}
 
+		// An entry replacing a symlink of the archive would be written through it
+		if hdr.Typeflag != tar.TypeSymlink && slices.Contains(links, dest) {
+			return fmt.Errorf("untar: %v: overwrites symlink", hdr.Name)
+		}
+
 		switch hdr.Typeflag {
 		case tar.TypeReg:
 			err = untarFile(hdr, tr, dest, o.RO)
@@ -233,13 +327,19 @@ func UntarWith(ctx context.Context, in, to string, o UntarOptions) (err error) {
 				return fmt.Errorf("untar: symlink empty for %v", hdr.Name)
 			}
 
+			err := untarLinkTarget(to, dest, hdr)
+			if err != nil {
+				return err
+			}
+
 			recordFile(hdr.Name)
+			links = append(links, dest)
 
 			if fs2.PathExists(dest) {
 				return nil
 			}
 
-			err := os.Symlink(hdr.Linkname, dest)
+			err = os.Symlink(hdr.Linkname, dest)
 			if err != nil {
 				return fmt.Errorf("untar: %w", err)
 			}
@@ -251,6 +351,45 @@ func UntarWith(ctx context.Context, in, to string, o UntarOptions) (err error) {
 	})
 }
 
+// untarDest returns where the entry is extracted, rejecting names escaping the root,
+// directly or through a symlink extracted earlier
+func untarDest(to, name string, links []string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("untar: %v: absolute path", name)
+	}
+
+	dest := filepath.Join(to, name)
+
+	rel, err := filepath.Rel(to, dest)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("untar: %v: outside of %v", name, to)
+	}
+
+	for _, link := range links {
+		if strings.HasPrefix(dest, link+string(filepath.Separator)) {
+			return "", fmt.Errorf("untar: %v: through symlink %v", name, link)
+		}
+	}
+
+	return dest, nil
+}
+
+// untarLinkTarget rejects symlinks pointing outside the root
+func untarLinkTarget(to, dest string, hdr *tar.Header) error {
+	if filepath.IsAbs(hdr.Linkname) {
+		return fmt.Errorf("untar: %v: symlink to absolute path %v", hdr.Name, hdr.Linkname)
+	}
+
+	target := filepath.Join(filepath.Dir(dest), hdr.Linkname)
+
+	rel, err := filepath.Rel(to, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("untar: %v: symlink %v outside of %v", hdr.Name, hdr.Linkname, to)
+	}
+
+	return nil
+}
+
 func UntarList(ctx context.Context, in string) ([]string, error) {
 	listPath := in + ".list"
 	if fs2.PathExists(listPath) {
@@ -296,13 +435,13 @@ func Walk(ctx context.Context, path string, fs ...func(*tar.Header, *tar.Reader)
 		tarf.Close()
 	}()
 
-	gr, err := gzip.NewReader(tarf)
+	cr, err := decompressReader(tarf)
 	if err != nil {
 		return err
 	}
-	defer gr.Close()
+	defer cr.Close()
 
-	tr := tar.NewReader(gr)
+	tr := tar.NewReader(cr)
 
 	for {
 		hdr, err := tr.Next()
@@ -334,6 +473,14 @@ func Walk(ctx context.Context, path string, fs ...func(*tar.Header, *tar.Reader)
 }
 
 func untarFile(hdr *tar.Header, tr *tar.Reader, to string, ro bool) error {
+	// Replace a symlink left on disk rather than writing to what it points to
+	if info, err := os.Lstat(to); err == nil && info.Mode().Type() == os.ModeSymlink {
+		err := os.Remove(to)
+		if err != nil {
+			return err
+		}
+	}
+
 	f, err := os.OpenFile(to, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
 	if err != nil {
 		return err
@@ -358,9 +505,12 @@ func untarFile(hdr *tar.Header, tr *tar.Reader, to string, ro bool) error {
 		return err
 	}
 
-	err = os.Chtimes(to, hdr.AccessTime, hdr.ModTime)
-	if err != nil {
-		return err
+	// Entries of deterministic archives carry no mtime, keep the extraction time
+	if !hdr.ModTime.Equal(DeterministicModTime) {
+		err = os.Chtimes(to, hdr.AccessTime, hdr.ModTime)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -0,0 +1,188 @@
+package tar
+
+import (
+	"archive/tar"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTree(t *testing.T, mtime time.Time, perm os.FileMode) string {
+	dir := t.TempDir()
+
+	for _, name := range []string{"b", "a", "sub/c"} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		require.NoError(t, os.WriteFile(p, []byte(name), perm))
+		require.NoError(t, os.Chmod(p, perm))
+		require.NoError(t, os.Chtimes(p, mtime, mtime))
+	}
+	require.NoError(t, os.Symlink("a", filepath.Join(dir, "link")))
+
+	return dir
+}
+
+func TestTarDeterministic(t *testing.T) {
+	ctx := context.Background()
+	o := TarOptions{Deterministic: true}
+
+	dir1 := writeTree(t, time.Now(), 0640)
+	dir2 := writeTree(t, time.Now().Add(-time.Hour), 0600)
+
+	out1 := filepath.Join(t.TempDir(), "out.tar.gz")
+	err := TarWith(ctx, []TarFile{
+		{From: filepath.Join(dir1, "sub"), To: "sub"},
+		{From: filepath.Join(dir1, "b"), To: "b"},
+		{From: filepath.Join(dir1, "a"), To: "a"},
+		{From: filepath.Join(dir1, "link"), To: "link"},
+	}, out1, o)
+	require.NoError(t, err)
+
+	out2 := filepath.Join(t.TempDir(), "out.tar.gz")
+	err = TarWith(ctx, []TarFile{
+		{From: filepath.Join(dir2, "a"), To: "a"},
+		{From: filepath.Join(dir2, "link"), To: "link"},
+		{From: filepath.Join(dir2, "b"), To: "b"},
+		{From: filepath.Join(dir2, "sub"), To: "sub"},
+	}, out2, o)
+	require.NoError(t, err)
+
+	b1, err := os.ReadFile(out1)
+	require.NoError(t, err)
+	b2, err := os.ReadFile(out2)
+	require.NoError(t, err)
+	assert.Equal(t, b1, b2)
+
+	to := t.TempDir()
+	before := time.Now().Add(-time.Minute)
+	err = Untar(ctx, out1, to, false)
+	require.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(to, "sub/c"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+	assert.True(t, info.ModTime().After(before))
+}
+
+func TestTarCompression(t *testing.T) {
+	ctx := context.Background()
+	dir := writeTree(t, time.Now(), 0644)
+
+	for _, c := range CompressionValues {
+		c := Compression(c)
+		t.Run(string(c), func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out"+c.Ext())
+			err := TarWith(ctx, []TarFile{{From: dir, To: "dir"}}, out, TarOptions{Compression: c, Level: 1})
+			require.NoError(t, err)
+
+			files, err := UntarList(ctx, out)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"dir/a", "dir/b", "dir/sub/c", "dir/link"}, files)
+
+			to := t.TempDir()
+			err = Untar(ctx, out, to, false)
+			require.NoError(t, err)
+
+			b, err := os.ReadFile(filepath.Join(to, "dir/sub/c"))
+			require.NoError(t, err)
+			assert.Equal(t, "sub/c", string(b))
+		})
+	}
+}
+
+func TestCompressionValidateLevel(t *testing.T) {
+	assert.NoError(t, CompressionGzip.ValidateLevel(0))
+	assert.NoError(t, CompressionGzip.ValidateLevel(9))
+	assert.Error(t, CompressionGzip.ValidateLevel(19))
+	assert.NoError(t, CompressionZstd.ValidateLevel(19))
+	assert.Error(t, CompressionZstd.ValidateLevel(-1))
+	assert.NoError(t, CompressionNone.ValidateLevel(0))
+	assert.Error(t, CompressionNone.ValidateLevel(3))
+}
+
+func TestUntarEscape(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		entries []tar.Header
+		err     string
+	}{
+		{"parent", []tar.Header{{Name: "../evil", Typeflag: tar.TypeReg}}, "outside of"},
+		{"nested parent", []tar.Header{{Name: "a/../../evil", Typeflag: tar.TypeReg}}, "outside of"},
+		{"absolute", []tar.Header{{Name: "/evil", Typeflag: tar.TypeReg}}, "absolute path"},
+		{"through symlink", []tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "link/evil", Typeflag: tar.TypeReg},
+		}, "through symlink"},
+		{"symlink absolute", []tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/evil"}}, "symlink to absolute path"},
+		{"symlink escaping root", []tar.Header{{Name: "a/link", Typeflag: tar.TypeSymlink, Linkname: "../../evil"}}, "outside of"},
+		{"symlink then file", []tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "a"},
+			{Name: "link", Typeflag: tar.TypeReg},
+		}, "overwrites symlink"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := filepath.Join(t.TempDir(), "in.tar")
+
+			f, err := os.Create(in)
+			require.NoError(t, err)
+			tw := tar.NewWriter(f)
+			for _, hdr := range test.entries {
+				hdr := hdr
+				hdr.Mode = 0644
+				require.NoError(t, tw.WriteHeader(&hdr))
+			}
+			require.NoError(t, tw.Close())
+			require.NoError(t, f.Close())
+
+			root := t.TempDir()
+			to := filepath.Join(root, "to")
+
+			err = Untar(ctx, in, to, false)
+			assert.ErrorContains(t, err, test.err)
+			assert.NoFileExists(t, filepath.Join(root, "evil"))
+		})
+	}
+}
+
+func TestUntarExistingSymlink(t *testing.T) {
+	ctx := context.Background()
+
+	victim := filepath.Join(t.TempDir(), "victim")
+	require.NoError(t, os.WriteFile(victim, []byte("victim"), os.ModePerm))
+
+	to := t.TempDir()
+	require.NoError(t, os.Symlink(victim, filepath.Join(to, "a")))
+
+	in := filepath.Join(t.TempDir(), "in.tar")
+	f, err := os.Create(in)
+	require.NoError(t, err)
+	tw := tar.NewWriter(f)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeReg, Mode: 0644, Size: 3}))
+	_, err = tw.Write([]byte("new"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, f.Close())
+
+	err = Untar(ctx, in, to, false)
+	require.NoError(t, err)
+
+	// The link is replaced, not written through
+	b, err := os.ReadFile(victim)
+	require.NoError(t, err)
+	assert.Equal(t, "victim", string(b))
+
+	info, err := os.Lstat(filepath.Join(to, "a"))
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+
+	b, err = os.ReadFile(filepath.Join(to, "a"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(b))
+}
@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -41,3 +43,17 @@ func FormatDuration(d time.Duration) string {
 
 	return RoundDuration(d, 0).String()
 }
+
+// ParseAge parses a duration, with support for days such as `7d`
+func ParseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid age `%v`", s)
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,130 @@
+package httpcache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"heph/vfssimple/objfs"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type memHandler struct {
+	m        sync.Mutex
+	files    map[string][]byte
+	failures int
+	auth     string
+}
+
+func (h *memHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.auth != "" && r.Header.Get("Authorization") != h.auth {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if h.failures > 0 {
+		h.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		h.files[r.URL.Path] = b
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		b, ok := h.files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(b)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestFileSystem(t *testing.T, h *memHandler) (*objfs.FileSystem, string) {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	fs := objfs.NewFileSystem(name, Scheme, func(scheme, volume string) (objfs.Store, error) {
+		s := NewStore(scheme, volume)
+		s.Backoff = 0
+		return s, nil
+	})
+
+	return fs, strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Setenv(TokenEnv, "secret")
+
+	h := &memHandler{files: map[string][]byte{}, auth: "Bearer secret", failures: 2}
+	fs, volume := newTestFileSystem(t, h)
+
+	loc, err := fs.NewLocation(volume, "/pkg/target/abc/")
+	require.NoError(t, err)
+
+	for _, content := range []string{"", "hello"} {
+		f, err := loc.NewFile("out_" + content)
+		require.NoError(t, err)
+
+		exists, err := f.Exists()
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		exists, err = f.Exists()
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		b, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		assert.Equal(t, content, string(b))
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	h := &memHandler{files: map[string][]byte{}, auth: "Basic dXNlcjpwYXNz"} // user:pass
+	fs, volume := newTestFileSystem(t, h)
+
+	f, err := fs.NewFile("user:pass@"+volume, "/some/file")
+	require.NoError(t, err)
+	require.NoError(t, f.Touch())
+
+	f, err = fs.NewFile(volume, "/some/file")
+	require.NoError(t, err)
+	_, err = f.Exists()
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestDeleteNotImplemented(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotImplemented)
+	}))
+	t.Cleanup(srv.Close)
+
+	s := NewStore(Scheme, strings.TrimPrefix(srv.URL, "http://"))
+	s.Backoff = 0
+
+	err := s.Delete("/some/file")
+	assert.ErrorIs(t, err, objfs.ErrNotSupported)
+	// Not retried, the server does not support it
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,157 @@
+// Package mem implements an in-memory vfs backend on top of objfs.
+// Unlike the upstream one, it can be walked, which remote cache gc relies on.
+package mem
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/c2fo/vfs/v6/backend"
+	"heph/vfssimple/objfs"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Scheme = "mem"
+	name   = "In-Memory Filesystem"
+)
+
+func init() {
+	backend.Register(Scheme, NewFileSystem())
+}
+
+func NewFileSystem() *objfs.FileSystem {
+	return objfs.NewFileSystem(name, Scheme, func(scheme, volume string) (objfs.Store, error) {
+		return NewStore(), nil
+	})
+}
+
+type object struct {
+	data    []byte
+	modTime time.Time
+}
+
+// Store keeps the objects of a volume in memory, by absolute path
+type Store struct {
+	m       sync.Mutex
+	objects map[string]object
+}
+
+func NewStore() *Store {
+	return &Store{objects: map[string]object{}}
+}
+
+func (s *Store) get(p string) (object, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	o, ok := s.objects[p]
+	if !ok {
+		return object{}, fmt.Errorf("%v: %w", p, os.ErrNotExist)
+	}
+
+	return o, nil
+}
+
+func (s *Store) Stat(p string) (objfs.ObjectInfo, error) {
+	o, err := s.get(p)
+	if err != nil {
+		return objfs.ObjectInfo{}, err
+	}
+
+	return objfs.ObjectInfo{Size: uint64(len(o.data)), ModTime: o.modTime}, nil
+}
+
+func (s *Store) Open(p string) (io.ReadCloser, error) {
+	o, err := s.get(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(o.data)), nil
+}
+
+func (s *Store) Put(p string, r io.ReadSeeker, size int64) error {
+	data := make([]byte, size)
+	_, err := io.ReadFull(r, data)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.objects[p] = object{data: data, modTime: time.Now()}
+
+	return nil
+}
+
+func (s *Store) Delete(p string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.objects[p]; !ok {
+		return fmt.Errorf("%v: %w", p, os.ErrNotExist)
+	}
+
+	delete(s.objects, p)
+
+	return nil
+}
+
+func (s *Store) List(prefix string) ([]string, error) {
+	names := make([]string, 0)
+	err := s.Walk(prefix, func(name string, _ objfs.ObjectInfo) error {
+		if !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+		return nil
+	})
+
+	return names, err
+}
+
+// Walk calls fn for every object under prefix, sorted by path
+func (s *Store) Walk(prefix string, fn func(name string, info objfs.ObjectInfo) error) error {
+	s.m.Lock()
+	paths := make([]string, 0)
+	infos := map[string]objfs.ObjectInfo{}
+	for p, o := range s.objects {
+		if strings.HasPrefix(p, prefix) {
+			paths = append(paths, p)
+			infos[p] = objfs.ObjectInfo{Size: uint64(len(o.data)), ModTime: o.modTime}
+		}
+	}
+	s.m.Unlock()
+
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		err := fn(strings.TrimPrefix(p, prefix), infos[p])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Chtimes changes the modification time of the object, there is no other way to store an object in the past
+func (s *Store) Chtimes(p string, mtime time.Time) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	o, ok := s.objects[p]
+	if !ok {
+		return fmt.Errorf("%v: %w", p, os.ErrNotExist)
+	}
+
+	o.modTime = mtime
+	s.objects[p] = o
+
+	return nil
+}
@@ -0,0 +1,314 @@
+// Package reapi implements a vfs backend on top of the Bazel Remote Execution API caches.
+//
+// Every file is stored as an entry of the ActionCache, keyed by the digest of its path,
+// whose single output file points to the content stored in the CAS.
+package reapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/c2fo/vfs/v6/backend"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"heph/vfssimple/objfs"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	Scheme       = "grpc"
+	SchemeSecure = "grpcs"
+	name         = "Remote Execution API Cache"
+)
+
+// InstanceNameEnv allows selecting the REAPI instance name used by the backend
+const InstanceNameEnv = "HEPH_REAPI_INSTANCE_NAME"
+
+const chunkSize = 1024 * 1024
+
+func init() {
+	backend.Register(Scheme, NewFileSystem(Scheme))
+	backend.Register(SchemeSecure, NewFileSystem(SchemeSecure))
+}
+
+func NewFileSystem(scheme string) *objfs.FileSystem {
+	return objfs.NewFileSystem(name, scheme, func(scheme, volume string) (objfs.Store, error) {
+		return Dial(volume, scheme == SchemeSecure)
+	})
+}
+
+type Store struct {
+	Instance string
+
+	conn *grpc.ClientConn
+	ac   repb.ActionCacheClient
+	cas  repb.ContentAddressableStorageClient
+	bs   bytestream.ByteStreamClient
+}
+
+func Dial(addr string, secure bool) (*Store, error) {
+	var creds credentials.TransportCredentials
+	if secure {
+		creds = credentials.NewTLS(&tls.Config{})
+	} else {
+		creds = insecure.NewCredentials()
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("dial %v: %w", addr, err)
+	}
+
+	return NewStore(conn, os.Getenv(InstanceNameEnv)), nil
+}
+
+func NewStore(conn *grpc.ClientConn, instance string) *Store {
+	return &Store{
+		Instance: instance,
+		conn:     conn,
+		ac:       repb.NewActionCacheClient(conn),
+		cas:      repb.NewContentAddressableStorageClient(conn),
+		bs:       bytestream.NewByteStreamClient(conn),
+	}
+}
+
+func (s *Store) Close() error {
+	return s.conn.Close()
+}
+
+// keyDigest is the digest of the action cache key of p, sized as the key it hashes
+func keyDigest(p string) *repb.Digest {
+	key := []byte("heph:" + p)
+	h := sha256.Sum256(key)
+
+	return &repb.Digest{
+		Hash:      hex.EncodeToString(h[:]),
+		SizeBytes: int64(len(key)),
+	}
+}
+
+func (s *Store) resourceName(parts ...string) string {
+	if s.Instance == "" {
+		return path.Join(parts...)
+	}
+
+	return path.Join(append([]string{s.Instance}, parts...)...)
+}
+
+func (s *Store) getActionResult(p string) (*repb.OutputFile, *repb.ActionResult, error) {
+	res, err := s.ac.GetActionResult(context.Background(), &repb.GetActionResultRequest{
+		InstanceName: s.Instance,
+		ActionDigest: keyDigest(p),
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil, fmt.Errorf("%v: %w", p, os.ErrNotExist)
+		}
+		return nil, nil, err
+	}
+
+	if len(res.OutputFiles) != 1 || res.OutputFiles[0].Digest == nil {
+		return nil, nil, fmt.Errorf("%v: malformed action result", p)
+	}
+
+	return res.OutputFiles[0], res, nil
+}
+
+func (s *Store) Stat(p string) (objfs.ObjectInfo, error) {
+	f, res, err := s.getActionResult(p)
+	if err != nil {
+		return objfs.ObjectInfo{}, err
+	}
+
+	info := objfs.ObjectInfo{
+		Size: uint64(f.Digest.SizeBytes),
+	}
+	if md := res.ExecutionMetadata; md != nil && md.WorkerCompletedTimestamp != nil {
+		info.ModTime = md.WorkerCompletedTimestamp.AsTime()
+	}
+
+	return info, nil
+}
+
+func (s *Store) Open(p string) (io.ReadCloser, error) {
+	f, _, err := s.getActionResult(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Digest.SizeBytes == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream, err := s.bs.Read(ctx, &bytestream.ReadRequest{
+		ResourceName: s.resourceName("blobs", f.Digest.Hash, fmt.Sprint(f.Digest.SizeBytes)),
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &readCloser{stream: stream, cancel: cancel}, nil
+}
+
+type readCloser struct {
+	stream bytestream.ByteStream_ReadClient
+	cancel context.CancelFunc
+	buf    []byte
+}
+
+func (r *readCloser) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		res, err := r.stream.Recv()
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return 0, fmt.Errorf("blob: %w", os.ErrNotExist)
+			}
+			return 0, err
+		}
+		r.buf = res.Data
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+func (r *readCloser) Close() error {
+	r.cancel()
+	return nil
+}
+
+func (s *Store) Put(p string, r io.ReadSeeker, size int64) error {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+
+	digest := &repb.Digest{
+		Hash:      hex.EncodeToString(h.Sum(nil)),
+		SizeBytes: size,
+	}
+
+	if size > 0 {
+		_, err = r.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		err = s.uploadBlob(digest, r)
+		if err != nil {
+			return fmt.Errorf("upload: %w", err)
+		}
+	}
+
+	_, err = s.ac.UpdateActionResult(context.Background(), &repb.UpdateActionResultRequest{
+		InstanceName: s.Instance,
+		ActionDigest: keyDigest(p),
+		ActionResult: &repb.ActionResult{
+			OutputFiles: []*repb.OutputFile{{
+				Path:   path.Base(p),
+				Digest: digest,
+			}},
+			ExecutionMetadata: &repb.ExecutedActionMetadata{
+				Worker:                   "heph",
+				WorkerCompletedTimestamp: timestamppb.New(time.Now()),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("update action result: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) uploadBlob(digest *repb.Digest, r io.Reader) error {
+	ctx := context.Background()
+
+	missing, err := s.cas.FindMissingBlobs(ctx, &repb.FindMissingBlobsRequest{
+		InstanceName: s.Instance,
+		BlobDigests:  []*repb.Digest{digest},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(missing.MissingBlobDigests) == 0 {
+		return nil
+	}
+
+	stream, err := s.bs.Write(ctx)
+	if err != nil {
+		return err
+	}
+
+	resourceName := s.resourceName("uploads", uuid.New().String(), "blobs", digest.Hash, fmt.Sprint(digest.SizeBytes))
+
+	buf := make([]byte, chunkSize)
+	var offset int64
+	for {
+		n, rerr := io.ReadFull(r, buf)
+		if rerr != nil && !errors.Is(rerr, io.ErrUnexpectedEOF) && !errors.Is(rerr, io.EOF) {
+			return rerr
+		}
+
+		finish := offset+int64(n) >= digest.SizeBytes
+		err := stream.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			WriteOffset:  offset,
+			Data:         buf[:n],
+			FinishWrite:  finish,
+		})
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// The server closed the stream, the actual error will be returned by CloseAndRecv
+				break
+			}
+			return err
+		}
+		offset += int64(n)
+
+		if finish || rerr != nil {
+			break
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+
+	if res.CommittedSize != digest.SizeBytes {
+		return fmt.Errorf("committed %v bytes, expected %v", res.CommittedSize, digest.SizeBytes)
+	}
+
+	return nil
+}
+
+// Delete is not supported, the REAPI has no way to remove entries, they are expected to expire server-side
+func (s *Store) Delete(p string) error {
+	return fmt.Errorf("delete %v: %w", p, objfs.ErrNotSupported)
+}
+
+func (s *Store) List(p string) ([]string, error) {
+	return nil, fmt.Errorf("list %v: %w", p, objfs.ErrNotSupported)
+}
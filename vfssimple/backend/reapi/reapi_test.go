@@ -0,0 +1,172 @@
+package reapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/c2fo/vfs/v6/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type memServer struct {
+	repb.UnimplementedActionCacheServer
+	repb.UnimplementedContentAddressableStorageServer
+	bytestream.UnimplementedByteStreamServer
+
+	m     sync.Mutex
+	ac    map[string]*repb.ActionResult
+	blobs map[string][]byte
+}
+
+func (s *memServer) GetActionResult(_ context.Context, req *repb.GetActionResultRequest) (*repb.ActionResult, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	res, ok := s.ac[req.ActionDigest.Hash]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+
+	return res, nil
+}
+
+func (s *memServer) UpdateActionResult(_ context.Context, req *repb.UpdateActionResultRequest) (*repb.ActionResult, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.ac[req.ActionDigest.Hash] = req.ActionResult
+
+	return req.ActionResult, nil
+}
+
+func (s *memServer) FindMissingBlobs(_ context.Context, req *repb.FindMissingBlobsRequest) (*repb.FindMissingBlobsResponse, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	res := &repb.FindMissingBlobsResponse{}
+	for _, d := range req.BlobDigests {
+		if _, ok := s.blobs[d.Hash]; !ok {
+			res.MissingBlobDigests = append(res.MissingBlobDigests, d)
+		}
+	}
+
+	return res, nil
+}
+
+func (s *memServer) Read(req *bytestream.ReadRequest, stream bytestream.ByteStream_ReadServer) error {
+	parts := strings.Split(req.ResourceName, "/")
+
+	s.m.Lock()
+	b, ok := s.blobs[parts[len(parts)-2]]
+	s.m.Unlock()
+	if !ok {
+		return status.Error(codes.NotFound, "not found")
+	}
+
+	return stream.Send(&bytestream.ReadResponse{Data: b})
+}
+
+func (s *memServer) Write(stream bytestream.ByteStream_WriteServer) error {
+	var buf bytes.Buffer
+	var name string
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if req.ResourceName != "" {
+			name = req.ResourceName
+		}
+		buf.Write(req.Data)
+		if req.FinishWrite {
+			break
+		}
+	}
+
+	parts := strings.Split(name, "/")
+
+	s.m.Lock()
+	s.blobs[parts[len(parts)-2]] = buf.Bytes()
+	s.m.Unlock()
+
+	return stream.SendAndClose(&bytestream.WriteResponse{CommittedSize: int64(buf.Len())})
+}
+
+func startServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &memServer{
+		ac:    map[string]*repb.ActionResult{},
+		blobs: map[string][]byte{},
+	}
+
+	srv := grpc.NewServer()
+	repb.RegisterActionCacheServer(srv, s)
+	repb.RegisterContentAddressableStorageServer(srv, s)
+	bytestream.RegisterByteStreamServer(srv, s)
+
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+
+	return l.Addr().String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	addr := startServer(t)
+
+	fs := backend.Backend(Scheme)
+
+	loc, err := fs.NewLocation(addr, "/some/pkg/target/abc/")
+	require.NoError(t, err)
+
+	assert.Equal(t, fmt.Sprintf("grpc://%v/some/pkg/target/abc/", addr), loc.URI())
+
+	for _, content := range []string{"", "hello", strings.Repeat("a", 3*chunkSize+10)} {
+		f, err := loc.NewFile("out_" + fmt.Sprint(len(content)))
+		require.NoError(t, err)
+
+		exists, err := f.Exists()
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		exists, err = f.Exists()
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		size, err := f.Size()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(len(content)), size)
+
+		b, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		assert.Equal(t, content, string(b))
+	}
+}
+
+func TestKeyDigest(t *testing.T) {
+	d := keyDigest("/pkg/target/abc/out")
+
+	assert.Len(t, d.Hash, 64)
+	assert.Equal(t, int64(len("heph:/pkg/target/abc/out")), d.SizeBytes)
+	assert.NotEqual(t, d.Hash, keyDigest("/pkg/target/abc/other").Hash)
+}
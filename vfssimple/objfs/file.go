@@ -0,0 +1,232 @@
+package objfs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/c2fo/vfs/v6"
+	"github.com/c2fo/vfs/v6/options"
+	"github.com/c2fo/vfs/v6/utils"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// File implements vfs.File, writes are buffered in a temporary file and uploaded on Close
+type File struct {
+	fs     *FileSystem
+	store  Store
+	volume string
+	path   string
+
+	reader io.ReadCloser
+	read   bool
+
+	writer *os.File
+}
+
+func (f *File) Close() error {
+	var err error
+	if f.reader != nil {
+		err = f.reader.Close()
+		f.reader = nil
+	}
+	f.read = false
+
+	if f.writer != nil {
+		w := f.writer
+		f.writer = nil
+		defer os.Remove(w.Name())
+		defer w.Close()
+
+		size, serr := w.Seek(0, io.SeekEnd)
+		if serr != nil {
+			return serr
+		}
+
+		_, serr = w.Seek(0, io.SeekStart)
+		if serr != nil {
+			return serr
+		}
+
+		perr := f.store.Put(f.path, w, size)
+		if perr != nil {
+			return fmt.Errorf("put %v: %w", f.URI(), perr)
+		}
+	}
+
+	return err
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	if f.writer != nil {
+		return 0, errors.New("cannot read a file being written")
+	}
+
+	if f.reader == nil {
+		r, err := f.store.Open(f.path)
+		if err != nil {
+			return 0, err
+		}
+		f.reader = r
+	}
+	f.read = true
+
+	return f.reader.Read(p)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	// Only rewinding before any read is supported, that is all vfs copy helpers need
+	if offset == 0 && (whence == io.SeekCurrent || whence == io.SeekStart) && !f.read {
+		return 0, nil
+	}
+
+	return 0, fmt.Errorf("%v: seek not supported", f.fs.Name())
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	if f.reader != nil {
+		return 0, errors.New("cannot write a file being read")
+	}
+
+	if f.writer == nil {
+		w, err := os.CreateTemp("", "heph_objfs")
+		if err != nil {
+			return 0, err
+		}
+		f.writer = w
+	}
+
+	return f.writer.Write(p)
+}
+
+func (f *File) String() string {
+	return f.URI()
+}
+
+func (f *File) Exists() (bool, error) {
+	_, err := f.store.Stat(f.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (f *File) Location() vfs.Location {
+	return &Location{
+		fs:     f.fs,
+		store:  f.store,
+		volume: f.volume,
+		path:   utils.EnsureTrailingSlash(path.Dir(f.path)),
+	}
+}
+
+func (f *File) CopyToLocation(location vfs.Location) (vfs.File, error) {
+	dst, err := location.NewFile(f.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.CopyToFile(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+func (f *File) CopyToFile(file vfs.File) error {
+	r, err := f.store.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// Always write, so that empty files get created too
+	_, err = file.Write([]byte{})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, r)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (f *File) MoveToLocation(location vfs.Location) (vfs.File, error) {
+	dst, err := f.CopyToLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return dst, f.Delete()
+}
+
+func (f *File) MoveToFile(file vfs.File) error {
+	err := f.CopyToFile(file)
+	if err != nil {
+		return err
+	}
+
+	return f.Delete()
+}
+
+func (f *File) Delete(_ ...options.DeleteOption) error {
+	return f.store.Delete(f.path)
+}
+
+func (f *File) LastModified() (*time.Time, error) {
+	info, err := f.store.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &info.ModTime, nil
+}
+
+func (f *File) Size() (uint64, error) {
+	info, err := f.store.Stat(f.path)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size, nil
+}
+
+func (f *File) Path() string {
+	return f.path
+}
+
+func (f *File) Name() string {
+	return path.Base(f.path)
+}
+
+func (f *File) Touch() error {
+	exists, err := f.Exists()
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	_, err = f.Write([]byte{})
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func (f *File) URI() string {
+	return utils.GetFileURI(f)
+}
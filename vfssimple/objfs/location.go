@@ -0,0 +1,121 @@
+package objfs
+
+import (
+	"errors"
+	"github.com/c2fo/vfs/v6"
+	"github.com/c2fo/vfs/v6/options"
+	"github.com/c2fo/vfs/v6/utils"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type Location struct {
+	fs     *FileSystem
+	store  Store
+	volume string
+	path   string
+}
+
+func (l *Location) String() string {
+	return l.URI()
+}
+
+func (l *Location) List() ([]string, error) {
+	return l.store.List(l.Path())
+}
+
+func (l *Location) ListByPrefix(prefix string) ([]string, error) {
+	names, err := l.List()
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			filtered = append(filtered, name)
+		}
+	}
+
+	return filtered, nil
+}
+
+func (l *Location) ListByRegex(regex *regexp.Regexp) ([]string, error) {
+	names, err := l.List()
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]string, 0, len(names))
+	for _, name := range names {
+		if regex.MatchString(name) {
+			filtered = append(filtered, name)
+		}
+	}
+
+	return filtered, nil
+}
+
+func (l *Location) Volume() string {
+	return l.volume
+}
+
+func (l *Location) Path() string {
+	return utils.EnsureLeadingSlash(utils.EnsureTrailingSlash(path.Clean(l.path)))
+}
+
+func (l *Location) Exists() (bool, error) {
+	// Object stores have no concept of directories, a location always exists
+	return true, nil
+}
+
+func (l *Location) NewLocation(relLocPath string) (vfs.Location, error) {
+	err := utils.ValidateRelativeLocationPath(relLocPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.fs.NewLocation(l.volume, utils.EnsureTrailingSlash(path.Join(l.Path(), relLocPath)))
+}
+
+func (l *Location) ChangeDir(relLocPath string) error {
+	err := utils.ValidateRelativeLocationPath(relLocPath)
+	if err != nil {
+		return err
+	}
+
+	l.path = utils.EnsureTrailingSlash(path.Join(l.Path(), relLocPath))
+
+	return nil
+}
+
+func (l *Location) FileSystem() vfs.FileSystem {
+	return l.fs
+}
+
+func (l *Location) NewFile(relFilePath string) (vfs.File, error) {
+	if relFilePath == "" {
+		return nil, errors.New("cannot use empty name for file")
+	}
+
+	err := utils.ValidateRelativeFilePath(relFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.fs.NewFile(l.volume, path.Join(l.Path(), relFilePath))
+}
+
+func (l *Location) DeleteFile(relFilePath string, _ ...options.DeleteOption) error {
+	f, err := l.NewFile(relFilePath)
+	if err != nil {
+		return err
+	}
+
+	return f.Delete()
+}
+
+func (l *Location) URI() string {
+	return utils.GetLocationURI(l)
+}
@@ -0,0 +1,131 @@
+package objfs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/c2fo/vfs/v6"
+	"github.com/c2fo/vfs/v6/utils"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrNotSupported is returned by a Store for operations its protocol cannot express (listing a CAS for example)
+var ErrNotSupported = errors.New("operation not supported by this backend")
+
+type ObjectInfo struct {
+	Size    uint64
+	ModTime time.Time
+}
+
+// Store is a flat object store addressed by absolute path.
+// Missing objects must be reported with an error wrapping os.ErrNotExist
+type Store interface {
+	Stat(path string) (ObjectInfo, error)
+	Open(path string) (io.ReadCloser, error)
+	Put(path string, r io.ReadSeeker, size int64) error
+	Delete(path string) error
+	// List returns the names of the objects directly under the prefix location
+	List(prefix string) ([]string, error)
+}
+
+// Walker is implemented by the Stores able to list recursively
+type Walker interface {
+	// Walk calls fn for every object under the prefix location, with its path relative to it
+	Walk(prefix string, fn func(name string, info ObjectInfo) error) error
+}
+
+type StoreFactory func(scheme, volume string) (Store, error)
+
+// FileSystem implements vfs.FileSystem on top of a Store, one Store per volume
+type FileSystem struct {
+	name    string
+	scheme  string
+	factory StoreFactory
+
+	m      sync.Mutex
+	stores map[string]Store
+}
+
+func NewFileSystem(name, scheme string, factory StoreFactory) *FileSystem {
+	return &FileSystem{
+		name:    name,
+		scheme:  scheme,
+		factory: factory,
+		stores:  map[string]Store{},
+	}
+}
+
+// Store returns the Store of the volume
+func (fs *FileSystem) Store(volume string) (Store, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+
+	if s, ok := fs.stores[volume]; ok {
+		return s, nil
+	}
+
+	s, err := fs.factory(fs.scheme, volume)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", fs.name, err)
+	}
+
+	fs.stores[volume] = s
+
+	return s, nil
+}
+
+func (fs *FileSystem) NewFile(volume string, absFilePath string) (vfs.File, error) {
+	err := utils.ValidateAbsoluteFilePath(absFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := fs.Store(volume)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{fs: fs, store: s, volume: volume, path: absFilePath}, nil
+}
+
+func (fs *FileSystem) NewLocation(volume string, absLocPath string) (vfs.Location, error) {
+	err := utils.ValidateAbsoluteLocationPath(absLocPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := fs.Store(volume)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Location{fs: fs, store: s, volume: volume, path: absLocPath}, nil
+}
+
+// Walk calls fn for every object under the location path of the volume, if its Store is a Walker
+func (fs *FileSystem) Walk(volume, absLocPath string, fn func(name string, info ObjectInfo) error) error {
+	s, err := fs.Store(volume)
+	if err != nil {
+		return err
+	}
+
+	w, ok := s.(Walker)
+	if !ok {
+		return fmt.Errorf("%v: walk: %w", fs.name, ErrNotSupported)
+	}
+
+	return w.Walk(utils.EnsureTrailingSlash(absLocPath), fn)
+}
+
+func (fs *FileSystem) Name() string {
+	return fs.name
+}
+
+func (fs *FileSystem) Scheme() string {
+	return fs.scheme
+}
+
+func (fs *FileSystem) Retry() vfs.Retry {
+	return vfs.DefaultRetryer()
+}
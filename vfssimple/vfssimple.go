@@ -5,8 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/c2fo/vfs/v6/backend/gs"
-	"github.com/c2fo/vfs/v6/backend/mem"
 	"github.com/c2fo/vfs/v6/backend/os"
+	"heph/vfssimple/backend/mem"
 	"net/url"
 	"strings"
 
@@ -18,10 +18,12 @@ import (
 	// Disabled due to https://github.com/Azure/azure-pipeline-go/issues/31
 	//_ "github.com/c2fo/vfs/v6/backend/azure" // register azure backend
 	_ "github.com/c2fo/vfs/v6/backend/gs"   // register gs backend
-	_ "github.com/c2fo/vfs/v6/backend/mem"  // register mem backend
 	_ "github.com/c2fo/vfs/v6/backend/os"   // register os backend
 	_ "github.com/c2fo/vfs/v6/backend/s3"   // register s3 backend
 	_ "github.com/c2fo/vfs/v6/backend/sftp" // register sftp backend
+	_ "heph/vfssimple/backend/httpcache"    // register http & https backend
+	_ "heph/vfssimple/backend/mem"          // register mem backend
+	_ "heph/vfssimple/backend/reapi"        // register grpc & grpcs backend
 )
 
 func WithContext(ctx context.Context) {
@@ -0,0 +1,148 @@
+package vfssimple
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/c2fo/vfs/v6"
+	"github.com/c2fo/vfs/v6/backend/gs"
+	vfsos "github.com/c2fo/vfs/v6/backend/os"
+	vfss3 "github.com/c2fo/vfs/v6/backend/s3"
+	"google.golang.org/api/iterator"
+	"heph/vfssimple/objfs"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WalkFunc is called with the path of the file, relative to the walked location and slash separated
+type WalkFunc func(name string, modTime time.Time) error
+
+// Walk calls fn for every file under loc, recursively. vfs.Location only lists the files of a folder,
+// the backends able to list by prefix are walked through their client or objfs.Walker, the others return objfs.ErrNotSupported
+func Walk(ctx context.Context, loc vfs.Location, fn WalkFunc) error {
+	switch fs := loc.FileSystem().(type) {
+	case *vfsos.FileSystem:
+		return walkOs(loc.Path(), fn)
+	case *gs.FileSystem:
+		return walkGs(ctx, fs, loc, fn)
+	case *vfss3.FileSystem:
+		return walkS3(ctx, fs, loc, fn)
+	case *objfs.FileSystem:
+		return fs.Walk(loc.Volume(), loc.Path(), func(name string, info objfs.ObjectInfo) error {
+			return fn(name, info.ModTime)
+		})
+	default:
+		return fmt.Errorf("walking %v: %w", loc.FileSystem().Scheme(), objfs.ErrNotSupported)
+	}
+}
+
+func walkOs(root string, fn WalkFunc) error {
+	err := filepath.WalkDir(root, func(path string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		return fn(filepath.ToSlash(rel), info.ModTime())
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func objectPrefix(loc vfs.Location) string {
+	return strings.TrimPrefix(loc.Path(), "/")
+}
+
+// objectName returns the name of the object key relative to the prefix, false for folder placeholders
+func objectName(prefix, key string) (string, bool) {
+	if strings.HasSuffix(key, "/") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(key, prefix), true
+}
+
+func walkGs(ctx context.Context, fs *gs.FileSystem, loc vfs.Location, fn WalkFunc) error {
+	client, err := fs.Client()
+	if err != nil {
+		return err
+	}
+
+	prefix := objectPrefix(loc)
+
+	it := client.Bucket(loc.Volume()).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				return nil
+			}
+			return err
+		}
+
+		name, ok := objectName(prefix, attrs.Name)
+		if !ok {
+			continue
+		}
+
+		err = fn(name, attrs.Updated)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func walkS3(ctx context.Context, fs *vfss3.FileSystem, loc vfs.Location, fn WalkFunc) error {
+	client, err := fs.Client()
+	if err != nil {
+		return err
+	}
+
+	prefix := objectPrefix(loc)
+
+	var ferr error
+	err = client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(loc.Volume()),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			name, ok := objectName(prefix, aws.StringValue(object.Key))
+			if !ok {
+				continue
+			}
+
+			ferr = fn(name, aws.TimeValue(object.LastModified))
+			if ferr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return ferr
+}
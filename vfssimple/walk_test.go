@@ -0,0 +1,70 @@
+package vfssimple
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestWalkMem(t *testing.T) {
+	volume := fmt.Sprintf("mem://walk%v/", time.Now().UnixNano())
+	root := volume + "root/"
+
+	for _, name := range []string{"a", "pkg/b", "pkg/sub/c"} {
+		f, err := NewFile(root + name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(name))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	other, err := NewFile(volume + "other/d")
+	require.NoError(t, err)
+	require.NoError(t, other.Touch())
+
+	loc, err := NewLocation(root)
+	require.NoError(t, err)
+
+	names := make([]string, 0)
+	err = Walk(context.Background(), loc, func(name string, modTime time.Time) error {
+		assert.False(t, modTime.IsZero())
+		names = append(names, name)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "pkg/b", "pkg/sub/c"}, names)
+
+	// Listing stays on the folder
+	list, err := loc.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, list)
+}
+
+func TestObjectName(t *testing.T) {
+	tests := []struct {
+		uri  string
+		key  string
+		name string
+		ok   bool
+	}{
+		{"gs://bucket/cache/", "cache/pkg/__target_a/1/hash_input", "pkg/__target_a/1/hash_input", true},
+		{"gs://bucket/cache/", "cache/pkg/", "", false},
+		{"gs://bucket/", "pkg/a", "pkg/a", true},
+		{"s3://bucket/some/cache/", "some/cache/_cas/o1.tar.gz", "_cas/o1.tar.gz", true},
+		{"s3://bucket/some/cache/", "some/cache/", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.uri+test.key, func(t *testing.T) {
+			loc, err := NewLocation(test.uri)
+			require.NoError(t, err)
+
+			name, ok := objectName(objectPrefix(loc), test.key)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.name, name)
+		})
+	}
+}